@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/gin-gonic/gin"
+)
+
+// accessLogKey is the key of the accessLogEntry of a request in the gin
+// context.
+const accessLogKey = "sqlrunner.access_log"
+
+// accessLogEntry is what a query request runs, which its handler records
+// for the access log with setAccessLogEntry.
+type accessLogEntry struct {
+	schemaHash string
+	query      string
+	// result is nil if the query failed
+	result *sqlrunner.QueryResult
+}
+
+// setAccessLogEntry records the entry of the request for the access log.
+// The handler may update the entry until it returns, such as to add the
+// result.
+func setAccessLogEntry(c *gin.Context, entry *accessLogEntry) {
+	c.Set(accessLogKey, entry)
+}
+
+// accessLogger logs a sample of the query requests as structured records,
+// to spot the abuse of a public playground: the client IP, the schema hash,
+// the length and the statement type of the query, the number of rows, and
+// the duration. The query text is only logged with queryText, since it is
+// large and may be sensitive.
+type accessLogger struct {
+	logger *slog.Logger
+	// rate is the fraction of the requests to log, from 0 to 1
+	rate      float64
+	queryText bool
+}
+
+// middleware logs the request after its handler if it is sampled.
+func (l accessLogger) middleware(c *gin.Context) {
+	// rand.Float64 is in [0, 1), so 0 logs none and 1 logs all
+	if rand.Float64() >= l.rate {
+		c.Next()
+		return
+	}
+
+	start := time.Now()
+	c.Next()
+
+	attrs := []slog.Attr{
+		slog.String("client.ip", c.ClientIP()),
+		slog.String("route", c.FullPath()),
+		slog.Int("status", c.Writer.Status()),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if value, ok := c.Get(accessLogKey); ok {
+		entry := value.(*accessLogEntry)
+		attrs = append(attrs,
+			slog.String("schema.hash", entry.schemaHash),
+			slog.Int("query.length", len(entry.query)),
+		)
+		if l.queryText {
+			attrs = append(attrs, slog.String("query.text", entry.query))
+		}
+		if entry.result != nil {
+			attrs = append(attrs,
+				slog.String("query.statement_type", string(entry.result.StatementType)),
+				slog.Int("result.rows", len(entry.result.Rows)),
+			)
+		}
+	}
+
+	l.logger.LogAttrs(c.Request.Context(), slog.LevelInfo, "Query access", attrs...)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// UnauthorizedError is returned when an admin request has no valid token.
+type UnauthorizedError struct{}
+
+func (UnauthorizedError) Error() string {
+	return "a valid admin token is required"
+}
+
+// registerAdminRoutes registers the endpoints changing the state of the
+// server, which require the token in the `Authorization: Bearer` header.
+// They are not registered unless ADMIN_TOKEN is set.
+func registerAdminRoutes(r *gin.Engine, service *SqlQueryService, token string) {
+	admin := r.Group("/admin", adminAuth(token))
+	admin.POST("/flush", service.ServeFlush)
+}
+
+// adminAuth rejects the requests without the token.
+func adminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, failedResponse(c, UnauthorizedError{}))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ServeFlush resets the state of the server for troubleshooting: it drops
+// the runners and their cached results, and removes the schema databases
+// and the disk caches. The next query on each schema initializes it again.
+func (s *SqlQueryService) ServeFlush(c *gin.Context) {
+	_, span := tracer.Start(c.Request.Context(), "SqlQueryService.ServeFlush")
+	defer span.End()
+
+	if err := s.runners.Flush(); err != nil {
+		span.SetStatus(codes.Error, "flush error")
+		span.RecordError(err)
+
+		c.JSON(http.StatusInternalServerError, failedResponse(c, err))
+		return
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	c.JSON(http.StatusOK, NewSuccessResponse(nil))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// ShuttingDownError is returned for the requests received while the server
+// drains the requests in flight before shutting down.
+type ShuttingDownError struct{}
+
+func (ShuttingDownError) Error() string {
+	return "the server is shutting down"
+}
+
+// drainer tracks the requests in flight, so that the server waits for them
+// to finish before shutting down, such as a query running up to its timeout.
+// The requests received while draining are rejected with 503.
+type drainer struct {
+	// mu guards draining, so that no request is added to inFlight once
+	// drain waits for it.
+	mu       sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
+}
+
+// handler wraps the handler to track its requests.
+func (d *drainer) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(NewFailedResponse(ShuttingDownError{}))
+			return
+		}
+		d.inFlight.Add(1)
+		d.mu.Unlock()
+
+		defer d.inFlight.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// drain rejects the next requests, and waits for the requests in flight to
+// finish or the context to be done, whichever is first.
+func (d *drainer) drain(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
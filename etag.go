@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+)
+
+// resultETag returns the strong ETag of the response of the result in the
+// format, the shape, and the serialization of the cells, which is a hash of
+// the columns, the rows, and the warnings of the result. The same result in
+// another format, shape, or serialization has another ETag.
+func resultETag(result *sqlrunner.QueryResult, format, shape, cells string) (string, error) {
+	content, err := json.Marshal(struct {
+		Format       string     `json:"format"`
+		Shape        string     `json:"shape"`
+		Cells        string     `json:"cells"`
+		Columns      []string   `json:"columns"`
+		ColumnTypes  []string   `json:"column_types"`
+		Rows         [][]string `json:"rows"`
+		Warnings     []string   `json:"warnings"`
+		RowsAffected int64      `json:"rows_affected"`
+	}{format, shape, cells, result.Columns, result.ColumnTypes, result.Rows, result.Warnings, result.RowsAffected})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether the If-None-Match header matches the ETag.
+// As specified for If-None-Match, the weak comparison is used.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
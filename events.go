@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// contentTypeEventStream is the content type of Server-Sent Events.
+const contentTypeEventStream = "text/event-stream"
+
+// The events of ServeEvents.
+const (
+	eventHeartbeat = "heartbeat"
+	eventResult    = "result"
+	eventError     = "error"
+)
+
+// heartbeatInterval is the interval of the heartbeat events while a query
+// is running. It is shortened in the tests.
+var heartbeatInterval = time.Second
+
+// HeartbeatEvent is the data of a heartbeat event.
+type HeartbeatEvent struct {
+	// ElapsedMs is the time since the query started, in milliseconds.
+	ElapsedMs int64 `json:"elapsed_ms"`
+}
+
+// ServeEvents runs a query like Serve, but responds with Server-Sent Events
+// to show the progress of a long query: a heartbeat event per
+// heartbeatInterval while the query is running, and then a result event
+// with the response of Serve, or an error event with its failed response.
+//
+// The modernc.org/sqlite driver does not expose the progress handler of
+// SQLite, so the heartbeats are sent by a timer while the query runs. The
+// query is interrupted if the client disconnects.
+func (s *SqlQueryService) ServeEvents(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "SqlQueryService.ServeEvents")
+	defer span.End()
+
+	recordMetrics := s.createRecordMetricsFunc()
+
+	var req QueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(err)
+
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, BadPayloadError{Parent: err}))
+		return
+	}
+
+	blob := blobEncoding(c)
+	if !isSupportedBlobEncoding(blob) {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(errors.New("unsupported blob encoding"))
+
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("unsupported blob encoding: "+string(blob))))
+		return
+	}
+
+	partial, err := partialResults(c)
+	if err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(err)
+
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("unsupported partial: "+c.Query("partial"))))
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(err)
+
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, err))
+		return
+	}
+
+	c.Header("Content-Type", contentTypeEventStream)
+	c.Header("Cache-Control", "no-cache")
+	// Disable the response buffering of the reverse proxies, such as nginx
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	type outcome struct {
+		result *sqlrunner.QueryResult
+		err    error
+	}
+	// The goroutine fills the entry before sending the outcome, which the
+	// handler always waits for
+	accessLogEntry := &accessLogEntry{query: req.Query}
+	setAccessLogEntry(c, accessLogEntry)
+
+	done := make(chan outcome, 1)
+	go func() {
+		// The schema is initialized here, which may be slow too
+		span.AddEvent("runner.find")
+		runner, err := s.runner(req)
+		if err != nil {
+			var schemaError sqlrunner.SchemaError
+			if errors.As(err, &schemaError) {
+				s.p.IncrementCounterValue("schema_init_failures_total", []string{schemaError.Category()})
+			}
+
+			done <- outcome{err: err}
+			return
+		}
+
+		accessLogEntry.schemaHash = runner.SchemaHash()
+
+		span.AddEvent("runner.query")
+		result, err := runner.Query(queryCtx, req.Query, req.queryOptions(blob, partial)...)
+		accessLogEntry.result = result
+		done <- outcome{result: result, err: err}
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := writeEvent(c.Writer, eventHeartbeat, HeartbeatEvent{ElapsedMs: time.Since(start).Milliseconds()})
+			if err != nil {
+				// The client has gone, so interrupt the query
+				span.RecordError(err)
+				cancel()
+				<-done
+				return
+			}
+			c.Writer.Flush()
+
+		case outcome := <-done:
+			if outcome.err != nil {
+				span.SetStatus(codes.Error, "query error")
+				span.RecordError(outcome.err)
+
+				recordMetrics(eventErrorStatus(outcome.err), cacheStatusNone)
+				_ = writeEvent(c.Writer, eventError, failedResponse(c, outcome.err))
+				c.Writer.Flush()
+				return
+			}
+
+			recordMetrics(http.StatusOK, outcome.result.CacheStatus)
+			span.SetStatus(codes.Ok, "success")
+
+			_ = writeEvent(c.Writer, eventResult, NewSuccessResponse(outcome.result))
+			c.Writer.Flush()
+			return
+		}
+	}
+}
+
+// eventErrorStatus returns the status Serve would respond with for the
+// error, to label the metrics of an error event.
+func eventErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, sqlrunner.ErrTooManyQueries):
+		return http.StatusTooManyRequests
+	case errors.As(err, &NotFoundError{}):
+		return http.StatusNotFound
+	case errors.As(err, &sqlrunner.ParameterError{}):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &sqlrunner.SchemaError{}):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// writeEvent writes a Server-Sent Event with the data encoded in JSON,
+// which has no newlines and is thus a single data line.
+func writeEvent(w io.Writer, event string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+	return err
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// formatJSON responds the result as a single JSON object.
+	formatJSON = "json"
+	// formatNDJSON responds the result as newline-delimited JSON:
+	// a header line with the columns, then one JSON object per row.
+	formatNDJSON = "ndjson"
+	// formatColumnar responds the result in the column-oriented form.
+	formatColumnar = "columnar"
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+const (
+	// shapeArrays serializes each row as an array of cells.
+	shapeArrays = "arrays"
+	// shapeObjects serializes each row as an object keyed by the column names.
+	shapeObjects = "objects"
+)
+
+// ObjectQueryResult is a query result whose rows are
+// objects keyed by the column names.
+type ObjectQueryResult struct {
+	Columns []string `json:"columns"`
+	// Rows is a slice of rows, each row is a JSON object
+	// keyed by the column names in the column order.
+	Rows          []json.RawMessage       `json:"rows"`
+	Warnings      []string                `json:"warnings,omitempty"`
+	StatementType sqlrunner.StatementType `json:"statement_type,omitempty"`
+	Partial       bool                    `json:"partial,omitempty"`
+}
+
+// NDJSONHeader is the first line of a NDJSON response.
+type NDJSONHeader struct {
+	Columns       []string                `json:"columns"`
+	Warnings      []string                `json:"warnings,omitempty"`
+	StatementType sqlrunner.StatementType `json:"statement_type,omitempty"`
+	Partial       bool                    `json:"partial,omitempty"`
+}
+
+// responseFormat determines the response format from the `format` query
+// parameter, or the Accept header if it is absent.
+func responseFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), contentTypeNDJSON) {
+		return formatNDJSON
+	}
+
+	return formatJSON
+}
+
+// responseShape determines the row shape from the `shape` query parameter.
+func responseShape(c *gin.Context) string {
+	return c.DefaultQuery("shape", shapeArrays)
+}
+
+// blobEncoding determines the encoding of the BLOB cells from the `blob`
+// query parameter. It applies to all the response formats.
+func blobEncoding(c *gin.Context) sqlrunner.BlobEncoding {
+	return sqlrunner.BlobEncoding(c.DefaultQuery("blob", string(sqlrunner.BlobHex)))
+}
+
+// partialResults reports whether the rows read so far are returned if the
+// query times out, from the `partial` query parameter.
+func partialResults(c *gin.Context) (bool, error) {
+	value := c.Query("partial")
+	if value == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// isSupportedBlobEncoding reports whether the BLOB encoding is supported.
+func isSupportedBlobEncoding(encoding sqlrunner.BlobEncoding) bool {
+	switch encoding {
+	case sqlrunner.BlobHex, sqlrunner.BlobBase64:
+		return true
+	default:
+		return false
+	}
+}
+
+// isSupportedShape reports whether the row shape is supported.
+func isSupportedShape(shape string) bool {
+	switch shape {
+	case shapeArrays, shapeObjects:
+		return true
+	default:
+		return false
+	}
+}
+
+// newObjectQueryResult converts the result to the object shape.
+func newObjectQueryResult(result *sqlrunner.QueryResult) (*ObjectQueryResult, error) {
+	keys := objectKeys(result.Columns)
+
+	rows := make([]json.RawMessage, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		var buf bytes.Buffer
+		if err := writeRowObject(&buf, keys, row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, buf.Bytes())
+	}
+
+	return &ObjectQueryResult{
+		Columns:       result.Columns,
+		Rows:          rows,
+		Warnings:      result.Warnings,
+		StatementType: result.StatementType,
+		Partial:       result.Partial,
+	}, nil
+}
+
+// objectKeys returns the unique object keys of the columns. A duplicate
+// column name is suffixed with its occurrence, such as "id_2".
+func objectKeys(columns []string) []string {
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		seen[column] = true
+	}
+
+	keys := make([]string, 0, len(columns))
+	occurrences := make(map[string]int, len(columns))
+	for _, column := range columns {
+		occurrences[column]++
+		if occurrences[column] == 1 {
+			keys = append(keys, column)
+			continue
+		}
+
+		key := column + "_" + strconv.Itoa(occurrences[column])
+		for seen[key] {
+			occurrences[column]++
+			key = column + "_" + strconv.Itoa(occurrences[column])
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// isSupportedFormat reports whether the response format is supported.
+func isSupportedFormat(format string) bool {
+	switch format {
+	case formatJSON, formatNDJSON, formatColumnar:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeNDJSON writes the result as NDJSON, with each row as
+// a JSON object keyed by the column names in the column order.
+// Duplicate column names are deduplicated as in objectKeys.
+func writeNDJSON(w io.Writer, result *sqlrunner.QueryResult) error {
+	header, err := json.Marshal(NDJSONHeader{
+		Columns:       result.Columns,
+		Warnings:      result.Warnings,
+		StatementType: result.StatementType,
+		Partial:       result.Partial,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		return err
+	}
+
+	keys := objectKeys(result.Columns)
+
+	var line bytes.Buffer
+	for _, row := range result.Rows {
+		line.Reset()
+		if err := writeRowObject(&line, keys, row); err != nil {
+			return err
+		}
+		line.WriteByte('\n')
+
+		if _, err := w.Write(line.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeRowObject writes the row as a JSON object keyed by the column names,
+// preserving the column order.
+func writeRowObject(buf *bytes.Buffer, columns []string, row []string) error {
+	buf.WriteByte('{')
+	for i, column := range columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(column)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(row[i])
+		if err != nil {
+			return err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"golang.org/x/sync/singleflight"
+)
+
+// idempotencyKeyHeader is the header carrying the key of a request the
+// client may retry, such as a UUID.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayedHeader is set on the responses replayed for a retried
+// request.
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+const (
+	// idempotencyTTL is how long a response is replayed for its key.
+	idempotencyTTL = 5 * time.Minute
+	// maxIdempotentResponses is the maximum number of the responses kept,
+	// beyond which the least recently used one is dropped.
+	maxIdempotentResponses = 1024
+	// maxIdempotentResponseBytes is the maximum size of a response body
+	// kept, so that a few large results do not hold the memory.
+	maxIdempotentResponseBytes = 1 << 20
+	// maxIdempotencyKeyLength is the maximum length of a key.
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotentResponse is a response kept for the retries of its request.
+type idempotentResponse struct {
+	// fingerprint is the hash of the request, to reject a key reused for
+	// another request.
+	fingerprint [sha256.Size]byte
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// idempotency replays the response of a request with an Idempotency-Key
+// header to the retries of the request with the same key, so that a client
+// behind a flaky network gets the same bytes without executing the query
+// again. The retries arriving while the request is executing wait for its
+// response.
+//
+// The responses of 429 and 5xx are not kept, since a retry may succeed, and
+// neither are the ones larger than maxIdempotentResponseBytes.
+type idempotency struct {
+	responses *expirable.LRU[string, *idempotentResponse]
+	inFlight  singleflight.Group
+}
+
+func newIdempotency() *idempotency {
+	return &idempotency{
+		responses: expirable.NewLRU[string, *idempotentResponse](maxIdempotentResponses, nil, idempotencyTTL),
+	}
+}
+
+// middleware replays the response of the key of the request, or executes
+// the handler and keeps its response.
+func (i *idempotency) middleware(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("the Idempotency-Key header is too long")))
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, failedResponse(c, BadPayloadError{Parent: err}))
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	fingerprint := requestFingerprint(c, body)
+
+	executed := false
+	value, _, _ := i.inFlight.Do(key, func() (any, error) {
+		if response, ok := i.responses.Get(key); ok {
+			return response, nil
+		}
+
+		executed = true
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		response := &idempotentResponse{
+			fingerprint: fingerprint,
+			status:      writer.Status(),
+			header:      writer.Header().Clone(),
+			body:        writer.body.Bytes(),
+		}
+		if response.status != http.StatusTooManyRequests && response.status < 500 && !writer.overflow {
+			i.responses.Add(key, response)
+		}
+		return response, nil
+	})
+	if executed {
+		return
+	}
+
+	response := value.(*idempotentResponse)
+	if response.fingerprint != fingerprint {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("the Idempotency-Key was used for another request")))
+		return
+	}
+
+	// The request ID and the trace ID are of this request
+	for name, values := range response.header {
+		if name != http.CanonicalHeaderKey(requestIDHeader) && name != http.CanonicalHeaderKey("X-Trace-ID") {
+			c.Writer.Header()[name] = values
+		}
+	}
+	c.Header(idempotentReplayedHeader, "true")
+	c.Status(response.status)
+	_, _ = c.Writer.Write(response.body)
+	c.Abort()
+}
+
+// requestFingerprint returns the hash of what determines the response of
+// the request: the method, the URL, the Accept and If-None-Match headers,
+// and the body.
+func requestFingerprint(c *gin.Context, body []byte) [sha256.Size]byte {
+	h := sha256.New()
+	for _, part := range []string{c.Request.Method, c.Request.URL.RequestURI(), c.GetHeader("Accept"), c.GetHeader("If-None-Match")} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// capturingWriter keeps a copy of the body written to the response, up to
+// maxIdempotentResponseBytes.
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+	// overflow reports whether the body exceeded the maximum size
+	overflow bool
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *capturingWriter) capture(b []byte) {
+	if w.overflow || w.body.Len()+len(b) > maxIdempotentResponseBytes {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(b)
+}
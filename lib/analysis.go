@@ -0,0 +1,504 @@
+package sqlrunner
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// warnUnorderedRows is the warning for multi-row results without ORDER BY.
+const warnUnorderedRows = "The query returns multiple rows without an ORDER BY clause; the row order is not guaranteed."
+
+// OrderCheck is how Query reports a query returning multiple rows without
+// a top-level ORDER BY clause, whose row order is not guaranteed.
+type OrderCheck string
+
+const (
+	// OrderCheckOff does not report it. It is the default.
+	OrderCheckOff OrderCheck = "off"
+	// OrderCheckWarn adds a warning to the result.
+	OrderCheckWarn OrderCheck = "warn"
+	// OrderCheckError fails the query with a QueryError wrapping
+	// ErrUnorderedRows, such as for the strictly graded assignments.
+	OrderCheckError OrderCheck = "error"
+)
+
+// compareRows compares two rows by their cells from the first column for
+// WithStableOrdering. Two numbers are compared numerically, such as 9 before
+// 10, and any other cells as strings.
+func compareRows(a, b []string) int {
+	for i := range min(len(a), len(b)) {
+		if c := compareCells(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+
+	return cmp.Compare(len(a), len(b))
+}
+
+// compareCells compares two cells numerically if they are both numbers,
+// or as strings otherwise.
+func compareCells(a, b string) int {
+	af, aErr := strconv.ParseFloat(a, 64)
+	bf, bErr := strconv.ParseFloat(b, 64)
+	if aErr == nil && bErr == nil {
+		if c := cmp.Compare(af, bf); c != 0 {
+			return c
+		}
+	}
+
+	return strings.Compare(a, b)
+}
+
+// hasTopLevelOrderBy reports whether the query has an ORDER BY clause
+// outside of any parentheses, such as subqueries and window definitions.
+func hasTopLevelOrderBy(query string) bool {
+	tokens := tokenize(query)
+
+	depth := 0
+	for i, t := range tokens {
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth == 0 && t.is("ORDER"):
+			if next := nextSignificant(tokens, i); next >= 0 && tokens[next].is("BY") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// warnCartesianProduct returns the warning for a query combining the rows
+// of the tables without a join condition.
+func warnCartesianProduct(tables []string) string {
+	return fmt.Sprintf("The query combines every row of %s with every row of %s, which may return "+
+		"a huge number of rows; is a join condition missing?",
+		strings.Join(tables[:len(tables)-1], ", "), tables[len(tables)-1])
+}
+
+// cartesianProductTables returns the tables a query combines without a join
+// condition, or nil if there are none. The query plan tells the tables which
+// are scanned in full in the same loop, and they are combined without a
+// condition unless the query joins them with ON, USING, or NATURAL, or refers
+// to two of them in the WHERE clause. An explicit CROSS JOIN is intended.
+//
+// It is a heuristic for the teaching purpose: a condition on the unqualified
+// columns is not recognized, for example.
+func cartesianProductTables(ctx context.Context, db queryer, query string, args ...any) ([]string, error) {
+	tokens := tokenize(query)
+	for _, t := range tokens {
+		if t.is("ON") || t.is("USING") || t.is("NATURAL") || t.is("CROSS") {
+			return nil, nil
+		}
+	}
+
+	plan, err := db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("explain query plan: %w", err)
+	}
+	defer func() {
+		_ = plan.Close()
+	}()
+
+	// The full scans by the parent of their loop
+	scans := map[int64][]string{}
+	var parents []int64
+	// The subqueries and CTEs evaluated into a temporary table,
+	// which may be a single row
+	subqueries := map[string]bool{}
+
+	for plan.Next() {
+		var id, parent, notUsed int64
+		var detail string
+		if err := plan.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return nil, fmt.Errorf("scan query plan: %w", err)
+		}
+
+		if name, ok := strings.CutPrefix(detail, "CO-ROUTINE "); ok {
+			subqueries[name] = true
+		} else if name, ok := strings.CutPrefix(detail, "MATERIALIZE "); ok {
+			subqueries[name] = true
+		} else if scan, ok := strings.CutPrefix(detail, "SCAN "); ok {
+			name, _, _ := strings.Cut(scan, " ")
+			if name == "CONSTANT" || strings.HasPrefix(name, "(") {
+				continue
+			}
+
+			if _, ok := scans[parent]; !ok {
+				parents = append(parents, parent)
+			}
+			scans[parent] = append(scans[parent], name)
+		}
+	}
+	if err := plan.Err(); err != nil {
+		return nil, fmt.Errorf("read query plan: %w", err)
+	}
+
+	for _, parent := range parents {
+		tables := slices.DeleteFunc(scans[parent], func(name string) bool {
+			return subqueries[name]
+		})
+		if len(tables) >= 2 && !hasWhereReference(tokens, tables) {
+			return tables, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// hasWhereReference reports whether two of the tables are referred to, as
+// the qualifiers of the columns, in the WHERE clauses of the query.
+func hasWhereReference(tokens []token, tables []string) bool {
+	referred := map[string]bool{}
+	inWhere := false
+
+	for i, t := range tokens {
+		switch {
+		case t.is("WHERE"):
+			inWhere = true
+		case t.is("GROUP") || t.is("HAVING") || t.is("ORDER") || t.is("LIMIT") || t.is("WINDOW") || t.is("UNION") ||
+			t.is("INTERSECT") || t.is("EXCEPT") || t.is("SELECT"):
+			inWhere = false
+		case inWhere && isIdentifierToken(t):
+			if next := nextSignificant(tokens, i); next < 0 || !tokens[next].is(".") {
+				continue
+			}
+
+			for _, table := range tables {
+				if strings.EqualFold(unquoteIdentifier(t), table) {
+					referred[strings.ToLower(table)] = true
+				}
+			}
+		}
+	}
+
+	return len(referred) >= 2
+}
+
+// warnTableScan returns the suggestion for a table a query scans in full.
+func warnTableScan(table string) string {
+	return fmt.Sprintf("The query scans every row of %s; consider an index on the columns it is "+
+		"filtered or joined by.", table)
+}
+
+// warnAutomaticIndex returns the suggestion for a table SQLite builds a
+// temporary index on for the query, on the columns such as "(sid=?)".
+func warnAutomaticIndex(table, columns string) string {
+	return fmt.Sprintf("The query builds a temporary index on %s %s each time it runs; consider "+
+		"creating an index on these columns.", table, columns)
+}
+
+// indexUsageWarnings returns the suggestions for the tables a query reads
+// without an index, from its query plan. A SCAN step reads every row of the
+// table, unlike a SEARCH step using an index, and a SEARCH step using an
+// AUTOMATIC index builds the index for the query. The tables are named as
+// in the plan, which are their aliases if any.
+//
+// The queries without WHERE, ON, or USING are not analyzed, since they
+// have to read every row anyway, and neither are the scans of the
+// subqueries and CTEs evaluated into a temporary table.
+func indexUsageWarnings(ctx context.Context, db queryer, query string, args ...any) ([]string, error) {
+	filtered := false
+	for _, t := range tokenize(query) {
+		if t.kind == tokenIdentifier && (t.is("WHERE") || t.is("ON") || t.is("USING")) {
+			filtered = true
+			break
+		}
+	}
+	if !filtered {
+		return nil, nil
+	}
+
+	plan, err := db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("explain query plan: %w", err)
+	}
+	defer func() {
+		_ = plan.Close()
+	}()
+
+	var warnings []string
+	warned := map[string]bool{}
+	// The subqueries and CTEs evaluated into a temporary table, which have
+	// no indexes to suggest, and the ids of their steps
+	subqueries := map[string]bool{}
+	subquerySteps := map[int64]bool{}
+
+	for plan.Next() {
+		var id, parent, notUsed int64
+		var detail string
+		if err := plan.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return nil, fmt.Errorf("scan query plan: %w", err)
+		}
+
+		if name, ok := strings.CutPrefix(detail, "CO-ROUTINE "); ok {
+			subqueries[name] = true
+			subquerySteps[id] = true
+		} else if name, ok := strings.CutPrefix(detail, "MATERIALIZE "); ok {
+			subqueries[name] = true
+			subquerySteps[id] = true
+		} else if scan, ok := strings.CutPrefix(detail, "SCAN "); ok {
+			// A scan USING an INDEX reads the index only. The filter of a
+			// subquery or CTE scanned in full, if any, is in the query
+			// reading it, so the scan is not warned.
+			name, rest, _ := strings.Cut(scan, " ")
+			if name == "CONSTANT" || strings.HasPrefix(name, "(") || subqueries[name] || subquerySteps[parent] ||
+				rest != "" || warned[name] {
+				continue
+			}
+
+			warned[name] = true
+			warnings = append(warnings, warnTableScan(name))
+		} else if search, ok := strings.CutPrefix(detail, "SEARCH "); ok {
+			name, rest, _ := strings.Cut(search, " ")
+			columns, automatic := strings.CutPrefix(rest, "USING AUTOMATIC ")
+			if !automatic || subqueries[name] || warned[name] {
+				continue
+			}
+
+			// Such as "COVERING INDEX (sid=?)"
+			if i := strings.Index(columns, "("); i >= 0 {
+				columns = columns[i:]
+			}
+			warned[name] = true
+			warnings = append(warnings, warnAutomaticIndex(name, columns))
+		}
+	}
+	if err := plan.Err(); err != nil {
+		return nil, fmt.Errorf("read query plan: %w", err)
+	}
+
+	return warnings, nil
+}
+
+// maxHintTables is the maximum number of the tables emptyResultHint
+// checks, to bound its cost.
+const maxHintTables = 8
+
+// warnEmptyTable returns the hint for a query returning no rows since it
+// reads an empty table.
+func warnEmptyTable(table string) string {
+	return fmt.Sprintf("The query returns no rows since the table %s is empty.", table)
+}
+
+// warnFilteredOut returns the hint for a query returning no rows since the
+// clauses, such as "the WHERE clause", filtered out the rows of its tables.
+func warnFilteredOut(clauses []string) string {
+	return fmt.Sprintf("The query returns no rows: the tables have rows, but %s matched none of them.",
+		strings.Join(clauses, " and "))
+}
+
+// emptyResultHint returns the hint explaining why a SELECT returns no rows:
+// a table in its FROM clause is empty, or else its WHERE clause, its join
+// conditions, or its HAVING clause matched none of the rows. It returns
+// empty if there is no hint, such as for a query without a table.
+//
+// Each table is checked by reading at most a row of it, and at most
+// maxHintTables tables are checked.
+func emptyResultHint(ctx context.Context, db queryer, query string) (string, error) {
+	tokens := tokenize(query)
+
+	checked := 0
+	for _, source := range fromSources(tokens) {
+		if source.table == "" {
+			continue
+		}
+		if checked++; checked > maxHintTables {
+			break
+		}
+
+		hasRows, err := tableHasRows(ctx, db, source.table)
+		if err != nil {
+			return "", err
+		}
+		if !hasRows {
+			return warnEmptyTable(source.table), nil
+		}
+	}
+	if checked == 0 {
+		return "", nil
+	}
+
+	var where, join, having bool
+	depth := 0
+	for _, t := range tokens {
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth != 0:
+		case t.is("WHERE"):
+			where = true
+		case t.is("ON") || t.is("USING") || t.is("NATURAL"):
+			join = true
+		case t.is("HAVING"):
+			having = true
+		}
+	}
+
+	var clauses []string
+	if where {
+		clauses = append(clauses, "the WHERE clause")
+	}
+	if join {
+		clauses = append(clauses, "the join conditions")
+	}
+	if having {
+		clauses = append(clauses, "the HAVING clause")
+	}
+	if len(clauses) == 0 {
+		return "", nil
+	}
+
+	return warnFilteredOut(clauses), nil
+}
+
+// tableHasRows reports whether the table has any row.
+func tableHasRows(ctx context.Context, db queryer, table string) (bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT 1 FROM "+quoteIdentifier(table)+" LIMIT 1")
+	if err != nil {
+		return false, fmt.Errorf("check table rows: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	hasRows := rows.Next()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("check table rows: %w", err)
+	}
+
+	return hasRows, nil
+}
+
+// booleanKeywords are the operators whose results are boolean.
+var booleanKeywords = []string{
+	"IS", "IN", "LIKE", "GLOB", "REGEXP", "RLIKE", "MATCH", "BETWEEN", "AND", "OR", "NOT", "EXISTS", "NOTNULL",
+}
+
+// booleanColumns reports whether each of the n result columns of the query
+// is a comparison or a logical operation, such as value = 1, from the select
+// list of its first top-level SELECT. It returns nil if the select list does
+// not have n expressions, such as with *.
+func booleanColumns(query string, n int) []bool {
+	expressions := selectList(tokenize(query))
+	if len(expressions) != n {
+		return nil
+	}
+
+	booleans := make([]bool, n)
+	for i, expression := range expressions {
+		booleans[i] = isBooleanExpression(expression)
+	}
+
+	return booleans
+}
+
+// selectList returns the tokens of each expression in the select list of the
+// first top-level SELECT, or nil if it has *.
+func selectList(tokens []token) [][]token {
+	// Skip the SELECT of the CTEs and the subqueries
+	start := -1
+	depth := 0
+	for i := 0; i < len(tokens) && start < 0; i++ {
+		switch {
+		case tokens[i].is("("):
+			depth++
+		case tokens[i].is(")"):
+			depth--
+		case depth == 0 && tokens[i].is("SELECT"):
+			if start = nextSignificant(tokens, i); start < 0 {
+				return nil
+			}
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	if tokens[start].is("DISTINCT") || tokens[start].is("ALL") {
+		start++
+	}
+
+	var expressions [][]token
+	expressionStart := start
+	for i := start; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.is("(") || t.is("CASE"):
+			depth++
+		case t.is(")") || t.is("END"):
+			depth--
+		case depth == 0 && t.is("*"):
+			if previous := previousSignificant(tokens, i); previous < start || tokens[previous].is(",") ||
+				tokens[previous].is(".") {
+				return nil
+			}
+		case depth == 0 && t.is(","):
+			expressions = append(expressions, tokens[expressionStart:i])
+			expressionStart = i + 1
+		case depth == 0 && isAnyOf(t, []string{"FROM", "WHERE", "GROUP", "HAVING", "WINDOW", "ORDER", "LIMIT",
+			"UNION", "INTERSECT", "EXCEPT"}) || t.kind == tokenSemicolon:
+			return append(expressions, tokens[expressionStart:i])
+		}
+	}
+
+	return append(expressions, tokens[expressionStart:])
+}
+
+// isBooleanExpression reports whether the expression has a comparison or
+// a logical operator outside of any parentheses and CASE expressions.
+func isBooleanExpression(expression []token) bool {
+	// An expression in parentheses, optionally with an alias
+	if open := nextSignificant(expression, -1); open >= 0 && expression[open].is("(") {
+		if close := matchingClose(expression, open); close >= 0 && isAlias(expression[close+1:]) {
+			return isBooleanExpression(expression[open+1 : close])
+		}
+	}
+
+	depth := 0
+	for i, t := range expression {
+		switch {
+		case t.is("(") || t.is("CASE"):
+			depth++
+		case t.is(")") || t.is("END"):
+			depth--
+		case depth > 0:
+		case isAnyOf(t, booleanKeywords):
+			return true
+		case t.is("=") || t.is("!"):
+			return true
+		case t.is("<") || t.is(">"):
+			// Not the shift operators << and >>, nor the JSON operators -> and ->>
+			nextAdjacent := i+1 < len(expression) && t.pos+len(t.text) == expression[i+1].pos
+			previousAdjacent := i > 0 && expression[i-1].pos+len(expression[i-1].text) == t.pos
+			shift := (nextAdjacent && expression[i+1].is(t.text)) ||
+				(previousAdjacent && (expression[i-1].is(t.text) || expression[i-1].is("-")))
+			if !shift {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isAlias reports whether the tokens are empty or an alias, such as AS name.
+func isAlias(tokens []token) bool {
+	i := nextSignificant(tokens, -1)
+	if i >= 0 && tokens[i].is("AS") {
+		i = nextSignificant(tokens, i)
+	}
+	if i < 0 {
+		return true
+	}
+
+	return (isIdentifierToken(tokens[i]) || tokens[i].kind == tokenString) && nextSignificant(tokens, i) < 0
+}
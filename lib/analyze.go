@@ -0,0 +1,255 @@
+package sqlrunner
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"unsafe"
+
+	"go.opentelemetry.io/otel/codes"
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// AnalyzeResult is the result of ExplainAnalyze.
+type AnalyzeResult struct {
+	// Plan is the query plan, as in EXPLAIN QUERY PLAN.
+	Plan []PlanStep `json:"plan"`
+	// ResultRows is the number of the rows the query returned.
+	ResultRows int `json:"result_rows"`
+	// FullScanSteps is the number of the steps of the full table scans,
+	// which is about the number of the rows they examined.
+	FullScanSteps int64 `json:"full_scan_steps"`
+	// VMSteps is the number of the virtual machine operations, which is a
+	// measure of the total work of the query.
+	VMSteps int64 `json:"vm_steps"`
+	// Sorts is the number of the sort operations, which ORDER BY and GROUP
+	// BY need without an index in their order.
+	Sorts int64 `json:"sorts"`
+	// AutoIndexRows is the number of the rows inserted into the automatic
+	// indexes built for the query.
+	AutoIndexRows int64 `json:"auto_index_rows"`
+}
+
+// PlanStep is a step of a query plan. The steps form a tree by Parent,
+// which is 0 for the top-level steps.
+type PlanStep struct {
+	ID     int64  `json:"id"`
+	Parent int64  `json:"parent"`
+	Detail string `json:"detail"`
+}
+
+// errAnalyzeUnsupported is returned by ExplainAnalyze if the statement
+// counters of the driver are unavailable.
+var errAnalyzeUnsupported = errors.New("analyze is not supported by this version of the sqlite driver")
+
+// ExplainAnalyze executes a single-statement query, bypassing the cache, and
+// returns its plan with the counters of its execution, such as to show in a
+// lesson on performance tuning that a filter on an indexed column examines
+// fewer rows than a full table scan.
+//
+// SQLite only counts the work of the whole statement, so the counters are
+// not broken down by the steps of the plan. It returns the errors of Query,
+// and ErrMultipleStatements for a query with more than one statement.
+func (r *SQLRunner) ExplainAnalyze(ctx context.Context, query string, opts ...QueryOption) (*AnalyzeResult, error) {
+	ctx, span := tracer.Start(ctx, "SQLRunner.ExplainAnalyze")
+	defer span.End()
+
+	queryOpts := newQueryOptions(opts)
+	if err := queryOpts.bindVariables(query); err != nil {
+		span.SetStatus(codes.Error, "parameter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	if err := r.options.checkPolicy(query); err != nil {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	if len(SplitStatements(query)) > 1 {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(ErrMultipleStatements)
+
+		return nil, ErrMultipleStatements
+	}
+
+	if translated, ok := translateCommand(query); ok {
+		query = translated
+	}
+	query, args, _, err := bindParams(query, queryOpts.params)
+	if err != nil {
+		span.SetStatus(codes.Error, "parameter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	rewrittenQuery, _ := r.options.rewrite(query)
+
+	span.AddEvent("limiter.acquire")
+	releaseSlot, err := r.options.limiter.acquire(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "limiter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	defer releaseSlot()
+
+	// Prevent the schema file from being invalidated during the query
+	unlockFiles := rlockSchemaFiles(baseSchemaHash(r.schemaHash))
+	defer unlockFiles()
+
+	db, release, err := r.getSqliteInstance(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "schema error")
+		span.RecordError(err)
+
+		return nil, fmt.Errorf("get schema: %w", err)
+	}
+	defer release()
+
+	plan, err := queryPlan(ctx, db, rewrittenQuery, args...)
+	if err != nil {
+		span.SetStatus(codes.Error, "query error")
+		span.RecordError(err)
+
+		return nil, NewQueryError(explainQueryError(err))
+	}
+
+	// The counters are read from the statement of the driver, so the query
+	// runs on a dedicated connection of the handle.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get connection: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	result := &AnalyzeResult{Plan: plan}
+	err = conn.Raw(func(driverConn any) error {
+		return analyzeStatement(ctx, driverConn, rewrittenQuery, args, result)
+	})
+	if errors.Is(err, errAnalyzeUnsupported) {
+		span.SetStatus(codes.Error, "unsupported")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "query error")
+		span.RecordError(err)
+
+		return nil, NewQueryError(explainQueryError(err))
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	return result, nil
+}
+
+// queryPlan returns the steps of the plan of the query.
+func queryPlan(ctx context.Context, db queryer, query string, args ...any) ([]PlanStep, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	plan := []PlanStep{}
+	for rows.Next() {
+		var step PlanStep
+		var notUsed int64
+		if err := rows.Scan(&step.ID, &step.Parent, &notUsed, &step.Detail); err != nil {
+			return nil, fmt.Errorf("scan query plan: %w", err)
+		}
+		plan = append(plan, step)
+	}
+
+	return plan, rows.Err()
+}
+
+// analyzeStatement executes the query on the connection of the driver,
+// discarding its rows, and fills the counters of the result from its
+// statement before it is finalized.
+func analyzeStatement(ctx context.Context, driverConn any, query string, args []any, result *AnalyzeResult) error {
+	queryer, ok := driverConn.(driver.QueryerContext)
+	if !ok {
+		return errAnalyzeUnsupported
+	}
+
+	namedArgs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		value, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return fmt.Errorf("convert parameter: %w", err)
+		}
+		namedArgs[i] = driver.NamedValue{Ordinal: i + 1, Value: value}
+	}
+
+	rows, err := queryer.QueryContext(ctx, query, namedArgs)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	dest := make([]driver.Value, len(rows.Columns()))
+	for {
+		err := rows.Next(dest)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		result.ResultRows++
+	}
+
+	tls, stmt, err := driverStatement(rows)
+	if err != nil {
+		return err
+	}
+	status := func(op int32) int64 {
+		return int64(sqlite3.Xsqlite3_stmt_status(tls, stmt, op, 0))
+	}
+	result.FullScanSteps = status(sqlite3.SQLITE_STMTSTATUS_FULLSCAN_STEP)
+	result.VMSteps = status(sqlite3.SQLITE_STMTSTATUS_VM_STEP)
+	result.Sorts = status(sqlite3.SQLITE_STMTSTATUS_SORT)
+	result.AutoIndexRows = status(sqlite3.SQLITE_STMTSTATUS_AUTOINDEX)
+
+	return nil
+}
+
+// driverStatement returns the thread-local storage of the connection and
+// the handle of the statement of the rows of the modernc.org/sqlite driver,
+// which does not expose sqlite3_stmt_status. They are read from the
+// unexported fields of the rows, so it returns errAnalyzeUnsupported if
+// another version of the driver lays them out differently.
+func driverStatement(rows driver.Rows) (*libc.TLS, uintptr, error) {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, 0, errAnalyzeUnsupported
+	}
+
+	stmt := v.Elem().FieldByName("pstmt")
+	conn := v.Elem().FieldByName("c")
+	if stmt.Kind() != reflect.Uintptr || conn.Kind() != reflect.Pointer || conn.Elem().Kind() != reflect.Struct {
+		return nil, 0, errAnalyzeUnsupported
+	}
+
+	tls := conn.Elem().FieldByName("tls")
+	if !tls.IsValid() || tls.Type() != reflect.TypeFor[*libc.TLS]() {
+		return nil, 0, errAnalyzeUnsupported
+	}
+
+	return *(**libc.TLS)(unsafe.Pointer(tls.UnsafeAddr())), uintptr(stmt.Uint()), nil
+}
@@ -0,0 +1,137 @@
+package sqlrunner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// MaxBenchmarkRuns is the maximum number of runs of Benchmark, which holds
+// a slot of the concurrency limit for all of them.
+const MaxBenchmarkRuns = 100
+
+// BenchmarkResult is the result of Benchmark.
+type BenchmarkResult struct {
+	// Result is the result of the first run.
+	Result *QueryResult
+	// Runs is the number of runs.
+	Runs int
+	// Min, Median, P95, and Max are the statistics of the execution
+	// durations of the runs, by the nearest-rank method.
+	Min, Median, P95, Max time.Duration
+}
+
+// Benchmark executes the query runs times, bypassing the cache, and returns
+// the result of the first run with the statistics of the execution
+// durations, such as to compare the plans of the queries in a lesson on
+// performance tuning. The duration of a run excludes waiting for the
+// concurrency limit and opening the database.
+//
+// In the writable mode, each run is on a fresh copy of the database, so the
+// runs of a modification affect the same rows. It returns the errors of
+// Query, and an error if runs is not between 1 and MaxBenchmarkRuns.
+func (r *SQLRunner) Benchmark(ctx context.Context, query string, runs int, opts ...QueryOption) (*BenchmarkResult, error) {
+	ctx, span := tracer.Start(ctx, "SQLRunner.Benchmark")
+	defer span.End()
+
+	if runs < 1 || runs > MaxBenchmarkRuns {
+		return nil, fmt.Errorf("the number of runs must be between 1 and %d: %d", MaxBenchmarkRuns, runs)
+	}
+
+	queryOpts := newQueryOptions(opts)
+	if err := queryOpts.bindVariables(query); err != nil {
+		span.SetStatus(codes.Error, "parameter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	if err := r.options.checkPolicy(query); err != nil {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	if err := r.options.checkSingleStatement(query); err != nil {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	span.AddEvent("limiter.acquire")
+	releaseSlot, err := r.options.limiter.acquire(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "limiter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	defer releaseSlot()
+
+	// Prevent the schema file from being invalidated during the runs
+	unlockFiles := rlockSchemaFiles(baseSchemaHash(r.schemaHash))
+	defer unlockFiles()
+
+	benchmark := &BenchmarkResult{Runs: runs}
+	durations := make([]time.Duration, 0, runs)
+	for range runs {
+		result, duration, err := r.benchmarkRun(ctx, query, queryOpts)
+		if err != nil {
+			span.SetStatus(codes.Error, "run error")
+			span.RecordError(err)
+
+			return nil, err
+		}
+
+		if benchmark.Result == nil {
+			result.CacheStatus = r.executionStatus()
+			benchmark.Result = result
+		}
+		durations = append(durations, duration)
+	}
+
+	slices.Sort(durations)
+	benchmark.Min = durations[0]
+	benchmark.Median = nearestRank(durations, 50)
+	benchmark.P95 = nearestRank(durations, 95)
+	benchmark.Max = durations[len(durations)-1]
+
+	span.SetStatus(codes.Ok, "success")
+	return benchmark, nil
+}
+
+// benchmarkRun executes the query once, and returns its result with the
+// duration of its execution. The caller must hold rlockSchemaFiles.
+func (r *SQLRunner) benchmarkRun(ctx context.Context, query string, queryOpts queryOptions) (*QueryResult, time.Duration, error) {
+	db, release, err := r.getSqliteInstance(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get schema: %w", err)
+	}
+	defer release()
+
+	start := time.Now()
+	var result *QueryResult
+	if isModification(query) && !returnsRows(query) {
+		result, err = r.exec(ctx, db, query, queryOpts)
+	} else {
+		result, err = r.query(ctx, db, query, queryOpts)
+	}
+	duration := time.Since(start)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return result, duration, nil
+}
+
+// nearestRank returns the p-th percentile of the sorted durations by the
+// nearest-rank method, which is the smallest duration not less than p
+// percent of the durations.
+func nearestRank(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
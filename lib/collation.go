@@ -0,0 +1,145 @@
+package sqlrunner
+
+import (
+	"strings"
+)
+
+// tableConstraintKeywords are the keywords a table constraint starts with.
+var tableConstraintKeywords = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN"}
+
+// columnConstraintKeywords are the keywords ending the type of a column definition.
+var columnConstraintKeywords = []string{
+	"CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK", "DEFAULT",
+	"COLLATE", "REFERENCES", "GENERATED", "AS",
+}
+
+// applyDefaultCollation adds "COLLATE collation" to the definitions of the
+// text columns without a COLLATE clause in the CREATE TABLE statements of
+// the schema.
+func applyDefaultCollation(schema, collation string) string {
+	tokens := tokenize(schema)
+
+	// insertAt is the set of the token indexes to insert the clause after
+	insertAt := map[int]bool{}
+	for i := range tokens {
+		if !tokens[i].is("TABLE") || !isCreateStatement(tokens, i) {
+			continue
+		}
+
+		// Find the opening parenthesis of the column definitions. A CREATE
+		// TABLE ... AS SELECT statement has no column definitions.
+		open := -1
+		for j := nextSignificant(tokens, i); j >= 0; j = nextSignificant(tokens, j) {
+			if tokens[j].is("(") {
+				open = j
+				break
+			}
+			if tokens[j].is("AS") || tokens[j].kind == tokenSemicolon {
+				break
+			}
+		}
+		if open < 0 {
+			continue
+		}
+
+		for _, def := range splitDefinitions(tokens, open) {
+			if last, ok := textColumnWithoutCollation(tokens, def); ok {
+				insertAt[last] = true
+			}
+		}
+	}
+
+	if len(insertAt) == 0 {
+		return schema
+	}
+
+	var b strings.Builder
+	for i, t := range tokens {
+		b.WriteString(t.text)
+		if insertAt[i] {
+			b.WriteString(" COLLATE ")
+			b.WriteString(collation)
+		}
+	}
+
+	return b.String()
+}
+
+// splitDefinitions returns the significant token indexes of each comma-separated
+// definition in the parentheses opened by the token at open.
+func splitDefinitions(tokens []token, open int) [][]int {
+	var defs [][]int
+	var def []int
+
+	depth := 0
+	for j := nextSignificant(tokens, open); j >= 0; j = nextSignificant(tokens, j) {
+		t := tokens[j]
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")") && depth == 0:
+			return append(defs, def)
+		case t.is(")"):
+			depth--
+		case t.is(",") && depth == 0:
+			defs = append(defs, def)
+			def = nil
+			continue
+		}
+
+		def = append(def, j)
+	}
+
+	return append(defs, def)
+}
+
+// textColumnWithoutCollation reports whether the definition is of a column
+// with the TEXT affinity and without a COLLATE clause, and returns the index
+// of its last token.
+func textColumnWithoutCollation(tokens []token, def []int) (last int, ok bool) {
+	if len(def) < 2 || isAnyKeyword(tokens[def[0]], tableConstraintKeywords) {
+		return 0, false
+	}
+
+	// The type is the words following the column name
+	var declType []string
+	for _, j := range def[1:] {
+		if tokens[j].kind != tokenIdentifier || isAnyKeyword(tokens[j], columnConstraintKeywords) {
+			break
+		}
+		declType = append(declType, tokens[j].text)
+	}
+	if !hasTextAffinity(strings.Join(declType, " ")) {
+		return 0, false
+	}
+
+	for _, j := range def {
+		if tokens[j].is("COLLATE") {
+			return 0, false
+		}
+	}
+
+	return def[len(def)-1], true
+}
+
+// hasTextAffinity reports whether the declared column type has the TEXT
+// affinity, according to https://www.sqlite.org/datatype3.html#determination_of_column_affinity.
+func hasTextAffinity(declType string) bool {
+	declType = strings.ToUpper(declType)
+	if strings.Contains(declType, "INT") {
+		return false
+	}
+
+	return strings.Contains(declType, "CHAR") || strings.Contains(declType, "CLOB") || strings.Contains(declType, "TEXT")
+}
+
+// isAnyKeyword reports whether the token is any of the keywords.
+func isAnyKeyword(t token, keywords []string) bool {
+	for _, keyword := range keywords {
+		if t.kind == tokenIdentifier && t.is(keyword) {
+			return true
+		}
+	}
+
+	return false
+}
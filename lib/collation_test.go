@@ -0,0 +1,43 @@
+package sqlrunner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyDefaultCollation(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		schema   string
+		expected string
+	}{
+		"Text columns": {
+			schema:   "CREATE TABLE a (id INT, name TEXT, code VARCHAR(10) NOT NULL, note CLOB)",
+			expected: "CREATE TABLE a (id INT, name TEXT COLLATE NOCASE, code VARCHAR(10) NOT NULL COLLATE NOCASE, note CLOB COLLATE NOCASE)",
+		},
+		"Explicit collation": {
+			schema:   "CREATE TABLE a (name TEXT COLLATE BINARY, other TEXT)",
+			expected: "CREATE TABLE a (name TEXT COLLATE BINARY, other TEXT COLLATE NOCASE)",
+		},
+		"Table constraints": {
+			schema:   "CREATE TABLE IF NOT EXISTS a (name TEXT, PRIMARY KEY (name), CHECK (name <> ''))",
+			expected: "CREATE TABLE IF NOT EXISTS a (name TEXT COLLATE NOCASE, PRIMARY KEY (name), CHECK (name <> ''))",
+		},
+		"Multiple tables": {
+			schema:   "CREATE TABLE a (x TEXT);\nINSERT INTO a VALUES ('TEXT');\nCREATE TEMP TABLE b (y CHARACTER(2) DEFAULT 'a' -- comment\n);",
+			expected: "CREATE TABLE a (x TEXT COLLATE NOCASE);\nINSERT INTO a VALUES ('TEXT');\nCREATE TEMP TABLE b (y CHARACTER(2) DEFAULT 'a' COLLATE NOCASE -- comment\n);",
+		},
+		"No text columns": {
+			schema:   "CREATE TABLE a (id INTEGER, score REAL, data BLOB, untyped); CREATE TABLE b AS SELECT 'x' AS text;",
+			expected: "CREATE TABLE a (id INTEGER, score REAL, data BLOB, untyped); CREATE TABLE b AS SELECT 'x' AS text;",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, applyDefaultCollation(tc.schema, "NOCASE"))
+		})
+	}
+}
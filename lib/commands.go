@@ -0,0 +1,127 @@
+package sqlrunner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// translateCommand translates the MySQL commands SQLite does not support,
+// such as DESCRIBE, to the equivalent SQLite queries.
+//
+// It reports false if the query is not such a command.
+func translateCommand(query string) (string, bool) {
+	words := commandWords(query)
+
+	switch {
+	// SHOW TABLES
+	case len(words) == 2 && words[0].is("SHOW") && words[1].is("TABLES"):
+		return showTablesQuery, true
+	// DESCRIBE table, DESC table
+	case len(words) == 2 && (words[0].is("DESCRIBE") || words[0].is("DESC")) && isIdentifierToken(words[1]):
+		return describeTable(unquoteIdentifier(words[1])), true
+	// SHOW COLUMNS FROM table, SHOW FIELDS FROM table
+	case len(words) == 4 && words[0].is("SHOW") && (words[1].is("COLUMNS") || words[1].is("FIELDS")) &&
+		(words[2].is("FROM") || words[2].is("IN")) && isIdentifierToken(words[3]):
+		return describeTable(unquoteIdentifier(words[3])), true
+	}
+
+	return "", false
+}
+
+// truncatedTable returns the table of the MySQL TRUNCATE [TABLE] command,
+// which SQLite does not support. It reports false if the statement is not
+// such a command.
+func truncatedTable(statement string) (string, bool) {
+	words := commandWords(statement)
+	if len(words) > 0 && words[0].is("TRUNCATE") {
+		words = words[1:]
+	} else {
+		return "", false
+	}
+	if len(words) > 0 && words[0].is("TABLE") {
+		words = words[1:]
+	}
+
+	if len(words) != 1 || !isIdentifierToken(words[0]) {
+		return "", false
+	}
+	return unquoteIdentifier(words[0]), true
+}
+
+// commandWords returns the tokens of the query other than whitespace and
+// comments, without the trailing semicolon, which is optional.
+func commandWords(query string) []token {
+	var words []token
+	for _, t := range tokenize(query) {
+		if t.kind == tokenWhitespace || t.kind == tokenComment {
+			continue
+		}
+		words = append(words, t)
+	}
+
+	if len(words) > 0 && words[len(words)-1].kind == tokenSemicolon {
+		words = words[:len(words)-1]
+	}
+
+	return words
+}
+
+// showTablesQuery lists the tables and views except the internal tables of
+// SQLite, such as sqlite_stat1, in the column layout of the MySQL SHOW TABLES
+// command. The database of SQLite is named "main".
+const showTablesQuery = `SELECT name AS "Tables_in_main"
+FROM sqlite_master
+WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+ORDER BY name`
+
+// describeTable returns the query describing the columns of the table
+// in the column layout of the MySQL DESCRIBE command.
+func describeTable(table string) string {
+	return fmt.Sprintf(`SELECT
+	name AS "Field",
+	type AS "Type",
+	CASE WHEN "notnull" OR pk > 0 THEN 'NO' ELSE 'YES' END AS "Null",
+	CASE WHEN pk > 0 THEN 'PRI' ELSE '' END AS "Key",
+	dflt_value AS "Default",
+	'' AS "Extra"
+FROM pragma_table_info(%s)
+ORDER BY cid`, quoteLiteral(table))
+}
+
+// isIdentifierToken reports whether the token is a bare or quoted identifier.
+func isIdentifierToken(t token) bool {
+	return t.kind == tokenIdentifier || t.kind == tokenQuotedIdentifier
+}
+
+// unquoteIdentifier returns the name of a bare or quoted identifier.
+func unquoteIdentifier(t token) string {
+	if t.kind != tokenQuotedIdentifier || len(t.text) < 2 {
+		return t.text
+	}
+
+	closing := t.text[0]
+	if closing == '[' {
+		closing = ']'
+	}
+	if t.text[len(t.text)-1] != closing {
+		// Unterminated identifier
+		return t.text[1:]
+	}
+
+	inner := t.text[1 : len(t.text)-1]
+	if closing == ']' {
+		return inner
+	}
+
+	return strings.ReplaceAll(inner, string(closing)+string(closing), string(closing))
+}
+
+// quoteIdentifier returns s as an SQLite quoted identifier.
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteLiteral returns s as an SQLite string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,105 @@
+package sqlrunner_test
+
+import (
+	"context"
+	"testing"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribe(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE describetest (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			score REAL DEFAULT 0
+		);
+
+		CREATE TABLE "describe test" (
+			value TEXT
+		);
+	`)
+	require.NoError(t, err)
+
+	for _, query := range []string{
+		"DESCRIBE describetest",
+		"desc describetest;",
+		"SHOW COLUMNS FROM describetest",
+		"show fields in `describetest`",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"Field", "Type", "Null", "Key", "Default", "Extra"}, result.Columns)
+			assert.Equal(t, [][]string{
+				{"id", "INTEGER", "NO", "PRI", "NULL", ""},
+				{"name", "TEXT", "NO", "", "NULL", ""},
+				{"score", "REAL", "YES", "", "0", ""},
+			}, result.Rows)
+			assert.Empty(t, result.Warnings)
+		})
+	}
+
+	t.Run("Quoted name", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), `DESCRIBE "describe test"`)
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"value", "TEXT", "YES", "", "NULL", ""}}, result.Rows)
+	})
+
+	t.Run("QueryMulti", func(t *testing.T) {
+		t.Parallel()
+
+		results, err := runner.QueryMulti(context.TODO(), "DESCRIBE describetest; SELECT 1")
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Len(t, results[0].Rows, 3)
+	})
+}
+
+func TestShowTables(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE showtablesusers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT
+		);
+		CREATE INDEX showtablesusers_name ON showtablesusers (name);
+
+		CREATE TABLE showtablesorders (
+			id INTEGER PRIMARY KEY,
+			user_id INT
+		);
+
+		INSERT INTO showtablesusers (name) VALUES ('a');
+	`)
+	require.NoError(t, err)
+
+	for _, query := range []string{"SHOW TABLES", "show tables;"} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"Tables_in_main"}, result.Columns)
+			// sqlite_sequence and sqlite_stat1 are excluded
+			assert.Equal(t, [][]string{{"showtablesorders"}, {"showtablesusers"}}, result.Rows)
+		})
+	}
+
+	t.Run("Internal tables exist", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT count(*) FROM sqlite_master WHERE name LIKE 'sqlite%'")
+		require.NoError(t, err)
+		assert.NotEqual(t, [][]string{{"0"}}, result.Rows)
+	})
+}
@@ -0,0 +1,203 @@
+package sqlrunner
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Relaxation is a relaxation of CompareResults, which ignores a kind of
+// difference between the cells of the results.
+type Relaxation string
+
+const (
+	// RelaxationTrimTrailingSpace ignores the trailing whitespace of the cells.
+	RelaxationTrimTrailingSpace Relaxation = "trim_trailing_space"
+	// RelaxationIgnoreCase compares the cells case-insensitively.
+	RelaxationIgnoreCase Relaxation = "ignore_case"
+)
+
+// relaxations are the relaxations in the order they are tried.
+var relaxations = []Relaxation{RelaxationTrimTrailingSpace, RelaxationIgnoreCase}
+
+// CompareOption configures CompareResults.
+type CompareOption func(*compareOptions)
+
+// compareOptions is the configuration of CompareResults.
+type compareOptions struct {
+	// ignoreRowOrder compares the rows as multisets.
+	ignoreRowOrder bool
+	// columns are the 0-based indexes of the columns each relaxation
+	// applies to. An empty set applies to all the columns.
+	columns map[Relaxation]map[int]bool
+}
+
+// IgnoreRowOrder makes CompareResults compare the rows regardless of their
+// order, such as for a query without ORDER BY. The duplicate rows still
+// count; see QueryResult.Distinct to ignore them.
+func IgnoreRowOrder() CompareOption {
+	return func(o *compareOptions) {
+		o.ignoreRowOrder = true
+	}
+}
+
+// TrimTrailingSpace allows CompareResults to ignore the trailing whitespace
+// of the cells of the columns at the 0-based indexes, or of all the columns
+// if none is given.
+func TrimTrailingSpace(columns ...int) CompareOption {
+	return relax(RelaxationTrimTrailingSpace, columns)
+}
+
+// IgnoreCase allows CompareResults to compare the cells of the columns at
+// the 0-based indexes case-insensitively, or of all the columns if none is
+// given.
+func IgnoreCase(columns ...int) CompareOption {
+	return relax(RelaxationIgnoreCase, columns)
+}
+
+// relax returns the option allowing the relaxation for the columns.
+func relax(relaxation Relaxation, columns []int) CompareOption {
+	return func(o *compareOptions) {
+		set := make(map[int]bool, len(columns))
+		for _, column := range columns {
+			set[column] = true
+		}
+		o.columns[relaxation] = set
+	}
+}
+
+// Comparison is the outcome of CompareResults.
+type Comparison struct {
+	// Match reports whether the results match, exactly or with
+	// the relaxations.
+	Match bool `json:"match"`
+	// Exact reports whether the results match without any relaxation.
+	Exact bool `json:"exact"`
+	// Relaxations are the fewest of the allowed relaxations which make the
+	// results match, for a "close but not exact" feedback. It is empty if
+	// they match exactly or do not match.
+	Relaxations []Relaxation `json:"relaxations,omitempty"`
+	// Reason describes the first difference if they do not match,
+	// which remains with all the allowed relaxations.
+	Reason string `json:"reason,omitempty"`
+}
+
+// CompareResults compares the rows of an actual result, such as the one of
+// a student's answer, with the expected result, such as the one of the
+// reference answer. The column names are not compared, since the answers
+// may alias the columns differently, but the numbers of the columns are.
+//
+// The results match exactly if the cells are equal. Otherwise, the
+// relaxations allowed by the options are tried, and the fewest of them
+// which make the results match are reported.
+func CompareResults(expected, actual *QueryResult, opts ...CompareOption) Comparison {
+	o := compareOptions{columns: make(map[Relaxation]map[int]bool)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if len(expected.Columns) != len(actual.Columns) {
+		return Comparison{Reason: fmt.Sprintf("expected %d columns, got %d", len(expected.Columns), len(actual.Columns))}
+	}
+	if len(expected.Rows) != len(actual.Rows) {
+		return Comparison{Reason: fmt.Sprintf("expected %d rows, got %d", len(expected.Rows), len(actual.Rows))}
+	}
+
+	reason := o.compareRows(expected.Rows, actual.Rows, nil)
+	if reason == "" {
+		return Comparison{Match: true, Exact: true}
+	}
+
+	var allowed []Relaxation
+	for _, relaxation := range relaxations {
+		if _, ok := o.columns[relaxation]; ok {
+			allowed = append(allowed, relaxation)
+		}
+	}
+
+	// Try the subsets of the allowed relaxations from the smallest
+	for size := 1; size <= len(allowed); size++ {
+		for _, subset := range combinations(allowed, size) {
+			if o.compareRows(expected.Rows, actual.Rows, subset) == "" {
+				return Comparison{Match: true, Relaxations: subset}
+			}
+		}
+	}
+
+	// The difference remaining with all the relaxations
+	if len(allowed) > 0 {
+		reason = o.compareRows(expected.Rows, actual.Rows, allowed)
+	}
+	return Comparison{Reason: reason}
+}
+
+// compareRows compares the rows with the relaxations, and returns the
+// description of the first difference, or empty if they match.
+func (o compareOptions) compareRows(expected, actual [][]string, relaxations []Relaxation) string {
+	normalize := func(rows [][]string) []string {
+		keys := make([]string, len(rows))
+		for i, row := range rows {
+			cells := make([]string, len(row))
+			for j, cell := range row {
+				cells[j] = o.normalize(cell, j, relaxations)
+			}
+			keys[i] = rowKey(cells)
+		}
+
+		if o.ignoreRowOrder {
+			slices.Sort(keys)
+		}
+		return keys
+	}
+
+	expectedKeys, actualKeys := normalize(expected), normalize(actual)
+	for i := range expectedKeys {
+		if expectedKeys[i] == actualKeys[i] {
+			continue
+		}
+
+		if o.ignoreRowOrder {
+			return "the rows differ regardless of their order"
+		}
+		return fmt.Sprintf("row %d differs: expected %q, got %q", i+1, expected[i], actual[i])
+	}
+
+	return ""
+}
+
+// normalize normalizes a cell of the column at the index with the
+// relaxations applying to the column.
+func (o compareOptions) normalize(cell string, column int, relaxations []Relaxation) string {
+	for _, relaxation := range relaxations {
+		if columns := o.columns[relaxation]; len(columns) > 0 && !columns[column] {
+			continue
+		}
+
+		switch relaxation {
+		case RelaxationTrimTrailingSpace:
+			cell = strings.TrimRightFunc(cell, unicode.IsSpace)
+		case RelaxationIgnoreCase:
+			cell = strings.ToLower(cell)
+		}
+	}
+
+	return cell
+}
+
+// combinations returns the combinations of the size of the elements,
+// in the order of the elements.
+func combinations[T any](elements []T, size int) [][]T {
+	if size == 0 {
+		return [][]T{nil}
+	}
+
+	var result [][]T
+	for i := range len(elements) - size + 1 {
+		for _, rest := range combinations(elements[i+1:], size-1) {
+			result = append(result, append([]T{elements[i]}, rest...))
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,104 @@
+package sqlrunner_test
+
+import (
+	"context"
+	"testing"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareResults(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE comparetest (
+			id INT,
+			name TEXT,
+			padded TEXT
+		);
+
+		INSERT INTO comparetest (id, name, padded) VALUES
+			(1, 'Alice', 'Alice  '),
+			(2, 'Bob', 'BOB ');
+	`)
+	require.NoError(t, err)
+
+	query := func(t *testing.T, query string) *sqlrunner.QueryResult {
+		t.Helper()
+
+		result, err := runner.Query(context.TODO(), query)
+		require.NoError(t, err)
+		return result
+	}
+
+	expected := query(t, "SELECT id, name FROM comparetest ORDER BY id")
+
+	t.Run("Exact", func(t *testing.T) {
+		t.Parallel()
+
+		comparison := sqlrunner.CompareResults(expected, query(t, "SELECT id AS n, name FROM comparetest ORDER BY id"), sqlrunner.TrimTrailingSpace())
+		assert.Equal(t, sqlrunner.Comparison{Match: true, Exact: true}, comparison)
+	})
+
+	t.Run("Trailing space", func(t *testing.T) {
+		t.Parallel()
+
+		actual := query(t, "SELECT id, padded FROM comparetest WHERE id = 1")
+		expected := query(t, "SELECT id, name FROM comparetest WHERE id = 1")
+
+		comparison := sqlrunner.CompareResults(expected, actual)
+		assert.False(t, comparison.Match)
+		assert.Equal(t, `row 1 differs: expected ["1" "Alice"], got ["1" "Alice  "]`, comparison.Reason)
+
+		comparison = sqlrunner.CompareResults(expected, actual, sqlrunner.TrimTrailingSpace(), sqlrunner.IgnoreCase())
+		assert.Equal(t, sqlrunner.Comparison{
+			Match:       true,
+			Relaxations: []sqlrunner.Relaxation{sqlrunner.RelaxationTrimTrailingSpace},
+		}, comparison)
+
+		// The relaxation applies to the other column only
+		comparison = sqlrunner.CompareResults(expected, actual, sqlrunner.TrimTrailingSpace(0))
+		assert.False(t, comparison.Match)
+	})
+
+	t.Run("Both", func(t *testing.T) {
+		t.Parallel()
+
+		actual := query(t, "SELECT id, padded FROM comparetest ORDER BY id")
+
+		comparison := sqlrunner.CompareResults(expected, actual, sqlrunner.TrimTrailingSpace(1))
+		assert.False(t, comparison.Match)
+		assert.Equal(t, `row 2 differs: expected ["2" "Bob"], got ["2" "BOB "]`, comparison.Reason)
+
+		comparison = sqlrunner.CompareResults(expected, actual, sqlrunner.TrimTrailingSpace(1), sqlrunner.IgnoreCase(1))
+		assert.Equal(t, sqlrunner.Comparison{
+			Match:       true,
+			Relaxations: []sqlrunner.Relaxation{sqlrunner.RelaxationTrimTrailingSpace, sqlrunner.RelaxationIgnoreCase},
+		}, comparison)
+	})
+
+	t.Run("Row order", func(t *testing.T) {
+		t.Parallel()
+
+		actual := query(t, "SELECT id, name FROM comparetest ORDER BY id DESC")
+
+		assert.False(t, sqlrunner.CompareResults(expected, actual).Match)
+		assert.Equal(t, sqlrunner.Comparison{Match: true, Exact: true}, sqlrunner.CompareResults(expected, actual, sqlrunner.IgnoreRowOrder()))
+
+		actual = query(t, "SELECT id, padded FROM comparetest ORDER BY id DESC")
+		comparison := sqlrunner.CompareResults(expected, actual, sqlrunner.IgnoreRowOrder(), sqlrunner.TrimTrailingSpace())
+		assert.Equal(t, "the rows differ regardless of their order", comparison.Reason)
+	})
+
+	t.Run("Shape", func(t *testing.T) {
+		t.Parallel()
+
+		comparison := sqlrunner.CompareResults(expected, query(t, "SELECT id FROM comparetest ORDER BY id"))
+		assert.Equal(t, sqlrunner.Comparison{Reason: "expected 2 columns, got 1"}, comparison)
+
+		comparison = sqlrunner.CompareResults(expected, query(t, "SELECT id, name FROM comparetest WHERE id = 1"))
+		assert.Equal(t, sqlrunner.Comparison{Reason: "expected 2 rows, got 1"}, comparison)
+	})
+}
@@ -0,0 +1,264 @@
+package sqlrunner
+
+import (
+	"strings"
+)
+
+// intervalUnits are the MySQL interval units supported by DATE_ADD and DATE_SUB.
+var intervalUnits = []string{"SECOND", "MINUTE", "HOUR", "DAY", "WEEK", "MONTH", "QUARTER", "YEAR"}
+
+// operandBoundaries are the tokens which may precede the date operand of
+// `date + INTERVAL n UNIT`. The operators binding tighter than + are excluded,
+// so that `a * d + INTERVAL 1 DAY` is not rewritten.
+var operandBoundaries = []string{
+	"(", ",", "=", "<", ">",
+	"SELECT", "DISTINCT", "WHERE", "AND", "OR", "ON", "WHEN", "THEN", "ELSE",
+	"BETWEEN", "BY", "HAVING", "SET", "VALUES", "RETURNING",
+}
+
+// maxDateArithmeticRewrites bounds the rewrites of a query.
+const maxDateArithmeticRewrites = 100
+
+// RewriteMySQLDateArithmetic rewrites the MySQL date arithmetic, such as
+// `d + INTERVAL 7 DAY` and `DATE_SUB(d, INTERVAL 1 MONTH)`, to the DATE_ADD
+// and DATE_SUB functions of this package, such as `DATE_ADD(d, 7, 'DAY')`.
+//
+// It is conservative: the date must be a column, a string, or a function
+// call, and the amount a number or a column; the other expressions are left
+// as is. WithMySQLDateArithmetic applies it to the queries of a runner.
+func RewriteMySQLDateArithmetic(query string) string {
+	rewritten, _ := rewriteDateArithmetic(query)
+	return rewritten
+}
+
+// rewriteDateArithmetic rewrites the MySQL date arithmetic of the query.
+// It also returns the pairs of the rewritten and the original expressions
+// in the order of the rewrites.
+func rewriteDateArithmetic(query string) (rewritten string, replacements []string) {
+	for range maxDateArithmeticRewrites {
+		start, end, replacement, ok := findDateArithmetic(tokenize(query))
+		if !ok {
+			break
+		}
+
+		replacements = append(replacements, replacement, query[start:end])
+		query = query[:start] + replacement + query[end:]
+	}
+
+	return query, replacements
+}
+
+// findDateArithmetic finds the first date arithmetic to rewrite, and returns
+// its byte range in the query and its replacement.
+func findDateArithmetic(tokens []token) (start, end int, replacement string, ok bool) {
+	for i, t := range tokens {
+		if !t.is("INTERVAL") {
+			continue
+		}
+
+		amount, unit, ok := parseInterval(tokens, i)
+		if !ok {
+			continue
+		}
+		amountText := significantText(tokens, amount)
+		unitName := strings.ToUpper(tokens[unit].text)
+		intervalEnd := tokens[unit].pos + len(tokens[unit].text)
+
+		op := previousSignificant(tokens, i)
+		if op < 0 {
+			continue
+		}
+
+		switch {
+		// DATE_ADD(d, INTERVAL n UNIT)
+		case tokens[op].is(","):
+			call := enclosingCall(tokens, op)
+			if call < 0 || !(tokens[call].is("DATE_ADD") || tokens[call].is("DATE_SUB")) {
+				continue
+			}
+			if next := nextSignificant(tokens, unit); next < 0 || !tokens[next].is(")") {
+				continue
+			}
+
+			return t.pos, intervalEnd, amountText + ", " + quoteLiteral(unitName), true
+		// d + INTERVAL n UNIT, d - INTERVAL n UNIT
+		case tokens[op].is("+") || tokens[op].is("-"):
+			operand := operandStart(tokens, previousSignificant(tokens, op))
+			if operand < 0 {
+				continue
+			}
+			if next := nextSignificant(tokens, unit); next >= 0 && !isDateArithmeticEnd(tokens[next]) {
+				continue
+			}
+
+			function := "DATE_ADD"
+			if tokens[op].is("-") {
+				function = "DATE_SUB"
+			}
+
+			operandText := strings.TrimSpace(joinText(tokens, operand, op))
+			return tokens[operand].pos, intervalEnd,
+				function + "(" + operandText + ", " + amountText + ", " + quoteLiteral(unitName) + ")", true
+		}
+	}
+
+	return 0, 0, "", false
+}
+
+// parseInterval parses `INTERVAL n UNIT` starting at the INTERVAL token,
+// and returns the token indexes of the amount and the unit.
+func parseInterval(tokens []token, i int) (amount []int, unit int, ok bool) {
+	j := nextSignificant(tokens, i)
+	if j < 0 {
+		return nil, 0, false
+	}
+
+	// An optional sign
+	if tokens[j].is("-") || tokens[j].is("+") {
+		amount = append(amount, j)
+		if j = nextSignificant(tokens, j); j < 0 || tokens[j].kind != tokenNumber {
+			return nil, 0, false
+		}
+	}
+
+	switch tokens[j].kind {
+	case tokenNumber, tokenIdentifier, tokenQuotedIdentifier:
+		amount = append(amount, j)
+	default:
+		return nil, 0, false
+	}
+
+	unit = nextSignificant(tokens, j)
+	if unit < 0 || !isAnyKeyword(tokens[unit], intervalUnits) {
+		return nil, 0, false
+	}
+
+	return amount, unit, true
+}
+
+// operandStart returns the index of the first token of the date operand
+// ending at the token at end, or -1 if the operand is not simple enough.
+func operandStart(tokens []token, end int) int {
+	if end < 0 {
+		return -1
+	}
+
+	start := end
+	switch t := tokens[end]; {
+	case t.kind == tokenString:
+	case t.kind == tokenIdentifier || t.kind == tokenQuotedIdentifier:
+		// A qualified name, such as t.d
+		for {
+			dot := previousSignificant(tokens, start)
+			if dot < 0 || !tokens[dot].is(".") {
+				break
+			}
+			name := previousSignificant(tokens, dot)
+			if name < 0 || !isIdentifierToken(tokens[name]) {
+				return -1
+			}
+			start = name
+		}
+	case t.is(")"):
+		// A function call, such as DATE(d)
+		open := matchingOpen(tokens, end)
+		if open < 0 {
+			return -1
+		}
+		name := previousSignificant(tokens, open)
+		if name < 0 || tokens[name].kind != tokenIdentifier || isAnyKeyword(tokens[name], operandBoundaries) {
+			return -1
+		}
+		start = name
+	default:
+		return -1
+	}
+
+	if prev := previousSignificant(tokens, start); prev >= 0 && !isAnyOf(tokens[prev], operandBoundaries) {
+		return -1
+	}
+
+	return start
+}
+
+// isDateArithmeticEnd reports whether the token may follow a rewritten
+// date arithmetic, which excludes the operators binding tighter than +.
+func isDateArithmeticEnd(t token) bool {
+	switch {
+	case t.is("*"), t.is("/"), t.is("%"), t.is("|"), t.is("&"), t.is("."):
+		return false
+	default:
+		return true
+	}
+}
+
+// enclosingCall returns the index of the function name whose parentheses
+// enclose the token at i, or -1 if there is none.
+func enclosingCall(tokens []token, i int) int {
+	depth := 0
+	for j := i - 1; j >= 0; j-- {
+		switch {
+		case tokens[j].is(")"):
+			depth++
+		case tokens[j].is("(") && depth > 0:
+			depth--
+		case tokens[j].is("("):
+			name := previousSignificant(tokens, j)
+			if name < 0 || tokens[name].kind != tokenIdentifier {
+				return -1
+			}
+			return name
+		}
+	}
+
+	return -1
+}
+
+// matchingOpen returns the index of the parenthesis opening the one at close.
+func matchingOpen(tokens []token, close int) int {
+	depth := 0
+	for j := close; j >= 0; j-- {
+		switch {
+		case tokens[j].is(")"):
+			depth++
+		case tokens[j].is("("):
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+
+	return -1
+}
+
+// isAnyOf reports whether the token is any of the keywords or punctuation.
+func isAnyOf(t token, texts []string) bool {
+	for _, text := range texts {
+		if t.is(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// significantText joins the text of the tokens at the indexes.
+func significantText(tokens []token, indexes []int) string {
+	var b strings.Builder
+	for _, i := range indexes {
+		b.WriteString(tokens[i].text)
+	}
+
+	return b.String()
+}
+
+// joinText joins the text of the tokens in [start, end).
+func joinText(tokens []token, start, end int) string {
+	var b strings.Builder
+	for _, t := range tokens[start:end] {
+		b.WriteString(t.text)
+	}
+
+	return b.String()
+}
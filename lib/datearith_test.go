@@ -0,0 +1,37 @@
+package sqlrunner_test
+
+import (
+	"testing"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteMySQLDateArithmetic(t *testing.T) {
+	t.Parallel()
+
+	for query, expected := range map[string]string{
+		"SELECT d + INTERVAL 7 DAY FROM t":                     "SELECT DATE_ADD(d, 7, 'DAY') FROM t",
+		"SELECT t.d - interval 1 month FROM t":                 "SELECT DATE_SUB(t.d, 1, 'MONTH') FROM t",
+		"SELECT '2024-01-31' + INTERVAL -2 HOUR":               "SELECT DATE_ADD('2024-01-31', -2, 'HOUR')",
+		"SELECT DATE(d) + INTERVAL n WEEK FROM t":              "SELECT DATE_ADD(DATE(d), n, 'WEEK') FROM t",
+		"SELECT d + INTERVAL 1 DAY + INTERVAL 2 HOUR FROM t":   "SELECT DATE_ADD(DATE_ADD(d, 1, 'DAY'), 2, 'HOUR') FROM t",
+		"SELECT * FROM t WHERE d < NOW() - INTERVAL 1 YEAR":    "SELECT * FROM t WHERE d < DATE_SUB(NOW(), 1, 'YEAR')",
+		"SELECT DATE_ADD(d, INTERVAL 3 QUARTER) FROM t":        "SELECT DATE_ADD(d, 3, 'QUARTER') FROM t",
+		"SELECT date_sub(d, INTERVAL 10 SECOND) FROM t":        "SELECT date_sub(d, 10, 'SECOND') FROM t",
+		"SELECT 'd + INTERVAL 7 DAY' FROM t":                   "SELECT 'd + INTERVAL 7 DAY' FROM t",
+		"SELECT a * d + INTERVAL 1 DAY FROM t":                 "SELECT a * d + INTERVAL 1 DAY FROM t",
+		"SELECT d + INTERVAL 1 + 1 DAY FROM t":                 "SELECT d + INTERVAL 1 + 1 DAY FROM t",
+		"SELECT d + INTERVAL 1 FORTNIGHT FROM t":               "SELECT d + INTERVAL 1 FORTNIGHT FROM t",
+		"SELECT (d + 1) + INTERVAL 1 DAY FROM t":               "SELECT (d + 1) + INTERVAL 1 DAY FROM t",
+		"SELECT d + INTERVAL 1 DAY * 2 FROM t":                 "SELECT d + INTERVAL 1 DAY * 2 FROM t",
+		"SELECT ADDTIME(d, INTERVAL 1 DAY) FROM t":             "SELECT ADDTIME(d, INTERVAL 1 DAY) FROM t",
+		"SELECT d + INTERVAL 1 DAY AS tomorrow FROM t -- note": "SELECT DATE_ADD(d, 1, 'DAY') AS tomorrow FROM t -- note",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, expected, sqlrunner.RewriteMySQLDateArithmetic(query))
+		})
+	}
+}
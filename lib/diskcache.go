@@ -0,0 +1,209 @@
+package sqlrunner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// diskCacheDir is the directory of the disk caches, which holds
+// a subdirectory of the cached results per schema hash.
+var diskCacheDir = filepath.Join(tmpDir, "cache")
+
+// diskCache is a cache of the query results of a schema on the disk, so the
+// results survive the restarts of the process. A nil diskCache caches nothing.
+type diskCache struct {
+	// dir is the directory of the cached results of the schema.
+	dir string
+	// maxBytes is the maximum total size of the cached results of the schema.
+	maxBytes int64
+	// ttl is the duration a cached result is valid for. Zero means forever.
+	ttl time.Duration
+}
+
+// diskCacheEntry is the content of a file of the disk cache.
+type diskCacheEntry struct {
+	// Checksum is the hex-encoded SHA-256 hash of Result,
+	// to ignore the truncated or corrupted files.
+	Checksum string          `json:"checksum"`
+	Result   json.RawMessage `json:"result"`
+}
+
+// diskCachedResult is the part of a QueryResult stored in the disk cache.
+type diskCachedResult struct {
+	Columns     []string `json:"columns"`
+	ColumnTypes []string `json:"column_types"`
+	// ColumnMetadata is empty in the results cached by the older versions
+	ColumnMetadata []ColumnMetadata `json:"column_metadata,omitempty"`
+	Rows           [][]string       `json:"rows"`
+	Warnings       []string         `json:"warnings,omitempty"`
+	RowsAffected   int64            `json:"rows_affected,omitempty"`
+	// StatementType is empty in the results cached by the older versions
+	StatementType StatementType `json:"statement_type,omitempty"`
+}
+
+// diskCacheDirname returns the directory of the cached results of the schema hash.
+func diskCacheDirname(schemaHash string) string {
+	return filepath.Join(diskCacheDir, schemaHash)
+}
+
+// filename returns the file of the cached result of the key.
+func (c *diskCache) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the cached result of the key, if it is intact and not expired.
+func (c *diskCache) get(key string) (*QueryResult, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	filename := c.filename(key)
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+		_ = os.Remove(filename)
+		return nil, false
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry diskCacheEntry
+	if err := json.Unmarshal(content, &entry); err != nil || entry.Checksum != checksum(entry.Result) {
+		_ = os.Remove(filename)
+		return nil, false
+	}
+
+	var result diskCachedResult
+	if err := json.Unmarshal(entry.Result, &result); err != nil {
+		_ = os.Remove(filename)
+		return nil, false
+	}
+
+	return &QueryResult{
+		Columns:        result.Columns,
+		ColumnTypes:    result.ColumnTypes,
+		ColumnMetadata: result.ColumnMetadata,
+		Rows:           result.Rows,
+		Warnings:       result.Warnings,
+		RowsAffected:   result.RowsAffected,
+		StatementType:  result.StatementType,
+	}, true
+}
+
+// add writes the result of the key, and removes the least recently written
+// results if the total size exceeds the limit. A result larger than the
+// limit is not written.
+func (c *diskCache) add(key string, result *QueryResult) error {
+	if c == nil {
+		return nil
+	}
+
+	resultJSON, err := json.Marshal(diskCachedResult{
+		Columns:        result.Columns,
+		ColumnTypes:    result.ColumnTypes,
+		ColumnMetadata: result.ColumnMetadata,
+		Rows:           result.Rows,
+		Warnings:       result.Warnings,
+		RowsAffected:   result.RowsAffected,
+		StatementType:  result.StatementType,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal result: %w", err)
+	}
+
+	content, err := json.Marshal(diskCacheEntry{
+		Checksum: checksum(resultJSON),
+		Result:   resultJSON,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal entry: %w", err)
+	}
+	if int64(len(content)) > c.maxBytes {
+		return nil
+	}
+
+	if err := makeDir(c.dir); err != nil {
+		return fmt.Errorf("create cache directory: %w", err)
+	}
+
+	// Write to a temporary file first so a reader never sees a partial file
+	file, err := createTempFile(c.dir, "*.tmp")
+	if err != nil {
+		return fmt.Errorf("create cache file: %w", err)
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.filename(key))
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("write cache file: %w", err)
+	}
+
+	return c.trim()
+}
+
+// trim removes the least recently written results until
+// the total size is within the limit.
+func (c *diskCache) trim() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("read cache directory: %w", err)
+	}
+
+	infos := make([]fs.FileInfo, 0, len(entries))
+	var total int64
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// Removed concurrently
+			continue
+		}
+		infos = append(infos, info)
+		total += info.Size()
+	}
+
+	slices.SortFunc(infos, func(a, b fs.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+	for _, info := range infos {
+		if total <= c.maxBytes {
+			break
+		}
+
+		err := os.Remove(filepath.Join(c.dir, info.Name()))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove cache file: %w", err)
+		}
+		total -= info.Size()
+	}
+
+	return nil
+}
+
+// checksum returns the hex-encoded SHA-256 hash of the content.
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
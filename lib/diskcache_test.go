@@ -0,0 +1,142 @@
+package sqlrunner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCache(t *testing.T) {
+	t.Parallel()
+
+	newRunner := func(t *testing.T, schema string, opts ...Option) *SQLRunner {
+		t.Helper()
+
+		runner, err := NewSQLRunner(schema, append([]Option{WithDiskCache(1<<20, time.Hour)}, opts...)...)
+		require.NoError(t, err)
+		return runner
+	}
+
+	t.Run("Served after recreating the runner", func(t *testing.T) {
+		t.Parallel()
+
+		schema := "CREATE TABLE diskcachetest1 (value REAL); INSERT INTO diskcachetest1 VALUES (1.5);"
+		query := "SELECT value FROM diskcachetest1"
+
+		// The disk cache survives the previous runs of the test
+		require.NoError(t, InvalidateSchema(schema))
+
+		result, err := newRunner(t, schema).Query(context.TODO(), query)
+		require.NoError(t, err)
+		assert.Equal(t, CacheCold, result.CacheStatus)
+
+		cached, err := newRunner(t, schema).Query(context.TODO(), query)
+		require.NoError(t, err)
+		assert.Equal(t, CacheDisk, cached.CacheStatus)
+		assert.True(t, cached.FromCache)
+		assert.Equal(t, result.Columns, cached.Columns)
+		assert.Equal(t, result.ColumnTypes, cached.ColumnTypes)
+		assert.Equal(t, result.Rows, cached.Rows)
+
+		// The options affecting the results do not share the cached results.
+		other, err := newRunner(t, schema, WithRealDecimals(2)).Query(context.TODO(), query)
+		require.NoError(t, err)
+		assert.Equal(t, CacheCold, other.CacheStatus)
+		assert.Equal(t, [][]string{{"1.50"}}, other.Rows)
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		t.Parallel()
+
+		schema := "CREATE TABLE diskcachetest2 (value TEXT); INSERT INTO diskcachetest2 VALUES ('hello');"
+		query := "SELECT value FROM diskcachetest2"
+
+		// The disk cache survives the previous runs of the test
+		require.NoError(t, InvalidateSchema(schema))
+
+		runner := newRunner(t, schema)
+		_, err := runner.Query(context.TODO(), query)
+		require.NoError(t, err)
+
+		filename := runner.diskCache.filename(runner.options.resultKey() + "\x00" + query)
+		require.NoError(t, os.WriteFile(filename, []byte(`{"checksum":"0","result":{"rows":[["forged"]]}}`), 0o644))
+
+		result, err := newRunner(t, schema).Query(context.TODO(), query)
+		require.NoError(t, err)
+		assert.Equal(t, CacheCold, result.CacheStatus)
+		assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+
+		schema := "CREATE TABLE diskcachetest3 (value TEXT);"
+		query := "SELECT value FROM diskcachetest3"
+
+		// The disk cache survives the previous runs of the test
+		require.NoError(t, InvalidateSchema(schema))
+
+		runner := newRunner(t, schema)
+		_, err := runner.Query(context.TODO(), query)
+		require.NoError(t, err)
+
+		filename := runner.diskCache.filename(runner.options.resultKey() + "\x00" + query)
+		past := time.Now().Add(-2 * time.Hour)
+		require.NoError(t, os.Chtimes(filename, past, past))
+
+		result, err := newRunner(t, schema).Query(context.TODO(), query)
+		require.NoError(t, err)
+		assert.Equal(t, CacheCold, result.CacheStatus)
+	})
+
+	t.Run("Size limit", func(t *testing.T) {
+		t.Parallel()
+
+		schema := "CREATE TABLE diskcachetest4 (value TEXT);"
+
+		// The disk cache survives the previous runs of the test
+		require.NoError(t, InvalidateSchema(schema))
+		runner, err := NewSQLRunner(schema, WithDiskCache(300, 0))
+		require.NoError(t, err)
+
+		for _, query := range []string{"SELECT 1", "SELECT 2", "SELECT 3", "SELECT 4"} {
+			_, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+		}
+
+		files, err := filepath.Glob(filepath.Join(runner.diskCache.dir, "*.json"))
+		require.NoError(t, err)
+		assert.NotEmpty(t, files)
+		assert.Less(t, len(files), 4)
+
+		var total int64
+		for _, file := range files {
+			info, err := os.Stat(file)
+			require.NoError(t, err)
+			total += info.Size()
+		}
+		assert.LessOrEqual(t, total, int64(300))
+	})
+
+	t.Run("Invalidated", func(t *testing.T) {
+		t.Parallel()
+
+		schema := "CREATE TABLE diskcachetest5 (value TEXT);"
+		query := "SELECT value FROM diskcachetest5"
+
+		// The disk cache survives the previous runs of the test
+		require.NoError(t, InvalidateSchema(schema))
+
+		runner := newRunner(t, schema)
+		_, err := runner.Query(context.TODO(), query)
+		require.NoError(t, err)
+
+		require.NoError(t, InvalidateSchema(schema))
+		assert.NoDirExists(t, runner.diskCache.dir)
+	})
+}
@@ -0,0 +1,254 @@
+package sqlrunner
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+)
+
+// strictDivisorFunction is the registered function the divisors are
+// wrapped in by WithStrictDivision, which fails for a zero divisor.
+const strictDivisorFunction = "STRICT_DIVISOR"
+
+// errDivisionByZero is the error of strictDivisorFunction. SQLite only
+// keeps its message, so the query fails with a QueryError rather than it.
+var errDivisionByZero = errors.New("division by zero")
+
+// strictDivisor returns the divisor as it is, so that SQLite divides as
+// usual, or fails with errDivisionByZero if it is zero. A string is zero
+// if it converts to 0 like MySQL, such as '0' and 'abc'.
+func strictDivisor(v driver.Value) (driver.Value, error) {
+	switch divisor := v.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		if divisor == 0 {
+			return nil, errDivisionByZero
+		}
+	case float64:
+		if divisor == 0 {
+			return nil, errDivisionByZero
+		}
+	default:
+		if toDouble(divisor) == 0 {
+			return nil, errDivisionByZero
+		}
+	}
+
+	return v, nil
+}
+
+// rewriteStrictDivision wraps the divisors of the /, %, and MOD in the query
+// in strictDivisorFunction, such as 1 / 0 to 1 / STRICT_DIVISOR(0). A divisor
+// which is not simple enough to be delimited by the tokens is left as is.
+func rewriteStrictDivision(query string) string {
+	tokens := tokenize(query)
+
+	// The tokens to insert the wrapper before and the closing parenthesis
+	// after, by the token index
+	opens := make(map[int]int)
+	closes := make(map[int]int)
+	for i, t := range tokens {
+		var start int
+		switch {
+		case t.is("/"), t.is("%"):
+			start = nextSignificant(tokens, i)
+		case t.kind == tokenIdentifier && t.is("MOD"):
+			start = modDivisorStart(tokens, i)
+		default:
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+
+		if end := divisorEnd(tokens, start); end >= 0 {
+			opens[start]++
+			closes[end]++
+		}
+	}
+
+	if len(opens) == 0 {
+		return query
+	}
+
+	var b strings.Builder
+	for i, t := range tokens {
+		for range opens[i] {
+			b.WriteString(strictDivisorFunction + "(")
+		}
+		b.WriteString(t.text)
+		for range closes[i] {
+			b.WriteString(")")
+		}
+	}
+
+	return b.String()
+}
+
+// modDivisorStart returns the index of the first token of the second
+// argument of the MOD call at i, or -1 if it is not a call of two arguments.
+func modDivisorStart(tokens []token, i int) int {
+	open := nextSignificant(tokens, i)
+	if open < 0 || !tokens[open].is("(") {
+		return -1
+	}
+
+	depth := 0
+	for j := open + 1; j < len(tokens); j++ {
+		switch {
+		case tokens[j].is("("):
+			depth++
+		case tokens[j].is(")"):
+			if depth == 0 {
+				return -1
+			}
+			depth--
+		case tokens[j].is(",") && depth == 0:
+			return nextSignificant(tokens, j)
+		}
+	}
+
+	return -1
+}
+
+// divisorEnd returns the index of the last token of the divisor starting
+// at start, which is the operand binding tighter than the division, or -1
+// if it is not simple enough: a literal, a column, a parameter, a function
+// call, a parenthesized expression, or a CASE expression, with the unary
+// operators, the || concatenations, and the COLLATE clauses.
+func divisorEnd(tokens []token, start int) int {
+	i := start
+	for i >= 0 && (tokens[i].is("-") || tokens[i].is("+") || tokens[i].is("~")) {
+		i = nextSignificant(tokens, i)
+	}
+	if i < 0 {
+		return -1
+	}
+
+	end := -1
+	switch t := tokens[i]; {
+	case t.is("("):
+		end = matchingClose(tokens, i)
+	case t.kind == tokenIdentifier && t.is("CASE"):
+		end = caseEnd(tokens, i)
+	case t.kind == tokenIdentifier && t.is("NOT"):
+		return -1
+	case t.kind == tokenNumber, t.kind == tokenString:
+		end = i
+	case t.is("?"), t.is(":"), t.is("@"):
+		// A parameter, such as ?1 or :name
+		end = i
+		if i+1 < len(tokens) && (tokens[i+1].kind == tokenNumber || tokens[i+1].kind == tokenIdentifier) {
+			end = i + 1
+		}
+	case isIdentifierToken(t):
+		end = i
+		// A qualified name, such as t.c
+		for {
+			dot := nextSignificant(tokens, end)
+			if dot < 0 || !tokens[dot].is(".") {
+				break
+			}
+			name := nextSignificant(tokens, dot)
+			if name < 0 || !isIdentifierToken(tokens[name]) {
+				return -1
+			}
+			end = name
+		}
+		// A function call, such as ABS(x), with a window, such as
+		// SUM(x) OVER (PARTITION BY y)
+		if open := nextSignificant(tokens, end); open >= 0 && tokens[open].is("(") {
+			if end = matchingClose(tokens, open); end < 0 {
+				return -1
+			}
+			if over := nextSignificant(tokens, end); over >= 0 && tokens[over].kind == tokenIdentifier && tokens[over].is("OVER") {
+				window := nextSignificant(tokens, over)
+				switch {
+				case window < 0:
+					return -1
+				case tokens[window].is("("):
+					end = matchingClose(tokens, window)
+				default:
+					end = window
+				}
+			}
+		}
+	}
+	if end < 0 {
+		return -1
+	}
+
+	// The operators binding tighter than the division
+	for {
+		next := nextSignificant(tokens, end)
+		switch {
+		case next >= 0 && tokens[next].is("|") && next+1 < len(tokens) && tokens[next+1].is("|"):
+			operand := nextSignificant(tokens, next+1)
+			if operand < 0 {
+				return -1
+			}
+			if end = divisorEnd(tokens, operand); end < 0 {
+				return -1
+			}
+		case next >= 0 && tokens[next].kind == tokenIdentifier && tokens[next].is("COLLATE"):
+			name := nextSignificant(tokens, next)
+			if name < 0 || !isIdentifierToken(tokens[name]) {
+				return -1
+			}
+			end = name
+		default:
+			return end
+		}
+	}
+}
+
+// caseEnd returns the index of the END of the CASE expression at i,
+// or -1 if it is not ended.
+func caseEnd(tokens []token, i int) int {
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		if tokens[j].kind != tokenIdentifier {
+			continue
+		}
+		switch {
+		case tokens[j].is("CASE"):
+			depth++
+		case tokens[j].is("END"):
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+
+	return -1
+}
+
+// restoreStrictDivision removes the wrappers of rewriteStrictDivision from
+// a column name, so that the column of 1 / 0 is still named "1 / 0".
+func restoreStrictDivision(column string) string {
+	if !strings.Contains(column, strictDivisorFunction+"(") {
+		return column
+	}
+
+	tokens := tokenize(column)
+	skip := make(map[int]bool)
+	for i, t := range tokens {
+		if t.kind != tokenIdentifier || t.text != strictDivisorFunction || i+1 >= len(tokens) || !tokens[i+1].is("(") {
+			continue
+		}
+		if end := matchingClose(tokens, i+1); end >= 0 {
+			skip[i], skip[i+1], skip[end] = true, true, true
+		}
+	}
+
+	var b strings.Builder
+	for i, t := range tokens {
+		if !skip[i] {
+			b.WriteString(t.text)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,38 @@
+package sqlrunner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteStrictDivision(t *testing.T) {
+	t.Parallel()
+
+	for query, expected := range map[string]string{
+		"SELECT 1 / 0":                        "SELECT 1 / STRICT_DIVISOR(0)",
+		"SELECT a % b FROM t":                 "SELECT a % STRICT_DIVISOR(b) FROM t",
+		"SELECT MOD(a, b + 1) FROM t":         "SELECT MOD(a, STRICT_DIVISOR(b) + 1) FROM t",
+		"SELECT a / -t.b * 2 FROM t":          "SELECT a / STRICT_DIVISOR(-t.b) * 2 FROM t",
+		"SELECT a / (b - c) FROM t":           "SELECT a / STRICT_DIVISOR((b - c)) FROM t",
+		"SELECT a / (b / c) FROM t":           "SELECT a / STRICT_DIVISOR((b / STRICT_DIVISOR(c))) FROM t",
+		"SELECT a / ABS(b) FROM t":            "SELECT a / STRICT_DIVISOR(ABS(b)) FROM t",
+		"SELECT a / SUM(b) OVER (ORDER BY c)": "SELECT a / STRICT_DIVISOR(SUM(b) OVER (ORDER BY c))",
+		"SELECT a / CASE WHEN b THEN 0 END":   "SELECT a / STRICT_DIVISOR(CASE WHEN b THEN 0 END)",
+		"SELECT a / b || c / d":               "SELECT a / STRICT_DIVISOR(b || c) / STRICT_DIVISOR(d)",
+		"SELECT a / ?":                        "SELECT a / STRICT_DIVISOR(?)",
+		"SELECT a / :n":                       "SELECT a / STRICT_DIVISOR(:n)",
+		"SELECT 'a/b', a /* / */ FROM t":      "SELECT 'a/b', a /* / */ FROM t",
+		"SELECT name FROM t WHERE x LIKE '%'": "SELECT name FROM t WHERE x LIKE '%'",
+		"SELECT a / NOT b":                    "SELECT a / NOT b",
+		"SELECT MOD(a) FROM t":                "SELECT MOD(a) FROM t",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			rewritten := rewriteStrictDivision(query)
+			assert.Equal(t, expected, rewritten)
+			assert.Equal(t, query, restoreStrictDivision(rewritten))
+		})
+	}
+}
@@ -1,5 +1,61 @@
 package sqlrunner
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The coarse categories of SchemaError.
+const (
+	// SchemaErrorParse means the schema has a syntax error.
+	SchemaErrorParse = "parse"
+	// SchemaErrorConstraint means the data violates a constraint.
+	SchemaErrorConstraint = "constraint"
+	// SchemaErrorOther is any other schema error.
+	SchemaErrorOther = "other"
+)
+
+// The categories of PrepareError.
+const (
+	// PrepareErrorSyntax means the query has a syntax error.
+	PrepareErrorSyntax = "syntax"
+	// PrepareErrorReference means the query refers to a table, a column,
+	// or a function which does not exist.
+	PrepareErrorReference = "reference"
+	// PrepareErrorOther is any other error.
+	PrepareErrorOther = "other"
+)
+
+// ErrMultipleStatements is returned by Query for a query with more than one
+// statement if WithSingleStatement is enabled.
+var ErrMultipleStatements = errors.New("only a single statement is allowed per query")
+
+// ErrSchemaTimeout is wrapped in the SchemaError returned for a schema whose
+// initialization takes longer than WithInitTimeout allows.
+var ErrSchemaTimeout = errors.New("schema initialization timed out")
+
+// ErrTooManyColumns is wrapped in the QueryError returned for a result with
+// more columns than WithMaxColumns allows.
+var ErrTooManyColumns = errors.New("too many columns in the result")
+
+// ErrResultTooLarge is wrapped in the QueryError returned for a result
+// larger than WithMaxResponseBytes allows.
+var ErrResultTooLarge = errors.New("the result is too large")
+
+// ErrUnorderedRows is wrapped in the QueryError returned for a query
+// returning multiple rows without a top-level ORDER BY clause with
+// OrderCheckError.
+var ErrUnorderedRows = errors.New("the query returns multiple rows without an ORDER BY clause; add one to guarantee the row order")
+
+// ErrReadOnly is wrapped in the QueryError returned for a MySQL command
+// modifying the schema database outside the writable mode, such as
+// TRUNCATE TABLE.
+var ErrReadOnly = errors.New("the database is read-only; the statement is only allowed in the writable mode")
+
 // SchemaError is returned when the schema registeration failed.
 type SchemaError struct {
 	Parent error
@@ -10,6 +66,30 @@ type QueryError struct {
 	Parent error
 }
 
+// PolicyError is returned when a query has a statement
+// the policy of the runner does not allow.
+type PolicyError struct {
+	// StatementType is the disallowed statement type, such as DELETE.
+	StatementType string
+	// Pragma is the lower-cased name of the disallowed PRAGMA,
+	// such as writable_schema, if any.
+	Pragma string
+	// Function is the upper-cased name of the disabled function the
+	// statement calls, such as LEFT, if any.
+	Function string
+}
+
+// PrepareError is returned by Prepare when a query is invalid.
+type PrepareError struct {
+	Parent error
+	// Category is the coarse category of the error, such as PrepareErrorSyntax.
+	Category string
+	// Line and Column are the 1-based position of the error in the query,
+	// which are zero if unknown.
+	Line   int
+	Column int
+}
+
 func NewSchemaError(err error) error {
 	return SchemaError{Parent: err}
 }
@@ -18,10 +98,79 @@ func NewQueryError(err error) error {
 	return QueryError{Parent: err}
 }
 
+// windowFunctionMisuseHint explains the "misuse of window function" error,
+// which SQLite returns for a window function used in WHERE, GROUP BY, or HAVING.
+const windowFunctionMisuseHint = "window functions are only allowed in the SELECT list and the ORDER BY clause; " +
+	"filter or group by their results in an outer query"
+
+// explainQueryError adds a hint to the errors of SQLite which are
+// confusing to the students.
+func explainQueryError(err error) error {
+	if strings.Contains(err.Error(), "misuse of window function") {
+		return fmt.Errorf("%w (%s)", err, windowFunctionMisuseHint)
+	}
+
+	return err
+}
+
 func (e SchemaError) Error() string {
 	return "invalid schema: " + e.Parent.Error()
 }
 
+// Category returns the coarse category of the error, such as
+// SchemaErrorParse, which is suitable for a metric label.
+func (e SchemaError) Category() string {
+	var sqliteErr *sqlite.Error
+	if !errors.As(e.Parent, &sqliteErr) {
+		return SchemaErrorOther
+	}
+
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_CONSTRAINT:
+		return SchemaErrorConstraint
+	case sqlite3.SQLITE_ERROR:
+		msg := sqliteErr.Error()
+		if strings.Contains(msg, "syntax error") || strings.Contains(msg, "incomplete input") ||
+			strings.Contains(msg, "unrecognized token") {
+			return SchemaErrorParse
+		}
+	}
+
+	return SchemaErrorOther
+}
+
 func (e QueryError) Error() string {
 	return "query error: " + e.Parent.Error()
 }
+
+func (e QueryError) Unwrap() error {
+	return e.Parent
+}
+
+func (e PrepareError) Error() string {
+	if e.Line == 0 {
+		return "invalid query: " + e.Parent.Error()
+	}
+
+	return fmt.Sprintf("invalid query at line %d, column %d: %s", e.Line, e.Column, e.Parent.Error())
+}
+
+func (e PrepareError) Unwrap() error {
+	return e.Parent
+}
+
+func (e PolicyError) Error() string {
+	if e.Pragma != "" {
+		return "pragma not allowed: " + e.Pragma
+	}
+	if e.Function != "" {
+		return "function not allowed: " + e.Function
+	}
+
+	statementType := e.StatementType
+	if statementType == "" {
+		statementType = "unknown"
+	}
+
+	return "statement type not allowed: " + statementType
+}
@@ -0,0 +1,63 @@
+package sqlrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ExportDatabase opens the SQLite database file the schema of the runner is
+// initialized into, such as to download it for offline study, and returns
+// it with its size in bytes. The caller must close it.
+//
+// The opened file stays readable even if the schema is invalidated meanwhile.
+func (r *SQLRunner) ExportDatabase(ctx context.Context) (io.ReadCloser, int64, error) {
+	_, span := tracer.Start(ctx, "SQLRunner.ExportDatabase")
+	defer span.End()
+
+	// Prevent the schema file from being invalidated before it is opened
+	unlockFiles := rlockSchemaFiles(baseSchemaHash(r.schemaHash))
+	defer unlockFiles()
+
+	filename, err := initializeThreadSafe(r.schema, r.options.schema(), r.options.initTimeout)
+	if err != nil {
+		span.SetStatus(codes.Error, "initialize error")
+		span.RecordError(err)
+
+		if !errors.As(err, &SchemaError{}) {
+			err = NewSchemaError(err)
+		}
+		return nil, 0, err
+	}
+
+	// The filename is derived from the schema hash, but never open a file
+	// outside tmpDir anyway.
+	if filepath.Dir(filename) != filepath.Clean(tmpDir) {
+		return nil, 0, fmt.Errorf("schema database outside %s: %s", tmpDir, filename)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		span.SetStatus(codes.Error, "open error")
+		span.RecordError(err)
+
+		return nil, 0, fmt.Errorf("open schema database: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		span.SetStatus(codes.Error, "stat error")
+		span.RecordError(err)
+
+		return nil, 0, fmt.Errorf("stat schema database: %w", err)
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	return file, info.Size(), nil
+}
@@ -0,0 +1,216 @@
+package sqlrunner
+
+import (
+	"database/sql/driver"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// fieldLookup maps the elements of a FIELD list, converted to doubles, to
+// their first positions. FIELD is usually called with the same list for each
+// row, such as in ORDER BY FIELD(status, 'new', 'paid', 'shipped'), so the
+// strings of a list are converted once rather than per row.
+type fieldLookup map[float64]int64
+
+// fieldLookups keeps the lookups of the recent FIELD lists, keyed on
+// the hashes of the lists.
+var fieldLookups = func() *lru.Cache[fieldListKey, fieldLookup] {
+	cache, err := lru.New[fieldListKey, fieldLookup](128)
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}()
+
+// findInSetLookups keeps the lookups of the recent FIND_IN_SET lists,
+// keyed on the lists, so that a list is split once rather than per row.
+var findInSetLookups = func() *lru.Cache[string, map[string]int64] {
+	cache, err := lru.New[string, map[string]int64](128)
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}()
+
+// field returns the 1-based position of the first argument in the rest,
+// or 0 if it is NULL or not found, like MySQL FIELD. The arguments are
+// compared as strings if they are all strings, as numbers if they are all
+// numbers, and as doubles otherwise. The strings are compared
+// case-sensitively, like SQLite's default BINARY collation. NULL elements
+// never match.
+func field(args []driver.Value) int64 {
+	if args[0] == nil {
+		return 0
+	}
+
+	hasNumber, hasText := false, false
+	for _, arg := range args {
+		switch arg.(type) {
+		case nil:
+		case int64, float64:
+			hasNumber = true
+		default:
+			hasText = true
+		}
+	}
+
+	// Comparing the strings or the numbers as they are is as fast as
+	// hashing the list, so only the conversions to doubles are cached
+	if !hasText {
+		needle, _ := toFloat64(args[0])
+		for i, element := range args[1:] {
+			if f, ok := toFloat64(element); ok && f == needle {
+				return int64(i + 1)
+			}
+		}
+		return 0
+	}
+	if !hasNumber {
+		needle := sqliteText(args[0])
+		for i, element := range args[1:] {
+			if element != nil && sqliteText(element) == needle {
+				return int64(i + 1)
+			}
+		}
+		return 0
+	}
+
+	list := args[1:]
+	key := newFieldListKey(list)
+	lookup, ok := fieldLookups.Get(key)
+	if !ok {
+		lookup = make(fieldLookup, len(list))
+		for i, element := range list {
+			if element == nil {
+				continue
+			}
+			if f := toDouble(element); lookup[f] == 0 {
+				lookup[f] = int64(i + 1)
+			}
+		}
+		fieldLookups.Add(key, lookup)
+	}
+
+	return lookup[toDouble(args[0])]
+}
+
+// toDouble converts a non-NULL value to a double like MySQL.
+func toDouble(v driver.Value) float64 {
+	if f, ok := toFloat64(v); ok {
+		return f
+	}
+	return textToFloat(sqliteText(v))
+}
+
+// fieldListKey is the cache key of a FIELD list: the number of the elements
+// and a hash of them with a random seed, which practically never collide.
+// Unlike an encoding of the list, hashing it does not allocate.
+type fieldListKey struct {
+	n    int
+	hash uint64
+}
+
+// fieldListSeed is the seed of the hashes of fieldListKey.
+var fieldListSeed = maphash.MakeSeed()
+
+// newFieldListKey returns the cache key of a FIELD list. The hash of each
+// element is mixed with its type, so that, for example, (1, '1') and
+// ('1', 1) are hashed differently.
+func newFieldListKey(list []driver.Value) fieldListKey {
+	key := fieldListKey{n: len(list)}
+	for _, element := range list {
+		var kind, hash uint64
+		switch element := element.(type) {
+		case nil:
+			kind = 1
+		case int64:
+			kind, hash = 2, uint64(element)
+		case float64:
+			kind, hash = 3, math.Float64bits(element)
+		case string:
+			kind, hash = 4, maphash.String(fieldListSeed, element)
+		default:
+			kind, hash = 4, maphash.String(fieldListSeed, sqliteText(element))
+		}
+
+		// Mix it in with the multipliers of xxHash
+		key.hash ^= bits.RotateLeft64((hash^kind)*0xc2b2ae3d27d4eb4f, 31) * 0x9e3779b185ebca87
+		key.hash = bits.RotateLeft64(key.hash, 27)*0x9e3779b185ebca87 + 0x85ebca77c2b2ae63
+	}
+
+	return key
+}
+
+// findInSet returns the 1-based position of the first argument in the
+// comma-separated list of the second argument, or 0 if it is not found,
+// like MySQL FIND_IN_SET. It returns NULL if either argument is NULL.
+func findInSet(args []driver.Value) driver.Value {
+	if hasNull(args) {
+		return nil
+	}
+
+	needle, list := sqliteText(args[0]), sqliteText(args[1])
+	// An empty list has no elements, and an element never contains a comma
+	if list == "" || strings.Contains(needle, ",") {
+		return int64(0)
+	}
+
+	lookup, ok := findInSetLookups.Get(list)
+	if !ok {
+		lookup = make(map[string]int64)
+		for i, element := range strings.Split(list, ",") {
+			if _, ok := lookup[element]; !ok {
+				lookup[element] = int64(i + 1)
+			}
+		}
+		findInSetLookups.Add(list, lookup)
+	}
+
+	return lookup[needle]
+}
+
+// textToFloat converts a string to a double like MySQL: the longest numeric
+// prefix after the leading spaces is converted, and 0 if there is none.
+func textToFloat(s string) float64 {
+	s = strings.TrimLeft(s, " \t\n\r")
+
+	end := 0
+	if end < len(s) && (s[end] == '+' || s[end] == '-') {
+		end++
+	}
+	digits := scanDigits(s, &end)
+	if end < len(s) && s[end] == '.' {
+		end++
+		digits += scanDigits(s, &end)
+	}
+	if digits == 0 {
+		return 0
+	}
+	if end < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		exponent := end + 1
+		if exponent < len(s) && (s[exponent] == '+' || s[exponent] == '-') {
+			exponent++
+		}
+		if scanDigits(s, &exponent) > 0 {
+			end = exponent
+		}
+	}
+
+	f, _ := strconv.ParseFloat(s[:end], 64)
+	return f
+}
+
+// scanDigits advances end over the digits of s from end, and returns
+// the number of the digits.
+func scanDigits(s string, end *int) int {
+	start := *end
+	for *end < len(s) && s[*end] >= '0' && s[*end] <= '9' {
+		*end++
+	}
+	return *end - start
+}
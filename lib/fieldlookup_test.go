@@ -0,0 +1,167 @@
+package sqlrunner
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fieldNaive is FIELD scanning the list on each call, which field must agree with.
+func fieldNaive(args []driver.Value) int64 {
+	if args[0] == nil {
+		return 0
+	}
+
+	asNumbers := false
+	for _, arg := range args {
+		switch arg.(type) {
+		case int64, float64:
+			asNumbers = true
+		}
+	}
+
+	// Without a string, comparing as doubles is comparing as numbers
+
+	for i, element := range args[1:] {
+		if element == nil {
+			continue
+		}
+		if asNumbers && toDouble(args[0]) == toDouble(element) {
+			return int64(i + 1)
+		}
+		if !asNumbers && sqliteText(args[0]) == sqliteText(element) {
+			return int64(i + 1)
+		}
+	}
+
+	return 0
+}
+
+// findInSetNaive is FIND_IN_SET splitting the list on each call, which
+// findInSet must agree with.
+func findInSetNaive(args []driver.Value) driver.Value {
+	if hasNull(args) {
+		return nil
+	}
+
+	needle, list := sqliteText(args[0]), sqliteText(args[1])
+	if list == "" {
+		return int64(0)
+	}
+	for i, element := range strings.Split(list, ",") {
+		if element == needle {
+			return int64(i + 1)
+		}
+	}
+
+	return int64(0)
+}
+
+func TestFieldLookup(t *testing.T) {
+	t.Parallel()
+
+	lists := [][]driver.Value{
+		{"a", "b", "c", "b"},
+		{int64(1), int64(2), 3.5, nil},
+		{"1", int64(2), "3abc", " 4", "x"},
+		{nil, "", "a"},
+		{[]byte("a"), "b"},
+		{-0.0, "1e2", "1.5e", "-.5"},
+	}
+	needles := []driver.Value{
+		nil, "a", "b", "B", "", "x", "1", "2", " 3", "3abc", "4", "100", "1.5", "-0.5",
+		int64(0), int64(1), int64(2), int64(4), int64(100), 3.5, 1.5, -0.5, []byte("a"),
+	}
+
+	for _, list := range lists {
+		for _, needle := range needles {
+			args := append([]driver.Value{needle}, list...)
+			// The second call is answered by the cached lookup
+			assert.Equal(t, fieldNaive(args), field(args), "FIELD%v", args)
+			assert.Equal(t, fieldNaive(args), field(args), "FIELD%v", args)
+		}
+	}
+
+	for _, list := range []driver.Value{nil, "", "a,b,c,b", "a,,c", ",", "1,2,3", "資料庫,資料"} {
+		for _, needle := range []driver.Value{nil, "", "a", "b", "c", "d", "1", int64(2), "資料"} {
+			args := []driver.Value{needle, list}
+			assert.Equal(t, findInSetNaive(args), findInSet(args), "FIND_IN_SET%v", args)
+			assert.Equal(t, findInSetNaive(args), findInSet(args), "FIND_IN_SET%v", args)
+		}
+	}
+}
+
+func BenchmarkFieldLookup(b *testing.B) {
+	// FIELD in ORDER BY is called with the same list for each of the rows
+	for name, element := range map[string]func(i int) driver.Value{
+		"Strings": func(i int) driver.Value { return fmt.Sprintf("status-%d", i) },
+		// The strings are converted to doubles with a number in the list
+		"Mixed": func(i int) driver.Value {
+			if i == 0 {
+				return int64(0)
+			}
+			return strconv.Itoa(i)
+		},
+	} {
+		list := make([]driver.Value, 100)
+		for i := range list {
+			list[i] = element(i)
+		}
+		rows := make([][]driver.Value, 1000)
+		for i := range rows {
+			rows[i] = append([]driver.Value{element(i % len(list))}, list...)
+		}
+
+		b.Run(name+"/Naive", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				for _, args := range rows {
+					fieldNaive(args)
+				}
+			}
+		})
+
+		b.Run(name+"/Optimized", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				for _, args := range rows {
+					field(args)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFindInSetLookup(b *testing.B) {
+	elements := make([]string, 100)
+	for i := range elements {
+		elements[i] = fmt.Sprintf("tag-%d", i)
+	}
+	list := strings.Join(elements, ",")
+	rows := make([][]driver.Value, 1000)
+	for i := range rows {
+		rows[i] = []driver.Value{elements[i%len(elements)], list}
+	}
+
+	b.Run("Naive", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, args := range rows {
+				findInSetNaive(args)
+			}
+		}
+	})
+
+	b.Run("Optimized", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, args := range rows {
+				findInSet(args)
+			}
+		}
+	})
+}
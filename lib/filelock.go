@@ -0,0 +1,51 @@
+package sqlrunner
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// lockRetryInterval is the interval to check if a lock file is released.
+	lockRetryInterval = 50 * time.Millisecond
+	// lockTimeout is the maximum duration to wait for a lock file.
+	lockTimeout = 2 * time.Minute
+)
+
+// acquireFileLock acquires a lock across processes by taking an advisory
+// lock of the operating system on the lock file. It waits until the lock
+// is released by its holder. The system releases the lock of a crashed
+// process, so there is no stale lock to break.
+//
+// The lock file is never removed, since a process removing it could let
+// another one lock a new file at the same path while the old one is still
+// locked. The returned function releases the lock.
+func acquireFileLock(filename string) (unlock func(), err error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, currentFilePermissions().File)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("lock file: %w", err)
+		}
+		if locked {
+			return func() {
+				_ = unlockFile(f)
+				_ = f.Close()
+			}, nil
+		}
+
+		if time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, fmt.Errorf("timed out waiting for lock file %s", filename)
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+}
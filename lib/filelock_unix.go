@@ -0,0 +1,24 @@
+//go:build unix
+
+package sqlrunner
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLockFile takes the exclusive flock of the file without waiting, and
+// reports whether it is taken.
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
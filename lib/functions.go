@@ -0,0 +1,1440 @@
+package sqlrunner
+
+import (
+	"bytes"
+	"cmp"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"modernc.org/sqlite"
+)
+
+// FunctionInfo describes a MySQL-compatible function registered by this package.
+type FunctionInfo struct {
+	// Name is the SQL name of the function.
+	Name string `json:"name"`
+	// NArgs is the number of arguments the function accepts (-1 for variadic).
+	NArgs int32 `json:"nargs"`
+	// Deterministic reports whether the function always returns the same
+	// output for the same input.
+	Deterministic bool `json:"deterministic"`
+	// Description is a short human-readable description of the function.
+	Description string `json:"description"`
+}
+
+// registeredFunction is an entry of the function registration table.
+type registeredFunction struct {
+	name        string
+	description string
+	// example is a query exercising the function, and expected is the
+	// single cell it returns. They are run by SelfTest.
+	example  string
+	expected string
+	impl     *sqlite.FunctionImpl
+}
+
+// functions is the registration table of the MySQL-compatible functions.
+var functions = []registeredFunction{
+	{
+		name:        "YEAR",
+		description: "Returns the year of a date.",
+		example:     "SELECT YEAR('2021-03-04')",
+		expected:    "2021",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				d, err := parseSqliteDate(args[0])
+				if err != nil {
+					return nil, fmt.Errorf("parse date: %w", err)
+				}
+
+				return int64(d.Year()), nil
+			},
+		},
+	},
+	{
+		name:        "MONTH",
+		description: "Returns the month (1-12) of a date.",
+		example:     "SELECT MONTH('2021-03-04')",
+		expected:    "3",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				d, err := parseSqliteDate(args[0])
+				if err != nil {
+					return nil, fmt.Errorf("parse date: %w", err)
+				}
+
+				return int64(d.Month()), nil
+			},
+		},
+	},
+	{
+		name:        "DAY",
+		description: "Returns the day of the month (1-31) of a date.",
+		example:     "SELECT DAY('2021-03-04')",
+		expected:    "4",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				d, err := parseSqliteDate(args[0])
+				if err != nil {
+					return nil, fmt.Errorf("parse date: %w", err)
+				}
+
+				return int64(d.Day()), nil
+			},
+		},
+	},
+	{
+		name:        "LEFT",
+		description: "Returns the leftmost N characters of a string.",
+		example:     "SELECT LEFT('hello', 2)",
+		expected:    "he",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				str, ok := args[0].(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid argument type: %T", args[0])
+				}
+
+				length, ok := args[1].(int64)
+				if !ok {
+					return nil, fmt.Errorf("invalid argument type: %T", args[1])
+				}
+
+				if length < 0 {
+					return nil, fmt.Errorf("negative length: %d", length)
+				}
+
+				if int(length) > len(str) {
+					return str, nil
+				}
+
+				return str[:length], nil
+			},
+		},
+	},
+	{
+		name:        "IF",
+		description: "Returns the second argument if the condition is true, otherwise the third.",
+		example:     "SELECT IF(1 > 0, 'yes', 'no')",
+		expected:    "yes",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         3,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				condition, ok := args[0].(bool)
+				if !ok {
+					conditionInt64, ok := args[0].(int64)
+					if !ok {
+						return nil, fmt.Errorf("invalid argument type: %T", args[0])
+					}
+
+					condition = conditionInt64 != 0
+				}
+
+				if condition {
+					return args[1], nil
+				}
+
+				return args[2], nil
+			},
+		},
+	},
+	{
+		name:        "ISNULL",
+		description: "Returns 1 if the argument is NULL, otherwise 0.",
+		example:     "SELECT ISNULL(NULL)",
+		expected:    "1",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return args[0] == nil, nil
+			},
+		},
+	},
+	{
+		name:        "NULL_SAFE_EQ",
+		description: "Compares two values like the MySQL <=> operator, treating two NULLs as equal.",
+		example:     "SELECT NULL_SAFE_EQ(NULL, NULL)",
+		expected:    "1",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if args[0] == nil || args[1] == nil {
+					return args[0] == nil && args[1] == nil, nil
+				}
+
+				return valuesEqual(args[0], args[1]), nil
+			},
+		},
+	},
+	{
+		name:        "MAKEDATE",
+		description: "Creates a date from a year and a day of the year.",
+		example:     "SELECT MAKEDATE(2021, 32)",
+		expected:    "2021-02-01",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if hasNull(args) {
+					return nil, nil
+				}
+
+				year, err := toInt64(args[0])
+				if err != nil {
+					return nil, err
+				}
+				dayOfYear, err := toInt64(args[1])
+				if err != nil {
+					return nil, err
+				}
+
+				if dayOfYear <= 0 || year < 0 || year > 9999 {
+					return nil, nil
+				}
+
+				// Days beyond the year roll forward to the next years.
+				d := time.Date(int(year), time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(dayOfYear-1))
+				return d.Format(time.DateOnly), nil
+			},
+		},
+	},
+	{
+		name:        "TO_DAYS",
+		description: "Returns the number of days of a date since year 0.",
+		example:     "SELECT TO_DAYS('2007-10-07')",
+		expected:    "733321",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if args[0] == nil {
+					return nil, nil
+				}
+
+				d, err := parseSqliteDate(args[0])
+				if err != nil || d.IsZero() {
+					return nil, nil
+				}
+
+				return toDays(*d), nil
+			},
+		},
+	},
+	{
+		name:        "FROM_DAYS",
+		description: "Returns the date of a number of days since year 0.",
+		example:     "SELECT FROM_DAYS(733321)",
+		expected:    "2007-10-07",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if args[0] == nil {
+					return nil, nil
+				}
+
+				n, err := toInt64(args[0])
+				if err != nil {
+					return nil, nil
+				}
+
+				return fromDays(n), nil
+			},
+		},
+	},
+	{
+		name:        "MAKETIME",
+		description: "Creates a time from an hour, a minute, and a second.",
+		example:     "SELECT MAKETIME(12, 15, 30)",
+		expected:    "12:15:30",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         3,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if hasNull(args) {
+					return nil, nil
+				}
+
+				hour, err := toInt64(args[0])
+				if err != nil {
+					return nil, err
+				}
+				minute, err := toInt64(args[1])
+				if err != nil {
+					return nil, err
+				}
+				second, err := toInt64(args[2])
+				if err != nil {
+					return nil, err
+				}
+
+				if minute < 0 || minute > 59 || second < 0 || second > 59 {
+					return nil, nil
+				}
+
+				sign := ""
+				if hour < 0 {
+					sign = "-"
+					hour = -hour
+				}
+
+				return fmt.Sprintf("%s%02d:%02d:%02d", sign, hour, minute, second), nil
+			},
+		},
+	},
+	{
+		name:        "PERIOD_DIFF",
+		description: "Returns the number of months between two periods in the YYMM or YYYYMM format.",
+		example:     "SELECT PERIOD_DIFF(202103, 202012)",
+		expected:    "3",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if hasNull(args) {
+					return nil, nil
+				}
+
+				p1, err := toInt64(args[0])
+				if err != nil {
+					return nil, err
+				}
+				p2, err := toInt64(args[1])
+				if err != nil {
+					return nil, err
+				}
+
+				return periodToMonths(p1) - periodToMonths(p2), nil
+			},
+		},
+	},
+	{
+		name:        "ADDTIME",
+		description: "Adds a time interval to a time or a datetime.",
+		example:     "SELECT ADDTIME('2021-01-01 23:00:00', '02:00:00')",
+		expected:    "2021-01-02 01:00:00",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return addTime(args[0], args[1], 1)
+			},
+		},
+	},
+	{
+		name:        "SUBTIME",
+		description: "Subtracts a time interval from a time or a datetime.",
+		example:     "SELECT SUBTIME('01:00:00', '02:00:00')",
+		expected:    "-01:00:00",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return addTime(args[0], args[1], -1)
+			},
+		},
+	},
+	{
+		name:        "CHAR_LENGTH",
+		description: "Returns the number of characters of a string.",
+		example:     "SELECT CHAR_LENGTH('café')",
+		expected:    "4",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if args[0] == nil {
+					return nil, nil
+				}
+
+				return int64(utf8.RuneCountInString(sqliteText(args[0]))), nil
+			},
+		},
+	},
+	{
+		name:        "OCTET_LENGTH",
+		description: "Returns the number of bytes of a string in UTF-8.",
+		example:     "SELECT OCTET_LENGTH('café')",
+		expected:    "5",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if args[0] == nil {
+					return nil, nil
+				}
+
+				return int64(len(sqliteText(args[0]))), nil
+			},
+		},
+	},
+	{
+		name:        "BIT_LENGTH",
+		description: "Returns the number of bits of a string in UTF-8.",
+		example:     "SELECT BIT_LENGTH('café')",
+		expected:    "40",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if args[0] == nil {
+					return nil, nil
+				}
+
+				return int64(len(sqliteText(args[0]))) * 8, nil
+			},
+		},
+	},
+	{
+		name:        "SUBSTRING_INDEX",
+		description: "Returns the substring before the count-th occurrence of a delimiter, or after it counting from the end if count is negative.",
+		example:     "SELECT SUBSTRING_INDEX('a.b.c.d', '.', 2)",
+		expected:    "a.b",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         3,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if hasNull(args) {
+					return nil, nil
+				}
+
+				count, err := toInt64(args[2])
+				if err != nil {
+					return nil, err
+				}
+
+				return substringIndex(sqliteText(args[0]), sqliteText(args[1]), count), nil
+			},
+		},
+	},
+	{
+		name:        "INSERT",
+		description: "Replaces the characters of a string from a 1-based position and of a length with another string.",
+		example:     "SELECT INSERT('Quadratic', 3, 4, 'What')",
+		expected:    "QuWhattic",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         4,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if hasNull(args) {
+					return nil, nil
+				}
+
+				pos, err := toInt64(args[1])
+				if err != nil {
+					return nil, err
+				}
+				length, err := toInt64(args[2])
+				if err != nil {
+					return nil, err
+				}
+
+				return insertString(sqliteText(args[0]), pos, length, sqliteText(args[3])), nil
+			},
+		},
+	},
+	{
+		name:        "STRCMP",
+		description: "Returns -1, 0, or 1 if a string is less than, equal to, or greater than another in the default collation.",
+		example:     "SELECT STRCMP('a', 'b')",
+		expected:    "-1",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return strcmp(args, "")
+			},
+		},
+	},
+	{
+		name:        "SORT_KEY",
+		description: "Returns the hexadecimal comparison key of a string in the default collation, which sorts like the string.",
+		example:     "SELECT SORT_KEY('Ab')",
+		expected:    "4162",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return sortKey(args, ""), nil
+			},
+		},
+	},
+	{
+		name:        "QUOTE",
+		description: "Returns a string as a single-quoted SQL literal with the special characters escaped.",
+		example:     "SELECT QUOTE('Don''t')",
+		expected:    "'Don\\'t'",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				switch v := args[0].(type) {
+				case nil:
+					return "NULL", nil
+				case string:
+					return quoteString(v), nil
+				case []byte:
+					return quoteString(string(v)), nil
+				case int64:
+					return quoteString(strconv.FormatInt(v, 10)), nil
+				case float64:
+					return quoteString(sqliteText(v)), nil
+				default:
+					return nil, fmt.Errorf("invalid argument type: %T", v)
+				}
+			},
+		},
+	},
+	{
+		name:        "UNHEX_TEXT",
+		description: "Decodes a string of hexadecimal digits to a UTF-8 string, unlike UNHEX returning the bytes.",
+		example:     "SELECT UNHEX_TEXT('68656C6C6F')",
+		expected:    "hello",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if args[0] == nil {
+					return nil, nil
+				}
+
+				return unhexText(sqliteText(args[0])), nil
+			},
+		},
+	},
+	{
+		name:        "CEIL",
+		description: "Returns the smallest integer not less than a number, as an integer for an integer.",
+		example:     "SELECT CEIL(1.1)",
+		expected:    "2",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return roundIntegral(args[0], math.Ceil)
+			},
+		},
+	},
+	{
+		name:        "CEILING",
+		description: "Returns the smallest integer not less than a number, as an integer for an integer.",
+		example:     "SELECT CEILING(5)",
+		expected:    "5",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return roundIntegral(args[0], math.Ceil)
+			},
+		},
+	},
+	{
+		name:        "FLOOR",
+		description: "Returns the largest integer not greater than a number, as an integer for an integer.",
+		example:     "SELECT FLOOR(-1.1)",
+		expected:    "-2",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return roundIntegral(args[0], math.Floor)
+			},
+		},
+	},
+	{
+		name:        "DATE_ADD",
+		description: "Adds an interval of a unit, such as 7 and 'DAY', to a date.",
+		example:     "SELECT DATE_ADD('2021-01-31', 1, 'MONTH')",
+		expected:    "2021-02-28",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         3,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return addInterval(args, 1)
+			},
+		},
+	},
+	{
+		name:        "DATE_SUB",
+		description: "Subtracts an interval of a unit, such as 7 and 'DAY', from a date.",
+		example:     "SELECT DATE_SUB('2021-01-01', 1, 'DAY')",
+		expected:    "2020-12-31",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         3,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return addInterval(args, -1)
+			},
+		},
+	},
+	{
+		name:        "TIMESTAMPADD",
+		description: "Adds an interval of a unit, such as 'DAY' and 7, to a date, with the arguments in the MySQL TIMESTAMPADD order.",
+		example:     "SELECT TIMESTAMPADD('MONTH', 1, '2021-01-31 12:00:00')",
+		expected:    "2021-02-28 12:00:00",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         3,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return addInterval([]driver.Value{args[2], args[1], args[0]}, 1)
+			},
+		},
+	},
+	{
+		name:        "REGEXP",
+		description: "Backs the REGEXP and RLIKE operators: reports whether a string matches a regular expression, case-insensitively.",
+		example:     "SELECT 'MySQL' REGEXP '^my'",
+		expected:    "1",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				// SQLite calls it as REGEXP(pattern, string) for string REGEXP pattern
+				if hasNull(args) {
+					return nil, nil
+				}
+
+				re, err := compileRegexp(sqliteText(args[0]))
+				if err != nil {
+					return nil, err
+				}
+
+				if re.MatchString(sqliteText(args[1])) {
+					return int64(1), nil
+				}
+				return int64(0), nil
+			},
+		},
+	},
+	{
+		name:        "FIELD",
+		description: "Returns the position of the first argument in the rest, or 0 if it is not found.",
+		example:     "SELECT FIELD('b', 'a', 'b', 'c')",
+		expected:    "2",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         -1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if len(args) < 2 {
+					return nil, errors.New("wrong number of arguments to function FIELD()")
+				}
+
+				return field(args), nil
+			},
+		},
+	},
+	{
+		name:        "FIND_IN_SET",
+		description: "Returns the position of a string in a comma-separated list, or 0 if it is not found.",
+		example:     "SELECT FIND_IN_SET('b', 'a,b,c')",
+		expected:    "2",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return findInSet(args), nil
+			},
+		},
+	},
+	{
+		name:        "LEAST",
+		description: "Returns the smallest argument, or NULL if any argument is NULL.",
+		example:     "SELECT LEAST(3, 1, 2)",
+		expected:    "1",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         -1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if len(args) < 2 {
+					return nil, errors.New("wrong number of arguments to function LEAST()")
+				}
+
+				return extremum(args, -1, false), nil
+			},
+		},
+	},
+	{
+		name:        "GREATEST",
+		description: "Returns the largest argument, or NULL if any argument is NULL.",
+		example:     "SELECT GREATEST(3, 1, 2)",
+		expected:    "3",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         -1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if len(args) < 2 {
+					return nil, errors.New("wrong number of arguments to function GREATEST()")
+				}
+
+				return extremum(args, 1, false), nil
+			},
+		},
+	},
+	{
+		name:        "LEAST_IGNORE_NULL",
+		description: "Returns the smallest non-NULL argument, or NULL if all the arguments are NULL.",
+		example:     "SELECT LEAST_IGNORE_NULL(3, NULL, 2)",
+		expected:    "2",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         -1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if len(args) < 2 {
+					return nil, errors.New("wrong number of arguments to function LEAST_IGNORE_NULL()")
+				}
+
+				return extremum(args, -1, true), nil
+			},
+		},
+	},
+	{
+		name:        "GREATEST_IGNORE_NULL",
+		description: "Returns the largest non-NULL argument, or NULL if all the arguments are NULL.",
+		example:     "SELECT GREATEST_IGNORE_NULL(3, NULL, 2)",
+		expected:    "3",
+		impl: &sqlite.FunctionImpl{
+			NArgs:         -1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if len(args) < 2 {
+					return nil, errors.New("wrong number of arguments to function GREATEST_IGNORE_NULL()")
+				}
+
+				return extremum(args, 1, true), nil
+			},
+		},
+	},
+	{
+		name:        "VERSION",
+		description: "Returns a MySQL-like version string of the server.",
+		example:     "SELECT VERSION() <> ''",
+		expected:    "1",
+		impl: &sqlite.FunctionImpl{
+			NArgs: 0,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return currentServerInfo().Version, nil
+			},
+		},
+	},
+	{
+		name:        "DATABASE",
+		description: "Returns the name of the current database.",
+		example:     "SELECT DATABASE() <> ''",
+		expected:    "1",
+		impl: &sqlite.FunctionImpl{
+			NArgs: 0,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return currentServerInfo().Database, nil
+			},
+		},
+	},
+	{
+		name:        "CURRENT_USER",
+		description: "Returns a placeholder user name and host of the current user.",
+		example:     "SELECT CURRENT_USER() LIKE '%@%'",
+		expected:    "1",
+		impl: &sqlite.FunctionImpl{
+			NArgs: 0,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return currentServerInfo().User, nil
+			},
+		},
+	},
+}
+
+// nullStrictSuffix is the suffix of the function variants
+// returning NULL if any argument is NULL.
+const nullStrictSuffix = "__NULL_STRICT"
+
+// collationSuffix returns the suffix of the function variants comparing
+// strings in the collation, such as STRCMP__NOCASE.
+func collationSuffix(collation string) string {
+	return "__" + collation
+}
+
+// variantFunctions are the function variants called instead of the functions
+// by the runners with the options choosing them, and the other functions the
+// queries are rewritten to call. See functionVariants.
+var variantFunctions = []registeredFunction{
+	{
+		name: strictDivisorFunction,
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return strictDivisor(args[0])
+			},
+		},
+	},
+	{
+		name: "STRCMP" + collationSuffix("NOCASE"),
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return strcmp(args, "NOCASE")
+			},
+		},
+	},
+	{
+		name: "STRCMP" + collationSuffix("RTRIM"),
+		impl: &sqlite.FunctionImpl{
+			NArgs:         2,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return strcmp(args, "RTRIM")
+			},
+		},
+	},
+	{
+		name: "SORT_KEY" + collationSuffix("NOCASE"),
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return sortKey(args, "NOCASE"), nil
+			},
+		},
+	},
+	{
+		name: "SORT_KEY" + collationSuffix("RTRIM"),
+		impl: &sqlite.FunctionImpl{
+			NArgs:         1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return sortKey(args, "RTRIM"), nil
+			},
+		},
+	},
+	{
+		name: "CONCAT" + nullStrictSuffix,
+		impl: &sqlite.FunctionImpl{
+			NArgs:         -1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if len(args) == 0 {
+					return nil, errors.New("wrong number of arguments to function CONCAT()")
+				}
+				if hasNull(args) {
+					return nil, nil
+				}
+
+				var b strings.Builder
+				for _, arg := range args {
+					b.WriteString(sqliteText(arg))
+				}
+
+				return b.String(), nil
+			},
+		},
+	},
+	{
+		name: "CONCAT_WS" + nullStrictSuffix,
+		impl: &sqlite.FunctionImpl{
+			NArgs:         -1,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				if len(args) < 2 {
+					return nil, errors.New("wrong number of arguments to function CONCAT_WS()")
+				}
+				if hasNull(args) {
+					return nil, nil
+				}
+
+				values := make([]string, 0, len(args)-1)
+				for _, arg := range args[1:] {
+					values = append(values, sqliteText(arg))
+				}
+
+				return strings.Join(values, sqliteText(args[0])), nil
+			},
+		},
+	},
+	{
+		name: groupConcatCapFunction,
+		impl: &sqlite.FunctionImpl{
+			NArgs:         3,
+			Deterministic: true,
+			Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return capGroupConcat(args)
+			},
+		},
+	},
+}
+
+func init() {
+	// MySQL-compatible functions
+	for _, fn := range functions {
+		sqlite.MustRegisterFunction(fn.name, fn.impl)
+	}
+
+	for _, fn := range variantFunctions {
+		sqlite.MustRegisterFunction(fn.name, fn.impl)
+	}
+
+	for _, fn := range overflowVariantFunctions() {
+		sqlite.MustRegisterFunction(fn.name, fn.impl)
+	}
+}
+
+// RegisteredFunctions returns the MySQL-compatible functions registered
+// on top of SQLite.
+func RegisteredFunctions() []FunctionInfo {
+	infos := make([]FunctionInfo, 0, len(functions))
+	for _, fn := range functions {
+		infos = append(infos, FunctionInfo{
+			Name:          fn.name,
+			NArgs:         fn.impl.NArgs,
+			Deterministic: fn.impl.Deterministic,
+			Description:   fn.description,
+		})
+	}
+
+	return infos
+}
+
+// valuesEqual reports whether two non-NULL SQLite values are equal.
+// Numbers are compared by their numeric values regardless of their types.
+func valuesEqual(a, b driver.Value) bool {
+	if af, ok := toFloat64(a); ok {
+		bf, ok := toFloat64(b)
+		return ok && af == bf
+	}
+
+	switch a := a.(type) {
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	default:
+		return a == b
+	}
+}
+
+// toFloat64 converts a numeric SQLite value to float64.
+func toFloat64(v driver.Value) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// sqliteText converts a non-NULL SQLite value to text like SQLite does,
+// except that the REAL values are rendered without an exponent like the
+// REAL cells, see formatReal.
+func sqliteText(v driver.Value) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		// Like the REAL cells, but SQLite keeps the decimal point of
+		// integral real numbers
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return formatReal(v) + ".0"
+		}
+		return formatReal(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// hasNull reports whether any of the arguments is NULL.
+func hasNull(args []driver.Value) bool {
+	return slices.Contains(args, nil)
+}
+
+// toInt64 converts a numeric SQLite value, or a string of a number, to int64.
+// Real numbers are rounded to the nearest integer.
+func toInt64(v driver.Value) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		return int64(math.Round(v)), nil
+	case string:
+		if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number: %q", v)
+		}
+		return int64(math.Round(f)), nil
+	default:
+		return 0, fmt.Errorf("invalid argument type: %T", v)
+	}
+}
+
+// roundIntegral rounds a number to an integral value with round, such as
+// math.Ceil. Like MySQL, an integer stays an integer and a real number stays
+// a real number; a string is converted to the number it represents.
+func roundIntegral(v driver.Value, round func(float64) float64) (driver.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		return v, nil
+	case float64:
+		return round(v), nil
+	case string, []byte:
+		text := strings.TrimSpace(sqliteText(v))
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %q", text)
+		}
+		return round(f), nil
+	default:
+		return nil, fmt.Errorf("invalid argument type: %T", v)
+	}
+}
+
+// substringIndex returns the substring of s before the count-th occurrence
+// of delim, or after the -count-th occurrence from the end if count is
+// negative, like MySQL. It returns s if delim occurs fewer times.
+func substringIndex(s, delim string, count int64) string {
+	if delim == "" || count == 0 {
+		return ""
+	}
+
+	parts := strings.Split(s, delim)
+	if count > 0 {
+		if count >= int64(len(parts)) {
+			return s
+		}
+		return strings.Join(parts[:count], delim)
+	}
+
+	if -count >= int64(len(parts)) {
+		return s
+	}
+	return strings.Join(parts[int64(len(parts))+count:], delim)
+}
+
+// insertString replaces length characters of s from the 1-based position
+// pos with replacement, like the MySQL INSERT function. It returns s if pos
+// is out of range, and replaces the rest of s if length exceeds it.
+func insertString(s string, pos, length int64, replacement string) string {
+	runes := []rune(s)
+	if pos < 1 || pos > int64(len(runes)) {
+		return s
+	}
+
+	start := pos - 1
+	end := int64(len(runes))
+	if length >= 0 && length < end-start {
+		end = start + length
+	}
+
+	return string(runes[:start]) + replacement + string(runes[end:])
+}
+
+// strcmp compares two strings like the built-in collation of SQLite, which
+// is BINARY if empty, and returns -1, 0, or 1. It returns NULL if any
+// argument is NULL.
+func strcmp(args []driver.Value, collation string) (driver.Value, error) {
+	if hasNull(args) {
+		return nil, nil
+	}
+
+	a, b := sqliteText(args[0]), sqliteText(args[1])
+	switch collation {
+	case "NOCASE":
+		// NOCASE only folds the ASCII letters
+		a, b = asciiLower(a), asciiLower(b)
+	case "RTRIM":
+		a, b = strings.TrimRight(a, " "), strings.TrimRight(b, " ")
+	}
+
+	return int64(strings.Compare(a, b)), nil
+}
+
+// sortKey returns the comparison key of the text of the argument in the
+// collation as upper-case hexadecimal, so that the keys of two strings
+// compare like the strings, or NULL if the argument is NULL. It is only
+// informational, such as to show why ORDER BY puts two strings together.
+func sortKey(args []driver.Value, collation string) driver.Value {
+	if args[0] == nil {
+		return nil
+	}
+
+	key := sqliteText(args[0])
+	switch collation {
+	case "NOCASE":
+		// NOCASE only folds the ASCII letters
+		key = asciiLower(key)
+	case "RTRIM":
+		key = strings.TrimRight(key, " ")
+	}
+
+	return strings.ToUpper(hex.EncodeToString([]byte(key)))
+}
+
+// unhexText decodes the hexadecimal digits to a string like MySQL UNHEX,
+// assuming a leading 0 for an odd number of digits. It returns NULL if the
+// string has a non-hexadecimal character or does not decode to UTF-8, which
+// UNHEX returns as a BLOB instead.
+func unhexText(digits string) driver.Value {
+	if len(digits)%2 == 1 {
+		digits = "0" + digits
+	}
+
+	decoded, err := hex.DecodeString(digits)
+	if err != nil || !utf8.Valid(decoded) {
+		return nil
+	}
+
+	return string(decoded)
+}
+
+// extremum returns the smallest argument if sign is -1, or the largest if
+// sign is 1, like MySQL LEAST and GREATEST: the arguments are compared as
+// integers if they are all integers, as doubles if any is a real number,
+// and as strings otherwise. The strings are compared like the BINARY
+// collation. It returns NULL if any argument is NULL, or, with ignoreNull,
+// if all the arguments are NULL.
+func extremum(args []driver.Value, sign int, ignoreNull bool) driver.Value {
+	if ignoreNull {
+		args = slices.DeleteFunc(slices.Clone(args), func(arg driver.Value) bool { return arg == nil })
+	}
+	if len(args) == 0 || hasNull(args) {
+		return nil
+	}
+
+	allIntegers, hasReal := true, false
+	for _, arg := range args {
+		switch arg.(type) {
+		case int64:
+		case float64:
+			hasReal = true
+			allIntegers = false
+		default:
+			allIntegers = false
+		}
+	}
+
+	compare := func(a, b driver.Value) int {
+		switch {
+		case allIntegers:
+			return cmp.Compare(a.(int64), b.(int64))
+		case hasReal:
+			return cmp.Compare(toDouble(a), toDouble(b))
+		default:
+			return strings.Compare(sqliteText(a), sqliteText(b))
+		}
+	}
+
+	result := args[0]
+	for _, arg := range args[1:] {
+		if compare(arg, result)*sign > 0 {
+			result = arg
+		}
+	}
+
+	return result
+}
+
+// asciiLower lower-cases the ASCII letters of a string.
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, s)
+}
+
+// quoteEscaper escapes the characters escaped by the MySQL QUOTE function.
+var quoteEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\x00", `\0`,
+	"\x1a", `\Z`,
+)
+
+// quoteString returns s as a single-quoted SQL string literal, escaping
+// backslashes, single quotes, NUL, and Control+Z with a backslash like MySQL.
+func quoteString(s string) string {
+	return "'" + quoteEscaper.Replace(s) + "'"
+}
+
+// regexpCache keeps the compiled patterns of REGEXP, which is called
+// with the same pattern for each row.
+var regexpCache = func() *lru.Cache[string, *regexp.Regexp] {
+	cache, err := lru.New[string, *regexp.Regexp](128)
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}()
+
+// compileRegexp compiles a pattern of REGEXP. The match is case-insensitive
+// like MySQL with its default collation.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexpCache.Get(pattern); ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	regexpCache.Add(pattern, re)
+
+	return re, nil
+}
+
+// dayZero is 0000-01-01 in days since the Unix epoch, see toDays.
+var dayZero = time.Date(0, time.January, 1, 0, 0, 0, 0, time.UTC).Unix() / 86400
+
+// maxDays is the day number of 9999-12-31, the last date of FROM_DAYS.
+const maxDays = 3652424
+
+// toDays returns the MySQL day number of the date, ignoring its time.
+//
+// MySQL counts the days of the proleptic Gregorian calendar from
+// 0000-01-01, which is day 1, except that year 0 is not a leap year.
+// Counting the days since 0000-01-01 in Go, whose year 0 is a leap year,
+// thus results in the same numbers from 0000-03-01.
+func toDays(d time.Time) int64 {
+	midnight := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+	days := midnight.Unix()/86400 - dayZero
+	if d.Year() == 0 && d.Month() <= time.February {
+		days++
+	}
+
+	return days
+}
+
+// fromDays returns the date of the MySQL day number, the inverse of toDays.
+// It returns NULL for the days before year 1 and after year 9999, for which
+// MySQL returns 0000-00-00 or dates SQLite does not understand.
+func fromDays(n int64) driver.Value {
+	if n < 366 || n > maxDays {
+		return nil
+	}
+
+	return time.Unix((dayZero+n)*86400, 0).UTC().Format(time.DateOnly)
+}
+
+// periodToMonths converts a period in the YYMM or YYYYMM format to
+// the number of months since year 0. Two-digit years are mapped to
+// 1970-2069 like MySQL.
+func periodToMonths(period int64) int64 {
+	year, month := period/100, period%100
+	if year < 100 {
+		if year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+
+	return year*12 + month - 1
+}
+
+// addTime adds sign times the time interval to expr, which is either
+// a datetime or a time. It returns NULL if any argument is NULL or invalid.
+func addTime(expr, interval driver.Value, sign time.Duration) (driver.Value, error) {
+	if expr == nil || interval == nil {
+		return nil, nil
+	}
+
+	delta, ok := parseTimeInterval(interval)
+	if !ok {
+		return nil, nil
+	}
+	delta *= sign
+
+	// A time value, such as '01:00:00', is added as a duration.
+	if d, ok := parseTimeInterval(expr); ok {
+		return formatTimeInterval(d + delta), nil
+	}
+
+	d, err := parseSqliteDate(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parse date: %w", err)
+	}
+	if d.IsZero() {
+		return nil, nil
+	}
+
+	return formatSqliteDate(d.Add(delta)), nil
+}
+
+// addInterval adds sign times the interval of args[1] units of args[2]
+// to the date of args[0]. It returns NULL if any argument is NULL or invalid.
+//
+// A date without the time part stays so if the unit is a day or longer.
+func addInterval(args []driver.Value, sign int64) (driver.Value, error) {
+	if hasNull(args) {
+		return nil, nil
+	}
+
+	n, err := toInt64(args[1])
+	if err != nil {
+		return nil, err
+	}
+	n *= sign
+
+	unit, ok := args[2].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid unit type: %T", args[2])
+	}
+
+	d, err := parseSqliteDate(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse date: %w", err)
+	}
+	if d.IsZero() {
+		return nil, nil
+	}
+
+	var result time.Time
+	dateOnly := false
+	switch strings.ToUpper(unit) {
+	case "SECOND":
+		result = d.Add(time.Duration(n) * time.Second)
+	case "MINUTE":
+		result = d.Add(time.Duration(n) * time.Minute)
+	case "HOUR":
+		result = d.Add(time.Duration(n) * time.Hour)
+	case "DAY":
+		result, dateOnly = d.AddDate(0, 0, int(n)), true
+	case "WEEK":
+		result, dateOnly = d.AddDate(0, 0, int(n)*7), true
+	case "MONTH":
+		result, dateOnly = addMonths(*d, n), true
+	case "QUARTER":
+		result, dateOnly = addMonths(*d, n*3), true
+	case "YEAR":
+		result, dateOnly = addMonths(*d, n*12), true
+	default:
+		return nil, fmt.Errorf("unsupported unit: %s", unit)
+	}
+
+	if str, ok := args[0].(string); ok && dateOnly && len(strings.TrimSpace(str)) == len(time.DateOnly) {
+		return result.Format(time.DateOnly), nil
+	}
+
+	return formatSqliteDate(result), nil
+}
+
+// addMonths adds months to a date like MySQL, which clamps the day to the
+// last day of the resulting month, so 2021-01-31 plus a month is 2021-02-28.
+func addMonths(d time.Time, months int64) time.Time {
+	firstOfMonth := time.Date(d.Year(), d.Month(), 1, d.Hour(), d.Minute(), d.Second(), d.Nanosecond(), d.Location())
+	target := firstOfMonth.AddDate(0, int(months), 0)
+
+	lastDay := target.AddDate(0, 1, -1).Day()
+	return target.AddDate(0, 0, min(d.Day(), lastDay)-1)
+}
+
+// parseTimeInterval parses a MySQL time value in the
+// "[-][D ]HH:MM:SS[.fraction]", "HH:MM", or "SS[.fraction]" format.
+func parseTimeInterval(v driver.Value) (time.Duration, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, false
+	}
+
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	var days int64
+	if dayPart, rest, found := strings.Cut(s, " "); found {
+		var err error
+		if days, err = strconv.ParseInt(dayPart, 10, 64); err != nil || days < 0 {
+			return 0, false
+		}
+		s = strings.TrimSpace(rest)
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 || (days != 0 && len(parts) == 1) {
+		return 0, false
+	}
+
+	var hours, minutes int64
+	var seconds float64
+	var err error
+	switch len(parts) {
+	case 1:
+		seconds, err = strconv.ParseFloat(parts[0], 64)
+	case 2:
+		if hours, err = strconv.ParseInt(parts[0], 10, 64); err == nil {
+			minutes, err = strconv.ParseInt(parts[1], 10, 64)
+		}
+	case 3:
+		if hours, err = strconv.ParseInt(parts[0], 10, 64); err == nil {
+			if minutes, err = strconv.ParseInt(parts[1], 10, 64); err == nil {
+				seconds, err = strconv.ParseFloat(parts[2], 64)
+			}
+		}
+	}
+	if err != nil || hours < 0 || minutes < 0 || minutes > 59 || seconds < 0 || (len(parts) > 1 && seconds >= 60) {
+		return 0, false
+	}
+
+	d := time.Duration(days*24+hours)*time.Hour +
+		time.Duration(minutes)*time.Minute +
+		time.Duration(math.Round(seconds*1e6))*time.Microsecond
+	if negative {
+		d = -d
+	}
+
+	return d, true
+}
+
+// formatTimeInterval formats a duration as a MySQL time value,
+// e.g. "-26:00:00.5".
+func formatTimeInterval(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+
+	hours := d / time.Hour
+	minutes := d % time.Hour / time.Minute
+	seconds := d % time.Minute / time.Second
+	micros := d % time.Second / time.Microsecond
+
+	s := fmt.Sprintf("%s%02d:%02d:%02d", sign, hours, minutes, seconds)
+	if micros != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%06d", micros), "0")
+	}
+
+	return s
+}
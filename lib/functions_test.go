@@ -0,0 +1,1167 @@
+package sqlrunner_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisteredFunctions(t *testing.T) {
+	t.Parallel()
+
+	functions := make(map[string]sqlrunner.FunctionInfo)
+	for _, fn := range sqlrunner.RegisteredFunctions() {
+		functions[fn.Name] = fn
+	}
+
+	for name, nargs := range map[string]int32{
+		"YEAR": 1,
+		"LEFT": 2,
+		"IF":   3,
+	} {
+		fn, ok := functions[name]
+		require.True(t, ok, "function %s is not registered", name)
+		assert.Equal(t, nargs, fn.NArgs, "function %s", name)
+		assert.True(t, fn.Deterministic, "function %s", name)
+		assert.NotEmpty(t, fn.Description, "function %s", name)
+	}
+}
+
+func TestNullFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE nulltest (
+			value INT
+		);
+
+		INSERT INTO nulltest (value) VALUES (1);
+		INSERT INTO nulltest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	t.Run("ISNULL", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT ISNULL(value) FROM nulltest")
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"ISNULL(value)"}, result.Columns)
+		assert.Equal(t, [][]string{{"0"}, {"1"}}, result.Rows)
+	})
+
+	t.Run("ISNULL operator", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT value ISNULL FROM nulltest")
+		require.NoError(t, err)
+
+		assert.Equal(t, [][]string{{"0"}, {"1"}}, result.Rows)
+	})
+
+	t.Run("NULL_SAFE_EQ", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT NULL_SAFE_EQ(NULL, NULL), NULL_SAFE_EQ(1, NULL), NULL_SAFE_EQ(NULL, 1), NULL_SAFE_EQ(1, 1.0), NULL_SAFE_EQ('a', 'b')")
+		require.NoError(t, err)
+
+		assert.Equal(t, [][]string{{"1", "0", "0", "1", "0"}}, result.Rows)
+	})
+
+	t.Run("NULL_SAFE_EQ on column", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT value FROM nulltest WHERE NULL_SAFE_EQ(value, NULL)")
+		require.NoError(t, err)
+
+		assert.Equal(t, [][]string{{"NULL"}}, result.Rows)
+	})
+}
+
+func TestDateConstructionFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE dateconstructtest (
+			value INT
+		);
+
+		INSERT INTO dateconstructtest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT MAKEDATE(2021, 32)":                                "2021-02-01",
+		"SELECT MAKEDATE(2021, 1)":                                 "2021-01-01",
+		"SELECT MAKEDATE(2021, 366)":                               "2022-01-01",
+		"SELECT MAKEDATE(2021, 0)":                                 "NULL",
+		"SELECT TO_DAYS('2007-10-07')":                             "733321",
+		"SELECT TO_DAYS('1995-05-01 23:59:59')":                    "728779",
+		"SELECT TO_DAYS('0000-01-01')":                             "1",
+		"SELECT TO_DAYS('0001-03-01')":                             "425",
+		"SELECT TO_DAYS('not a date')":                             "NULL",
+		"SELECT TO_DAYS('2008-01-01') - TO_DAYS('2007-12-01')":     "31",
+		"SELECT FROM_DAYS(730669)":                                 "2000-07-03",
+		"SELECT FROM_DAYS(TO_DAYS('2024-02-29'))":                  "2024-02-29",
+		"SELECT FROM_DAYS(TO_DAYS('9999-12-31'))":                  "9999-12-31",
+		"SELECT FROM_DAYS(365)":                                    "NULL",
+		"SELECT FROM_DAYS('not a number')":                         "NULL",
+		"SELECT MAKETIME(12, 15, 30)":                              "12:15:30",
+		"SELECT MAKETIME(-1, 2, 3)":                                "-01:02:03",
+		"SELECT MAKETIME(12, 60, 30)":                              "NULL",
+		"SELECT PERIOD_DIFF(202103, 202012)":                       "3",
+		"SELECT PERIOD_DIFF(2012, 202103)":                         "-3",
+		"SELECT MAKEDATE(value, 1) FROM dateconstructtest":         "NULL",
+		"SELECT MAKETIME(1, value, 1) FROM dateconstructtest":      "NULL",
+		"SELECT PERIOD_DIFF(value, 202103) FROM dateconstructtest": "NULL",
+		"SELECT TO_DAYS(value) FROM dateconstructtest":             "NULL",
+		"SELECT FROM_DAYS(value) FROM dateconstructtest":           "NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+}
+
+func TestTimeArithmeticFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE timearithtest (
+			value TEXT
+		);
+
+		INSERT INTO timearithtest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT ADDTIME('2021-01-01 23:00:00', '02:00:00')":               "2021-01-02 01:00:00",
+		"SELECT ADDTIME('2021-12-31 23:59:59', '1 00:00:01')":             "2022-01-02 00:00:00",
+		"SELECT ADDTIME('2021-01-01 00:00:00', '00:00:01.5')":             "2021-01-01 00:00:01.5",
+		"SELECT ADDTIME('2021-01-01', '-01:00:00')":                       "2020-12-31 23:00:00",
+		"SELECT ADDTIME('01:00:00', '02:30:00')":                          "03:30:00",
+		"SELECT ADDTIME('23:00:00', '02:00:00')":                          "25:00:00",
+		"SELECT SUBTIME('2021-01-02 01:00:00', '02:00:00')":               "2021-01-01 23:00:00",
+		"SELECT SUBTIME('2021-03-01 00:00:00', '1 00:00:00')":             "2021-02-28 00:00:00",
+		"SELECT SUBTIME('01:00:00', '02:00:00')":                          "-01:00:00",
+		"SELECT ADDTIME('2021-01-01 00:00:00', '00:60:00')":               "NULL",
+		"SELECT ADDTIME(value, '01:00:00') FROM timearithtest":            "NULL",
+		"SELECT SUBTIME('2021-01-01 00:00:00', value) FROM timearithtest": "NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+}
+
+func TestDateIntervalFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE dateintervaltest (
+			value TEXT
+		);
+
+		INSERT INTO dateintervaltest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT DATE_ADD('2021-01-01', 7, 'DAY')":                           "2021-01-08",
+		"SELECT DATE_ADD('2021-01-31', 1, 'month')":                         "2021-02-28",
+		"SELECT DATE_ADD('2020-02-29', 1, 'YEAR')":                          "2021-02-28",
+		"SELECT DATE_ADD('2021-01-01', 2, 'QUARTER')":                       "2021-07-01",
+		"SELECT DATE_ADD('2021-01-01', 1, 'WEEK')":                          "2021-01-08",
+		"SELECT DATE_ADD('2021-01-01', 90, 'MINUTE')":                       "2021-01-01 01:30:00",
+		"SELECT DATE_ADD('2021-01-01 23:00:00', 2, 'HOUR')":                 "2021-01-02 01:00:00",
+		"SELECT DATE_SUB('2021-03-31', 1, 'MONTH')":                         "2021-02-28",
+		"SELECT DATE_SUB('2021-01-01 00:00:00', 1, 'SECOND')":               "2020-12-31 23:59:59",
+		"SELECT DATE_ADD(value, 1, 'DAY') FROM dateintervaltest":            "NULL",
+		"SELECT DATE_SUB('2021-01-01', value, 'DAY') FROM dateintervaltest": "NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+
+	t.Run("Unsupported unit", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.Query(context.TODO(), "SELECT DATE_ADD('2021-01-01', 1, 'FORTNIGHT')")
+		assert.Error(t, err)
+	})
+}
+
+func TestTimestampAddFunction(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE timestampaddtest (
+			value TEXT
+		);
+
+		INSERT INTO timestampaddtest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT TIMESTAMPADD('MONTH', 2, '2021-01-31')":                         "2021-03-31",
+		"SELECT TIMESTAMPADD('MONTH', 1, '2021-01-31')":                         "2021-02-28",
+		"SELECT TIMESTAMPADD('MONTH', 1, '2024-01-31 08:30:00')":                "2024-02-29 08:30:00",
+		"SELECT TIMESTAMPADD('MONTH', -1, '2021-03-31')":                        "2021-02-28",
+		"SELECT TIMESTAMPADD('QUARTER', 1, '2021-11-30')":                       "2022-02-28",
+		"SELECT TIMESTAMPADD('YEAR', 1, '2020-02-29')":                          "2021-02-28",
+		"SELECT TIMESTAMPADD('WEEK', 2, '2021-01-01')":                          "2021-01-15",
+		"SELECT TIMESTAMPADD('DAY', 1, '2021-12-31')":                           "2022-01-01",
+		"SELECT TIMESTAMPADD('HOUR', 25, '2021-01-01')":                         "2021-01-02 01:00:00",
+		"SELECT TIMESTAMPADD('minute', 30, '2021-01-01 23:45:00')":              "2021-01-02 00:15:00",
+		"SELECT TIMESTAMPADD('SECOND', -1, '2021-01-01 00:00:00')":              "2020-12-31 23:59:59",
+		"SELECT TIMESTAMPADD('DAY', 1, value) FROM timestampaddtest":            "NULL",
+		"SELECT TIMESTAMPADD('DAY', value, '2021-01-01') FROM timestampaddtest": "NULL",
+		"SELECT TIMESTAMPADD(value, 1, '2021-01-01') FROM timestampaddtest":     "NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+
+	t.Run("Unsupported unit", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.Query(context.TODO(), "SELECT TIMESTAMPADD('FRAC_SECOND', 1, '2021-01-01')")
+		assert.Error(t, err)
+	})
+}
+
+func TestMySQLDateArithmetic(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE datearithtest (
+			d DATE
+		);
+
+		INSERT INTO datearithtest (d) VALUES ('2021-01-28');
+	`
+
+	t.Run("Rewritten", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithMySQLDateArithmetic(true))
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT d + INTERVAL 7 DAY, d - INTERVAL 1 MONTH FROM datearithtest")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"d + INTERVAL 7 DAY", "d - INTERVAL 1 MONTH"}, result.Columns)
+		assert.Equal(t, [][]string{{"2021-02-04", "2020-12-28"}}, result.Rows)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema)
+		require.NoError(t, err)
+
+		_, err = runner.Query(context.TODO(), "SELECT d + INTERVAL 7 DAY FROM datearithtest")
+		var queryError sqlrunner.QueryError
+		assert.ErrorAs(t, err, &queryError)
+	})
+}
+
+func TestStrictDivision(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE strictdivisiontest (
+			a INT,
+			b INT
+		);
+
+		INSERT INTO strictdivisiontest (a, b) VALUES (7, 2), (1, 0);
+	`
+
+	t.Run("NULL", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema)
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT 1/0, 1 % 0, MOD(1, 0)")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"NULL", "NULL", "NULL"}}, result.Rows)
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithStrictDivision(true))
+		require.NoError(t, err)
+
+		for _, query := range []string{
+			"SELECT 1/0",
+			"SELECT 1 % 0",
+			"SELECT MOD(1, 0)",
+			"SELECT 1.5 / 0.0",
+			"SELECT 1 / '0'",
+			"SELECT a / b FROM strictdivisiontest",
+			"SELECT a / (b * 2) FROM strictdivisiontest",
+		} {
+			_, err := runner.Query(context.TODO(), query)
+			var queryError sqlrunner.QueryError
+			require.ErrorAs(t, err, &queryError, query)
+			assert.Contains(t, err.Error(), "division by zero", query)
+		}
+
+		// The other divisions are not affected, including the column names
+		result, err := runner.Query(context.TODO(), "SELECT a / b, a % b, MOD(a, b), a / NULL FROM strictdivisiontest WHERE b <> 0")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a / b", "a % b", "MOD(a, b)", "a / NULL"}, result.Columns)
+		assert.Equal(t, [][]string{{"3", "1", "1", "NULL"}}, result.Rows)
+	})
+}
+
+func TestIntegerOverflow(t *testing.T) {
+	t.Parallel()
+
+	schema := "CREATE TABLE overflowtest (value INT);"
+	query := "SELECT POW(2, 64), POWER(3, 39), pow(2, 0.5), MOD(9007199254740993, 10)"
+
+	for name, tc := range map[string]struct {
+		mode     sqlrunner.IntegerOverflow
+		expected []string
+	}{
+		"Float": {
+			mode:     sqlrunner.OverflowFloat,
+			expected: []string{"18446744073709552000", "4052555153018976000", "1.4142135623730951", "2"},
+		},
+		"Wrap": {
+			mode:     sqlrunner.OverflowWrap,
+			expected: []string{"0", "4052555153018976267", "1.4142135623730951", "3"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithIntegerOverflow(tc.mode))
+			require.NoError(t, err)
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"POW(2, 64)", "POWER(3, 39)", "pow(2, 0.5)", "MOD(9007199254740993, 10)"}, result.Columns)
+			assert.Equal(t, [][]string{tc.expected}, result.Rows)
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithIntegerOverflow(sqlrunner.OverflowError))
+		require.NoError(t, err)
+
+		_, err = runner.Query(context.TODO(), "SELECT POW(2, 64)")
+		var queryError sqlrunner.QueryError
+		require.ErrorAs(t, err, &queryError)
+		assert.Contains(t, err.Error(), "BIGINT value is out of range in POW(2, 64)")
+
+		// The results in range are exact integers
+		result, err := runner.Query(context.TODO(), "SELECT POW(2, 62), POW(-2, 63), POWER(3, 39), MOD(-7, 2), MOD(1, 0)")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"4611686018427387904", "-9223372036854775808", "4052555153018976267", "-1", "NULL"}}, result.Rows)
+	})
+}
+
+func TestRoundingFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE roundingtest (
+			value INT
+		);
+
+		INSERT INTO roundingtest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT CEIL(1.1)":                                   "2",
+		"SELECT CEILING(-1.9)":                               "-1",
+		"SELECT FLOOR(-1.1)":                                 "-2",
+		"SELECT FLOOR(1.9)":                                  "1",
+		"SELECT CEIL(5), FLOOR(-5)":                          "5|-5",
+		"SELECT typeof(CEIL(5)), typeof(FLOOR(5))":           "integer|integer",
+		"SELECT typeof(CEIL(1.1)), typeof(FLOOR(1))":         "real|integer",
+		"SELECT CEIL(9007199254740993)":                      "9007199254740993",
+		"SELECT CEIL('2.5'), FLOOR('7')":                     "3|7",
+		"SELECT CEIL(value), FLOOR(value) FROM roundingtest": "NULL|NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			require.Len(t, result.Rows, 1)
+			assert.Equal(t, expected, strings.Join(result.Rows[0], "|"))
+		})
+	}
+}
+
+func TestMathFunctionsOnEmptyAggregates(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE emptyaggtest (
+			grp INT,
+			value REAL
+		);
+
+		INSERT INTO emptyaggtest (grp, value) VALUES (1, NULL), (1, NULL);
+	`)
+	require.NoError(t, err)
+
+	// The aggregates of an empty group or of only NULLs, except COUNT,
+	// are NULL, which the math functions propagate rather than
+	// turning into 0 or an error.
+	for _, aggregate := range []string{
+		"AVG(value)",
+		"SUM(value)",
+		"MAX(value)",
+		"MIN(value)",
+	} {
+		for _, function := range []string{
+			"CEIL(%s)",
+			"CEILING(%s)",
+			"FLOOR(%s)",
+			"ROUND(%s)",
+			"ROUND(%s, 2)",
+			"MOD(%s, 3)",
+			"MOD(7, %s)",
+		} {
+			expression := fmt.Sprintf(function, aggregate)
+
+			t.Run(expression, func(t *testing.T) {
+				t.Parallel()
+
+				for _, query := range []string{
+					"SELECT " + expression + " FROM emptyaggtest",
+					"SELECT " + expression + " FROM emptyaggtest WHERE grp = 2",
+					"SELECT grp, " + expression + " FROM emptyaggtest GROUP BY grp",
+				} {
+					result, err := runner.Query(context.TODO(), query)
+					require.NoError(t, err, query)
+					require.Len(t, result.Rows, 1, query)
+					assert.Equal(t, "NULL", result.Rows[0][len(result.Rows[0])-1], query)
+				}
+			})
+		}
+	}
+}
+
+func TestLengthFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE lengthtest (
+			value TEXT
+		);
+
+		INSERT INTO lengthtest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT CHAR_LENGTH('héllo'), OCTET_LENGTH('héllo'), BIT_LENGTH('héllo')":           "5|6|48",
+		"SELECT CHAR_LENGTH('日本'), OCTET_LENGTH('日本'), BIT_LENGTH('日本')":                    "2|6|48",
+		"SELECT CHAR_LENGTH('abc'), OCTET_LENGTH('abc'), BIT_LENGTH('abc')":                 "3|3|24",
+		"SELECT OCTET_LENGTH(''), BIT_LENGTH(''), OCTET_LENGTH(123)":                        "0|0|3",
+		"SELECT OCTET_LENGTH(X'00FF'), BIT_LENGTH(X'00FF')":                                 "2|16",
+		"SELECT CHAR_LENGTH(value), OCTET_LENGTH(value), BIT_LENGTH(value) FROM lengthtest": "NULL|NULL|NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			require.Len(t, result.Rows, 1)
+			assert.Equal(t, expected, strings.Join(result.Rows[0], "|"))
+		})
+	}
+}
+
+func TestSubstringIndexFunction(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE substringindextest (
+			value TEXT
+		);
+
+		INSERT INTO substringindextest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT SUBSTRING_INDEX('a.b.c.d', '.', 2)":                       "a.b",
+		"SELECT SUBSTRING_INDEX('a.b.c.d', '.', -2)":                      "c.d",
+		"SELECT SUBSTRING_INDEX('a.b.c.d', '.', 10)":                      "a.b.c.d",
+		"SELECT SUBSTRING_INDEX('a.b.c.d', '.', -10)":                     "a.b.c.d",
+		"SELECT SUBSTRING_INDEX('a.b.c.d', '.', 0)":                       "",
+		"SELECT SUBSTRING_INDEX('alice@example.com', '@', -1)":            "example.com",
+		"SELECT SUBSTRING_INDEX('/usr/local/bin', '/', 2)":                "/usr",
+		"SELECT SUBSTRING_INDEX('一、二、三', '、', 2)":                         "一、二",
+		"SELECT SUBSTRING_INDEX('a::b::c', '::', -1)":                     "c",
+		"SELECT SUBSTRING_INDEX(value, '.', 1) FROM substringindextest":   "NULL",
+		"SELECT SUBSTRING_INDEX('a.b', value, 1) FROM substringindextest": "NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+}
+
+func TestInsertFunction(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE insertfunctiontest (
+			value TEXT
+		);
+
+		INSERT INTO insertfunctiontest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT INSERT('Quadratic', 3, 4, 'What')":                    "QuWhattic",
+		"SELECT insert('Quadratic', 1, 0, 'Very ')":                   "Very Quadratic",
+		"SELECT INSERT('Quadratic', -1, 4, 'What')":                   "Quadratic",
+		"SELECT INSERT('Quadratic', 10, 4, 'What')":                   "Quadratic",
+		"SELECT INSERT('Quadratic', 3, 100, 'What')":                  "QuWhat",
+		"SELECT INSERT('Quadratic', 3, -1, 'What')":                   "QuWhat",
+		"SELECT INSERT('資料庫系統', 3, 1, '倉')":                           "資料倉系統",
+		"SELECT INSERT(value, 1, 1, 'x') FROM insertfunctiontest":     "NULL",
+		"SELECT INSERT('abc', 1, value, 'x') FROM insertfunctiontest": "NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+
+	t.Run("Column name", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT INSERT('abc', 2, 1, 'x')")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"INSERT('abc', 2, 1, 'x')"}, result.Columns)
+	})
+}
+
+// TestReplaceFunction verifies that SQLite's built-in REPLACE agrees with
+// MySQL's, so it is not overridden.
+func TestReplaceFunction(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE replacetest (
+			value TEXT
+		);
+
+		INSERT INTO replacetest (value) VALUES (NULL);
+	`
+
+	runner, err := sqlrunner.NewSQLRunner(schema)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT REPLACE('www.mysql.com', 'w', 'Ww')":           "WwWwWw.mysql.com",
+		"SELECT REPLACE('Hello hello', 'hello', 'bye')":        "Hello bye",
+		"SELECT REPLACE('aaa', 'aa', 'b')":                     "ba",
+		"SELECT REPLACE('abc', 'x', 'y')":                      "abc",
+		"SELECT REPLACE('abc', '', 'y')":                       "abc",
+		"SELECT REPLACE('a-b-c', '-', '')":                     "abc",
+		"SELECT REPLACE('資料庫系統', '資料庫', '倉')":                  "倉系統",
+		"SELECT REPLACE('café café', 'é', 'e')":                "cafe cafe",
+		"SELECT REPLACE(REPLACE('a-b_c', '-', '_'), '_', ' ')": "a b c",
+		"SELECT REPLACE(12345, 3, 0)":                          "12045",
+		"SELECT REPLACE(value, 'a', 'b') FROM replacetest":     "NULL",
+		"SELECT REPLACE('abc', value, 'b') FROM replacetest":   "NULL",
+		"SELECT REPLACE('abc', 'a', value) FROM replacetest":   "NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+
+	t.Run("Collation", func(t *testing.T) {
+		t.Parallel()
+
+		// REPLACE is case-sensitive regardless of the collation, like MySQL
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithDefaultCollation("NOCASE"))
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT REPLACE('Hello hello', 'HELLO', 'bye')")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"Hello hello"}}, result.Rows)
+	})
+}
+
+func TestFieldFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE fieldtest (
+			id INT,
+			status TEXT
+		);
+
+		INSERT INTO fieldtest (id, status) VALUES (1, 'paid'), (2, 'new'), (3, 'shipped'), (4, 'new'), (5, NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT FIELD('b', 'a', 'b', 'c')":   "2",
+		"SELECT FIELD('d', 'a', 'b', 'c')":   "0",
+		"SELECT FIELD('b', 'a', 'b', 'b')":   "2",
+		"SELECT FIELD('B', 'a', 'b', 'c')":   "0",
+		"SELECT FIELD(NULL, 'a', NULL)":      "0",
+		"SELECT FIELD('a', NULL, 'a')":       "2",
+		"SELECT FIELD(2, 1, 2, 3)":           "2",
+		"SELECT FIELD(2.0, 1, 2, 3)":         "2",
+		"SELECT FIELD('2', 1, 2, 3)":         "2",
+		"SELECT FIELD('2abc', 'x', 2)":       "2",
+		"SELECT FIELD('資料', '資料庫', '資料')":    "2",
+		"SELECT FIND_IN_SET('b', 'a,b,c')":   "2",
+		"SELECT FIND_IN_SET('d', 'a,b,c')":   "0",
+		"SELECT FIND_IN_SET('', 'a,,c')":     "2",
+		"SELECT FIND_IN_SET('', '')":         "0",
+		"SELECT FIND_IN_SET('a,b', 'a,b,c')": "0",
+		"SELECT FIND_IN_SET(2, '1,2,3')":     "2",
+		"SELECT FIND_IN_SET(NULL, 'a,b')":    "NULL",
+		"SELECT FIND_IN_SET('a', NULL)":      "NULL",
+		"SELECT FIND_IN_SET('資料', '資料庫,資料')": "2",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+
+	t.Run("ORDER BY FIELD", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT id FROM fieldtest ORDER BY FIELD(status, 'new', 'paid', 'shipped'), id")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"5"}, {"2"}, {"4"}, {"1"}, {"3"}}, result.Rows)
+	})
+
+	t.Run("Too few arguments", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.Query(context.TODO(), "SELECT FIELD('a')")
+		require.Error(t, err)
+	})
+}
+
+func TestLeastGreatestFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE leasttest (
+			a INT,
+			b INT,
+			c INT
+		);
+
+		INSERT INTO leasttest (a, b, c) VALUES (3, NULL, 1);
+		INSERT INTO leasttest (a, b, c) VALUES (NULL, NULL, NULL);
+	`)
+	require.NoError(t, err)
+
+	for args, expected := range map[string][4]string{
+		// LEAST, GREATEST, LEAST_IGNORE_NULL, GREATEST_IGNORE_NULL
+		"3, 1, 2":                          {"1", "3", "1", "3"},
+		"3, NULL, 1":                       {"NULL", "NULL", "1", "3"},
+		"NULL, NULL":                       {"NULL", "NULL", "NULL", "NULL"},
+		"NULL, 2":                          {"NULL", "NULL", "2", "2"},
+		"1.5, 2, -1":                       {"-1", "2", "-1", "2"},
+		"'b', 'a', 'c'":                    {"a", "c", "a", "c"},
+		"'B', 'a'":                         {"B", "a", "B", "a"},
+		"'10', 9":                          {"10", "9", "10", "9"},
+		"'10', 9.5":                        {"9.5", "10", "9.5", "10"},
+		"'2021-03-04', NULL, '2020-01-01'": {"NULL", "NULL", "2020-01-01", "2021-03-04"},
+	} {
+		query := fmt.Sprintf("SELECT LEAST(%[1]s), GREATEST(%[1]s), LEAST_IGNORE_NULL(%[1]s), GREATEST_IGNORE_NULL(%[1]s)", args)
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{expected[:]}, result.Rows)
+		})
+	}
+
+	t.Run("Columns", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT LEAST(a, b, c), LEAST_IGNORE_NULL(a, b, c), GREATEST_IGNORE_NULL(a, b, c) FROM leasttest ORDER BY a IS NULL")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"NULL", "1", "3"}, {"NULL", "NULL", "NULL"}}, result.Rows)
+	})
+
+	t.Run("Too few arguments", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.Query(context.TODO(), "SELECT LEAST_IGNORE_NULL(1)")
+		require.Error(t, err)
+	})
+}
+
+func TestStrcmpFunction(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE strcmptest (
+			name TEXT
+		);
+
+		INSERT INTO strcmptest (name) VALUES ('alice');
+		INSERT INTO strcmptest (name) VALUES (NULL);
+	`
+
+	runner, err := sqlrunner.NewSQLRunner(schema)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		"SELECT STRCMP('a', 'b')":                                     "-1",
+		"SELECT STRCMP('b', 'a')":                                     "1",
+		"SELECT STRCMP('abc', 'abc')":                                 "0",
+		"SELECT STRCMP('a', 'ab')":                                    "-1",
+		"SELECT STRCMP(1, '1')":                                       "0",
+		"SELECT STRCMP(name, 'a') FROM strcmptest WHERE name IS NULL": "NULL",
+		"SELECT STRCMP('a', NULL)":                                    "NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+
+	t.Run("Collation", func(t *testing.T) {
+		t.Parallel()
+
+		for collation, expected := range map[string][]string{
+			"BINARY": {"1", "0"},
+			"NOCASE": {"0", "1"},
+		} {
+			runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithDefaultCollation(collation))
+			require.NoError(t, err)
+
+			// STRCMP agrees with the comparison of the column
+			result, err := runner.Query(context.TODO(), "SELECT STRCMP(name, 'ALICE'), name = 'ALICE' FROM strcmptest WHERE name IS NOT NULL")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"STRCMP(name, 'ALICE')", "name = 'ALICE'"}, result.Columns, "collation %s", collation)
+			assert.Equal(t, [][]string{expected}, result.Rows, "collation %s", collation)
+		}
+	})
+}
+
+func TestSortKeyFunction(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE sortkeytest (
+			name TEXT
+		);
+
+		INSERT INTO sortkeytest (name) VALUES ('b');
+		INSERT INTO sortkeytest (name) VALUES ('B');
+		INSERT INTO sortkeytest (name) VALUES ('a ');
+		INSERT INTO sortkeytest (name) VALUES (NULL);
+	`
+
+	// The keys sort like the column, so NOCASE folds 'b' and 'B' to the
+	// same key, unlike BINARY.
+	for collation, expected := range map[string][][]string{
+		"BINARY": {{"NULL", "NULL"}, {"B", "42"}, {"a ", "6120"}, {"b", "62"}},
+		"NOCASE": {{"NULL", "NULL"}, {"a ", "6120"}, {"b", "62"}, {"B", "62"}},
+		"RTRIM":  {{"NULL", "NULL"}, {"B", "42"}, {"a ", "61"}, {"b", "62"}},
+	} {
+		t.Run(collation, func(t *testing.T) {
+			t.Parallel()
+
+			runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithDefaultCollation(collation))
+			require.NoError(t, err)
+
+			result, err := runner.Query(context.TODO(), "SELECT name, SORT_KEY(name) FROM sortkeytest ORDER BY name, rowid")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"name", "SORT_KEY(name)"}, result.Columns)
+			assert.Equal(t, expected, result.Rows)
+		})
+	}
+}
+
+func TestQuoteFunction(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE quotetest (
+			value TEXT
+		);
+
+		INSERT INTO quotetest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		`SELECT QUOTE('Don''t!')`:                `'Don\'t!'`,
+		`SELECT QUOTE('C:\path')`:                `'C:\\path'`,
+		`SELECT QUOTE('hello')`:                  `'hello'`,
+		`SELECT QUOTE(42)`:                       `'42'`,
+		`SELECT QUOTE(value) FROM quotetest`:     `NULL`,
+		`SELECT QUOTE(NULL) IS NULL`:             `0`,
+		`SELECT quote('it''s') = QUOTE('it''s')`: `1`,
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+}
+
+func TestUnhexTextFunction(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE unhextest (
+			value TEXT
+		);
+
+		INSERT INTO unhextest (value) VALUES ('hello');
+		INSERT INTO unhextest (value) VALUES (NULL);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string]string{
+		// HEX encodes the UTF-8 bytes of a text, which UNHEX_TEXT decodes
+		"SELECT HEX('hello')":                                          "68656C6C6F",
+		"SELECT UNHEX_TEXT(HEX('hello'))":                              "hello",
+		"SELECT UNHEX_TEXT(HEX(value)) = value FROM unhextest LIMIT 1": "1",
+		"SELECT UNHEX_TEXT(HEX('你好'))":                                 "你好",
+		"SELECT UNHEX_TEXT('68656c6c6f')":                              "hello",
+		"SELECT UNHEX_TEXT('')":                                        "",
+		"SELECT UNHEX_TEXT('141')":                                     "\x01A",
+		// UNHEX returns a BLOB, rendered as hexadecimal again
+		"SELECT UNHEX(HEX('hello'))":                                  "68656c6c6f",
+		"SELECT UNHEX_TEXT('XY')":                                     "NULL",
+		"SELECT UNHEX_TEXT('FF')":                                     "NULL",
+		"SELECT UNHEX_TEXT(value) FROM unhextest WHERE value IS NULL": "NULL",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{expected}}, result.Rows)
+		})
+	}
+}
+
+func TestConcatNullPropagation(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE concatnulltest (
+			first TEXT,
+			middle TEXT,
+			last TEXT
+		);
+
+		INSERT INTO concatnulltest (first, middle, last) VALUES ('John', NULL, 'Doe');
+	`
+
+	for name, tc := range map[string]struct {
+		opts     []sqlrunner.Option
+		expected []string
+	}{
+		"Default": {
+			expected: []string{"JohnDoe", "John Doe"},
+		},
+		"Propagation": {
+			opts:     []sqlrunner.Option{sqlrunner.WithConcatNullPropagation(true)},
+			expected: []string{"NULL", "NULL"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			runner, err := sqlrunner.NewSQLRunner(schema, tc.opts...)
+			require.NoError(t, err)
+
+			result, err := runner.Query(context.TODO(), "SELECT CONCAT(first, middle, last), concat_ws(' ', first, middle, last) FROM concatnulltest")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"CONCAT(first, middle, last)", "concat_ws(' ', first, middle, last)"}, result.Columns)
+			assert.Equal(t, [][]string{tc.expected}, result.Rows)
+		})
+	}
+
+	t.Run("Propagation without NULL", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithConcatNullPropagation(true))
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT CONCAT(first, ' ', last, 1, 2.0), CONCAT_WS('-', first, last) FROM concatnulltest")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"John Doe12.0", "John-Doe"}}, result.Rows)
+	})
+
+	t.Run("Numbers", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithConcatNullPropagation(true))
+		require.NoError(t, err)
+
+		// The REAL values are rendered like the REAL cells, without an exponent
+		result, err := runner.Query(context.TODO(), "SELECT CONCAT(1145141919.81), CONCAT_WS(',', 1e20, 0.1 + 0.2, -2.5), QUOTE(1e-7)")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"1145141919.81", "100000000000000000000.0,0.30000000000000004,-2.5", "'0.0000001'"}}, result.Rows)
+	})
+}
+
+func TestRegexpOperators(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE regexptest (
+			name TEXT
+		);
+
+		INSERT INTO regexptest (name) VALUES ('alice'), ('Bob'), ('carol'), (NULL);
+	`)
+	require.NoError(t, err)
+
+	for _, condition := range []string{
+		"name %s '^[ab]'",
+		"name NOT %s 'o'",
+		"(name %s 'l') = 1",
+		"name %s NULL",
+	} {
+		t.Run(condition, func(t *testing.T) {
+			t.Parallel()
+
+			regexpResult, err := runner.Query(context.TODO(), "SELECT name FROM regexptest WHERE "+fmt.Sprintf(condition, "REGEXP")+" ORDER BY name")
+			require.NoError(t, err)
+
+			for _, operator := range []string{"RLIKE", "rlike"} {
+				rlikeResult, err := runner.Query(context.TODO(), "SELECT name FROM regexptest WHERE "+fmt.Sprintf(condition, operator)+" ORDER BY name")
+				require.NoError(t, err)
+				assert.Equal(t, regexpResult.Rows, rlikeResult.Rows)
+			}
+		})
+	}
+
+	result, err := runner.Query(context.TODO(), "SELECT name RLIKE '^a' FROM regexptest WHERE name = 'alice'")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name RLIKE '^a'"}, result.Columns)
+	assert.Equal(t, [][]string{{"1"}}, result.Rows)
+
+	_, err = runner.Query(context.TODO(), "SELECT 'a' RLIKE '('")
+	require.Error(t, err)
+}
+
+func TestRowValues(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE rowvaluetest (
+			a INTEGER,
+			b TEXT
+		);
+
+		INSERT INTO rowvaluetest (a, b) VALUES (1, 'x'), (1, 'y'), (2, 'x'), (NULL, 'x');
+	`)
+	require.NoError(t, err)
+
+	for condition, expected := range map[string][][]string{
+		"(a, b) = (1, 'y')":                     {{"1", "y"}},
+		"ROW(a, b) = ROW(1, 'y')":               {{"1", "y"}},
+		"(a, b) IN ((1, 'y'), (2, 'x'))":        {{"1", "y"}, {"2", "x"}},
+		"row (a, b) IN (ROW(1, 'y'), (2, 'x'))": {{"1", "y"}, {"2", "x"}},
+		"(a, b) NOT IN ((1, 'y'), (2, 'x'))":    {{"1", "x"}},
+		"(a, b) IN (VALUES ROW(1, 'x'))":        {{"1", "x"}},
+		"(a, b) > (1, 'x')":                     {{"1", "y"}, {"2", "x"}},
+	} {
+		t.Run(condition, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), "SELECT a, b FROM rowvaluetest WHERE "+condition+" ORDER BY a, b")
+			require.NoError(t, err)
+			assert.Equal(t, expected, result.Rows)
+		})
+	}
+
+	result, err := runner.Query(context.TODO(), "SELECT ROW(1, 2) = row(1, 2)")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ROW(1, 2) = row(1, 2)"}, result.Columns)
+	assert.Equal(t, [][]string{{"1"}}, result.Rows)
+}
+
+func TestJSONAggregateFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE jsonaggtest (
+			class TEXT,
+			name TEXT,
+			score INT
+		);
+
+		INSERT INTO jsonaggtest (class, name, score) VALUES
+			('A', 'alice', 90), ('A', 'bob', NULL), ('B', 'carol', 85);
+	`)
+	require.NoError(t, err)
+
+	for query, expected := range map[string][][]string{
+		"SELECT class, JSON_ARRAYAGG(name ORDER BY name) FROM jsonaggtest GROUP BY class ORDER BY class": {
+			{"A", `["alice","bob"]`},
+			{"B", `["carol"]`},
+		},
+		"SELECT class, JSON_OBJECTAGG(name, score) FROM jsonaggtest GROUP BY class ORDER BY class": {
+			{"A", `{"alice":90,"bob":null}`},
+			{"B", `{"carol":85}`},
+		},
+		"SELECT json_arrayagg(json_object('name', name) ORDER BY name) FROM jsonaggtest WHERE class = 'A'": {
+			{`[{"name":"alice"},{"name":"bob"}]`},
+		},
+		// Unlike MySQL, which returns NULL
+		"SELECT JSON_ARRAYAGG(name) FROM jsonaggtest WHERE class = 'C'": {
+			{`[]`},
+		},
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, expected, result.Rows)
+		})
+	}
+
+	t.Run("Column name", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT JSON_OBJECTAGG(name, score), json_arrayagg(score) FROM jsonaggtest")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"JSON_OBJECTAGG(name, score)", "json_arrayagg(score)"}, result.Columns)
+	})
+}
+
+// TestInformationalFunctions is not parallel since the server information
+// is process-wide.
+func TestInformationalFunctions(t *testing.T) {
+	runner, err := sqlrunner.NewSQLRunner("CREATE TABLE informationaltest (value TEXT);")
+	require.NoError(t, err)
+
+	result, err := runner.Query(context.TODO(), "SELECT VERSION(), DATABASE(), CURRENT_USER()")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"VERSION()", "DATABASE()", "CURRENT_USER()"}, result.Columns)
+	assert.Equal(t, [][]string{{"8.0.36-sqlrunner", "main", "playground@localhost"}}, result.Rows)
+
+	sqlrunner.SetServerInfo(sqlrunner.ServerInfo{Version: "8.4.0", Database: "school", User: "student@%"})
+	defer sqlrunner.SetServerInfo(sqlrunner.DefaultServerInfo)
+
+	// Another query, since the result of the previous one is cached
+	result, err = runner.Query(context.TODO(), "SELECT version(), database(), current_user()")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"8.4.0", "school", "student@%"}}, result.Rows)
+}
+
+func TestGroupConcatMaxLen(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE groupconcattest (
+			grp TEXT,
+			name TEXT
+		);
+
+		INSERT INTO groupconcattest (grp, name) VALUES
+			('a', 'alice'), ('a', 'bob'), ('a', 'alice'), ('b', 'carol'), ('c', '一二三四五六');
+	`, sqlrunner.WithGroupConcatMaxLen(5))
+	require.NoError(t, err)
+
+	t.Run("Truncated", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT grp, GROUP_CONCAT(name) FROM groupconcattest GROUP BY grp ORDER BY grp")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"grp", "GROUP_CONCAT(name)"}, result.Columns)
+		assert.Equal(t, [][]string{{"a", "alice"}, {"b", "carol"}, {"c", "一二三四五"}}, result.Rows)
+		assert.Equal(t, []string{"The result of GROUP_CONCAT is truncated to 5 characters."}, result.Warnings)
+	})
+
+	t.Run("Not truncated", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT GROUP_CONCAT(DISTINCT grp ORDER BY grp) AS grps FROM groupconcattest WHERE grp <> 'c'")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"a,b"}}, result.Rows)
+		assert.Empty(t, result.Warnings)
+	})
+
+	t.Run("Window function", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), `
+			SELECT group_concat(name, '') FILTER (WHERE grp = 'a') OVER (ORDER BY rowid) AS names
+			FROM groupconcattest
+			ORDER BY rowid
+			LIMIT 3
+		`)
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"alice"}, {"alice"}, {"alice"}}, result.Rows)
+		assert.Len(t, result.Warnings, 1)
+	})
+}
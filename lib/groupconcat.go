@@ -0,0 +1,180 @@
+package sqlrunner
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// groupConcatCapFunction is the function the GROUP_CONCAT calls are wrapped
+// in to cap their results, see WithGroupConcatMaxLen.
+const groupConcatCapFunction = "GROUP_CONCAT__CAP"
+
+// groupConcatQueryIDs numbers the queries with capped GROUP_CONCAT calls,
+// so that a truncation is reported to the query it happened in.
+var groupConcatQueryIDs atomic.Int64
+
+// groupConcatTruncations is the set of the IDs of the queries which have
+// truncated a GROUP_CONCAT result.
+var groupConcatTruncations sync.Map
+
+// warnGroupConcatTruncated returns the warning for the truncated
+// GROUP_CONCAT results.
+func warnGroupConcatTruncated(maxLen int) string {
+	return fmt.Sprintf("The result of GROUP_CONCAT is truncated to %d characters.", maxLen)
+}
+
+// capGroupConcat truncates the result of GROUP_CONCAT, which is the first
+// argument, to the number of characters of the second argument, and records
+// the truncation for the query of the ID in the third argument.
+func capGroupConcat(args []driver.Value) (driver.Value, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+
+	maxLen, err := toInt64(args[1])
+	if err != nil {
+		return nil, err
+	}
+	queryID, err := toInt64(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	s := sqliteText(args[0])
+	if int64(len(s)) <= maxLen {
+		return s, nil
+	}
+
+	// Count the characters rather than the bytes not to split a character
+	n := int64(0)
+	for i := range s {
+		if n == maxLen {
+			groupConcatTruncations.Store(queryID, struct{}{})
+			return s[:i], nil
+		}
+		n++
+	}
+
+	return s, nil
+}
+
+// capGroupConcat wraps the GROUP_CONCAT calls of a rewritten query in the
+// capping function if WithGroupConcatMaxLen is set, and extends the function
+// restoring its column names accordingly.
+//
+// It returns the ID of the query to look up in groupConcatTruncations, which
+// the caller deletes once the query is done, or zero if it is not capped.
+func (o options) capGroupConcat(query string, restoreColumn func(string) string) (string, func(string) string, int64) {
+	if o.groupConcatMaxLen <= 0 {
+		return query, restoreColumn, 0
+	}
+
+	queryID := groupConcatQueryIDs.Add(1)
+	query, replacements := wrapGroupConcat(query, o.groupConcatMaxLen, queryID)
+	if replacements == nil {
+		return query, restoreColumn, queryID
+	}
+
+	replacer := strings.NewReplacer(replacements...)
+	return query, func(column string) string {
+		return restoreColumn(replacer.Replace(column))
+	}, queryID
+}
+
+// wrapGroupConcat wraps the GROUP_CONCAT calls of a query in the capping
+// function, including their FILTER and OVER clauses. The cap and the query
+// ID are passed as its arguments.
+//
+// It returns the rewritten query and the pairs of the (rewritten, original)
+// text to restore the column names, like rewriteQuery.
+func wrapGroupConcat(query string, maxLen int, queryID int64) (string, []string) {
+	tokens := tokenize(query)
+
+	prefix := groupConcatCapFunction + "("
+	suffix := ", " + strconv.Itoa(maxLen) + ", " + strconv.FormatInt(queryID, 10) + ")"
+
+	var b strings.Builder
+	wrapped := false
+	closeAt := -1 // index of the last token of the wrapped call
+
+	for i, t := range tokens {
+		if closeAt < 0 && t.kind == tokenIdentifier && strings.EqualFold(t.text, "GROUP_CONCAT") {
+			if open := nextSignificant(tokens, i); open >= 0 && tokens[open].is("(") {
+				if end := aggregateCallEnd(tokens, open); end >= 0 {
+					b.WriteString(prefix)
+					closeAt = end
+					wrapped = true
+				}
+			}
+		}
+
+		b.WriteString(t.text)
+
+		if i == closeAt {
+			b.WriteString(suffix)
+			closeAt = -1
+		}
+	}
+
+	if !wrapped {
+		return query, nil
+	}
+
+	return b.String(), []string{prefix, "", suffix, ""}
+}
+
+// aggregateCallEnd returns the index of the last token of the aggregate
+// call whose argument list opens at open, including its FILTER and OVER
+// clauses, or -1 if the parentheses are unbalanced.
+func aggregateCallEnd(tokens []token, open int) int {
+	end := matchingClose(tokens, open)
+	if end < 0 {
+		return -1
+	}
+
+	if next := nextSignificant(tokens, end); next >= 0 && tokens[next].is("FILTER") {
+		if filterOpen := nextSignificant(tokens, next); filterOpen >= 0 && tokens[filterOpen].is("(") {
+			if end = matchingClose(tokens, filterOpen); end < 0 {
+				return -1
+			}
+		}
+	}
+
+	if next := nextSignificant(tokens, end); next >= 0 && tokens[next].is("OVER") {
+		window := nextSignificant(tokens, next)
+		switch {
+		case window < 0:
+			return -1
+		case tokens[window].is("("):
+			end = matchingClose(tokens, window)
+		default:
+			// A named window
+			end = window
+		}
+	}
+
+	return end
+}
+
+// matchingClose returns the index of the parenthesis closing the one at
+// open, or -1 if there is none.
+func matchingClose(tokens []token, open int) int {
+	depth := 0
+	for j := open; j < len(tokens); j++ {
+		switch {
+		case tokens[j].is("("):
+			depth++
+		case tokens[j].is(")"):
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+
+	return -1
+}
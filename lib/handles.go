@@ -0,0 +1,228 @@
+package sqlrunner
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// handleMaxIdleConns is the number of the idle connections a shared
+// read-only handle keeps warm for the next queries.
+const handleMaxIdleConns = 4
+
+// handleConnMaxIdleTime is the duration after which an idle connection of
+// a shared read-only handle is closed, so that the schemas no longer
+// queried do not keep their files open.
+const handleConnMaxIdleTime = time.Minute
+
+// readOnlyHandles are the read-only handles of the schema database files
+// shared by the queries, keyed by the filename. The first queries on a
+// cold schema wait for a single handle to be opened and warmed, instead of
+// each opening the file.
+var readOnlyHandles = struct {
+	mu sync.Mutex
+	m  map[string]*sql.DB
+}{
+	m: make(map[string]*sql.DB),
+}
+
+// handlesSF coalesces the openings of the handle of the same file.
+var handlesSF = &singleflight.Group{}
+
+// handleOpens counts the read-only handles opened, for the tests.
+var handleOpens atomic.Int64
+
+// schemaFileOpens counts the opens of the schema database files, by the
+// integrity checks, the connections of the shared handles, and the
+// writable copies, for the tests.
+var schemaFileOpens atomic.Int64
+
+// hasReadOnlyHandle reports whether the schema database file has a shared
+// read-only handle, which means it is intact and kept until the handle is
+// closed by InvalidateSchema or FlushSchemas.
+func hasReadOnlyHandle(filename string) bool {
+	readOnlyHandles.mu.Lock()
+	defer readOnlyHandles.mu.Unlock()
+
+	_, ok := readOnlyHandles.m[filename]
+	return ok
+}
+
+// readOnlyHandle returns the shared read-only handle of the schema database
+// file, opening it if it is not opened yet. The handle must not be closed
+// by the caller.
+//
+// The caller must hold rlockSchemaFiles of the schema, which prevents the
+// handle from being closed by closeReadOnlyHandles until the query starts.
+func readOnlyHandle(ctx context.Context, filename string) (*sql.DB, error) {
+	readOnlyHandles.mu.Lock()
+	db, ok := readOnlyHandles.m[filename]
+	readOnlyHandles.mu.Unlock()
+	if ok {
+		return db, nil
+	}
+
+	dbAny, err, _ := handlesSF.Do(filename, func() (interface{}, error) {
+		readOnlyHandles.mu.Lock()
+		db, ok := readOnlyHandles.m[filename]
+		readOnlyHandles.mu.Unlock()
+		if ok {
+			return db, nil
+		}
+
+		db = sql.OpenDB(sessionConnector{dsn: readOnlyDSN(filename)})
+		handleOpens.Add(1)
+		db.SetMaxIdleConns(handleMaxIdleConns)
+		db.SetConnMaxIdleTime(handleConnMaxIdleTime)
+
+		// Warm the first connection, so that the herd waiting for the
+		// handle shares it rather than each opening one. The context of
+		// the first caller must not cancel the others.
+		if err := db.PingContext(context.WithoutCancel(ctx)); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("open schema database (r/o): %w", err)
+		}
+
+		readOnlyHandles.mu.Lock()
+		readOnlyHandles.m[filename] = db
+		readOnlyHandles.mu.Unlock()
+
+		return db, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dbAny.(*sql.DB), nil
+}
+
+// closeReadOnlyHandles closes the shared read-only handles of the files
+// the filter reports, such as the ones being removed. The queries in
+// progress keep their connections until they finish.
+//
+// The caller must hold lockSchemaFiles of the schemas of the files, or the
+// write lock of schemaFilesMu.
+func closeReadOnlyHandles(filter func(filename string) bool) {
+	readOnlyHandles.mu.Lock()
+	defer readOnlyHandles.mu.Unlock()
+
+	for filename, db := range readOnlyHandles.m {
+		if !filter(filename) {
+			continue
+		}
+
+		_ = db.Close()
+		delete(readOnlyHandles.m, filename)
+	}
+}
+
+// sqliteDriver is the driver of modernc.org/sqlite, which opens the
+// connections with the registered functions.
+var sqliteDriver = func() driver.Driver {
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	return db.Driver()
+}()
+
+// sessionConnector opens the connections of a shared read-only handle as
+// sessionConns, so that no state a query leaves on a connection reaches
+// the next query, which may be of another runner.
+type sessionConnector struct {
+	dsn string
+}
+
+func (c sessionConnector) Connect(context.Context) (driver.Conn, error) {
+	schemaFileOpens.Add(1)
+	conn, err := sqliteDriver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	sqliteConn, ok := conn.(sqliteConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, errors.New("unexpected sqlite connection")
+	}
+
+	return sessionConn{sqliteConn}, nil
+}
+
+func (c sessionConnector) Driver() driver.Driver {
+	return sqliteDriver
+}
+
+// sqliteConn is the interfaces of a connection of modernc.org/sqlite
+// database/sql uses.
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+// sessionConn is a connection of a shared read-only handle, which is
+// discarded before its reuse if a query changed its session: turned off
+// query_only, such as with a PRAGMA the policy missed, created a temporary
+// table, view, or trigger, which would shadow the tables of the schema, or
+// attached a database.
+type sessionConn struct {
+	sqliteConn
+}
+
+// cleanSessionQuery returns 1 if the session of the connection is as
+// opened.
+const cleanSessionQuery = `SELECT (SELECT query_only FROM pragma_query_only) = 1
+AND NOT EXISTS (SELECT 1 FROM temp.sqlite_schema)
+AND NOT EXISTS (SELECT 1 FROM pragma_database_list WHERE name NOT IN ('main', 'temp'))`
+
+func (c sessionConn) ResetSession(ctx context.Context) error {
+	if err := c.sqliteConn.ResetSession(ctx); err != nil {
+		return err
+	}
+
+	clean, err := c.cleanSession(ctx)
+	if err != nil || !clean {
+		return driver.ErrBadConn
+	}
+
+	return nil
+}
+
+// cleanSession reports whether the session of the connection is as opened.
+func (c sessionConn) cleanSession(ctx context.Context) (bool, error) {
+	rows, err := c.QueryContext(ctx, cleanSessionQuery, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("no session check result")
+		}
+		return false, err
+	}
+
+	return dest[0] == int64(1), nil
+}
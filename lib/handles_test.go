@@ -0,0 +1,109 @@
+package sqlrunner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestColdSchemaHerd is not parallel, since it counts the handles and the
+// schema files opened by all the tests of the package.
+func TestColdSchemaHerd(t *testing.T) {
+	schema := `
+		CREATE TABLE herdtest (
+			value INT
+		);
+
+		INSERT INTO herdtest (value) VALUES (1), (2), (3);
+	`
+	t.Cleanup(func() {
+		_ = InvalidateSchema(schema)
+	})
+
+	// As many queries execute at once as the handle keeps connections,
+	// so that the connections are reused rather than opened again.
+	service, err := NewService(WithConcurrencyLimit(handleMaxIdleConns, 64))
+	require.NoError(t, err)
+
+	const goroutines = 64
+	query := func(round int) {
+		var wg sync.WaitGroup
+		errs := make([]error, goroutines)
+		for i := range goroutines {
+			wg.Go(func() {
+				// Distinct queries, so that none of them is a cache hit
+				_, errs[i] = service.ExecuteQuery(context.TODO(), schema, fmt.Sprintf("SELECT value + %d FROM herdtest", round*goroutines+i))
+			})
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+	}
+
+	opens := handleOpens.Load()
+	fileOpens := schemaFileOpens.Load()
+	query(0)
+	assert.EqualValues(t, 1, handleOpens.Load()-opens)
+
+	// The warmed handle is shared by the next queries, which neither
+	// check the file again nor open more connections than it keeps.
+	query(1)
+	assert.EqualValues(t, 1, handleOpens.Load()-opens)
+	assert.LessOrEqual(t, schemaFileOpens.Load()-fileOpens, int64(handleMaxIdleConns))
+
+	// and opened again once the schema is invalidated.
+	require.NoError(t, InvalidateSchema(schema))
+	query(2)
+	assert.EqualValues(t, 2, handleOpens.Load()-opens)
+	assert.LessOrEqual(t, schemaFileOpens.Load()-fileOpens, int64(2*handleMaxIdleConns))
+}
+
+func TestSharedHandleSessionReset(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE sessiontest (
+			name TEXT
+		);
+
+		INSERT INTO sessiontest (name) VALUES ('alice');
+	`
+	filename, err := initializeThreadSafe(schema, defaultSchemaOptions, 0)
+	require.NoError(t, err)
+
+	unlockFiles := rlockSchemaFiles(schemaHash(schema, defaultSchemaOptions))
+	defer unlockFiles()
+
+	db, err := readOnlyHandle(context.TODO(), filename)
+	require.NoError(t, err)
+
+	for _, tamper := range []string{
+		"PRAGMA query_only = 0",
+		"PRAGMA query_only = 0; CREATE TEMP TABLE sessiontest (name TEXT)",
+		"PRAGMA query_only = 0; CREATE TEMP VIEW sessiontest AS SELECT 'mallory' AS name",
+		"ATTACH ':memory:' AS other",
+	} {
+		conn, err := db.Conn(context.TODO())
+		require.NoError(t, err)
+		_, err = conn.ExecContext(context.TODO(), tamper)
+		require.NoError(t, err, tamper)
+		require.NoError(t, conn.Close())
+
+		// The tampered connection is discarded rather than reused
+		var queryOnly, databases int
+		var name string
+		require.NoError(t, db.QueryRowContext(context.TODO(), `SELECT
+			(SELECT query_only FROM pragma_query_only),
+			(SELECT COUNT(*) FROM pragma_database_list),
+			(SELECT name FROM sessiontest)`).Scan(&queryOnly, &databases, &name))
+		assert.Equal(t, 1, queryOnly, tamper)
+		assert.Equal(t, 1, databases, tamper)
+		assert.Equal(t, "alice", name, tamper)
+	}
+}
@@ -0,0 +1,228 @@
+package sqlrunner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// schemaFilesMu prevents the database files of all the schemas from being
+// removed by FlushSchemas while they are being initialized or queried. The
+// others hold it for reading, with the lock of a schema from schemaLocks.
+var schemaFilesMu sync.RWMutex
+
+// schemaLocks prevent the database files of each schema from being removed
+// by InvalidateSchema while they are being initialized or queried, keyed by
+// the base schema hash, so that invalidating a schema only waits for the
+// queries on it. A lock is dropped once no one holds it.
+var schemaLocks = struct {
+	mu sync.Mutex
+	m  map[string]*schemaLock
+}{
+	m: make(map[string]*schemaLock),
+}
+
+// schemaLock is the lock of the files of a schema, with the number of the
+// goroutines holding or waiting for it.
+type schemaLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// rlockSchemaFiles prevents the files of the base schema hash from being
+// removed until the returned function is called.
+func rlockSchemaFiles(baseHash string) (unlock func()) {
+	schemaFilesMu.RLock()
+	l := refSchemaLock(baseHash)
+	l.RLock()
+
+	return func() {
+		l.RUnlock()
+		unrefSchemaLock(baseHash, l)
+		schemaFilesMu.RUnlock()
+	}
+}
+
+// lockSchemaFiles waits for the goroutines using the files of the base
+// schema hash, and prevents the others from using them until the returned
+// function is called.
+func lockSchemaFiles(baseHash string) (unlock func()) {
+	schemaFilesMu.RLock()
+	l := refSchemaLock(baseHash)
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+		unrefSchemaLock(baseHash, l)
+		schemaFilesMu.RUnlock()
+	}
+}
+
+func refSchemaLock(baseHash string) *schemaLock {
+	schemaLocks.mu.Lock()
+	defer schemaLocks.mu.Unlock()
+
+	l, ok := schemaLocks.m[baseHash]
+	if !ok {
+		l = &schemaLock{}
+		schemaLocks.m[baseHash] = l
+	}
+	l.refs++
+
+	return l
+}
+
+func unrefSchemaLock(baseHash string, l *schemaLock) {
+	schemaLocks.mu.Lock()
+	defer schemaLocks.mu.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(schemaLocks.m, baseHash)
+	}
+}
+
+// schemaGenerations tracks how many times each schema has been invalidated,
+// keyed by the base schema hash, so runners can tell that their cached
+// results are stale.
+var schemaGenerations = struct {
+	mu sync.Mutex
+	m  map[string]uint64
+	// flushes is how many times all the schemas have been flushed.
+	flushes uint64
+}{
+	m: make(map[string]uint64),
+}
+
+// schemaGeneration returns the current generation of the base schema hash.
+// It changes when the schema is invalidated or all the schemas are flushed.
+func schemaGeneration(baseHash string) uint64 {
+	schemaGenerations.mu.Lock()
+	defer schemaGenerations.mu.Unlock()
+
+	return schemaGenerations.m[baseHash] + schemaGenerations.flushes
+}
+
+// InvalidateSchema removes the cached database files of the schema and
+// purges the cached query results of every runner using it, including the
+// results cached on the disk by WithDiskCache, regardless
+// of the options the schema is initialized with.
+//
+// It waits for the initializations and the queries in progress on the
+// schema, but not on the other schemas. The next query on the schema
+// initializes it again.
+func InvalidateSchema(schema string) error {
+	base := schemaHash(schema, defaultSchemaOptions)
+
+	unlock := lockSchemaFiles(base)
+	defer unlock()
+
+	// The hashes of the schema with any options share the base hash.
+	hashes := []string{base}
+	filenames, err := filepath.Glob(schemaFilename(base + "-*"))
+	if err != nil {
+		return fmt.Errorf("find schema databases: %w", err)
+	}
+	for _, filename := range filenames {
+		hashes = append(hashes, strings.TrimSuffix(filepath.Base(filename), ".db"))
+	}
+	for _, hash := range failedSchemas.Keys() {
+		if baseSchemaHash(hash) == base {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	schemaGenerations.mu.Lock()
+	schemaGenerations.m[base]++
+	schemaGenerations.mu.Unlock()
+
+	closeReadOnlyHandles(func(filename string) bool {
+		hash := strings.TrimSuffix(filepath.Base(filename), ".db")
+		return baseSchemaHash(hash) == base
+	})
+
+	for _, hash := range hashes {
+		// Make sure the next initialization does not share
+		// the result of an in-flight one.
+		sf.Forget(hash)
+
+		failedSchemas.Remove(hash)
+		setSchemaFileVerified(schemaFilename(hash), false)
+
+		if err := os.Remove(schemaFilename(hash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove schema database: %w", err)
+		}
+	}
+
+	// The results cached on the disk with any options
+	cacheDirs, err := filepath.Glob(diskCacheDirname(base + "*"))
+	if err != nil {
+		return fmt.Errorf("find disk caches: %w", err)
+	}
+	for _, dir := range cacheDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("remove disk cache: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// FlushSchemas removes the database files and the disk caches of all the
+// schemas, and purges the cached query results of every runner, like
+// InvalidateSchema on every schema. It also forgets the schemas which
+// failed to initialize, so they are retried at once.
+//
+// It waits for the schema initializations and the queries in progress, and
+// the next query on each schema initializes it again. The files of the
+// other processes sharing the directory are removed too.
+func FlushSchemas() error {
+	schemaFilesMu.Lock()
+	defer schemaFilesMu.Unlock()
+
+	schemaGenerations.mu.Lock()
+	schemaGenerations.flushes++
+	schemaGenerations.mu.Unlock()
+
+	// No initialization is in flight while schemaFilesMu is held,
+	// so none of them are shared with the next ones.
+	failedSchemas.Purge()
+
+	verifiedSchemaFiles.mu.Lock()
+	clear(verifiedSchemaFiles.m)
+	verifiedSchemaFiles.mu.Unlock()
+
+	closeReadOnlyHandles(func(string) bool { return true })
+
+	filenames, err := filepath.Glob(schemaFilename("*"))
+	if err != nil {
+		return fmt.Errorf("find schema databases: %w", err)
+	}
+	for _, filename := range filenames {
+		if err := os.Remove(filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove schema database: %w", err)
+		}
+	}
+
+	// The lock files of each schema are not taken anymore since the
+	// schemas share the lock files of schemaLockFilename.
+	legacyLocks, err := filepath.Glob(schemaFilename("*") + ".lock")
+	if err != nil {
+		return fmt.Errorf("find legacy lock files: %w", err)
+	}
+	for _, filename := range legacyLocks {
+		if err := os.Remove(filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove legacy lock file: %w", err)
+		}
+	}
+
+	if err := os.RemoveAll(diskCacheDir); err != nil {
+		return fmt.Errorf("remove disk caches: %w", err)
+	}
+
+	return nil
+}
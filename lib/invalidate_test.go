@@ -0,0 +1,127 @@
+package sqlrunner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidateSchema(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE invalidatetest (
+			value TEXT
+		);
+
+		INSERT INTO invalidatetest (value) VALUES ('hello');
+	`
+	filename := schemaFilename(schemaHash(schema, defaultSchemaOptions))
+
+	runner, err := NewSQLRunner(schema)
+	require.NoError(t, err)
+
+	_, err = runner.Query(context.TODO(), "SELECT value FROM invalidatetest")
+	require.NoError(t, err)
+	assert.Equal(t, 1, runner.cache.Len())
+
+	require.NoError(t, InvalidateSchema(schema))
+	assert.NoFileExists(t, filename)
+
+	result, err := runner.Query(context.TODO(), "SELECT value FROM invalidatetest")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+
+	// The schema is initialized again on the next query.
+	assert.FileExists(t, filename)
+	assert.Equal(t, 1, runner.cache.Len())
+
+	t.Run("Unknown schema", func(t *testing.T) {
+		t.Parallel()
+
+		require.NoError(t, InvalidateSchema("CREATE TABLE unknown (value TEXT);"))
+	})
+}
+
+func TestInvalidateSchemaIsolated(t *testing.T) {
+	t.Parallel()
+
+	slowSchema := "CREATE TABLE invalidateslowtest (value TEXT);"
+	schema := "CREATE TABLE invalidateisolatedtest (value TEXT); INSERT INTO invalidateisolatedtest VALUES ('hello');"
+
+	slow, err := NewSQLRunner(slowSchema)
+	require.NoError(t, err)
+	runner, err := NewSQLRunner(schema)
+	require.NoError(t, err)
+
+	// The slow query holds the files of its schema until it is canceled
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := slow.Query(ctx, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c")
+		slowDone <- err
+	}()
+	slowBase := schemaHash(slowSchema, defaultSchemaOptions)
+	require.Eventually(t, func() bool {
+		schemaLocks.mu.Lock()
+		defer schemaLocks.mu.Unlock()
+		return schemaLocks.m[slowBase] != nil
+	}, 5*time.Second, time.Millisecond)
+
+	// Invalidating another schema does not wait for it
+	invalidated := make(chan error, 1)
+	go func() {
+		invalidated <- InvalidateSchema(schema)
+	}()
+	select {
+	case err := <-invalidated:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("InvalidateSchema waited for a query on another schema")
+	}
+
+	result, err := runner.Query(context.TODO(), "SELECT value FROM invalidateisolatedtest")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+
+	cancel()
+	assert.Error(t, <-slowDone)
+}
+
+func TestSchemaHashForeignKeys(t *testing.T) {
+	t.Parallel()
+
+	schema := "CREATE TABLE fkhashtest (value TEXT);"
+
+	assert.Equal(t, schemaHash(schema, schemaOptions{foreignKeys: true}), schemaHash(schema, defaultSchemaOptions))
+	assert.NotEqual(t, schemaHash(schema, schemaOptions{foreignKeys: true}), schemaHash(schema, schemaOptions{foreignKeys: false}))
+}
+
+func TestInvalidateSchemaVariants(t *testing.T) {
+	t.Parallel()
+
+	schema := "CREATE TABLE invalidatevarianttest (value TEXT); INSERT INTO invalidatevarianttest VALUES ('a');"
+
+	runner, err := NewSQLRunner(schema, WithForeignKeys(false), WithDefaultCollation("NOCASE"))
+	require.NoError(t, err)
+
+	_, err = runner.Query(context.TODO(), "SELECT value FROM invalidatevarianttest")
+	require.NoError(t, err)
+
+	filename := schemaFilename(runner.SchemaHash())
+	assert.FileExists(t, filename)
+	assert.Equal(t, schemaHash(schema, defaultSchemaOptions), baseSchemaHash(runner.SchemaHash()))
+
+	require.NoError(t, InvalidateSchema(schema))
+	assert.NoFileExists(t, filename)
+
+	// The cached result of the variant is purged as well.
+	result, err := runner.Query(context.TODO(), "SELECT value FROM invalidatevarianttest")
+	require.NoError(t, err)
+	assert.False(t, result.FromCache)
+	assert.FileExists(t, filename)
+}
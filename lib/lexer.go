@@ -0,0 +1,196 @@
+package sqlrunner
+
+import (
+	"strings"
+)
+
+// tokenKind is the kind of a SQL token.
+type tokenKind int
+
+const (
+	tokenWhitespace tokenKind = iota
+	tokenComment
+	// tokenIdentifier is a bare word, including keywords.
+	tokenIdentifier
+	// tokenQuotedIdentifier is an identifier quoted with "", ``, or [].
+	tokenQuotedIdentifier
+	tokenString
+	tokenNumber
+	tokenSemicolon
+	// tokenPunctuation is any other character, such as operators and parentheses.
+	tokenPunctuation
+)
+
+// token is a lexical token of a SQL query.
+type token struct {
+	kind tokenKind
+	text string
+	// pos is the byte offset of the token in the query.
+	pos int
+}
+
+// is reports whether the token is the given keyword or punctuation,
+// case-insensitively.
+func (t token) is(text string) bool {
+	return (t.kind == tokenIdentifier || t.kind == tokenPunctuation || t.kind == tokenSemicolon) &&
+		strings.EqualFold(t.text, text)
+}
+
+// tokenize splits a SQL query into tokens. It never fails: unterminated
+// strings, quoted identifiers, and comments extend to the end of the query.
+func tokenize(query string) []token {
+	var tokens []token
+
+	for pos := 0; pos < len(query); {
+		kind, end := scanToken(query, pos)
+		tokens = append(tokens, token{kind: kind, text: query[pos:end], pos: pos})
+		pos = end
+	}
+
+	return tokens
+}
+
+// scanToken scans the token starting at pos and returns its kind and end offset.
+func scanToken(query string, pos int) (tokenKind, int) {
+	c := query[pos]
+
+	switch {
+	case isSpace(c):
+		end := pos + 1
+		for end < len(query) && isSpace(query[end]) {
+			end++
+		}
+		return tokenWhitespace, end
+	case strings.HasPrefix(query[pos:], "--"):
+		end := strings.IndexByte(query[pos:], '\n')
+		if end < 0 {
+			return tokenComment, len(query)
+		}
+		return tokenComment, pos + end + 1
+	case strings.HasPrefix(query[pos:], "/*"):
+		end := strings.Index(query[pos+2:], "*/")
+		if end < 0 {
+			return tokenComment, len(query)
+		}
+		return tokenComment, pos + 2 + end + 2
+	case c == '\'':
+		return tokenString, scanQuoted(query, pos, '\'')
+	case c == '"':
+		return tokenQuotedIdentifier, scanQuoted(query, pos, '"')
+	case c == '`':
+		return tokenQuotedIdentifier, scanQuoted(query, pos, '`')
+	case c == '[':
+		end := strings.IndexByte(query[pos:], ']')
+		if end < 0 {
+			return tokenQuotedIdentifier, len(query)
+		}
+		return tokenQuotedIdentifier, pos + end + 1
+	case (c == 'x' || c == 'X') && pos+1 < len(query) && query[pos+1] == '\'':
+		// Blob literal
+		return tokenString, scanQuoted(query, pos+1, '\'')
+	case isDigit(c) || (c == '.' && pos+1 < len(query) && isDigit(query[pos+1])):
+		end := pos + 1
+		for end < len(query) && (isIdentifierChar(query[end]) || query[end] == '.' ||
+			((query[end] == '+' || query[end] == '-') && (query[end-1] == 'e' || query[end-1] == 'E'))) {
+			end++
+		}
+		return tokenNumber, end
+	case isIdentifierChar(c):
+		end := pos + 1
+		for end < len(query) && isIdentifierChar(query[end]) {
+			end++
+		}
+		return tokenIdentifier, end
+	case c == ';':
+		return tokenSemicolon, pos + 1
+	default:
+		return tokenPunctuation, pos + 1
+	}
+}
+
+// scanQuoted returns the end offset of the quoted text starting at pos.
+// The quote character is escaped by doubling it.
+func scanQuoted(query string, pos int, quote byte) int {
+	for end := pos + 1; end < len(query); end++ {
+		if query[end] != quote {
+			continue
+		}
+		if end+1 < len(query) && query[end+1] == quote {
+			end++
+			continue
+		}
+		return end + 1
+	}
+
+	return len(query)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// nextSignificant returns the index of the next token after i which is
+// neither whitespace nor a comment, or -1 if there is none.
+func nextSignificant(tokens []token, i int) int {
+	for j := i + 1; j < len(tokens); j++ {
+		if tokens[j].kind != tokenWhitespace && tokens[j].kind != tokenComment {
+			return j
+		}
+	}
+
+	return -1
+}
+
+// previousSignificant returns the index of the previous token before i which
+// is neither whitespace nor a comment, or -1 if there is none.
+func previousSignificant(tokens []token, i int) int {
+	for j := i - 1; j >= 0; j-- {
+		if tokens[j].kind != tokenWhitespace && tokens[j].kind != tokenComment {
+			return j
+		}
+	}
+
+	return -1
+}
+
+// NormalizeQuery returns the query without its comments, with each run of
+// whitespace and comments outside the strings and the quoted identifiers
+// collapsed into a single space, and without the leading and trailing
+// whitespace and semicolons, such as for comparing the submissions of the
+// students by their text. The strings, the identifiers, and the case of the
+// keywords are kept as they are, so the normalized query has the same
+// tokens and returns the same result.
+func NormalizeQuery(query string) string {
+	tokens := tokenize(query)
+
+	// Drop the trailing whitespace, comments, and semicolons
+	end := len(tokens)
+	for end > 0 && (tokens[end-1].kind == tokenWhitespace || tokens[end-1].kind == tokenComment ||
+		tokens[end-1].kind == tokenSemicolon) {
+		end--
+	}
+
+	var b strings.Builder
+	space := false
+	for _, t := range tokens[:end] {
+		if t.kind == tokenWhitespace || t.kind == tokenComment {
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(t.text)
+	}
+
+	return b.String()
+}
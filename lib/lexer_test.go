@@ -0,0 +1,147 @@
+package sqlrunner
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lexerSeeds are the tricky queries the fuzz tests start from.
+var lexerSeeds = []string{
+	"SELECT ';'",
+	"SELECT 'it''s; fine'; SELECT 2",
+	"SELECT 1 /* ; */; SELECT 2",
+	"SELECT 1 -- ;\n; SELECT 2",
+	`SELECT "a;""b" FROM [c;d] JOIN ` + "`e;f`",
+	"SELECT x'3B', 1.5e-3, .5",
+	"SELECT 'unterminated; string",
+	"SELECT /* unterminated; comment",
+	"SELECT 1 -- unterminated; comment",
+	"SELECT [unterminated; identifier",
+	"CREATE TRIGGER t AFTER INSERT ON a BEGIN DELETE FROM b; END; SELECT 1",
+	";;'",
+	"",
+}
+
+func TestTokenize(t *testing.T) {
+	t.Parallel()
+
+	type kindText struct {
+		kind tokenKind
+		text string
+	}
+
+	for query, expected := range map[string][]kindText{
+		"SELECT ';'": {
+			{tokenIdentifier, "SELECT"}, {tokenWhitespace, " "}, {tokenString, "';'"},
+		},
+		"'it''s';": {
+			{tokenString, "'it''s'"}, {tokenSemicolon, ";"},
+		},
+		"1/* ; */-- ;\n2": {
+			{tokenNumber, "1"}, {tokenComment, "/* ; */"}, {tokenComment, "-- ;\n"}, {tokenNumber, "2"},
+		},
+		`"a;""b"[c;d]`: {
+			{tokenQuotedIdentifier, `"a;""b"`}, {tokenQuotedIdentifier, "[c;d]"},
+		},
+		"x'3B'-1.5e-3": {
+			{tokenString, "x'3B'"}, {tokenPunctuation, "-"}, {tokenNumber, "1.5e-3"},
+		},
+		"'unterminated; string": {
+			{tokenString, "'unterminated; string"},
+		},
+		"/* unterminated; comment": {
+			{tokenComment, "/* unterminated; comment"},
+		},
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			var actual []kindText
+			for _, token := range tokenize(query) {
+				actual = append(actual, kindText{token.kind, token.text})
+			}
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	t.Parallel()
+
+	for query, expected := range map[string]string{
+		"  SELECT   1 ;\n":                        "SELECT 1",
+		"SELECT\n\t*\nFROM t -- all the rows\n;;": "SELECT * FROM t",
+		"SELECT/* inline */1":                     "SELECT 1",
+		"SELECT '  two  spaces ; '":               "SELECT '  two  spaces ; '",
+		`SELECT "a  b" FROM [c  d]`:               `SELECT "a  b" FROM [c  d]`,
+		"SELECT 1; SELECT 2;":                     "SELECT 1; SELECT 2",
+		"SELECT 'unterminated ;":                  "SELECT 'unterminated ;",
+		"-- only a comment":                       "",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, expected, NormalizeQuery(query))
+		})
+	}
+}
+
+func FuzzTokenize(f *testing.F) {
+	for _, seed := range lexerSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, query string) {
+		var b strings.Builder
+		for _, token := range tokenize(query) {
+			if token.text == "" {
+				t.Fatalf("empty token at %d", token.pos)
+			}
+			if token.pos != b.Len() {
+				t.Fatalf("token at %d, expected %d", token.pos, b.Len())
+			}
+			b.WriteString(token.text)
+		}
+
+		// The tokens cover the query without gaps
+		if b.String() != query {
+			t.Fatalf("tokens %q, expected %q", b.String(), query)
+		}
+	})
+}
+
+func FuzzNormalizeQuery(f *testing.F) {
+	for _, seed := range lexerSeeds {
+		f.Add(seed)
+	}
+
+	// significant returns the texts of the tokens other than whitespace
+	// and comments, without the trailing semicolons.
+	significant := func(query string) []string {
+		var texts []string
+		for _, token := range tokenize(query) {
+			if token.kind != tokenWhitespace && token.kind != tokenComment {
+				texts = append(texts, token.text)
+			}
+		}
+		for len(texts) > 0 && texts[len(texts)-1] == ";" {
+			texts = texts[:len(texts)-1]
+		}
+
+		return texts
+	}
+
+	f.Fuzz(func(t *testing.T, query string) {
+		normalized := NormalizeQuery(query)
+
+		if again := NormalizeQuery(normalized); again != normalized {
+			t.Fatalf("normalized %q again into %q", normalized, again)
+		}
+		if expected, actual := significant(query), significant(normalized); !slices.Equal(expected, actual) {
+			t.Fatalf("tokens %q, expected %q", actual, expected)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package sqlrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrTooManyQueries is returned when a query is rejected because the
+// concurrency limit of the Service is reached and its queue is full.
+var ErrTooManyQueries = errors.New("too many concurrent queries")
+
+// LimitEvent is an event of the concurrency limit of a Service.
+type LimitEvent string
+
+const (
+	// LimitQueued means a query waits for another query to finish.
+	LimitQueued LimitEvent = "queued"
+	// LimitRejected means a query is rejected with ErrTooManyQueries.
+	LimitRejected LimitEvent = "rejected"
+	// LimitAcquired means a query gets a slot and starts executing.
+	LimitAcquired LimitEvent = "acquired"
+)
+
+// queryLimiter limits the number of the concurrently executing queries.
+// A nil queryLimiter does not limit them.
+type queryLimiter struct {
+	// slots holds a value for each executing query.
+	slots chan struct{}
+	// queue holds a value for each query waiting for a slot.
+	queue   chan struct{}
+	observe func(LimitEvent)
+}
+
+func newQueryLimiter(limit, queueSize int, observe func(LimitEvent)) *queryLimiter {
+	if observe == nil {
+		observe = func(LimitEvent) {}
+	}
+
+	return &queryLimiter{
+		slots:   make(chan struct{}, limit),
+		queue:   make(chan struct{}, queueSize),
+		observe: observe,
+	}
+}
+
+// acquire waits for a slot to execute a query, and returns the function
+// releasing it. It fails with ErrTooManyQueries if the queue is full, or
+// with the error of the context if it is done while waiting.
+func (l *queryLimiter) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		l.observe(LimitAcquired)
+		return l.release, nil
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+		defer func() { <-l.queue }()
+	default:
+		l.observe(LimitRejected)
+		return nil, ErrTooManyQueries
+	}
+
+	l.observe(LimitQueued)
+	select {
+	case l.slots <- struct{}{}:
+		l.observe(LimitAcquired)
+		return l.release, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for query slot: %w", ctx.Err())
+	}
+}
+
+func (l *queryLimiter) release() {
+	<-l.slots
+}
@@ -0,0 +1,291 @@
+package sqlrunner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ColumnMetadata is the origin of a result column, see WithColumnMetadata.
+// It is the zero value if the origin is not determinable, such as for an
+// expression.
+type ColumnMetadata struct {
+	// Table is the table the column is read from
+	Table string `json:"table,omitempty"`
+	// Ordinal is the 1-based position of the column in the table
+	Ordinal int `json:"ordinal,omitempty"`
+	// PrimaryKey reports whether the column is a part of the primary key
+	// of the table
+	PrimaryKey bool `json:"primary_key,omitempty"`
+}
+
+// fromClauseEnd are the keywords ending the FROM clause of a SELECT.
+var fromClauseEnd = []string{"WHERE", "GROUP", "HAVING", "WINDOW", "ORDER", "LIMIT", "UNION", "INTERSECT", "EXCEPT"}
+
+// joinKeywords are the keywords following a table in the FROM clause,
+// which are not its alias.
+var joinKeywords = []string{"JOIN", "NATURAL", "LEFT", "RIGHT", "FULL", "INNER", "OUTER", "CROSS", "ON", "USING",
+	"INDEXED", "NOT"}
+
+// columnSource is a table, a subquery, a CTE, or a table-valued function in
+// the FROM clause of a query.
+type columnSource struct {
+	// table is the name of the table, which is empty for the other sources
+	// whose columns are not known
+	table string
+	// name is the alias of the source, or the name of the table
+	name string
+	// columns are the columns of the table by their lowercase names
+	columns map[string]ColumnMetadata
+}
+
+// columnSources returns the sources in the FROM clause of the first
+// top-level SELECT of the query, with the columns of the tables from their
+// table_info. It returns nil if the query has no such clause.
+func columnSources(ctx context.Context, db queryer, query string) ([]columnSource, error) {
+	sources := fromSources(tokenize(query))
+	for i := range sources {
+		if sources[i].table == "" {
+			continue
+		}
+
+		columns, err := tableColumns(ctx, db, sources[i].table)
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) == 0 {
+			// Not a table, such as a CTE not recognized by fromSources
+			sources[i].table = ""
+		}
+		sources[i].columns = columns
+	}
+
+	return sources, nil
+}
+
+// tableColumns returns the columns of the table or view by their lowercase
+// names, or none if there is no such table.
+func tableColumns(ctx context.Context, db queryer, table string) (map[string]ColumnMetadata, error) {
+	rows, err := db.QueryContext(ctx, "SELECT cid, name, pk FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("get table info: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	columns := make(map[string]ColumnMetadata)
+	for rows.Next() {
+		var cid, pk int
+		var name string
+		if err := rows.Scan(&cid, &name, &pk); err != nil {
+			return nil, fmt.Errorf("scan table info: %w", err)
+		}
+
+		columns[strings.ToLower(name)] = ColumnMetadata{Table: table, Ordinal: cid + 1, PrimaryKey: pk > 0}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read table info: %w", err)
+	}
+
+	return columns, nil
+}
+
+// fromSources returns the sources in the FROM clause of the first
+// top-level SELECT, without their columns. The CTEs, the subqueries, and
+// the table-valued functions have no table.
+func fromSources(tokens []token) []columnSource {
+	// The CTEs are named before the first top-level SELECT
+	ctes := map[string]bool{}
+	from := -1
+	selected := false
+	depth := 0
+	for i := 0; i < len(tokens) && from < 0; i++ {
+		t := tokens[i]
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth != 0:
+		case t.is("SELECT"):
+			selected = true
+		case !selected && isIdentifierToken(t):
+			if next := nextSignificant(tokens, i); next >= 0 && (tokens[next].is("AS") || tokens[next].is("(")) {
+				ctes[strings.ToLower(unquoteIdentifier(t))] = true
+			}
+		case selected && t.is("FROM"):
+			from = i
+		case selected && (isAnyOf(t, fromClauseEnd) || t.kind == tokenSemicolon):
+			return nil
+		}
+	}
+	if from < 0 {
+		return nil
+	}
+
+	var sources []columnSource
+	expectSource := true
+	for i := nextSignificant(tokens, from); i >= 0; i = nextSignificant(tokens, i) {
+		t := tokens[i]
+		switch {
+		case t.is(")") || t.kind == tokenSemicolon || isAnyOf(t, fromClauseEnd):
+			return sources
+		case t.is(",") || t.is("JOIN"):
+			expectSource = true
+		case t.is("("):
+			// A subquery, or the parentheses of ON and USING
+			if i = matchingClose(tokens, i); i < 0 {
+				return sources
+			}
+			if expectSource {
+				var source columnSource
+				source.name, i = sourceAlias(tokens, i)
+				sources = append(sources, source)
+				expectSource = false
+			}
+		case expectSource && isIdentifierToken(t):
+			name := unquoteIdentifier(t)
+			// A table of a schema, such as main.t
+			if dot := nextSignificant(tokens, i); dot >= 0 && tokens[dot].is(".") {
+				if next := nextSignificant(tokens, dot); next >= 0 && isIdentifierToken(tokens[next]) {
+					name = unquoteIdentifier(tokens[next])
+					i = next
+				}
+			}
+
+			source := columnSource{table: name, name: name}
+			if open := nextSignificant(tokens, i); open >= 0 && tokens[open].is("(") {
+				// A table-valued function, such as pragma_table_info('t')
+				if i = matchingClose(tokens, open); i < 0 {
+					return sources
+				}
+				source.table = ""
+			} else if ctes[strings.ToLower(name)] {
+				source.table = ""
+			}
+			if alias, next := sourceAlias(tokens, i); alias != "" {
+				source.name = alias
+				i = next
+			}
+
+			sources = append(sources, source)
+			expectSource = false
+		}
+	}
+
+	return sources
+}
+
+// sourceAlias returns the alias following the source ending at i, such as
+// AS s, and the index of its last token. It returns an empty alias and i if
+// there is none.
+func sourceAlias(tokens []token, i int) (string, int) {
+	next := nextSignificant(tokens, i)
+	if next >= 0 && tokens[next].is("AS") {
+		next = nextSignificant(tokens, next)
+	}
+	if next < 0 || !isIdentifierToken(tokens[next]) ||
+		isAnyOf(tokens[next], joinKeywords) || isAnyOf(tokens[next], fromClauseEnd) {
+		return "", i
+	}
+
+	return unquoteIdentifier(tokens[next]), next
+}
+
+// resolveColumnMetadata returns the metadata of the result columns of the
+// query from its sources. A column is resolved if it is a reference to a
+// column of a table, such as s.id or id AS key, and an unqualified column is
+// in a single source. For a select list with *, the columns are resolved by
+// their names.
+//
+// The columns of a compound SELECT, such as with UNION, are not resolved,
+// since they may be read from the tables of any of the SELECTs.
+func resolveColumnMetadata(query string, columns []string, sources []columnSource) []ColumnMetadata {
+	metadata := make([]ColumnMetadata, len(columns))
+
+	tokens := tokenize(query)
+	depth := 0
+	for _, t := range tokens {
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth == 0 && (t.is("UNION") || t.is("INTERSECT") || t.is("EXCEPT")):
+			return metadata
+		}
+	}
+
+	expressions := selectList(tokens)
+	if expressions != nil && len(expressions) != len(columns) {
+		return metadata
+	}
+
+	for i, column := range columns {
+		qualifier, name := "", column
+		if expressions != nil {
+			var ok bool
+			if qualifier, name, ok = columnReference(expressions[i]); !ok {
+				continue
+			}
+		}
+
+		metadata[i] = resolveColumn(sources, qualifier, name)
+	}
+
+	return metadata
+}
+
+// columnReference returns the qualifier and the name of the column if the
+// expression is a column reference, optionally with an alias, such as
+// s.id AS key.
+func columnReference(expression []token) (qualifier, name string, ok bool) {
+	var parts []string
+	end := -1
+	for i := nextSignificant(expression, -1); i >= 0 && isIdentifierToken(expression[i]); {
+		parts = append(parts, unquoteIdentifier(expression[i]))
+		end = i
+
+		dot := nextSignificant(expression, i)
+		if dot < 0 || !expression[dot].is(".") {
+			break
+		}
+		i = nextSignificant(expression, dot)
+	}
+	// Up to schema.table.column
+	if len(parts) == 0 || len(parts) > 3 || !isAlias(expression[end+1:]) {
+		return "", "", false
+	}
+
+	if len(parts) > 1 {
+		qualifier = parts[len(parts)-2]
+	}
+	return qualifier, parts[len(parts)-1], true
+}
+
+// resolveColumn returns the metadata of the column in the sources matching
+// the qualifier, or the zero value if it is in none or several of them, or
+// may be in a source whose columns are not known.
+func resolveColumn(sources []columnSource, qualifier, name string) ColumnMetadata {
+	var resolved ColumnMetadata
+	matches := 0
+	for _, source := range sources {
+		if qualifier != "" && !strings.EqualFold(source.name, qualifier) {
+			continue
+		}
+		if source.table == "" {
+			return ColumnMetadata{}
+		}
+
+		if column, ok := source.columns[strings.ToLower(name)]; ok {
+			resolved = column
+			matches++
+		}
+	}
+	if matches != 1 {
+		return ColumnMetadata{}
+	}
+
+	return resolved
+}
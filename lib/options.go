@@ -0,0 +1,601 @@
+package sqlrunner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Option configures a SQLRunner.
+type Option func(*options)
+
+// options is the configuration of a SQLRunner.
+type options struct {
+	// orderCheck is how the multi-row results of the queries without a
+	// top-level ORDER BY are reported.
+	orderCheck OrderCheck
+	// stableOrdering sorts the rows of the queries without a top-level
+	// ORDER BY, so that their results are deterministic.
+	stableOrdering bool
+	// cartesianWarning enables the warning for queries combining the rows
+	// of tables without a join condition.
+	cartesianWarning bool
+	// indexWarning enables the suggestions for the tables a query reads
+	// without an index.
+	indexWarning bool
+	// columnMetadata enables the metadata of the result columns.
+	columnMetadata bool
+	// integerOverflow is the behavior of the arithmetic functions when their
+	// integer result overflows.
+	integerOverflow IntegerOverflow
+	// emptyResultHints enables the hints for the SELECT queries returning
+	// no rows.
+	emptyResultHints bool
+	// booleanFormat is the text form of the boolean values.
+	booleanFormat BooleanFormat
+	// realDecimals is the fixed number of decimals to render the values
+	// of REAL columns with. Negative means disabled.
+	realDecimals int
+	// foreignKeys enables the foreign key enforcement
+	// during the schema initialization.
+	foreignKeys bool
+	// writable runs the queries on a private copy of the schema database.
+	writable bool
+	// allowedStatements is the set of the allowed statement types.
+	// Nil means all types are allowed.
+	allowedStatements map[string]bool
+	// allowedPragmas is the set of the lower-cased names of the allowed
+	// PRAGMA statements.
+	allowedPragmas map[string]bool
+	// disabledFunctions is the set of the upper-cased names of the
+	// functions the queries may not call.
+	disabledFunctions map[string]bool
+	// collation is the default collation of the text columns.
+	collation string
+	// singleStatement rejects the queries with multiple statements in Query.
+	singleStatement bool
+	// concatNullPropagation makes CONCAT and CONCAT_WS return NULL
+	// if any argument is NULL.
+	concatNullPropagation bool
+	// dateArithmetic rewrites the MySQL date arithmetic with INTERVAL.
+	dateArithmetic bool
+	// strictDivision makes the divisions by zero fail rather than
+	// result in NULL.
+	strictDivision bool
+	// limiter limits the concurrent queries of the runners of a Service.
+	limiter *queryLimiter
+	// diskCacheMaxBytes is the maximum total size of the results cached on
+	// the disk per schema. Zero disables the disk cache.
+	diskCacheMaxBytes int64
+	// diskCacheTTL is the duration a result cached on the disk is valid for.
+	diskCacheTTL time.Duration
+	// groupConcatMaxLen is the maximum number of characters of a GROUP_CONCAT
+	// result. Zero means unlimited.
+	groupConcatMaxLen int
+	// maxColumns is the maximum number of columns of a result.
+	// Zero means unlimited.
+	maxColumns int
+	// maxResponseBytes is the maximum estimated size of a result serialized
+	// as JSON. Zero means unlimited.
+	maxResponseBytes int
+	// cacheTTL is the duration a result cached in memory is valid for.
+	// Zero means until it is evicted.
+	cacheTTL time.Duration
+	// cacheSize is the maximum number of the results cached in memory.
+	cacheSize int
+	// evictionObserver is notified when a cached result is evicted to make
+	// room for another. Nil means no one is notified.
+	evictionObserver func()
+	// initTimeout is the maximum duration of the schema initialization.
+	// Zero means unlimited.
+	initTimeout time.Duration
+	// now returns the current time. It is replaced in the tests.
+	now func() time.Time
+}
+
+// schemaOptions are the options affecting how a schema is initialized.
+// They are part of the schema hash, so that each combination is
+// initialized into its own database file.
+type schemaOptions struct {
+	foreignKeys bool
+	// collation is the default collation of the text columns.
+	// Empty means the SQLite default, BINARY.
+	collation string
+}
+
+// key returns the canonical text form of the options to hash.
+func (so schemaOptions) key() string {
+	return fmt.Sprintf("foreign_keys=%t;collation=%s", so.foreignKeys, so.collation)
+}
+
+// defaultSchemaOptions are the schema options of a runner without options.
+var defaultSchemaOptions = newOptions(nil).schema()
+
+func newOptions(opts []Option) options {
+	o := options{
+		realDecimals:     -1,
+		orderCheck:       OrderCheckOff,
+		cacheSize:        defaultCacheSize,
+		maxResponseBytes: defaultMaxResponseBytes,
+		foreignKeys:      true,
+		booleanFormat:    BooleanNumeric,
+		integerOverflow:  OverflowFloat,
+		allowedPragmas:   pragmaSet(DefaultAllowedPragmas),
+		now:              time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// functionVariants returns the function variants to call instead of the
+// functions, according to the options.
+func (o options) functionVariants() functionVariants {
+	variants := functionVariants{}
+	if o.concatNullPropagation {
+		variants["CONCAT"] = nullStrictSuffix
+		variants["CONCAT_WS"] = nullStrictSuffix
+	}
+	// STRCMP and SORT_KEY agree with the comparisons of the text columns
+	if o.collation == "NOCASE" || o.collation == "RTRIM" {
+		variants["STRCMP"] = collationSuffix(o.collation)
+		variants["SORT_KEY"] = collationSuffix(o.collation)
+	}
+	if o.integerOverflow == OverflowError || o.integerOverflow == OverflowWrap {
+		for name := range overflowFunctions {
+			variants[name] = overflowSuffix(o.integerOverflow)
+		}
+	}
+
+	return variants
+}
+
+// resultKey returns the canonical text form of the options affecting the
+// query results, other than the schema options, to key the disk cache with.
+func (o options) resultKey() string {
+	return fmt.Sprintf("order_check=%s;stable_ordering=%t;cartesian_warning=%t;index_warning=%t;column_metadata=%t;empty_result_hints=%t;real_decimals=%d;boolean_format=%s;writable=%t;concat_null=%t;date_arithmetic=%t;strict_division=%t;integer_overflow=%s;group_concat_max_len=%d;max_columns=%d;max_response_bytes=%d",
+		o.orderCheck, o.stableOrdering, o.cartesianWarning, o.indexWarning, o.columnMetadata, o.emptyResultHints, o.realDecimals, o.booleanFormat, o.writable, o.concatNullPropagation, o.dateArithmetic, o.strictDivision, o.integerOverflow, o.groupConcatMaxLen, o.maxColumns, o.maxResponseBytes)
+}
+
+// schema returns the options affecting the schema initialization.
+func (o options) schema() schemaOptions {
+	return schemaOptions{
+		foreignKeys: o.foreignKeys,
+		collation:   o.collation,
+	}
+}
+
+// WithOrderWarning makes Query warn when a query returns multiple rows
+// without a top-level ORDER BY clause, since the row order is not guaranteed.
+// It is WithOrderCheck with OrderCheckWarn, or OrderCheckOff if disabled.
+func WithOrderWarning(enabled bool) Option {
+	return func(o *options) {
+		o.orderCheck = OrderCheckOff
+		if enabled {
+			o.orderCheck = OrderCheckWarn
+		}
+	}
+}
+
+// WithOrderCheck sets how Query reports a query returning multiple rows
+// without a top-level ORDER BY clause: not at all with OrderCheckOff, the
+// default, with a warning with OrderCheckWarn, or with a QueryError wrapping
+// ErrUnorderedRows with OrderCheckError. A query with RETURNING is not
+// checked.
+func WithOrderCheck(mode OrderCheck) Option {
+	return func(o *options) {
+		o.orderCheck = mode
+	}
+}
+
+// WithStableOrdering makes Query sort the rows of a query without a
+// top-level ORDER BY clause, so that a cached result and a fresh execution
+// agree on the row order, which SQLite does not guarantee otherwise. The rows
+// are sorted by their cells from the first column, comparing two numbers
+// numerically and any other cells as strings.
+//
+// The tradeoff is that the rows are no longer in the natural order of the
+// query, such as the insertion order of a table, and sorting a large result
+// takes time. Since the rows are sorted after the query, a LIMIT without
+// ORDER BY may still pick other rows. QueryRows does not sort the rows.
+func WithStableOrdering(enabled bool) Option {
+	return func(o *options) {
+		o.stableOrdering = enabled
+	}
+}
+
+// WithCartesianWarning makes Query warn when a query combines every row of
+// a table with every row of another, such as FROM a, b without a condition
+// joining them, which is a common mistake producing a huge result. The query
+// plan is examined before the query is executed.
+func WithCartesianWarning(enabled bool) Option {
+	return func(o *options) {
+		o.cartesianWarning = enabled
+	}
+}
+
+// WithIndexWarning makes Query suggest an index when the query plan of a
+// query filtering or joining the rows reads a table without one: the
+// tables scanned in full, and the tables SQLite builds a temporary index
+// on for the query. The query plan is examined before the query is
+// executed.
+//
+// It is opt-in, since scanning a small table is often as fast as
+// searching it, and the suggestions are meant for teaching.
+func WithIndexWarning(enabled bool) Option {
+	return func(o *options) {
+		o.indexWarning = enabled
+	}
+}
+
+// WithColumnMetadata makes Query return the metadata of the result columns
+// of a SELECT in QueryResult.ColumnMetadata: the table each column is read
+// from, its position in the table, and whether it is a part of the primary
+// key. The columns are resolved from the select list and the FROM clause,
+// since SQLite does not expose the origins of the columns to the driver, so
+// it is best-effort: the expressions, the columns of the subqueries and the
+// CTEs, and the ambiguous columns are not resolved.
+func WithColumnMetadata(enabled bool) Option {
+	return func(o *options) {
+		o.columnMetadata = enabled
+	}
+}
+
+// WithIntegerOverflow sets the behavior of POW, POWER, and MOD when their
+// integer result does not fit in a 64-bit integer, such as POW(2, 64).
+// With OverflowFloat, the default, they are the SQLite built-ins, which
+// compute and return the REAL values. With OverflowError and OverflowWrap,
+// they compute the results of the integers with the integers, exactly, and
+// fail the query with a QueryError or wrap the result around on overflow.
+func WithIntegerOverflow(mode IntegerOverflow) Option {
+	return func(o *options) {
+		o.integerOverflow = mode
+	}
+}
+
+// WithEmptyResultHints makes Query explain why a SELECT returns no rows in
+// the warnings, for the students who take an empty result for a working
+// query: a table it reads is empty, or its WHERE clause, join conditions, or
+// HAVING clause matched none of the rows. It checks whether each table in
+// the FROM clause has a row, up to 8 tables, after the query returns no rows.
+func WithEmptyResultHints(enabled bool) Option {
+	return func(o *options) {
+		o.emptyResultHints = enabled
+	}
+}
+
+// WithRealDecimals renders the values of columns declared with the REAL
+// affinity (such as REAL, FLOAT, and DOUBLE) with a fixed number of decimals,
+// so that 1.0 is rendered as "1.00" rather than "1" with 2 decimals.
+//
+// Values of expressions are not affected since they have no declared type.
+func WithRealDecimals(decimals int) Option {
+	return func(o *options) {
+		o.realDecimals = decimals
+	}
+}
+
+// WithForeignKeys sets whether the foreign key constraints are enforced
+// while the schema is initialized. It is enabled by default; disable it to
+// load data violating the constraints, such as in a lesson about them.
+//
+// Each setting initializes the schema into a separate database file.
+func WithForeignKeys(enabled bool) Option {
+	return func(o *options) {
+		o.foreignKeys = enabled
+	}
+}
+
+// WithWritable allows the queries to modify the database, such as to
+// teach INSERT and UPDATE. Each query runs on a private copy of the
+// schema database, so the modifications are discarded after the query
+// and never seen by other queries.
+func WithWritable(enabled bool) Option {
+	return func(o *options) {
+		o.writable = enabled
+	}
+}
+
+// WithAllowedStatements restricts the queries to the statement types, which
+// are the leading keywords of the statements, such as SELECT, INSERT, and
+// CREATE. The type of a statement with a WITH clause is the keyword following
+// the common table expressions, so WITH ... DELETE is a DELETE statement.
+//
+// Query returns a PolicyError for a query with any other statement.
+func WithAllowedStatements(types ...string) Option {
+	return func(o *options) {
+		o.allowedStatements = make(map[string]bool, len(types))
+		for _, t := range types {
+			o.allowedStatements[strings.ToUpper(t)] = true
+		}
+	}
+}
+
+// DefaultAllowedPragmas are the PRAGMA statements allowed by default, which
+// only introspect the schema.
+var DefaultAllowedPragmas = []string{
+	"table_info",
+	"table_xinfo",
+	"table_list",
+	"index_list",
+	"index_info",
+	"index_xinfo",
+	"foreign_key_list",
+	"foreign_key_check",
+	"collation_list",
+	"function_list",
+	"pragma_list",
+}
+
+// WithAllowedPragmas replaces DefaultAllowedPragmas with the names of the
+// PRAGMA statements a query may run. The other PRAGMAs, such as
+// writable_schema and database_list, which reveals the path of the database
+// file, are rejected with a PolicyError, and so are their table-valued
+// functions, such as pragma_database_list. No names block all the PRAGMAs.
+func WithAllowedPragmas(names ...string) Option {
+	return func(o *options) {
+		o.allowedPragmas = pragmaSet(names)
+	}
+}
+
+// pragmaSet returns the set of the lower-cased PRAGMA names.
+func pragmaSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(name)] = true
+	}
+	return set
+}
+
+// WithDisabledFunctions rejects the queries calling the functions, such as
+// LEFT or IF, with a PolicyError, so that a runner can offer a subset of the
+// functions, such as for an exercise on string manipulation. The names are
+// case-insensitive, and may be SQLite built-ins too. The operators
+// evaluated by a function, such as LIKE and REGEXP, count as calls of it,
+// and so does the MySQL syntax the runner rewrites to a call, such as
+// JSON_ARRAYAGG to json_group_array.
+//
+// The functions registered by this package are global to the process:
+// modernc.org/sqlite registers them on every connection of the driver and
+// has no connection-scoped registration. So the functions are disabled by
+// checking the queries rather than by not registering them, and the
+// semantics of a function only differ by runner through the options
+// selecting its variant, such as WithConcatNullPropagation.
+func WithDisabledFunctions(names ...string) Option {
+	return func(o *options) {
+		o.disabledFunctions = make(map[string]bool, len(names))
+		for _, name := range names {
+			o.disabledFunctions[strings.ToUpper(name)] = true
+		}
+	}
+}
+
+// WithSingleStatement makes Query return ErrMultipleStatements for a query
+// with more than one statement, which it otherwise executes while returning
+// the result of the last one only. Comments and empty statements, such as
+// the one after a trailing semicolon, are not counted. QueryMulti is not
+// affected.
+func WithSingleStatement(enabled bool) Option {
+	return func(o *options) {
+		o.singleStatement = enabled
+	}
+}
+
+// WithConcatNullPropagation sets whether CONCAT and CONCAT_WS return NULL
+// if any argument is NULL, like CONCAT in MySQL. By default, they follow
+// SQLite: CONCAT treats NULL as an empty string, and CONCAT_WS skips NULL
+// values (but returns NULL for a NULL separator).
+func WithConcatNullPropagation(enabled bool) Option {
+	return func(o *options) {
+		o.concatNullPropagation = enabled
+	}
+}
+
+// WithMySQLDateArithmetic makes the runner rewrite the MySQL date
+// arithmetic, such as d + INTERVAL 7 DAY, to DATE_ADD and DATE_SUB before
+// executing a query. See RewriteMySQLDateArithmetic for what is rewritten.
+func WithMySQLDateArithmetic(enabled bool) Option {
+	return func(o *options) {
+		o.dateArithmetic = enabled
+	}
+}
+
+// WithStrictDivision makes the divisions and the modulos by zero, such as
+// 1 / 0, 1 % 0, and MOD(1, 0), fail with a QueryError of "division by
+// zero", like MySQL in the strict mode. By default, they result in NULL,
+// like SQLite and MySQL in the other modes.
+//
+// The divisors in the query are wrapped in a function checking them, so
+// a divisor too complex to be delimited, such as a subquery without
+// parentheses, is not checked.
+func WithStrictDivision(enabled bool) Option {
+	return func(o *options) {
+		o.strictDivision = enabled
+	}
+}
+
+// WithDefaultCollation sets the collation of the text columns declared
+// without a COLLATE clause, such as NOCASE to compare and sort them
+// case-insensitively like the default collation of MySQL. SQLite compares
+// them with BINARY by default, which is case-sensitive.
+//
+// The collation is added to the column definitions of the CREATE TABLE
+// statements of the schema, so it does not affect the comparisons between
+// literals, such as 'a' = 'A'. Each collation initializes the schema into
+// a separate database file.
+func WithDefaultCollation(collation string) Option {
+	return func(o *options) {
+		o.collation = strings.ToUpper(collation)
+		if o.collation == "BINARY" {
+			o.collation = ""
+		}
+	}
+}
+
+// WithDiskCache caches the query results on the disk in addition to the
+// memory, so the results survive the restarts of the process. The results
+// of a schema are removed from the oldest once their total size exceeds
+// maxBytes, and expire after ttl unless it is zero.
+//
+// The cached results are shared by the runners with the same schema and
+// options, and removed by InvalidateSchema.
+func WithDiskCache(maxBytes int64, ttl time.Duration) Option {
+	return func(o *options) {
+		o.diskCacheMaxBytes = maxBytes
+		o.diskCacheTTL = ttl
+	}
+}
+
+// WithBooleanFormat sets the text form of the boolean values in the results,
+// which are 1 and 0 (BooleanNumeric) by default like MySQL. SQLite has no
+// boolean type, so the boolean values are the 1 and 0 of the columns
+// declared as BOOLEAN, and of the result columns whose expressions are
+// comparisons or logical operations, such as value = 1.
+func WithBooleanFormat(format BooleanFormat) Option {
+	return func(o *options) {
+		o.booleanFormat = format
+	}
+}
+
+// WithGroupConcatMaxLen truncates the results of GROUP_CONCAT to maxLen
+// characters, like group_concat_max_len of MySQL, and adds a warning to
+// the result of a query with a truncated value. SQLite does not limit
+// GROUP_CONCAT by default.
+func WithGroupConcatMaxLen(maxLen int) Option {
+	return func(o *options) {
+		o.groupConcatMaxLen = maxLen
+	}
+}
+
+// WithMaxColumns makes Query return a QueryError wrapping ErrTooManyColumns
+// for a result with more than maxColumns columns, such as SELECT * over a
+// very wide view, before any row is read. Zero means unlimited, which is
+// the default.
+func WithMaxColumns(maxColumns int) Option {
+	return func(o *options) {
+		o.maxColumns = maxColumns
+	}
+}
+
+// WithMaxResponseBytes makes Query return a QueryError wrapping
+// ErrResultTooLarge for a result whose size serialized as JSON is estimated
+// to exceed maxBytes, such as a SELECT * over a large table without LIMIT,
+// as soon as the rows read so far exceed it. The default is 64 MiB, and zero
+// means unlimited.
+//
+// The size is estimated from the column names and the cells as JSON
+// strings, without escaping. QueryRows is not limited, since it does not
+// keep the rows.
+func WithMaxResponseBytes(maxBytes int) Option {
+	return func(o *options) {
+		o.maxResponseBytes = maxBytes
+	}
+}
+
+// WithCacheTTL expires the query results cached in memory after ttl, so
+// the queries are executed again, such as to bound the staleness. By
+// default, a result is cached until it is evicted by the newer ones.
+//
+// The results cached on the disk expire after the TTL of WithDiskCache.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.cacheTTL = ttl
+	}
+}
+
+// WithCacheSize sets the maximum number of the results a runner caches in
+// memory, 100 by default. The least recently used result is evicted to make
+// room for another.
+func WithCacheSize(size int) Option {
+	return func(o *options) {
+		o.cacheSize = size
+	}
+}
+
+// WithEvictionObserver sets the function notified when a runner evicts a
+// cached result to make room for another, such as to count the evictions
+// for tuning WithCacheSize. The results dropped by an invalidation or an
+// expiration are not evictions. The evicted query is logged at the debug
+// level by its hash.
+func WithEvictionObserver(observe func()) Option {
+	return func(o *options) {
+		o.evictionObserver = observe
+	}
+}
+
+// WithInitTimeout aborts the initialization of the schema if it takes
+// longer than the timeout, such as a schema generating many rows with a
+// recursive INSERT, so NewSQLRunner and the queries fail with a SchemaError
+// wrapping ErrSchemaTimeout rather than hang. The failure is remembered
+// like the other schema errors. Zero, the default, means unlimited.
+//
+// The initialization of a schema is shared by the runners, so the timeout
+// of the runner starting it applies.
+func WithInitTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.initTimeout = timeout
+	}
+}
+
+// QueryOption configures a single query.
+type QueryOption func(*queryOptions)
+
+// queryOptions is the configuration of a single query.
+type queryOptions struct {
+	// blobEncoding is the encoding of the BLOB values in the result.
+	blobEncoding BlobEncoding
+	// params are the values of the named placeholders. Nil means the
+	// placeholders are not bound.
+	params map[string]any
+	// variables are the values of the user variables, see WithVariables.
+	// They are added to params by bindVariables.
+	variables map[string]any
+	// partial returns the rows read so far if the query times out.
+	partial bool
+}
+
+func newQueryOptions(opts []QueryOption) queryOptions {
+	o := queryOptions{
+		blobEncoding: BlobHex,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// cacheKey returns the key of the result of the query with the options.
+// The results with the default options are keyed by the query itself.
+func (o queryOptions) cacheKey(query string) string {
+	key := query
+	if o.params != nil {
+		key = "params=" + paramsKey(o.params) + "\x00" + key
+	}
+	if o.blobEncoding == BlobHex {
+		return key
+	}
+
+	return "blob=" + string(o.blobEncoding) + "\x00" + key
+}
+
+// WithBlobEncoding sets the encoding of the BLOB values in the result of
+// a query. The BLOB values are encoded in hexadecimal (BlobHex) by default.
+func WithBlobEncoding(encoding BlobEncoding) QueryOption {
+	return func(o *queryOptions) {
+		o.blobEncoding = encoding
+	}
+}
+
+// WithPartialResults makes Query return the rows read so far, with
+// QueryResult.Partial set, if the deadline of the context is exceeded while
+// the rows are read, such as for the preview of a large export, rather than
+// fail. A query timing out before its first row is ready still fails. The
+// partial results are not cached.
+func WithPartialResults(enabled bool) QueryOption {
+	return func(o *queryOptions) {
+		o.partial = enabled
+	}
+}
@@ -0,0 +1,155 @@
+package sqlrunner
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+// IntegerOverflow is the behavior of the arithmetic functions, such as POW,
+// when their integer result does not fit in a 64-bit integer.
+type IntegerOverflow string
+
+const (
+	// OverflowFloat returns a REAL instead, like SQLite. It is the default.
+	OverflowFloat IntegerOverflow = "float"
+	// OverflowError fails the query, like MySQL for a BIGINT out of range.
+	OverflowError IntegerOverflow = "error"
+	// OverflowWrap wraps the result around the 64-bit integers, like the
+	// two's complement arithmetic.
+	OverflowWrap IntegerOverflow = "wrap"
+)
+
+// overflowFunctions are the functions whose variants compute the integer
+// results with the integers rather than the doubles, and detect their
+// overflow, by the upper-cased names.
+var overflowFunctions = map[string]func(mode IntegerOverflow, name string, args []driver.Value) (driver.Value, error){
+	"POW":   overflowPow,
+	"POWER": overflowPow,
+	"MOD":   overflowMod,
+}
+
+// overflowSuffix returns the suffix of the function variants with the
+// integer overflow behavior, such as POW__OVERFLOW_WRAP.
+func overflowSuffix(mode IntegerOverflow) string {
+	return "__OVERFLOW_" + strings.ToUpper(string(mode))
+}
+
+// overflowVariantFunctions returns the variants of overflowFunctions for
+// OverflowError and OverflowWrap. OverflowFloat calls the SQLite built-ins.
+func overflowVariantFunctions() []registeredFunction {
+	var variants []registeredFunction
+	for _, mode := range []IntegerOverflow{OverflowError, OverflowWrap} {
+		for name, fn := range overflowFunctions {
+			variants = append(variants, registeredFunction{
+				name: name + overflowSuffix(mode),
+				impl: &sqlite.FunctionImpl{
+					NArgs:         2,
+					Deterministic: true,
+					Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+						return fn(mode, name, args)
+					},
+				},
+			})
+		}
+	}
+
+	return variants
+}
+
+// overflowPow raises the base to the exponent. The result of two integers
+// and a non-negative exponent is an integer, which fails or wraps around
+// by the mode if it overflows. Any other result is a REAL like POW.
+func overflowPow(mode IntegerOverflow, name string, args []driver.Value) (driver.Value, error) {
+	if hasNull(args) {
+		return nil, nil
+	}
+
+	base, baseOK := args[0].(int64)
+	exponent, exponentOK := args[1].(int64)
+	if !baseOK || !exponentOK || exponent < 0 {
+		return math.Pow(toDouble(args[0]), toDouble(args[1])), nil
+	}
+
+	result, overflow := integerPow(base, exponent)
+	if overflow && mode == OverflowError {
+		return nil, errIntegerOverflow(name, args)
+	}
+
+	return result, nil
+}
+
+// overflowMod returns the remainder of the division of two integers as an
+// integer, which never overflows, rather than of their doubles, which are
+// not exact beyond 2^53. Any other result is a REAL like MOD, and the
+// remainder of a division by zero is NULL.
+func overflowMod(_ IntegerOverflow, _ string, args []driver.Value) (driver.Value, error) {
+	if hasNull(args) {
+		return nil, nil
+	}
+
+	dividend, dividendOK := args[0].(int64)
+	divisor, divisorOK := args[1].(int64)
+	if !dividendOK || !divisorOK {
+		if toDouble(args[1]) == 0 {
+			return nil, nil
+		}
+		return math.Mod(toDouble(args[0]), toDouble(args[1])), nil
+	}
+	if divisor == 0 {
+		return nil, nil
+	}
+
+	// The remainder of math.MinInt64 by -1 is 0, unlike the quotient
+	return dividend % divisor, nil
+}
+
+// errIntegerOverflow returns the error of an integer result of the function
+// out of the range of the 64-bit integers, like MySQL.
+func errIntegerOverflow(name string, args []driver.Value) error {
+	texts := make([]string, len(args))
+	for i, arg := range args {
+		texts[i] = sqliteText(arg)
+	}
+
+	return fmt.Errorf("BIGINT value is out of range in %s(%s)", name, strings.Join(texts, ", "))
+}
+
+// integerPow raises the base to the non-negative exponent by squaring,
+// wrapping around the 64-bit integers, and reports whether the result
+// overflowed.
+func integerPow(base, exponent int64) (result int64, overflow bool) {
+	result = 1
+	for exponent > 0 {
+		if exponent&1 == 1 {
+			var o bool
+			result, o = multiplyOverflow(result, base)
+			overflow = overflow || o
+		}
+
+		// The square is only used, and thus only overflows the result,
+		// if there are more bits of the exponent.
+		if exponent >>= 1; exponent > 0 {
+			var o bool
+			base, o = multiplyOverflow(base, base)
+			overflow = overflow || o
+		}
+	}
+
+	return result, overflow
+}
+
+// multiplyOverflow multiplies the integers, wrapping around the 64-bit
+// integers, and reports whether the product overflowed.
+func multiplyOverflow(a, b int64) (int64, bool) {
+	product := a * b
+	if a == 0 || b == 0 {
+		return product, false
+	}
+
+	overflow := product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64)
+	return product, overflow
+}
@@ -0,0 +1,237 @@
+package sqlrunner
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParameterError is returned when the named parameters of a query do not
+// match its placeholders, such as a placeholder without a value.
+type ParameterError struct {
+	Parent error
+}
+
+func NewParameterError(err error) error {
+	return ParameterError{Parent: err}
+}
+
+func (e ParameterError) Error() string {
+	return "invalid parameters: " + e.Parent.Error()
+}
+
+func (e ParameterError) Unwrap() error {
+	return e.Parent
+}
+
+// WithParams binds the values to the named placeholders of the query, such
+// as :name and @name, by their names without the prefix. The values are
+// nil, int64, float64, string, bool, or []byte.
+//
+// Every placeholder must have a value and every value must have a
+// placeholder, or Query returns a ParameterError. The query must not have
+// the positional placeholders (?) too. Without WithParams, the placeholders
+// are bound to NULL.
+func WithParams(params map[string]any) QueryOption {
+	return func(o *queryOptions) {
+		o.params = params
+	}
+}
+
+// ErrUndefinedVariable is wrapped in the ParameterError returned for a
+// reference to a variable without a value in WithVariables.
+var ErrUndefinedVariable = errors.New("undefined variable")
+
+// WithVariables sets the MySQL user variables the query references, such
+// as @x, by their names without the @, like SET @x := 5 before the query in
+// MySQL. The names are case-insensitive, like MySQL. The variables are
+// scoped to the query, and are bound to the references like WithParams,
+// rather than substituted into the query text.
+//
+// Unlike the parameters, the variables not referenced in the query are
+// ignored. A reference to a variable without a value, which is NULL in
+// MySQL, makes Query return a ParameterError wrapping ErrUndefinedVariable,
+// such as for a misspelled name, unless WithParams has a value for it.
+func WithVariables(variables map[string]any) QueryOption {
+	return func(o *queryOptions) {
+		o.variables = variables
+	}
+}
+
+// bindVariables adds the values of the variables referenced in the query to
+// the parameters, by the names written in the query, so that bindParams
+// binds them.
+func (o *queryOptions) bindVariables(query string) error {
+	if o.variables == nil {
+		return nil
+	}
+
+	variables := make(map[string]any, len(o.variables))
+	for name, value := range o.variables {
+		variables[strings.ToLower(name)] = value
+	}
+
+	params := maps.Clone(o.params)
+	if params == nil {
+		params = make(map[string]any)
+	}
+	bound := false
+	tokens := tokenize(query)
+	for i, t := range tokens {
+		if !t.is("@") || i+1 >= len(tokens) || tokens[i+1].kind != tokenIdentifier {
+			continue
+		}
+
+		name := tokens[i+1].text
+		if _, ok := params[name]; ok {
+			continue
+		}
+		value, ok := variables[strings.ToLower(name)]
+		if !ok {
+			return NewParameterError(fmt.Errorf("%w @%s", ErrUndefinedVariable, name))
+		}
+		params[name] = value
+		bound = true
+	}
+
+	// Without a variable referenced, the placeholders are bound as if
+	// there were none, such as ? and the :name without a value
+	if bound {
+		o.params = params
+	}
+	return nil
+}
+
+// paramsKey returns the canonical text form of the parameters to key the
+// cached results with. The types are part of it, since 1 and '1' are
+// different values to SQLite.
+func paramsKey(params map[string]any) string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		fmt.Fprintf(&b, "%s=%T:%#v;", name, params[name], params[name])
+	}
+
+	return b.String()
+}
+
+// bindParams translates the named placeholders of the query to the
+// numbered ones SQLite binds the positional arguments to, such as
+// :a + :b + :a to ?1 + ?2 + ?1, and returns the arguments in order. The
+// returned function restores the placeholders in a column name, so that the
+// column of :a is still named ":a".
+//
+// Without params, the query is returned as is, with its named placeholders
+// bound to NULL.
+func bindParams(query string, params map[string]any) (string, []any, func(string) string, error) {
+	if params == nil {
+		return query, nullArgs(query), func(column string) string { return column }, nil
+	}
+
+	tokens := tokenize(query)
+
+	// The placeholders by the index of their prefix token
+	names := make(map[int]string)
+	// The distinct names in order, and the placeholder text of each
+	var order []string
+	placeholders := make(map[string]string)
+	for i, t := range tokens {
+		if t.is("?") {
+			return "", nil, nil, NewParameterError(errors.New("positional placeholders (?) cannot be mixed with the named parameters"))
+		}
+		if !(t.is(":") || t.is("@")) || i+1 >= len(tokens) || tokens[i+1].kind != tokenIdentifier {
+			continue
+		}
+
+		name := tokens[i+1].text
+		names[i] = name
+		if _, ok := placeholders[name]; !ok {
+			order = append(order, name)
+			placeholders[name] = t.text + name
+		}
+	}
+
+	args := make([]any, 0, len(order))
+	numbers := make(map[string]int, len(order))
+	for _, name := range order {
+		value, ok := params[name]
+		if !ok {
+			return "", nil, nil, NewParameterError(fmt.Errorf("missing value of the parameter %s", placeholders[name]))
+		}
+		args = append(args, value)
+		numbers[name] = len(args)
+	}
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		if _, ok := placeholders[name]; !ok {
+			return "", nil, nil, NewParameterError(fmt.Errorf("unused parameter %s", name))
+		}
+	}
+
+	if len(names) == 0 {
+		return query, args, func(column string) string { return column }, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(tokens); i++ {
+		if name, ok := names[i]; ok {
+			b.WriteString("?" + strconv.Itoa(numbers[name]))
+			i++
+			continue
+		}
+		b.WriteString(tokens[i].text)
+	}
+
+	restore := func(column string) string {
+		if !strings.Contains(column, "?") {
+			return column
+		}
+
+		tokens := tokenize(column)
+		var b strings.Builder
+		for i := 0; i < len(tokens); i++ {
+			if tokens[i].is("?") && i+1 < len(tokens) && tokens[i+1].kind == tokenNumber {
+				if n, err := strconv.Atoi(tokens[i+1].text); err == nil && n >= 1 && n <= len(order) {
+					b.WriteString(placeholders[order[n-1]])
+					i++
+					continue
+				}
+			}
+			b.WriteString(tokens[i].text)
+		}
+
+		return b.String()
+	}
+
+	return b.String(), args, restore, nil
+}
+
+// nullArgs returns the arguments binding NULL to the named placeholders of
+// the query, such as :name and @name.
+func nullArgs(query string) []any {
+	if !strings.ContainsAny(query, ":@") {
+		return nil
+	}
+
+	tokens := tokenize(query)
+	var args []any
+	seen := make(map[string]bool)
+	for i, t := range tokens {
+		if !(t.is(":") || t.is("@")) || i+1 >= len(tokens) || tokens[i+1].kind != tokenIdentifier {
+			continue
+		}
+
+		name := tokens[i+1].text
+		// database/sql only accepts the names starting with a letter
+		if r, _ := utf8.DecodeRuneInString(name); !seen[name] && unicode.IsLetter(r) {
+			seen[name] = true
+			args = append(args, sql.Named(name, nil))
+		}
+	}
+
+	return args
+}
@@ -0,0 +1,74 @@
+package sqlrunner
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sync/atomic"
+)
+
+// FilePermissions are the permissions of the directories and the files
+// the runners create in the temporary directory, such as the schema
+// databases and the disk cache.
+type FilePermissions struct {
+	Dir  fs.FileMode
+	File fs.FileMode
+}
+
+// DefaultFilePermissions only allow the user running the service to access
+// the files, so that the schema data is not exposed to the other users of
+// a shared host.
+var DefaultFilePermissions = FilePermissions{Dir: 0o700, File: 0o600}
+
+var filePermissions atomic.Pointer[FilePermissions]
+
+func init() {
+	SetFilePermissions(DefaultFilePermissions)
+}
+
+// SetFilePermissions sets the permissions of the directories and the files
+// created from now on. The temporary directory is shared by all the runners
+// of the process, so the permissions are process-wide.
+//
+// The permissions are set explicitly rather than through the umask, so a
+// permissive umask does not widen them.
+func SetFilePermissions(p FilePermissions) {
+	p.Dir &= fs.ModePerm
+	p.File &= fs.ModePerm
+	filePermissions.Store(&p)
+}
+
+// currentFilePermissions returns the permissions set by SetFilePermissions.
+func currentFilePermissions() FilePermissions {
+	return *filePermissions.Load()
+}
+
+// makeDir creates the directory with its parents if needed, and sets its
+// permissions even if it exists, such as one created by an older version.
+func makeDir(dir string) error {
+	mode := currentFilePermissions().Dir
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		return fmt.Errorf("set directory permissions: %w", err)
+	}
+
+	return nil
+}
+
+// createTempFile creates a temporary file like os.CreateTemp,
+// with the file permissions.
+func createTempFile(dir, pattern string) (*os.File, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(currentFilePermissions().File); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, fmt.Errorf("set file permissions: %w", err)
+	}
+
+	return file, nil
+}
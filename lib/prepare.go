@@ -0,0 +1,151 @@
+package sqlrunner
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// prepareErrorPatterns extract the fragment of the query an error of
+// SQLite is about, to locate the error in the query.
+var prepareErrorPatterns = []struct {
+	pattern  *regexp.Regexp
+	category string
+}{
+	{regexp.MustCompile(`near "(.*)": syntax error`), PrepareErrorSyntax},
+	{regexp.MustCompile(`unrecognized token: "(.*)"`), PrepareErrorSyntax},
+	{regexp.MustCompile(`(incomplete input)`), PrepareErrorSyntax},
+	{regexp.MustCompile(`no such (?:table|column|function): (\S+)`), PrepareErrorReference},
+	{regexp.MustCompile(`ambiguous column name: (\S+)`), PrepareErrorReference},
+}
+
+// Prepare checks a query against the schema without executing it, such
+// as to give instant feedback in a SQL editor. Each statement of the query
+// is prepared on the read-only database, even in the writable mode.
+//
+// It returns a PrepareError if a statement is invalid, or a PolicyError
+// if the query has a statement not allowed by WithAllowedStatements.
+func (r *SQLRunner) Prepare(ctx context.Context, query string) error {
+	ctx, span := tracer.Start(ctx, "SQLRunner.Prepare")
+	defer span.End()
+
+	if err := r.options.checkPolicy(query); err != nil {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(err)
+
+		return err
+	}
+
+	// Prevent the schema file from being invalidated during the preparation
+	unlockFiles := rlockSchemaFiles(baseSchemaHash(r.schemaHash))
+	defer unlockFiles()
+
+	filename, err := initializeThreadSafe(r.schema, r.options.schema(), r.options.initTimeout)
+	if err != nil {
+		span.SetStatus(codes.Error, "initialize error")
+		span.RecordError(err)
+
+		if !errors.As(err, &SchemaError{}) {
+			err = NewSchemaError(err)
+		}
+		return err
+	}
+
+	db, err := readOnlyHandle(ctx, filename)
+	if err != nil {
+		span.SetStatus(codes.Error, "open error")
+		span.RecordError(err)
+
+		return err
+	}
+
+	offset := 0
+	for _, statement := range SplitStatements(query) {
+		// The statements are trimmed substrings of the query in order
+		start := offset + strings.Index(query[offset:], statement)
+		offset = start + len(statement)
+
+		if translated, ok := translateCommand(statement); ok {
+			statement = translated
+		}
+		rewritten, _ := r.options.rewrite(statement)
+
+		stmt, err := db.PrepareContext(ctx, rewritten)
+		if err != nil {
+			span.SetStatus(codes.Error, "prepare error")
+			span.RecordError(err)
+
+			return newPrepareError(err, query, start, offset)
+		}
+		if err := stmt.Close(); err != nil {
+			slog.WarnContext(ctx, "close statement", slog.Any("error", err))
+		}
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	return nil
+}
+
+// newPrepareError classifies the error of preparing the statement in
+// query[start:end] and locates it in the query, on a best-effort basis.
+func newPrepareError(err error, query string, start, end int) PrepareError {
+	prepareError := PrepareError{
+		Parent:   err,
+		Category: PrepareErrorOther,
+	}
+
+	for _, p := range prepareErrorPatterns {
+		match := p.pattern.FindStringSubmatch(err.Error())
+		if match == nil {
+			continue
+		}
+		prepareError.Category = p.category
+
+		pos := end
+		if fragment := match[1]; fragment != "incomplete input" {
+			var ok bool
+			if pos, ok = locateFragment(query, start, end, fragment); !ok {
+				break
+			}
+		}
+		prepareError.Line, prepareError.Column = lineColumn(query, pos)
+		break
+	}
+
+	return prepareError
+}
+
+// locateFragment returns the offset of the first token of query[start:end]
+// which the fragment starts with. A qualified name, such as t.b, is located
+// by its last part.
+func locateFragment(query string, start, end int, fragment string) (int, bool) {
+	if i := strings.LastIndexByte(fragment, '.'); i >= 0 && i < len(fragment)-1 {
+		fragment = fragment[i+1:]
+	}
+
+	for _, t := range tokenize(query[start:end]) {
+		if t.kind == tokenWhitespace || t.kind == tokenComment {
+			continue
+		}
+		if strings.EqualFold(t.text, fragment) || strings.EqualFold(unquoteIdentifier(t), fragment) ||
+			(len(fragment) > 0 && strings.HasPrefix(strings.ToLower(t.text), strings.ToLower(fragment))) {
+			return start + t.pos, true
+		}
+	}
+
+	return 0, false
+}
+
+// lineColumn converts a byte offset of the query to the 1-based line and
+// column, which counts the characters.
+func lineColumn(query string, offset int) (line, column int) {
+	before := query[:offset]
+	line = strings.Count(before, "\n") + 1
+	column = len([]rune(before[strings.LastIndexByte(before, '\n')+1:])) + 1
+
+	return line, column
+}
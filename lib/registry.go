@@ -0,0 +1,124 @@
+package sqlrunner
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnknownSchemaName is returned when no schema is registered
+// under the name.
+var ErrUnknownSchemaName = errors.New("unknown schema name")
+
+// SchemaVersion is the current version of a named schema.
+type SchemaVersion struct {
+	Name string `json:"name"`
+	// Version starts from 1 and is incremented each time the schema
+	// of the name is changed.
+	Version int `json:"version"`
+	// Hash is the schema hash, as returned by SQLRunner.SchemaHash.
+	Hash   string `json:"hash"`
+	Schema string `json:"schema"`
+}
+
+// schemaRegistry maps the names of the schemas to their current versions.
+type schemaRegistry struct {
+	// registerMu serializes the registrations, including the
+	// initialization of the new versions.
+	registerMu sync.Mutex
+
+	mu       sync.RWMutex
+	versions map[string]SchemaVersion
+}
+
+// RegisterSchema registers the schema under the name, so that the queries
+// can target the name with NamedRunner rather than the schema text. If the
+// name has another schema, it is superseded by this one with the next
+// version, and the runner and the database files of the previous version
+// are removed, unless another name still has that schema. Registering the
+// current schema of the name again changes nothing.
+//
+// The schema is initialized before it is registered, so a schema which
+// fails to initialize does not supersede the current one.
+func (s *Service) RegisterSchema(name, schema string) (SchemaVersion, error) {
+	if name == "" {
+		return SchemaVersion{}, errors.New("schema name is required")
+	}
+
+	s.schemas.registerMu.Lock()
+	defer s.schemas.registerMu.Unlock()
+
+	runner, err := s.Runner(schema)
+	if err != nil {
+		return SchemaVersion{}, err
+	}
+
+	version, previous, superseded := s.schemas.update(name, runner.SchemaHash(), schema)
+	if !superseded {
+		return version, nil
+	}
+
+	// The lookups of the names are not blocked while the queries on the
+	// previous version finish, and registerMu keeps another name from
+	// registering it meanwhile. The evicted runner is closed.
+	s.runners.Remove(previous.Hash)
+	if err := InvalidateSchema(previous.Schema); err != nil {
+		return version, fmt.Errorf("clean up version %d: %w", previous.Version, err)
+	}
+
+	return version, nil
+}
+
+// update sets the current version of the name to the schema, unless it is
+// already the current one. It returns the current version and the previous
+// one, and whether the previous one is superseded and no longer the schema
+// of any name.
+func (r *schemaRegistry) update(name, hash, schema string) (version, previous SchemaVersion, superseded bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, ok := r.versions[name]
+	if ok && previous.Hash == hash {
+		return previous, previous, false
+	}
+
+	version = SchemaVersion{
+		Name:    name,
+		Version: previous.Version + 1,
+		Hash:    hash,
+		Schema:  schema,
+	}
+	r.versions[name] = version
+
+	if !ok {
+		return version, previous, false
+	}
+	for _, other := range r.versions {
+		if other.Hash == previous.Hash {
+			return version, previous, false
+		}
+	}
+
+	return version, previous, true
+}
+
+// NamedSchema returns the current version of the schema of the name.
+func (s *Service) NamedSchema(name string) (SchemaVersion, bool) {
+	s.schemas.mu.RLock()
+	defer s.schemas.mu.RUnlock()
+
+	version, ok := s.schemas.versions[name]
+	return version, ok
+}
+
+// NamedRunner returns the runner of the current version of the schema of
+// the name, creating it if needed. It returns ErrUnknownSchemaName if no
+// schema is registered under the name.
+func (s *Service) NamedRunner(name string) (*SQLRunner, error) {
+	version, ok := s.NamedSchema(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSchemaName, name)
+	}
+
+	return s.Runner(version.Schema)
+}
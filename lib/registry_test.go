@@ -0,0 +1,156 @@
+package sqlrunner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterSchema(t *testing.T) {
+	t.Parallel()
+
+	service, err := NewService()
+	require.NoError(t, err)
+
+	schemaV1 := "CREATE TABLE registrytest (value TEXT); INSERT INTO registrytest VALUES ('v1');"
+	schemaV2 := "CREATE TABLE registrytest (value TEXT); INSERT INTO registrytest VALUES ('v2');"
+
+	_, err = service.NamedRunner("registrytest")
+	require.ErrorIs(t, err, ErrUnknownSchemaName)
+
+	v1, err := service.RegisterSchema("registrytest", schemaV1)
+	require.NoError(t, err)
+	assert.Equal(t, SchemaVersion{
+		Name:    "registrytest",
+		Version: 1,
+		Hash:    schemaHash(schemaV1, defaultSchemaOptions),
+		Schema:  schemaV1,
+	}, v1)
+
+	runner, err := service.NamedRunner("registrytest")
+	require.NoError(t, err)
+	result, err := runner.Query(context.TODO(), "SELECT value FROM registrytest")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"v1"}}, result.Rows)
+	assert.FileExists(t, schemaFilename(v1.Hash))
+
+	// Registering the same schema again is not a new version
+	again, err := service.RegisterSchema("registrytest", schemaV1)
+	require.NoError(t, err)
+	assert.Equal(t, v1, again)
+
+	v2, err := service.RegisterSchema("registrytest", schemaV2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, v2.Version)
+	assert.Equal(t, schemaHash(schemaV2, defaultSchemaOptions), v2.Hash)
+
+	runner, err = service.NamedRunner("registrytest")
+	require.NoError(t, err)
+	result, err = runner.Query(context.TODO(), "SELECT value FROM registrytest")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"v2"}}, result.Rows)
+
+	// The superseded version is cleaned up
+	assert.NoFileExists(t, schemaFilename(v1.Hash))
+	assert.FileExists(t, schemaFilename(v2.Hash))
+	_, ok := service.Schema(v1.Hash)
+	assert.False(t, ok)
+
+	current, ok := service.NamedSchema("registrytest")
+	require.True(t, ok)
+	assert.Equal(t, v2, current)
+
+	t.Run("Failed schema", func(t *testing.T) {
+		t.Parallel()
+
+		service, err := NewService()
+		require.NoError(t, err)
+
+		schema := "CREATE TABLE registryfailtest (value TEXT);"
+		v1, err := service.RegisterSchema("registryfailtest", schema)
+		require.NoError(t, err)
+
+		_, err = service.RegisterSchema("registryfailtest", "CREATE TABLE")
+		require.ErrorAs(t, err, &SchemaError{})
+
+		// The current version is kept
+		current, ok := service.NamedSchema("registryfailtest")
+		require.True(t, ok)
+		assert.Equal(t, v1, current)
+		assert.FileExists(t, schemaFilename(v1.Hash))
+	})
+
+	t.Run("Shared schema", func(t *testing.T) {
+		t.Parallel()
+
+		service, err := NewService()
+		require.NoError(t, err)
+
+		schema := "CREATE TABLE registrysharedtest (value TEXT);"
+		v1, err := service.RegisterSchema("registrysharedtest1", schema)
+		require.NoError(t, err)
+		_, err = service.RegisterSchema("registrysharedtest2", schema)
+		require.NoError(t, err)
+
+		_, err = service.RegisterSchema("registrysharedtest1", "CREATE TABLE registrysharedtest (id INT);")
+		require.NoError(t, err)
+
+		// The other name still has the previous version
+		assert.FileExists(t, schemaFilename(v1.Hash))
+	})
+}
+
+func TestRegisterSchemaDoesNotBlockLookups(t *testing.T) {
+	t.Parallel()
+
+	service, err := NewService()
+	require.NoError(t, err)
+
+	schemaV1 := "CREATE TABLE registryblocktest (value TEXT); INSERT INTO registryblocktest VALUES ('v1');"
+	schemaV2 := "CREATE TABLE registryblocktest (value TEXT); INSERT INTO registryblocktest VALUES ('v2');"
+
+	_, err = service.RegisterSchema("registryblocktest", schemaV1)
+	require.NoError(t, err)
+	runner, err := service.NamedRunner("registryblocktest")
+	require.NoError(t, err)
+
+	// The slow query on the first version holds its files until it is
+	// canceled, so the cleanup of the version waits for it.
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := runner.Query(ctx, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c")
+		slowDone <- err
+	}()
+	base := schemaHash(schemaV1, defaultSchemaOptions)
+	require.Eventually(t, func() bool {
+		schemaLocks.mu.Lock()
+		defer schemaLocks.mu.Unlock()
+		return schemaLocks.m[base] != nil
+	}, 5*time.Second, time.Millisecond)
+
+	registered := make(chan error, 1)
+	go func() {
+		_, err := service.RegisterSchema("registryblocktest", schemaV2)
+		registered <- err
+	}()
+
+	// The lookups see the new version while the cleanup waits
+	require.Eventually(t, func() bool {
+		version, ok := service.NamedSchema("registryblocktest")
+		return ok && version.Version == 2
+	}, 5*time.Second, time.Millisecond)
+	select {
+	case err := <-registered:
+		t.Fatalf("the registration finished before the query on the previous version: %v", err)
+	default:
+	}
+
+	cancel()
+	assert.Error(t, <-slowDone)
+	require.NoError(t, <-registered)
+}
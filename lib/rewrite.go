@@ -0,0 +1,144 @@
+package sqlrunner
+
+import (
+	"strings"
+)
+
+// keywordFunctions are the registered functions whose names are SQLite
+// keywords. SQLite only accepts them as function names when quoted.
+var keywordFunctions = map[string]bool{
+	"ISNULL":   true,
+	"INSERT":   true,
+	"DATABASE": true,
+}
+
+// aliasFunctions maps the upper-cased names of the MySQL functions to the
+// SQLite built-ins they are called as. They are aliased rather than
+// registered so that the JSON results keep their JSON subtype, and thus
+// are not quoted again when nested, such as in
+// JSON_ARRAYAGG(json_object('id', id)).
+var aliasFunctions = map[string]string{
+	"JSON_ARRAYAGG":  "json_group_array",
+	"JSON_OBJECTAGG": "json_group_object",
+}
+
+// functionVariants maps the upper-cased name of a function to the suffix
+// of its variant to call instead, such as CONCAT to CONCAT__NULL_STRICT.
+//
+// The variant is named by appending the suffix to the name written in the
+// query, so that the original name can be restored in the column names.
+type functionVariants map[string]string
+
+// rewriteQuery rewrites the MySQL syntax SQLite does not understand to its
+// SQLite equivalent, such as RLIKE and the ROW constructor, the calls to the aliased functions, and the calls to the
+// functions with variants.
+//
+// It returns the rewritten query and a function restoring a column name of
+// the rewritten query to the one of the original query.
+func rewriteQuery(query string, variants functionVariants) (rewritten string, restoreColumn func(string) string) {
+	tokens := tokenize(query)
+
+	var b strings.Builder
+	var replacements []string // pairs of (rewritten, original)
+	// The ROW keywords of the row value constructors by the index of their
+	// opening parenthesis
+	rowParens := make(map[int]string)
+
+	for i, t := range tokens {
+		// ROW(a, b) is the MySQL constructor of the row value (a, b). The
+		// keyword is kept in a comment to restore it in the column names.
+		if t.is("ROW") {
+			if next := nextSignificant(tokens, i); next >= 0 && tokens[next].is("(") {
+				rowParens[next] = t.text
+				continue
+			}
+		}
+		if row, ok := rowParens[i]; ok {
+			marked := "(/*" + row + "*/"
+			replacements = append(replacements, marked, row+"(")
+			b.WriteString(marked)
+			continue
+		}
+
+		// RLIKE is the MySQL synonym of the REGEXP operator
+		if t.kind == tokenIdentifier && strings.EqualFold(t.text, "RLIKE") {
+			if next := nextSignificant(tokens, i); next < 0 || !tokens[next].is("(") {
+				regexpOperator := "REGEXP"
+				if t.text == strings.ToLower(t.text) {
+					regexpOperator = "regexp"
+				}
+				replacements = append(replacements, regexpOperator, t.text)
+				b.WriteString(regexpOperator)
+				continue
+			}
+		}
+
+		if t.kind == tokenIdentifier {
+			if next := nextSignificant(tokens, i); next >= 0 && tokens[next].is("(") {
+				name := strings.ToUpper(t.text)
+
+				if suffix, ok := variants[name]; ok {
+					renamed := t.text + suffix
+					replacements = append(replacements, renamed, t.text)
+					b.WriteString(renamed)
+					continue
+				}
+
+				if alias, ok := aliasFunctions[name]; ok {
+					if t.text != strings.ToLower(t.text) {
+						alias = strings.ToUpper(alias)
+					}
+					replacements = append(replacements, alias, t.text)
+					b.WriteString(alias)
+					continue
+				}
+
+				if keywordFunctions[name] {
+					quoted := `"` + t.text + `"`
+					replacements = append(replacements, quoted, t.text)
+					b.WriteString(quoted)
+					continue
+				}
+			}
+		}
+
+		b.WriteString(t.text)
+	}
+
+	if len(replacements) == 0 {
+		return query, func(column string) string { return column }
+	}
+
+	replacer := strings.NewReplacer(replacements...)
+	return b.String(), replacer.Replace
+}
+
+// rewrite rewrites a query according to the options. See rewriteQuery.
+func (o options) rewrite(query string) (rewritten string, restoreColumn func(string) string) {
+	if !o.dateArithmetic && !o.strictDivision {
+		return rewriteQuery(query, o.functionVariants())
+	}
+
+	var dateReplacements []string
+	if o.dateArithmetic {
+		query, dateReplacements = rewriteDateArithmetic(query)
+	}
+	if o.strictDivision {
+		query = rewriteStrictDivision(query)
+	}
+	rewritten, restoreFunctions := rewriteQuery(query, o.functionVariants())
+
+	return rewritten, func(column string) string {
+		column = restoreFunctions(column)
+		if o.strictDivision {
+			column = restoreStrictDivision(column)
+		}
+
+		// A later rewrite may contain an earlier one, so undo them backwards.
+		for i := len(dateReplacements) - 2; i >= 0; i -= 2 {
+			column = strings.ReplaceAll(column, dateReplacements[i], dateReplacements[i+1])
+		}
+
+		return column
+	}
+}
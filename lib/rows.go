@@ -0,0 +1,256 @@
+package sqlrunner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Rows is a cursor over the rows of a query, returned by QueryRows.
+// The rows are read from SQLite one at a time, rather than materialized
+// into a QueryResult.
+//
+// Rows holds a connection and a concurrency slot of the runner until it is
+// closed, which happens once Next returns false, so close it explicitly if
+// the iteration stops early. Rows is not safe for concurrent use.
+type Rows struct {
+	rows        *sql.Rows
+	columns     []string
+	columnTypes []string
+	// cells are the scanners of the current row, as the arguments of sql.Rows.Scan.
+	cells []any
+
+	// release closes the database and releases the concurrency slot.
+	release func()
+	// groupConcatQueryID is the ID of the query in groupConcatTruncations.
+	groupConcatQueryID int64
+	// truncated is whether a GROUP_CONCAT value has been truncated,
+	// which is recorded when the rows are closed.
+	truncated bool
+
+	err    error
+	closed bool
+}
+
+// QueryRows executes a query and returns a cursor over its rows, such as to
+// process a large result without holding it in memory. The result is not
+// cached.
+//
+// Like Query, it returns a PolicyError if the query has a statement not
+// allowed by WithAllowedStatements, ErrMultipleStatements if it has more
+// than one statement with WithSingleStatement, and a ParameterError if the
+// values of WithParams do not match its placeholders or the query references
+// a variable not in WithVariables.
+func (r *SQLRunner) QueryRows(ctx context.Context, query string, opts ...QueryOption) (*Rows, error) {
+	ctx, span := tracer.Start(ctx, "SQLRunner.QueryRows")
+	defer span.End()
+
+	queryOpts := newQueryOptions(opts)
+	if err := queryOpts.bindVariables(query); err != nil {
+		span.SetStatus(codes.Error, "parameter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	if err := r.options.checkPolicy(query); err != nil {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	if err := r.options.checkSingleStatement(query); err != nil {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	span.AddEvent("limiter.acquire")
+	releaseSlot, err := r.options.limiter.acquire(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "limiter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	// Prevent the schema file from being invalidated until the query starts.
+	// Then the connection keeps the file open even if it is removed, so the
+	// lock is not held while the caller iterates, which may invalidate the
+	// schema.
+	unlockFiles := rlockSchemaFiles(baseSchemaHash(r.schemaHash))
+	defer unlockFiles()
+
+	span.AddEvent("sqlite.open")
+	db, releaseDB, err := r.getSqliteInstance(ctx)
+	if err != nil {
+		releaseSlot()
+
+		span.SetStatus(codes.Error, "get schema error")
+		span.RecordError(err)
+
+		return nil, fmt.Errorf("get schema: %w", err)
+	}
+
+	rows := &Rows{
+		release: func() {
+			releaseDB()
+			releaseSlot()
+		},
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			_ = rows.Close()
+		}
+	}()
+
+	if translated, ok := translateCommand(query); ok {
+		span.AddEvent("translate_command")
+		query = translated
+	}
+	query, args, restoreParams, err := bindParams(query, queryOpts.params)
+	if err != nil {
+		return nil, err
+	}
+	rewrittenQuery, restoreColumn := r.options.rewrite(query)
+	rewrittenQuery, restoreColumn, rows.groupConcatQueryID = r.options.capGroupConcat(rewrittenQuery, restoreColumn)
+
+	span.AddEvent("sqlite.query")
+	rows.rows, err = db.QueryContext(ctx, rewrittenQuery, args...)
+	if err != nil {
+		span.SetStatus(codes.Error, "query error")
+		span.RecordError(err)
+
+		return nil, NewQueryError(explainQueryError(err))
+	}
+
+	rows.columns, err = rows.rows.Columns()
+	if err != nil {
+		span.SetStatus(codes.Error, "get columns error")
+		span.RecordError(err)
+
+		return nil, fmt.Errorf("get columns: %w", err)
+	}
+	if err := r.options.checkMaxColumns(len(rows.columns)); err != nil {
+		span.SetStatus(codes.Error, "too many columns")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	for i, column := range rows.columns {
+		rows.columns[i] = restoreParams(restoreColumn(column))
+	}
+
+	colTypes, err := rows.rows.ColumnTypes()
+	if err != nil {
+		span.SetStatus(codes.Error, "get column types error")
+		span.RecordError(err)
+
+		return nil, fmt.Errorf("get column types: %w", err)
+	}
+	scanners := r.newScanners(colTypes, query, queryOpts)
+	for i, colType := range colTypes {
+		rows.columnTypes = append(rows.columnTypes, colType.DatabaseTypeName())
+		rows.cells = append(rows.cells, &scanners[i])
+	}
+
+	r.executed.Store(true)
+	ok = true
+
+	span.SetStatus(codes.Ok, "success")
+	return rows, nil
+}
+
+// Columns returns the column names of the rows.
+func (rows *Rows) Columns() []string {
+	return rows.columns
+}
+
+// ColumnTypes returns the declared types of the columns,
+// like QueryResult.ColumnTypes.
+func (rows *Rows) ColumnTypes() []string {
+	return rows.columnTypes
+}
+
+// Next reads the next row for Scan. It returns false and closes the rows
+// when there are no more rows or an error occurs; see Err for the error.
+func (rows *Rows) Next() bool {
+	if rows.closed {
+		return false
+	}
+
+	if !rows.rows.Next() {
+		if err := rows.rows.Err(); err != nil {
+			rows.err = NewQueryError(explainQueryError(err))
+		}
+		_ = rows.Close()
+		return false
+	}
+
+	if err := rows.rows.Scan(rows.cells...); err != nil {
+		rows.err = fmt.Errorf("scan: %w", err)
+		_ = rows.Close()
+		return false
+	}
+
+	return true
+}
+
+// Scan copies the cells of the current row, rendered like the cells of
+// QueryResult.Rows, into dest, which must have a string per column.
+func (rows *Rows) Scan(dest []string) error {
+	if rows.closed {
+		return errors.New("rows are closed")
+	}
+	if len(dest) != len(rows.cells) {
+		return fmt.Errorf("expected %d destinations, got %d", len(rows.cells), len(dest))
+	}
+
+	for i, cell := range rows.cells {
+		dest[i] = cell.(*StringScanner).Value()
+	}
+
+	return nil
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (rows *Rows) Err() error {
+	return rows.err
+}
+
+// Truncated reports whether a GROUP_CONCAT value of the rows read so far
+// has been truncated by WithGroupConcatMaxLen.
+func (rows *Rows) Truncated() bool {
+	if rows.closed {
+		return rows.truncated
+	}
+
+	_, truncated := groupConcatTruncations.Load(rows.groupConcatQueryID)
+	return truncated
+}
+
+// Close closes the rows, the connection, and releases the concurrency
+// slot. It is idempotent.
+func (rows *Rows) Close() error {
+	if rows.closed {
+		return nil
+	}
+	rows.closed = true
+
+	var err error
+	if rows.rows != nil {
+		if err = rows.rows.Close(); err != nil {
+			slog.Warn("close rows", slog.Any("error", err))
+		}
+	}
+	rows.release()
+	_, rows.truncated = groupConcatTruncations.LoadAndDelete(rows.groupConcatQueryID)
+
+	return err
+}
@@ -2,30 +2,103 @@ package sqlrunner
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"strconv"
 	"time"
 )
 
+// BlobEncoding is the text encoding of the BLOB values in a result.
+type BlobEncoding string
+
+const (
+	// BlobHex encodes the BLOB values in lowercase hexadecimal. It is the default.
+	BlobHex BlobEncoding = "hex"
+	// BlobBase64 encodes the BLOB values in the standard base64 with padding,
+	// which is shorter than hexadecimal for binary data such as images.
+	BlobBase64 BlobEncoding = "base64"
+)
+
+// encode encodes a BLOB value. The empty encoding means BlobHex.
+func (e BlobEncoding) encode(blob []byte) string {
+	if e == BlobBase64 {
+		return base64.StdEncoding.EncodeToString(blob)
+	}
+
+	return hex.EncodeToString(blob)
+}
+
+// BooleanFormat is the text form of the boolean values in a result.
+type BooleanFormat string
+
+const (
+	// BooleanNumeric renders the boolean values as 1 and 0, like MySQL.
+	// It is the default.
+	BooleanNumeric BooleanFormat = "numeric"
+	// BooleanKeyword renders the boolean values as TRUE and FALSE.
+	BooleanKeyword BooleanFormat = "keyword"
+)
+
+// format renders a boolean value. The empty format means BooleanNumeric.
+func (f BooleanFormat) format(b bool) string {
+	switch {
+	case f == BooleanKeyword && b:
+		return "TRUE"
+	case f == BooleanKeyword:
+		return "FALSE"
+	case b:
+		return "1"
+	default:
+		return "0"
+	}
+}
+
 type StringScanner struct {
 	value string
+
+	// blobEncoding is the encoding of the BLOB values.
+	blobEncoding BlobEncoding
+
+	// boolean renders the integers 1 and 0 in booleanFormat,
+	// for the columns of the boolean values.
+	boolean       bool
+	booleanFormat BooleanFormat
+
+	// fixedDecimals renders numbers with the given decimals.
+	fixedDecimals bool
+	decimals      int
+}
+
+// NewFixedDecimalScanner creates a StringScanner rendering numbers
+// with a fixed number of decimals.
+func NewFixedDecimalScanner(decimals int) StringScanner {
+	return StringScanner{
+		fixedDecimals: true,
+		decimals:      decimals,
+	}
 }
 
 func (s *StringScanner) Scan(value any) error {
 	switch v := value.(type) {
 	case int64:
-		s.value = strconv.FormatInt(v, 10)
+		if s.boolean && (v == 0 || v == 1) {
+			s.value = s.booleanFormat.format(v == 1)
+		} else if s.fixedDecimals {
+			s.value = strconv.FormatFloat(float64(v), 'f', s.decimals, 64)
+		} else {
+			s.value = strconv.FormatInt(v, 10)
+		}
 	case float64:
-		s.value = strconv.FormatFloat(v, 'f', -1, 64)
-	case bool:
-		if v {
-			s.value = "1"
+		if s.fixedDecimals {
+			s.value = strconv.FormatFloat(v, 'f', s.decimals, 64)
 		} else {
-			s.value = "0"
+			s.value = formatReal(v)
 		}
+	case bool:
+		s.value = s.booleanFormat.format(v)
 	case []byte:
-		s.value = hex.EncodeToString(v)
+		s.value = s.blobEncoding.encode(v)
 	case string:
 		s.value = v
 	case time.Time:
@@ -39,6 +112,13 @@ func (s *StringScanner) Scan(value any) error {
 	return nil
 }
 
+// formatReal renders a REAL value in the shortest decimal form which reads
+// back as the same value, without an exponent, such as 1145141919.81 rather
+// than 1.14514191981e+09.
+func formatReal(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
 func (s *StringScanner) Value() string {
 	return s.value
 }
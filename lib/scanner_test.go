@@ -35,6 +35,22 @@ func TestScanner(t *testing.T) {
 		assert.Equal(t, "4242424242.424242", s.Value())
 	})
 
+	t.Run("float64 fixed decimals", func(t *testing.T) {
+		t.Parallel()
+
+		s := NewFixedDecimalScanner(2)
+		require.NoError(t, s.Scan(float64(1)))
+		assert.Equal(t, "1.00", s.Value())
+	})
+
+	t.Run("int64 fixed decimals", func(t *testing.T) {
+		t.Parallel()
+
+		s := NewFixedDecimalScanner(2)
+		require.NoError(t, s.Scan(int64(42)))
+		assert.Equal(t, "42.00", s.Value())
+	})
+
 	t.Run("bool true", func(t *testing.T) {
 		t.Parallel()
 
@@ -59,6 +75,14 @@ func TestScanner(t *testing.T) {
 		assert.Equal(t, "68656c6c6f", s.Value())
 	})
 
+	t.Run("[]byte base64", func(t *testing.T) {
+		t.Parallel()
+
+		s := &StringScanner{blobEncoding: BlobBase64}
+		require.NoError(t, s.Scan([]byte("hello")))
+		assert.Equal(t, "aGVsbG8=", s.Value())
+	})
+
 	t.Run("string", func(t *testing.T) {
 		t.Parallel()
 
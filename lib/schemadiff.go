@@ -0,0 +1,253 @@
+package sqlrunner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// TableSchema is a table or a view of a schema, see DescribeSchema.
+type TableSchema struct {
+	Name    string         `json:"name"`
+	Columns []ColumnSchema `json:"columns"`
+}
+
+// ColumnSchema is a column of a table, as in its table_info.
+type ColumnSchema struct {
+	Name string `json:"name"`
+	// Type is the declared type of the column, which may be empty
+	Type       string `json:"type"`
+	NotNull    bool   `json:"not_null"`
+	PrimaryKey bool   `json:"primary_key"`
+	// Default is the SQL text of the default value, or nil if there is none
+	Default *string `json:"default"`
+}
+
+// SchemaDiff is the difference between two schemas, see Service.SchemaDiff.
+// The tables and the columns are in the order of the schemas.
+type SchemaDiff struct {
+	// AddedTables are the tables only in the second schema
+	AddedTables []string `json:"added_tables"`
+	// RemovedTables are the tables only in the first schema
+	RemovedTables []string `json:"removed_tables"`
+	// ChangedTables are the tables in both schemas whose columns differ
+	ChangedTables []TableDiff `json:"changed_tables"`
+}
+
+// TableDiff is the difference between the columns of a table in two schemas.
+type TableDiff struct {
+	Table          string         `json:"table"`
+	AddedColumns   []string       `json:"added_columns"`
+	RemovedColumns []string       `json:"removed_columns"`
+	ChangedColumns []ColumnChange `json:"changed_columns"`
+}
+
+// ColumnChange is a column in both schemas whose definition differs, such as
+// its type.
+type ColumnChange struct {
+	Column string       `json:"column"`
+	From   ColumnSchema `json:"from"`
+	To     ColumnSchema `json:"to"`
+}
+
+// describeSchemaQuery lists the columns of the tables and the views of the
+// schema, other than the internal tables of SQLite.
+const describeSchemaQuery = `SELECT m.name, p.name, p.type, p."notnull", p.pk, p.dflt_value
+FROM sqlite_schema AS m JOIN pragma_table_info(m.name) AS p
+WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite\_%' ESCAPE '\'
+ORDER BY m.name, p.cid`
+
+// DescribeSchema returns the tables and the views of the schema with their
+// columns, ordered by their names.
+func (r *SQLRunner) DescribeSchema(ctx context.Context) ([]TableSchema, error) {
+	ctx, span := tracer.Start(ctx, "SQLRunner.DescribeSchema")
+	defer span.End()
+
+	// Prevent the schema file from being invalidated during the query
+	unlockFiles := rlockSchemaFiles(baseSchemaHash(r.schemaHash))
+	defer unlockFiles()
+
+	filename, err := initializeThreadSafe(r.schema, r.options.schema(), r.options.initTimeout)
+	if err != nil {
+		span.SetStatus(codes.Error, "initialize error")
+		span.RecordError(err)
+
+		if !errors.As(err, &SchemaError{}) {
+			err = NewSchemaError(err)
+		}
+		return nil, err
+	}
+
+	db, err := readOnlyHandle(ctx, filename)
+	if err != nil {
+		span.SetStatus(codes.Error, "open error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	tables, err := describeTables(ctx, db)
+	if err != nil {
+		span.SetStatus(codes.Error, "describe error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	return tables, nil
+}
+
+// describeTables runs describeSchemaQuery and groups its columns by table.
+func describeTables(ctx context.Context, db queryer) ([]TableSchema, error) {
+	rows, err := db.QueryContext(ctx, describeSchemaQuery)
+	if err != nil {
+		return nil, fmt.Errorf("describe schema: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var tables []TableSchema
+	for rows.Next() {
+		var table string
+		var column ColumnSchema
+		var notNull, pk int
+		var defaultValue sql.NullString
+		if err := rows.Scan(&table, &column.Name, &column.Type, &notNull, &pk, &defaultValue); err != nil {
+			return nil, fmt.Errorf("scan schema: %w", err)
+		}
+		// pk is the position of the column in a composite primary key
+		column.NotNull, column.PrimaryKey = notNull != 0, pk > 0
+		if defaultValue.Valid {
+			column.Default = &defaultValue.String
+		}
+
+		if len(tables) == 0 || tables[len(tables)-1].Name != table {
+			tables = append(tables, TableSchema{Name: table})
+		}
+		tables[len(tables)-1].Columns = append(tables[len(tables)-1].Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read schema: %w", err)
+	}
+
+	return tables, nil
+}
+
+// SchemaDiff returns the tables and the columns added, removed, or changed
+// from the first schema to the second one, such as between two versions of
+// the schema of an exercise. Both schemas are initialized with the runners
+// of the service, so their runners are reused by the next queries.
+//
+// The tables and the columns are matched by their names case-insensitively,
+// like SQLite.
+func (s *Service) SchemaDiff(ctx context.Context, schemaA, schemaB string) (*SchemaDiff, error) {
+	ctx, span := tracer.Start(ctx, "Service.SchemaDiff")
+	defer span.End()
+
+	var described [2][]TableSchema
+	for i, schema := range []string{schemaA, schemaB} {
+		runner, err := s.Runner(schema)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		if described[i], err = runner.DescribeSchema(ctx); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	return diffSchemas(described[0], described[1]), nil
+}
+
+// diffSchemas compares the described tables of two schemas.
+func diffSchemas(from, to []TableSchema) *SchemaDiff {
+	diff := &SchemaDiff{
+		AddedTables:   []string{},
+		RemovedTables: []string{},
+		ChangedTables: []TableDiff{},
+	}
+
+	fromTables := make(map[string]TableSchema, len(from))
+	for _, table := range from {
+		fromTables[strings.ToLower(table.Name)] = table
+	}
+	toTables := make(map[string]bool, len(to))
+	for _, table := range to {
+		toTables[strings.ToLower(table.Name)] = true
+	}
+
+	for _, table := range from {
+		if !toTables[strings.ToLower(table.Name)] {
+			diff.RemovedTables = append(diff.RemovedTables, table.Name)
+		}
+	}
+	for _, table := range to {
+		previous, ok := fromTables[strings.ToLower(table.Name)]
+		if !ok {
+			diff.AddedTables = append(diff.AddedTables, table.Name)
+			continue
+		}
+
+		if tableDiff, changed := diffColumns(table.Name, previous.Columns, table.Columns); changed {
+			diff.ChangedTables = append(diff.ChangedTables, tableDiff)
+		}
+	}
+
+	return diff
+}
+
+// diffColumns compares the columns of a table in two schemas, and reports
+// whether any of them differs.
+func diffColumns(table string, from, to []ColumnSchema) (TableDiff, bool) {
+	diff := TableDiff{
+		Table:          table,
+		AddedColumns:   []string{},
+		RemovedColumns: []string{},
+		ChangedColumns: []ColumnChange{},
+	}
+
+	fromColumns := make(map[string]ColumnSchema, len(from))
+	for _, column := range from {
+		fromColumns[strings.ToLower(column.Name)] = column
+	}
+	toColumns := make(map[string]bool, len(to))
+	for _, column := range to {
+		toColumns[strings.ToLower(column.Name)] = true
+	}
+
+	for _, column := range from {
+		if !toColumns[strings.ToLower(column.Name)] {
+			diff.RemovedColumns = append(diff.RemovedColumns, column.Name)
+		}
+	}
+	for _, column := range to {
+		previous, ok := fromColumns[strings.ToLower(column.Name)]
+		if !ok {
+			diff.AddedColumns = append(diff.AddedColumns, column.Name)
+			continue
+		}
+
+		if !sameColumn(previous, column) {
+			diff.ChangedColumns = append(diff.ChangedColumns, ColumnChange{Column: column.Name, From: previous, To: column})
+		}
+	}
+
+	changed := len(diff.AddedColumns) > 0 || len(diff.RemovedColumns) > 0 || len(diff.ChangedColumns) > 0
+	return diff, changed
+}
+
+// sameColumn reports whether the definitions of the column in two schemas
+// are the same. The types are compared case-insensitively.
+func sameColumn(a, b ColumnSchema) bool {
+	sameDefault := (a.Default == nil) == (b.Default == nil) && (a.Default == nil || *a.Default == *b.Default)
+
+	return strings.EqualFold(a.Type, b.Type) && a.NotNull == b.NotNull && a.PrimaryKey == b.PrimaryKey && sameDefault
+}
@@ -0,0 +1,79 @@
+package sqlrunner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SelfTestResult is the result of running the example of a registered
+// function in SelfTest.
+type SelfTestResult struct {
+	// Function is the name of the function.
+	Function string `json:"function"`
+	// Query is the example query exercising the function.
+	Query string `json:"query"`
+	// Expected is the expected result of the query, and Actual the returned one.
+	Expected string `json:"expected"`
+	Actual   string `json:"actual,omitempty"`
+	// Passed reports whether the query returned the expected result.
+	Passed bool `json:"passed"`
+	// Error is the error of the query, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// SelfTest runs the example query of each function returned by
+// RegisteredFunctions on an in-memory database, and reports whether each
+// returned the expected result. It catches the regressions caused by the
+// changes of the SQLite driver or the function registrations at runtime.
+func SelfTest(ctx context.Context) ([]SelfTestResult, error) {
+	ctx, span := tracer.Start(ctx, "SelfTest")
+	defer span.End()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	results := make([]SelfTestResult, 0, len(functions))
+	for _, fn := range functions {
+		result := SelfTestResult{
+			Function: fn.name,
+			Query:    fn.example,
+			Expected: fn.expected,
+		}
+
+		actual, err := selfTestQuery(ctx, db, fn.example)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Actual = actual
+			result.Passed = actual == fn.expected
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// selfTestQuery executes a query returning a single cell and renders the
+// cell like a SQLRunner does.
+func selfTestQuery(ctx context.Context, db *sql.DB, query string) (string, error) {
+	if query == "" {
+		return "", errors.New("no example query")
+	}
+
+	rewrittenQuery, _ := rewriteQuery(query, nil)
+
+	var cell StringScanner
+	if err := db.QueryRowContext(ctx, rewrittenQuery).Scan(&cell); err != nil {
+		return "", err
+	}
+
+	return cell.Value(), nil
+}
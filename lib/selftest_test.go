@@ -0,0 +1,23 @@
+package sqlrunner_test
+
+import (
+	"context"
+	"testing"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfTest(t *testing.T) {
+	t.Parallel()
+
+	results, err := sqlrunner.SelfTest(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, results, len(sqlrunner.RegisteredFunctions()))
+
+	for _, result := range results {
+		assert.True(t, result.Passed, "function %s: expected %q, got %q (error: %s)",
+			result.Function, result.Expected, result.Actual, result.Error)
+	}
+}
@@ -0,0 +1,42 @@
+package sqlrunner
+
+import "sync/atomic"
+
+// ServerInfo is what the MySQL informational functions, VERSION(),
+// DATABASE(), and CURRENT_USER(), return, so that the queries pasted from
+// MySQL which call them run instead of failing.
+type ServerInfo struct {
+	// Version is returned by VERSION().
+	Version string
+	// Database is returned by DATABASE(), the logical database name.
+	Database string
+	// User is returned by CURRENT_USER().
+	User string
+}
+
+// DefaultServerInfo is a MySQL-like version string, the SQLite name of
+// the schema database, and a placeholder user.
+var DefaultServerInfo = ServerInfo{
+	Version:  "8.0.36-sqlrunner",
+	Database: "main",
+	User:     "playground@localhost",
+}
+
+var serverInfo atomic.Pointer[ServerInfo]
+
+func init() {
+	SetServerInfo(DefaultServerInfo)
+}
+
+// SetServerInfo sets what the informational functions return. The functions
+// are registered to SQLite process-wide, so the information is process-wide.
+//
+// The cached results are not invalidated, so set it before executing any query.
+func SetServerInfo(info ServerInfo) {
+	serverInfo.Store(&info)
+}
+
+// currentServerInfo returns the information set by SetServerInfo.
+func currentServerInfo() ServerInfo {
+	return *serverInfo.Load()
+}
@@ -0,0 +1,177 @@
+package sqlrunner
+
+import (
+	"context"
+	"fmt"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultRunnerCapacity is the default number of runners a Service keeps.
+const defaultRunnerCapacity = 128
+
+// ServiceOption configures a Service.
+type ServiceOption func(*serviceOptions)
+
+// serviceOptions is the configuration of a Service.
+type serviceOptions struct {
+	// runnerCapacity is the maximum number of runners to keep.
+	runnerCapacity int
+	// runnerOptions are the options of the runners created by the service.
+	runnerOptions []Option
+	// maxConcurrentQueries is the maximum number of the concurrently
+	// executing queries. Zero means unlimited.
+	maxConcurrentQueries int
+	// queueSize is the maximum number of the queries waiting for
+	// another query to finish when maxConcurrentQueries is reached.
+	queueSize int
+	// limitObserver is notified of the events of the concurrency limit.
+	limitObserver func(LimitEvent)
+}
+
+// WithRunnerCapacity sets the maximum number of runners a Service keeps.
+// The least recently used runner is evicted and closed when the capacity
+// is exceeded.
+func WithRunnerCapacity(capacity int) ServiceOption {
+	return func(o *serviceOptions) {
+		o.runnerCapacity = capacity
+	}
+}
+
+// WithRunnerOptions sets the options of the runners created by a Service.
+func WithRunnerOptions(opts ...Option) ServiceOption {
+	return func(o *serviceOptions) {
+		o.runnerOptions = append(o.runnerOptions, opts...)
+	}
+}
+
+// WithConcurrencyLimit limits the number of the queries executing
+// concurrently on the runners of a Service, so that a burst of queries does
+// not open too many SQLite connections at once. Beyond the limit, up to
+// queueSize queries wait for another query to finish, and the others are
+// rejected with ErrTooManyQueries. The cached results are not limited.
+func WithConcurrencyLimit(limit, queueSize int) ServiceOption {
+	return func(o *serviceOptions) {
+		o.maxConcurrentQueries = limit
+		o.queueSize = queueSize
+	}
+}
+
+// WithLimitObserver sets the function notified when a query is queued,
+// rejected, or gets a slot by the concurrency limit, such as to count them
+// in the metrics.
+// It is called synchronously, so it must not block.
+func WithLimitObserver(observe func(LimitEvent)) ServiceOption {
+	return func(o *serviceOptions) {
+		o.limitObserver = observe
+	}
+}
+
+// Service executes queries on arbitrary schemas. It keeps a runner per
+// schema, so the schema initialization and the query cache are shared
+// by the queries on the same schema.
+type Service struct {
+	options serviceOptions
+
+	sfgroup singleflight.Group
+	// runners is keyed by the schema hash.
+	runners *lru.Cache[string, *SQLRunner]
+	// schemas is the registry of the named schemas.
+	schemas schemaRegistry
+}
+
+// NewService creates a Service.
+func NewService(opts ...ServiceOption) (*Service, error) {
+	o := serviceOptions{
+		runnerCapacity: defaultRunnerCapacity,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.maxConcurrentQueries > 0 {
+		limiter := newQueryLimiter(o.maxConcurrentQueries, o.queueSize, o.limitObserver)
+		o.runnerOptions = append(o.runnerOptions, func(ro *options) {
+			ro.limiter = limiter
+		})
+	}
+
+	runners, err := lru.NewWithEvict(o.runnerCapacity, func(_ string, runner *SQLRunner) {
+		_ = runner.Close()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create runner cache: %w", err)
+	}
+
+	return &Service{
+		options: o,
+		runners: runners,
+		schemas: schemaRegistry{versions: make(map[string]SchemaVersion)},
+	}, nil
+}
+
+// Runner returns the runner of the schema, creating it if needed.
+// Concurrent calls with the same schema share the same runner.
+func (s *Service) Runner(schema string) (*SQLRunner, error) {
+	hash := schemaHash(schema, newOptions(s.options.runnerOptions).schema())
+
+	if runner, ok := s.runners.Get(hash); ok {
+		return runner, nil
+	}
+
+	result, err, _ := s.sfgroup.Do(hash, func() (any, error) {
+		// The runner may have been created by a call finished just now.
+		if runner, ok := s.runners.Get(hash); ok {
+			return runner, nil
+		}
+
+		runner, err := NewSQLRunner(schema, s.options.runnerOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("create SQLRunner: %w", err)
+		}
+
+		s.runners.Add(hash, runner)
+		return runner, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*SQLRunner), nil
+}
+
+// Schema returns the schema of the hash, which is the one returned by
+// SQLRunner.SchemaHash, if the service still keeps its runner.
+func (s *Service) Schema(hash string) (string, bool) {
+	runner, ok := s.runners.Peek(hash)
+	if !ok {
+		return "", false
+	}
+
+	return runner.schema, true
+}
+
+// Flush closes the runners of the service, dropping their cached results,
+// and removes the database files and the disk caches of all the schemas
+// with FlushSchemas, such as to reset the state for troubleshooting. The
+// named schemas are kept, and are initialized again on the next query.
+func (s *Service) Flush() error {
+	s.runners.Purge()
+
+	return FlushSchemas()
+}
+
+// ExecuteQuery executes a query on the schema and returns the result.
+func (s *Service) ExecuteQuery(ctx context.Context, schema, query string, opts ...QueryOption) (*QueryResult, error) {
+	ctx, span := tracer.Start(ctx, "Service.ExecuteQuery")
+	defer span.End()
+
+	runner, err := s.Runner(schema)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return runner.Query(ctx, query, opts...)
+}
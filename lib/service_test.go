@@ -0,0 +1,146 @@
+package sqlrunner_test
+
+import (
+	"context"
+	"testing"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceExecuteQuery(t *testing.T) {
+	t.Parallel()
+
+	service, err := sqlrunner.NewService()
+	require.NoError(t, err)
+
+	result, err := service.ExecuteQuery(context.TODO(), `
+		CREATE TABLE servicetest (value TEXT);
+		INSERT INTO servicetest (value) VALUES ('hello');
+	`, "SELECT value FROM servicetest")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+
+	_, err = service.ExecuteQuery(context.TODO(), "CREATE TABLE", "SELECT 1")
+	var schemaError sqlrunner.SchemaError
+	assert.ErrorAs(t, err, &schemaError)
+}
+
+func TestServiceReusesRunner(t *testing.T) {
+	t.Parallel()
+
+	service, err := sqlrunner.NewService()
+	require.NoError(t, err)
+
+	schema := "CREATE TABLE servicereusetest (value TEXT);"
+
+	runner1, err := service.Runner(schema)
+	require.NoError(t, err)
+	runner2, err := service.Runner(schema)
+	require.NoError(t, err)
+	assert.Same(t, runner1, runner2)
+
+	other, err := service.Runner("CREATE TABLE servicereusetest2 (value TEXT);")
+	require.NoError(t, err)
+	assert.NotSame(t, runner1, other)
+}
+
+func TestServiceEvictsRunner(t *testing.T) {
+	t.Parallel()
+
+	service, err := sqlrunner.NewService(sqlrunner.WithRunnerCapacity(1))
+	require.NoError(t, err)
+
+	schema1 := "CREATE TABLE serviceevicttest1 (value TEXT);"
+	schema2 := "CREATE TABLE serviceevicttest2 (value TEXT);"
+
+	runner1, err := service.Runner(schema1)
+	require.NoError(t, err)
+
+	_, err = service.Runner(schema2)
+	require.NoError(t, err)
+
+	// The runner of schema1 has been evicted by the runner of schema2.
+	runner1Again, err := service.Runner(schema1)
+	require.NoError(t, err)
+	assert.NotSame(t, runner1, runner1Again)
+}
+
+func TestServiceSchema(t *testing.T) {
+	t.Parallel()
+
+	service, err := sqlrunner.NewService(sqlrunner.WithRunnerCapacity(1))
+	require.NoError(t, err)
+
+	schema := "CREATE TABLE serviceschematest1 (value TEXT);"
+	runner, err := service.Runner(schema)
+	require.NoError(t, err)
+
+	got, ok := service.Schema(runner.SchemaHash())
+	require.True(t, ok)
+	assert.Equal(t, schema, got)
+
+	// The schema is forgotten once its runner is evicted.
+	_, err = service.Runner("CREATE TABLE serviceschematest2 (value TEXT);")
+	require.NoError(t, err)
+
+	_, ok = service.Schema(runner.SchemaHash())
+	assert.False(t, ok)
+}
+
+func TestServiceSchemaDiff(t *testing.T) {
+	t.Parallel()
+
+	service, err := sqlrunner.NewService()
+	require.NoError(t, err)
+
+	const v1 = `
+		CREATE TABLE schemadiffusers (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE schemadifflogs (message TEXT);
+	`
+
+	t.Run("AddColumn", func(t *testing.T) {
+		t.Parallel()
+
+		v2 := `
+			CREATE TABLE schemadiffusers (id INTEGER PRIMARY KEY, name TEXT, email TEXT NOT NULL DEFAULT '');
+			CREATE TABLE schemadifflogs (message TEXT);
+		`
+
+		diff, err := service.SchemaDiff(context.TODO(), v1, v2)
+		require.NoError(t, err)
+		assert.Empty(t, diff.AddedTables)
+		assert.Empty(t, diff.RemovedTables)
+		require.Len(t, diff.ChangedTables, 1)
+		assert.Equal(t, "schemadiffusers", diff.ChangedTables[0].Table)
+		assert.Equal(t, []string{"email"}, diff.ChangedTables[0].AddedColumns)
+		assert.Empty(t, diff.ChangedTables[0].RemovedColumns)
+		assert.Empty(t, diff.ChangedTables[0].ChangedColumns)
+	})
+
+	t.Run("DropTable", func(t *testing.T) {
+		t.Parallel()
+
+		v2 := "CREATE TABLE schemadiffusers (id INTEGER PRIMARY KEY, name VARCHAR(20));"
+
+		diff, err := service.SchemaDiff(context.TODO(), v1, v2)
+		require.NoError(t, err)
+		assert.Empty(t, diff.AddedTables)
+		assert.Equal(t, []string{"schemadifflogs"}, diff.RemovedTables)
+		require.Len(t, diff.ChangedTables, 1)
+		require.Len(t, diff.ChangedTables[0].ChangedColumns, 1)
+
+		change := diff.ChangedTables[0].ChangedColumns[0]
+		assert.Equal(t, "name", change.Column)
+		assert.Equal(t, "TEXT", change.From.Type)
+		assert.Equal(t, "VARCHAR(20)", change.To.Type)
+	})
+
+	t.Run("InvalidSchema", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := service.SchemaDiff(context.TODO(), v1, "CREATE TABLE")
+		assert.ErrorAs(t, err, &sqlrunner.SchemaError{})
+	})
+}
@@ -6,179 +6,435 @@ import (
 	"context"
 	"crypto/sha1"
 	"database/sql"
-	"database/sql/driver"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/singleflight"
 	"modernc.org/sqlite"
-	_ "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 var sf = &singleflight.Group{}
 
-func init() {
-	// MySQL-compatible functions
-	sqlite.MustRegisterFunction("YEAR", &sqlite.FunctionImpl{
-		NArgs:         1,
-		Deterministic: true,
-		Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
-			d, err := parseSqliteDate(args[0])
-			if err != nil {
-				return nil, fmt.Errorf("parse date: %w", err)
-			}
-
-			return int64(d.Year()), nil
-		},
-	})
+// schemaFailureCooldown is the duration to remember a schema failed to initialize.
+const schemaFailureCooldown = 30 * time.Second
 
-	sqlite.MustRegisterFunction("MONTH", &sqlite.FunctionImpl{
-		NArgs:         1,
-		Deterministic: true,
-		Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
-			d, err := parseSqliteDate(args[0])
-			if err != nil {
-				return nil, fmt.Errorf("parse date: %w", err)
-			}
+// failedSchemas is the negative cache of the schemas failed to initialize,
+// keyed by the schema hash.
+var failedSchemas = expirable.NewLRU[string, error](1024, nil, schemaFailureCooldown)
 
-			return int64(d.Month()), nil
-		},
-	})
+// verifiedSchemaFiles are the schema database files the process built or
+// checked the integrity of, which are reused without checking them again.
+// They are forgotten when InvalidateSchema or FlushSchemas removes them.
+var verifiedSchemaFiles = struct {
+	mu sync.Mutex
+	m  map[string]bool
+}{
+	m: make(map[string]bool),
+}
 
-	sqlite.MustRegisterFunction("DAY", &sqlite.FunctionImpl{
-		NArgs:         1,
-		Deterministic: true,
-		Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
-			d, err := parseSqliteDate(args[0])
-			if err != nil {
-				return nil, fmt.Errorf("parse date: %w", err)
-			}
+// schemaFileVerified reports whether the schema database file is verified.
+func schemaFileVerified(filename string) bool {
+	verifiedSchemaFiles.mu.Lock()
+	defer verifiedSchemaFiles.mu.Unlock()
 
-			return int64(d.Day()), nil
-		},
-	})
+	return verifiedSchemaFiles.m[filename]
+}
 
-	sqlite.MustRegisterFunction("LEFT", &sqlite.FunctionImpl{
-		NArgs:         2,
-		Deterministic: true,
-		Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
-			str, ok := args[0].(string)
-			if !ok {
-				return nil, fmt.Errorf("invalid argument type: %T", args[0])
-			}
+// setSchemaFileVerified records whether the schema database file is verified.
+func setSchemaFileVerified(filename string, verified bool) {
+	verifiedSchemaFiles.mu.Lock()
+	defer verifiedSchemaFiles.mu.Unlock()
 
-			length, ok := args[1].(int64)
-			if !ok {
-				return nil, fmt.Errorf("invalid argument type: %T", args[1])
-			}
+	if verified {
+		verifiedSchemaFiles.m[filename] = true
+	} else {
+		delete(verifiedSchemaFiles.m, filename)
+	}
+}
 
-			if length < 0 {
-				return nil, fmt.Errorf("negative length: %d", length)
-			}
+const tmpDir = "/tmp/sqlrunner"
 
-			if int(length) > len(str) {
-				return str, nil
-			}
+// mmapSize is the maximum number of bytes of a schema database to memory-map.
+const mmapSize = 64 << 20
 
-			return str[:length], nil
-		},
-	})
+// defaultCacheSize is the number of the results a runner caches in memory
+// unless WithCacheSize is set.
+const defaultCacheSize = 100
 
-	sqlite.MustRegisterFunction("IF", &sqlite.FunctionImpl{
-		NArgs:         3,
-		Deterministic: true,
-		Scalar: func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
-			condition, ok := args[0].(bool)
-			if !ok {
-				conditionInt64, ok := args[0].(int64)
-				if !ok {
-					return nil, fmt.Errorf("invalid argument type: %T", args[0])
-				}
-
-				condition = conditionInt64 != 0
-			}
+// defaultMaxResponseBytes is the maximum estimated size of a result
+// serialized as JSON without WithMaxResponseBytes.
+const defaultMaxResponseBytes = 64 << 20
 
-			if condition {
-				return args[1], nil
-			}
+type SQLRunner struct {
+	schema     string
+	schemaHash string
+	options    options
 
-			return args[2], nil
-		},
-	})
+	cache *lru.Cache[string, cachedResult]
+	// diskCache is nil unless WithDiskCache is set.
+	diskCache *diskCache
+	// generation is the schema generation the cache entries belong to.
+	generation atomic.Uint64
+	// closed reports whether the runner has been closed.
+	closed atomic.Bool
+	// executed reports whether the runner has executed any query.
+	executed atomic.Bool
 }
 
-const tmpDir = "/tmp/sqlrunner"
-
-type SQLRunner struct {
-	schema string
-
-	cache *lru.Cache[string, *QueryResult]
+// cachedResult is an entry of the query cache of a SQLRunner.
+type cachedResult struct {
+	result *QueryResult
+	// cachedAt is when the result has been cached, to expire it.
+	cachedAt time.Time
 }
 
-func NewSQLRunner(schema string) (*SQLRunner, error) {
-	_ = os.MkdirAll(tmpDir, 0o755)
+func NewSQLRunner(schema string, opts ...Option) (*SQLRunner, error) {
+	if err := makeDir(tmpDir); err != nil {
+		return nil, fmt.Errorf("create tmp directory: %w", err)
+	}
 
-	cache, err := lru.New[string, *QueryResult](100)
+	options := newOptions(opts)
+	cache, err := lru.New[string, cachedResult](options.cacheSize)
 	if err != nil {
 		return nil, fmt.Errorf("create lru cache: %w", err)
 	}
 
 	runner := &SQLRunner{
-		schema: schema,
-		cache:  cache,
+		schema:     schema,
+		schemaHash: schemaHash(schema, options.schema()),
+		options:    options,
+		cache:      cache,
+	}
+	runner.generation.Store(schemaGeneration(baseSchemaHash(runner.schemaHash)))
+	if options.diskCacheMaxBytes > 0 {
+		runner.diskCache = &diskCache{
+			dir:      diskCacheDirname(runner.schemaHash),
+			maxBytes: options.diskCacheMaxBytes,
+			ttl:      options.diskCacheTTL,
+		}
 	}
 
 	// Initialize the SQLite instance early to
 	// make sure the schema is valid.
-	_, err = runner.getSqliteInstance()
+	unlockFiles := rlockSchemaFiles(baseSchemaHash(runner.schemaHash))
+	_, err = initializeThreadSafe(runner.schema, options.schema(), options.initTimeout)
+	unlockFiles()
 	if err != nil {
+		if !errors.As(err, &SchemaError{}) {
+			err = NewSchemaError(err)
+		}
 		return nil, fmt.Errorf("initialize sqlite: %w", err)
 	}
 
 	return runner, nil
 }
 
-// Query executes a query and returns the result.
-func (r *SQLRunner) Query(ctx context.Context, query string) (*QueryResult, error) {
-	_, span := tracer.Start(ctx, "SQLRunner.Query")
+// SchemaHash returns the hash identifying the schema of the runner and
+// the options it is initialized with. It is the hex-encoded SHA-1 hash of
+// the schema with the default options.
+func (r *SQLRunner) SchemaHash() string {
+	return r.schemaHash
+}
+
+// Query executes a query and returns the result. A query without
+// statements, such as an empty query, returns an empty result.
+//
+// It returns a PolicyError if the query has a statement
+// not allowed by WithAllowedStatements or calls a function disabled by
+// WithDisabledFunctions, ErrMultipleStatements if it has
+// more than one statement with WithSingleStatement, and a ParameterError if
+// the values of WithParams do not match its placeholders or the query
+// references a variable not in WithVariables.
+func (r *SQLRunner) Query(ctx context.Context, query string, opts ...QueryOption) (*QueryResult, error) {
+	ctx, span := tracer.Start(ctx, "SQLRunner.Query")
 	defer span.End()
 
+	queryOpts := newQueryOptions(opts)
+	if err := queryOpts.bindVariables(query); err != nil {
+		span.SetStatus(codes.Error, "parameter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	cacheKey := queryOpts.cacheKey(query)
+
+	if err := r.options.checkPolicy(query); err != nil {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	if err := r.options.checkSingleStatement(query); err != nil {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	// Drop the cached results if the schema has been invalidated
+	if generation := schemaGeneration(baseSchemaHash(r.schemaHash)); r.generation.Swap(generation) != generation {
+		span.AddEvent("cache.purge")
+		r.cache.Purge()
+	}
+
 	span.AddEvent("cache.get")
 	// Check the cache first
-	if result, ok := r.cache.Get(query); ok {
+	if result, ok := r.getCachedResult(cacheKey); ok {
 		span.SetStatus(codes.Ok, "cache hit")
+
+		// The cached result is shared, so return a copy with the status.
+		cachedResult := *result
+		cachedResult.CacheStatus = CacheHit
+		cachedResult.FromCache = true
+		return &cachedResult, nil
+	}
+
+	// Then the disk cache, which survives the restarts
+	diskCacheKey := r.options.resultKey() + "\x00" + cacheKey
+	if result, ok := r.diskCache.get(diskCacheKey); ok {
+		span.AddEvent("disk_cache.hit")
+		span.SetStatus(codes.Ok, "disk cache hit")
+
+		result.CacheStatus = CacheDisk
+		result.FromCache = true
+		if !r.closed.Load() {
+			r.addCachedResult(cacheKey, result)
+		}
 		return result, nil
 	}
 
+	span.AddEvent("limiter.acquire")
+	releaseSlot, err := r.options.limiter.acquire(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "limiter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	defer releaseSlot()
+
+	// Prevent the schema file from being invalidated during the query
+	unlockFiles := rlockSchemaFiles(baseSchemaHash(r.schemaHash))
+	defer unlockFiles()
+
 	span.AddEvent("sqlite.open")
-	db, err := r.getSqliteInstance()
+	db, release, err := r.getSqliteInstance(ctx)
 	if err != nil {
 		span.SetStatus(codes.Error, "get schema error")
 		span.RecordError(err)
 
 		return nil, fmt.Errorf("get schema: %w", err)
 	}
+	defer release()
+
+	var queryResult *QueryResult
+	if isModification(query) && !returnsRows(query) {
+		span.AddEvent("sqlite.exec")
+		queryResult, err = r.exec(ctx, db, query, queryOpts)
+	} else {
+		span.AddEvent("sqlite.query")
+		queryResult, err = r.query(ctx, db, query, queryOpts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	queryResult.CacheStatus = r.executionStatus()
+	if queryResult.Partial {
+		span.SetStatus(codes.Ok, "partial")
+		return queryResult, nil
+	}
+
+	// Add the result to the cache, unless the runner has been closed
+	if !r.closed.Load() {
+		span.AddEvent("cache.set")
+		r.addCachedResult(cacheKey, queryResult)
+	}
+	if err := r.diskCache.add(diskCacheKey, queryResult); err != nil {
+		slog.WarnContext(ctx, "add to disk cache", slog.Any("error", err))
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	return queryResult, nil
+}
+
+// QueryMulti executes a script of statements separated by semicolons and
+// returns a result for each statement. The statements producing rows, such
+// as SELECT, return their rows; the others return the number of rows they
+// affected, which is only possible in the writable mode.
+//
+// The statements are executed in order on the same connection, and the
+// execution stops at the first failing statement. The results are not cached.
+// The named parameters of WithParams and the variables of WithVariables are
+// not supported.
+func (r *SQLRunner) QueryMulti(ctx context.Context, script string, opts ...QueryOption) ([]*QueryResult, error) {
+	ctx, span := tracer.Start(ctx, "SQLRunner.QueryMulti")
+	defer span.End()
+
+	queryOpts := newQueryOptions(opts)
+
+	if err := r.options.checkPolicy(script); err != nil {
+		span.SetStatus(codes.Error, "policy error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	if queryOpts.params != nil || queryOpts.variables != nil {
+		return nil, NewParameterError(errors.New("the named parameters and the variables are not supported with multiple statements"))
+	}
+
+	span.AddEvent("limiter.acquire")
+	releaseSlot, err := r.options.limiter.acquire(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "limiter error")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	defer releaseSlot()
+
+	// Prevent the schema file from being invalidated during the query
+	unlockFiles := rlockSchemaFiles(baseSchemaHash(r.schemaHash))
+	defer unlockFiles()
+
+	span.AddEvent("sqlite.open")
+	db, release, err := r.getSqliteInstance(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "get schema error")
+		span.RecordError(err)
+
+		return nil, fmt.Errorf("get schema: %w", err)
+	}
+	defer release()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "get connection error")
+		span.RecordError(err)
+
+		return nil, fmt.Errorf("get connection: %w", err)
+	}
 	defer func() {
-		if err := db.Close(); err != nil {
-			slog.WarnContext(ctx, "close schema database", slog.Any("error", err))
+		if err := conn.Close(); err != nil {
+			slog.WarnContext(ctx, "close connection", slog.Any("error", err))
 		}
 	}()
 
-	span.AddEvent("sqlite.query")
-	result, err := db.QueryContext(ctx, query)
+	statements := SplitStatements(script)
+	results := make([]*QueryResult, 0, len(statements))
+	for i, statement := range statements {
+		var result *QueryResult
+		if returnsRows(statement) {
+			span.AddEvent("sqlite.query")
+			result, err = r.query(ctx, conn, statement, queryOpts)
+		} else {
+			span.AddEvent("sqlite.exec")
+			result, err = r.exec(ctx, conn, statement, queryOpts)
+		}
+
+		var queryError QueryError
+		if errors.As(err, &queryError) {
+			return nil, NewQueryError(fmt.Errorf("statement %d: %w", i+1, queryError.Parent))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+
+		results = append(results, result)
+	}
+
+	status := r.executionStatus()
+	for _, result := range results {
+		result.CacheStatus = status
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	return results, nil
+}
+
+// executionStatus returns the cache status of a query executed
+// by the runner, and marks the runner as executed.
+func (r *SQLRunner) executionStatus() CacheStatus {
+	if !r.executed.Swap(true) {
+		return CacheCold
+	}
+
+	return CacheWarm
+}
+
+// queryer is a database or a connection to run queries on.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// query executes a query and constructs its result from the returned rows.
+func (r *SQLRunner) query(ctx context.Context, db queryer, query string, queryOpts queryOptions) (*QueryResult, error) {
+	span := trace.SpanFromContext(ctx)
+	statementType := classifyStatement(query)
+
+	if translated, ok := translateCommand(query); ok {
+		span.AddEvent("translate_command")
+		query = translated
+	}
+
+	query, args, restoreParams, err := bindParams(query, queryOpts.params)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrittenQuery, restoreColumn := r.options.rewrite(query)
+
+	rewrittenQuery, restoreColumn, groupConcatQueryID := r.options.capGroupConcat(rewrittenQuery, restoreColumn)
+	defer groupConcatTruncations.Delete(groupConcatQueryID)
+
+	var cartesianTables []string
+	if r.options.cartesianWarning && !isModification(query) {
+		span.AddEvent("analyze_cartesian_product")
+		if cartesianTables, err = cartesianProductTables(ctx, db, rewrittenQuery, args...); err != nil {
+			// The query itself reports the error, if any
+			slog.DebugContext(ctx, "analyze cartesian product", slog.Any("error", err))
+		}
+	}
+
+	var indexWarnings []string
+	if r.options.indexWarning && !isModification(query) {
+		span.AddEvent("analyze_index_usage")
+		if indexWarnings, err = indexUsageWarnings(ctx, db, rewrittenQuery, args...); err != nil {
+			// The query itself reports the error, if any
+			slog.DebugContext(ctx, "analyze index usage", slog.Any("error", err))
+		}
+	}
+
+	var sources []columnSource
+	if r.options.columnMetadata && statementType == StatementSelect {
+		span.AddEvent("analyze_column_sources")
+		if sources, err = columnSources(ctx, db, query); err != nil {
+			slog.DebugContext(ctx, "analyze column sources", slog.Any("error", err))
+		}
+	}
+
+	result, err := db.QueryContext(ctx, rewrittenQuery, args...)
 	if err != nil {
 		span.SetStatus(codes.Error, "query error")
 		span.RecordError(err)
 
-		return nil, NewQueryError(err)
+		return nil, NewQueryError(explainQueryError(err))
 	}
 	defer func() {
 		if err := result.Close(); err != nil {
@@ -194,15 +450,44 @@ func (r *SQLRunner) Query(ctx context.Context, query string) (*QueryResult, erro
 
 		return nil, fmt.Errorf("get columns: %w", err)
 	}
+	if err := r.options.checkMaxColumns(len(cols)); err != nil {
+		span.SetStatus(codes.Error, "too many columns")
+		span.RecordError(err)
+
+		return nil, err
+	}
+	for i, col := range cols {
+		cols[i] = restoreParams(restoreColumn(col))
+	}
+
+	colTypes, err := result.ColumnTypes()
+	if err != nil {
+		span.SetStatus(codes.Error, "get column types error")
+		span.RecordError(err)
+
+		return nil, fmt.Errorf("get column types: %w", err)
+	}
+	scanners := r.newScanners(colTypes, query, queryOpts)
 
 	rows := [][]string{}
+	responseBytes := 0
+	for _, col := range cols {
+		responseBytes += estimatedJSONBytes(col)
+	}
+	partial := false
 	for result.Next() {
 		rawCells := make([]any, 0, len(cols))
-		for range cols {
-			rawCells = append(rawCells, &StringScanner{})
+		for i := range cols {
+			cell := scanners[i]
+			rawCells = append(rawCells, &cell)
 		}
 
 		if err := result.Scan(rawCells...); err != nil {
+			if queryOpts.partial && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				partial = true
+				break
+			}
+
 			span.SetStatus(codes.Error, "scan error")
 			span.RecordError(err)
 
@@ -212,50 +497,405 @@ func (r *SQLRunner) Query(ctx context.Context, query string) (*QueryResult, erro
 		row := make([]string, 0, len(cols))
 		for _, cell := range rawCells {
 			row = append(row, cell.(*StringScanner).Value())
+			responseBytes += estimatedJSONBytes(row[len(row)-1])
+		}
+		// The brackets of the row and its comma
+		responseBytes += 3
+		if err := r.options.checkMaxResponseBytes(responseBytes); err != nil {
+			span.SetStatus(codes.Error, "result too large")
+			span.RecordError(err)
+
+			return nil, err
 		}
 
 		rows = append(rows, row)
 	}
+	if err := result.Err(); err != nil {
+		if queryOpts.partial && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			partial = true
+		} else {
+			span.SetStatus(codes.Error, "query error")
+			span.RecordError(err)
+
+			return nil, NewQueryError(explainQueryError(err))
+		}
+	}
+	if partial {
+		span.AddEvent("partial_result")
+	}
+
+	declTypes := make([]string, 0, len(colTypes))
+	for _, colType := range colTypes {
+		declTypes = append(declTypes, colType.DatabaseTypeName())
+	}
 
 	queryResult := &QueryResult{
-		Columns: cols,
-		Rows:    rows,
+		Columns:       cols,
+		ColumnTypes:   declTypes,
+		Rows:          rows,
+		StatementType: statementType,
+		Partial:       partial,
+	}
+	if r.options.columnMetadata && statementType == StatementSelect {
+		queryResult.ColumnMetadata = resolveColumnMetadata(query, cols, sources)
 	}
 
-	// Add the result to the cache
-	span.AddEvent("cache.set")
-	r.cache.Add(query, queryResult)
+	// RETURNING returns a row for each modified row
+	if hasReturning(tokenize(query)) {
+		queryResult.RowsAffected = int64(len(rows))
+	} else if len(rows) > 1 && !hasTopLevelOrderBy(query) {
+		if r.options.orderCheck == OrderCheckError {
+			span.SetStatus(codes.Error, "unordered rows")
+			return nil, NewQueryError(ErrUnorderedRows)
+		}
+		if r.options.stableOrdering {
+			span.AddEvent("sort_rows")
+			slices.SortStableFunc(rows, compareRows)
+		}
+		if r.options.orderCheck == OrderCheckWarn {
+			queryResult.Warnings = append(queryResult.Warnings, warnUnorderedRows)
+		}
+	}
+	if cartesianTables != nil {
+		queryResult.Warnings = append(queryResult.Warnings, warnCartesianProduct(cartesianTables))
+	}
+	queryResult.Warnings = append(queryResult.Warnings, indexWarnings...)
+	if r.options.emptyResultHints && statementType == StatementSelect && len(rows) == 0 && !partial {
+		span.AddEvent("explain_empty_result")
+		if hint, err := emptyResultHint(ctx, db, query); err != nil {
+			slog.DebugContext(ctx, "explain empty result", slog.Any("error", err))
+		} else if hint != "" {
+			queryResult.Warnings = append(queryResult.Warnings, hint)
+		}
+	}
+	if _, truncated := groupConcatTruncations.Load(groupConcatQueryID); truncated {
+		queryResult.Warnings = append(queryResult.Warnings, warnGroupConcatTruncated(r.options.groupConcatMaxLen))
+	}
 
-	span.SetStatus(codes.Ok, "success")
 	return queryResult, nil
 }
 
+// checkMaxColumns returns a QueryError if a result has more columns
+// than WithMaxColumns allows.
+func (o options) checkMaxColumns(n int) error {
+	if o.maxColumns > 0 && n > o.maxColumns {
+		return NewQueryError(fmt.Errorf("%w: %d columns, exceeding the limit of %d", ErrTooManyColumns, n, o.maxColumns))
+	}
+	return nil
+}
+
+// checkMaxResponseBytes returns a QueryError if the estimated size of a
+// result exceeds WithMaxResponseBytes.
+func (o options) checkMaxResponseBytes(n int) error {
+	if o.maxResponseBytes > 0 && n > o.maxResponseBytes {
+		return NewQueryError(fmt.Errorf("%w: exceeding the limit of %d bytes; add a LIMIT to the query to return fewer rows", ErrResultTooLarge, o.maxResponseBytes))
+	}
+	return nil
+}
+
+// estimatedJSONBytes estimates the size of a value in the JSON of a result,
+// as a quoted string followed by a comma.
+func estimatedJSONBytes(value string) int {
+	return len(value) + 3
+}
+
+// exec executes a statement which does not return rows,
+// and reports the number of rows it affected.
+func (r *SQLRunner) exec(ctx context.Context, db queryer, statement string, queryOpts queryOptions) (*QueryResult, error) {
+	span := trace.SpanFromContext(ctx)
+
+	if table, ok := truncatedTable(statement); ok {
+		if !r.options.writable {
+			span.SetStatus(codes.Error, "read-only error")
+			return nil, NewQueryError(ErrReadOnly)
+		}
+
+		span.AddEvent("translate_truncate")
+		return truncate(ctx, db, table)
+	}
+
+	statement, args, _, err := bindParams(statement, queryOpts.params)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrittenStatement, _ := r.options.rewrite(statement)
+	result, err := db.ExecContext(ctx, rewrittenStatement, args...)
+	if err != nil {
+		span.SetStatus(codes.Error, "exec error")
+		span.RecordError(err)
+
+		return nil, NewQueryError(explainQueryError(err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.SetStatus(codes.Error, "get rows affected error")
+		span.RecordError(err)
+
+		return nil, fmt.Errorf("get rows affected: %w", err)
+	}
+
+	return &QueryResult{
+		Columns:       []string{},
+		ColumnTypes:   []string{},
+		Rows:          [][]string{},
+		RowsAffected:  rowsAffected,
+		StatementType: classifyStatement(statement),
+	}, nil
+}
+
+// truncate deletes the rows of the table for the MySQL TRUNCATE TABLE
+// command, and resets its AUTOINCREMENT counter like MySQL does, if any.
+func truncate(ctx context.Context, db queryer, table string) (*QueryResult, error) {
+	span := trace.SpanFromContext(ctx)
+
+	result, err := db.ExecContext(ctx, "DELETE FROM "+quoteIdentifier(table))
+	if err != nil {
+		span.SetStatus(codes.Error, "exec error")
+		span.RecordError(err)
+
+		return nil, NewQueryError(explainQueryError(err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.SetStatus(codes.Error, "get rows affected error")
+		span.RecordError(err)
+
+		return nil, fmt.Errorf("get rows affected: %w", err)
+	}
+
+	// sqlite_sequence only exists once a table with AUTOINCREMENT is created
+	if _, err := db.ExecContext(ctx, "DELETE FROM sqlite_sequence WHERE name = ?", table); err != nil &&
+		!strings.Contains(err.Error(), "no such table") {
+		span.SetStatus(codes.Error, "exec error")
+		span.RecordError(err)
+
+		return nil, NewQueryError(explainQueryError(err))
+	}
+
+	return &QueryResult{
+		Columns:       []string{},
+		ColumnTypes:   []string{},
+		Rows:          [][]string{},
+		RowsAffected:  rowsAffected,
+		StatementType: StatementDelete,
+	}, nil
+}
+
+// getCachedResult returns the cached result of the key, unless it has
+// expired. An expired result is removed.
+func (r *SQLRunner) getCachedResult(key string) (*QueryResult, bool) {
+	entry, ok := r.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	if r.options.cacheTTL > 0 && r.options.now().Sub(entry.cachedAt) > r.options.cacheTTL {
+		r.cache.Remove(key)
+		return nil, false
+	}
+
+	return entry.result, true
+}
+
+// addCachedResult adds the result of the key to the cache.
+func (r *SQLRunner) addCachedResult(key string, result *QueryResult) {
+	// The callback of the LRU is called for the invalidated and expired
+	// results too, so the evicted key is peeked beforehand. It may be
+	// another one if a result is added concurrently, which only matters
+	// to the log.
+	oldest, _, ok := r.cache.GetOldest()
+	if !r.cache.Add(key, cachedResult{result: result, cachedAt: r.options.now()}) || !ok {
+		return
+	}
+
+	slog.Debug("evict cached result",
+		slog.String("schema_hash", r.schemaHash),
+		slog.String("query_hash", fmt.Sprintf("%x", sha1.Sum([]byte(oldest)))))
+	if r.options.evictionObserver != nil {
+		r.options.evictionObserver()
+	}
+}
+
+// Close drops the cached results and stops caching new ones.
+// Queries in progress are not affected, and the runner can still execute
+// queries after Close, but without the cache. Close is idempotent.
+func (r *SQLRunner) Close() error {
+	r.closed.Store(true)
+	r.cache.Purge()
+
+	return nil
+}
+
+// newScanners creates the template scanner of each column of the query.
+func (r *SQLRunner) newScanners(colTypes []*sql.ColumnType, query string, queryOpts queryOptions) []StringScanner {
+	var booleans []bool
+	if r.options.booleanFormat != BooleanNumeric {
+		booleans = booleanColumns(query, len(colTypes))
+	}
+
+	scanners := make([]StringScanner, len(colTypes))
+	for i, colType := range colTypes {
+		if r.options.realDecimals >= 0 && hasRealAffinity(colType.DatabaseTypeName()) {
+			scanners[i] = NewFixedDecimalScanner(r.options.realDecimals)
+		}
+		scanners[i].blobEncoding = queryOpts.blobEncoding
+		scanners[i].booleanFormat = r.options.booleanFormat
+		scanners[i].boolean = strings.Contains(strings.ToUpper(colType.DatabaseTypeName()), "BOOL") ||
+			(booleans != nil && booleans[i])
+	}
+
+	return scanners
+}
+
+// hasRealAffinity reports whether the declared column type has the REAL
+// affinity, according to https://www.sqlite.org/datatype3.html#determination_of_column_affinity.
+func hasRealAffinity(declType string) bool {
+	declType = strings.ToUpper(declType)
+	if strings.Contains(declType, "INT") ||
+		strings.Contains(declType, "CHAR") || strings.Contains(declType, "CLOB") || strings.Contains(declType, "TEXT") ||
+		strings.Contains(declType, "BLOB") {
+		return false
+	}
+
+	return strings.Contains(declType, "REAL") || strings.Contains(declType, "FLOA") || strings.Contains(declType, "DOUB")
+}
+
 // getSqliteInstance gets the initialized SQLite instance.
 //
-// You should close the database after using it.
-func (r *SQLRunner) getSqliteInstance() (*sql.DB, error) {
-	filename, err := initializeThreadSafe(r.schema)
+// You should call release after using the database, which closes it
+// and removes its private copy in the writable mode. Otherwise, the
+// database is the read-only handle shared by the queries on the schema.
+func (r *SQLRunner) getSqliteInstance(ctx context.Context) (db *sql.DB, release func(), err error) {
+	_, span := tracer.Start(ctx, "SQLRunner.getSqliteInstance")
+	defer span.End()
+
+	filename, err := initializeThreadSafe(r.schema, r.options.schema(), r.options.initTimeout)
 	if errors.As(err, &SchemaError{}) {
-		return nil, err
+		span.SetStatus(codes.Error, "schema error")
+		span.RecordError(err)
+
+		return nil, nil, err
 	}
 	if err != nil {
-		return nil, NewSchemaError(err)
+		span.SetStatus(codes.Error, "initialize error")
+		span.RecordError(err)
+
+		return nil, nil, NewSchemaError(err)
 	}
 
-	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", filename))
+	if r.options.writable {
+		return openWritableCopy(ctx, filename, r.options.foreignKeys)
+	}
+
+	db, err = readOnlyHandle(ctx, filename)
 	if err != nil {
-		return nil, fmt.Errorf("open schema database (r/o): %w", err)
+		span.SetStatus(codes.Error, "open error")
+		span.RecordError(err)
+
+		return nil, nil, err
 	}
 
-	return db, nil
+	span.SetStatus(codes.Ok, "success")
+	return db, func() {}, nil
+}
+
+// openWritableCopy opens a private copy of the schema database file,
+// so that the writes are discarded once the copy is released. The foreign
+// keys are a setting of each connection, so they are enabled on the
+// connections of the copy rather than by the schema database.
+func openWritableCopy(ctx context.Context, filename string, foreignKeys bool) (db *sql.DB, release func(), err error) {
+	schemaFileOpens.Add(1)
+	src, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open schema database: %w", err)
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	dst, err := createTempFile(tmpDir, filepath.Base(filename)+".*.rw")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create writable copy: %w", err)
+	}
+	copyFilename := dst.Name()
+	removeCopy := func() {
+		for _, name := range []string{copyFilename, copyFilename + "-journal"} {
+			if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				slog.WarnContext(ctx, "remove writable copy", slog.Any("error", err))
+			}
+		}
+	}
+
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeCopy()
+		return nil, nil, fmt.Errorf("copy schema database: %w", err)
+	}
+
+	dsn := copyFilename
+	if foreignKeys {
+		dsn = fmt.Sprintf("file:%s?_pragma=foreign_keys(1)", copyFilename)
+	}
+	db, err = sql.Open("sqlite", dsn)
+	if err != nil {
+		removeCopy()
+		return nil, nil, fmt.Errorf("open schema database (r/w): %w", err)
+	}
+
+	return db, func() {
+		if err := db.Close(); err != nil {
+			slog.WarnContext(ctx, "close schema database", slog.Any("error", err))
+		}
+		removeCopy()
+	}, nil
+}
+
+// readOnlyDSN returns the DSN to open the schema database file for queries.
+//
+// Besides the read-only mode, query_only rejects writes even to the temporary
+// tables, and mmap_size lets SQLite read the pages from the page cache of the OS.
+func readOnlyDSN(filename string) string {
+	return fmt.Sprintf("file:%s?mode=ro&_pragma=query_only(1)&_pragma=mmap_size(%d)", filename, mmapSize)
 }
 
 // initializeThreadSafe creates a new SQLite database and sets up the schema.
 // It is thread safe which ensures that the schema is only initialized once.
-func initializeThreadSafe(schema string) (filename string, err error) {
-	filenameAny, err, _ := sf.Do(schema, func() (interface{}, error) {
-		return initialize(schema)
+//
+// The file of a shared read-only handle is returned as is, without checking
+// it again. Schemas failed to initialize are remembered for schemaFailureCooldown,
+// during which their SchemaError is returned without initializing them again.
+// An initialization taking longer than the timeout, if positive, is aborted
+// and fails with ErrSchemaTimeout, since the schema would be as slow again.
+func initializeThreadSafe(schema string, so schemaOptions, timeout time.Duration) (filename string, err error) {
+	hash := schemaHash(schema, so)
+	if filename := schemaFilename(hash); hasReadOnlyHandle(filename) {
+		return filename, nil
+	}
+	if err, ok := failedSchemas.Get(hash); ok {
+		return "", err
+	}
+
+	filenameAny, err, _ := sf.Do(hash, func() (interface{}, error) {
+		// The initialization is shared by the callers, so it is not
+		// canceled by the context of any of them.
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		return initialize(ctx, schema, so)
 	})
+	if errors.As(err, &SchemaError{}) {
+		failedSchemas.Add(hash, err)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -264,42 +904,266 @@ func initializeThreadSafe(schema string) (filename string, err error) {
 }
 
 // initialize creates a new SQLite database and sets up the schema.
-func initialize(schema string) (filename string, err error) {
-	schemaHash := sha1.Sum([]byte(schema))
-	schemaHashStr := hex.EncodeToString(schemaHash[:])
-	schemaFilename := filepath.Join(tmpDir, schemaHashStr+".db")
+//
+// It is safe to be called from multiple processes sharing the same
+// tmpDir: the schema is only built by the process holding the lock file.
+func initialize(ctx context.Context, schema string, so schemaOptions) (filename string, err error) {
+	schemaFilename := schemaFilename(schemaHash(schema, so))
+
+	// If the file already exists and is intact, return it
+	if ok, err := reuseSchemaFile(schemaFilename, false); ok || err != nil {
+		return schemaFilename, err
+	}
+
+	unlock, err := acquireFileLock(schemaLockFilename(schemaHash(schema, so)))
+	if err != nil {
+		return "", fmt.Errorf("lock schema: %w", err)
+	}
+	defer unlock()
+
+	// Another process may have built the file while we were waiting
+	if ok, err := reuseSchemaFile(schemaFilename, true); ok || err != nil {
+		return schemaFilename, err
+	}
+
+	if err := buildSchemaFileWithRetry(ctx, schema, so, schemaFilename, initBusyRetry); err != nil {
+		return "", err
+	}
+	setSchemaFileVerified(schemaFilename, true)
+
+	return schemaFilename, nil
+}
+
+// busyRetry configures how the schema initialization handles SQLITE_BUSY,
+// which a schema attaching other databases may hit on a slow filesystem.
+type busyRetry struct {
+	// timeout is the busy timeout of the initialization connection.
+	timeout time.Duration
+	// attempts is the maximum number of attempts to build the schema.
+	attempts int
+	// interval is the duration to wait between the attempts.
+	interval time.Duration
+}
+
+var initBusyRetry = busyRetry{
+	timeout:  5 * time.Second,
+	attempts: 3,
+	interval: 100 * time.Millisecond,
+}
+
+// isBusy reports whether the error is SQLITE_BUSY.
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_BUSY
+}
+
+// buildSchemaFileWithRetry builds the database of the schema into filename,
+// starting over when it hits SQLITE_BUSY. A persistent busy error is not a
+// SchemaError, since the schema is valid and the next initialization may
+// succeed, so it is not remembered in failedSchemas.
+func buildSchemaFileWithRetry(ctx context.Context, schema string, so schemaOptions, filename string, retry busyRetry) error {
+	for attempt := 1; ; attempt++ {
+		err := buildSchemaFile(ctx, schema, so, filename, retry.timeout)
+		if !isBusy(err) {
+			return err
+		}
+
+		if attempt >= retry.attempts {
+			return fmt.Errorf("database is still busy after %d attempts: %w", attempt, err)
+		}
+
+		slog.Warn("schema database is busy; retrying",
+			slog.String("filename", filename),
+			slog.Int("attempt", attempt),
+			slog.Any("error", err))
+		time.Sleep(retry.interval)
+	}
+}
 
-	// If the file already exists, return it
-	if _, err := os.Stat(schemaFilename); err == nil {
-		return schemaFilename, nil
+// buildSchemaFile builds the database of the schema in a temporary file
+// and renames it to filename. Nothing is left behind if it fails.
+func buildSchemaFile(ctx context.Context, schema string, so schemaOptions, filename string, busyTimeout time.Duration) error {
+	tmpFile, err := createTempFile(tmpDir, filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	tmpFilename := tmpFile.Name()
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
 	}
 
-	drv, err := sql.Open("sqlite", schemaFilename+".tmp")
+	drv, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)", tmpFilename, busyTimeout.Milliseconds()))
 	if err != nil {
-		return "", fmt.Errorf("open sqlite: %w", err)
+		return fmt.Errorf("open sqlite: %w", err)
 	}
 	defer func() {
 		if err := drv.Close(); err != nil {
 			slog.Warn("close sqlite", slog.Any("error", err))
 		}
 
-		_ = os.Remove(schemaFilename + ".tmp")
+		_ = os.Remove(tmpFilename)
 	}()
 
-	if _, err := drv.Exec("PRAGMA foreign_keys = ON;"); err != nil {
-		return "", fmt.Errorf("enable foreign keys: %w", err)
+	if so.foreignKeys {
+		if _, err := drv.Exec("PRAGMA foreign_keys = ON;"); err != nil {
+			return fmt.Errorf("enable foreign keys: %w", err)
+		}
+	}
+
+	// The file is discarded if the initialization fails,
+	// so the journal is not needed.
+	if _, err := drv.Exec("PRAGMA journal_mode = OFF; PRAGMA synchronous = OFF;"); err != nil {
+		return fmt.Errorf("disable journal: %w", err)
+	}
+
+	if so.collation != "" {
+		schema = applyDefaultCollation(schema, so.collation)
+	}
+
+	if _, err := drv.ExecContext(ctx, schema); err != nil {
+		if ctx.Err() != nil {
+			return NewSchemaError(fmt.Errorf("%w: %w", ErrSchemaTimeout, ctx.Err()))
+		}
+		// Busy errors are returned as is to be retried
+		if isBusy(err) {
+			return err
+		}
+		return NewSchemaError(err)
 	}
 
-	if _, err := drv.Exec(schema); err != nil {
-		return "", NewSchemaError(err)
+	// The database is read-only from now on, so gather the statistics
+	// for the query planner once. Note that it creates the sqlite_stat1 table.
+	if _, err := drv.ExecContext(ctx, "ANALYZE;"); err != nil {
+		if ctx.Err() != nil {
+			return NewSchemaError(fmt.Errorf("%w: %w", ErrSchemaTimeout, ctx.Err()))
+		}
+		return fmt.Errorf("analyze schema: %w", err)
 	}
 
 	// Rename the file to the final name
-	if err := os.Rename(schemaFilename+".tmp", schemaFilename); err != nil {
-		return "", fmt.Errorf("persistent schema: %w", err)
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		return fmt.Errorf("persistent schema: %w", err)
 	}
 
-	return schemaFilename, nil
+	return nil
+}
+
+// reuseSchemaFile reports whether the existing schema database file can be
+// reused. Its integrity is only checked the first time the process reuses
+// it, since the check reads the whole file.
+//
+// A corrupted file is removed so that it can be rebuilt, but only if the
+// caller holds its lock file, since another process may be building it. A
+// failure to check the file other than a corruption, such as too many open
+// files, is returned, and the file is kept.
+func reuseSchemaFile(filename string, locked bool) (bool, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return false, nil
+	}
+	if schemaFileVerified(filename) {
+		return true, nil
+	}
+
+	err := checkIntegrity(filename)
+	if err == nil {
+		setSchemaFileVerified(filename, true)
+		return true, nil
+	}
+	if !errors.Is(err, errSchemaCorrupted) {
+		return false, fmt.Errorf("check schema database: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+
+	slog.Warn("schema database is corrupted; rebuilding",
+		slog.String("filename", filename),
+		slog.Any("error", err))
+	if err := os.Remove(filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("remove corrupted schema: %w", err)
+	}
+
+	return false, nil
+}
+
+// errSchemaCorrupted is wrapped in the error of checkIntegrity if the file
+// is corrupted, rather than failed to be checked.
+var errSchemaCorrupted = errors.New("schema database is corrupted")
+
+// isCorrupt reports whether the error is SQLITE_CORRUPT or SQLITE_NOTADB.
+func isCorrupt(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_CORRUPT || code == sqlite3.SQLITE_NOTADB
+}
+
+// checkIntegrity checks if the database file is a valid SQLite database.
+// The error wraps errSchemaCorrupted if it is not.
+func checkIntegrity(filename string) error {
+	schemaFileOpens.Add(1)
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", filename))
+	if err != nil {
+		return fmt.Errorf("open sqlite: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			slog.Warn("close sqlite", slog.Any("error", err))
+		}
+	}()
+
+	var result string
+	if err := db.QueryRow("PRAGMA quick_check;").Scan(&result); err != nil {
+		if isCorrupt(err) {
+			return fmt.Errorf("quick check: %w: %w", errSchemaCorrupted, err)
+		}
+		return fmt.Errorf("quick check: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("quick check: %w: %s", errSchemaCorrupted, result)
+	}
+
+	return nil
+}
+
+// schemaHash returns the hash identifying the schema initialized with the options.
+//
+// The hash of the default options is the hash of the schema alone, and the
+// hash of the other options is suffixed with the hash of the options, so the
+// hashes of a schema share the prefix. See baseSchemaHash.
+func schemaHash(schema string, so schemaOptions) string {
+	hash := sha1.Sum([]byte(schema))
+	base := hex.EncodeToString(hash[:])
+	if so == defaultSchemaOptions {
+		return base
+	}
+
+	optionsHash := sha1.Sum([]byte(so.key()))
+	return base + "-" + hex.EncodeToString(optionsHash[:4])
+}
+
+// baseSchemaHash returns the hash of the schema alone from a hash
+// returned by schemaHash.
+func baseSchemaHash(hash string) string {
+	base, _, _ := strings.Cut(hash, "-")
+	return base
+}
+
+// schemaFilename returns the path of the database file of the schema hash.
+func schemaFilename(schemaHash string) string {
+	return filepath.Join(tmpDir, schemaHash+".db")
+}
+
+// schemaLockFilename returns the lock file taken to build the schema.
+//
+// The lock files are never removed, so the schemas share a fixed set of
+// 256 of them, chosen by the first byte of the hash, instead of leaving a
+// lock file behind for every schema ever built.
+func schemaLockFilename(schemaHash string) string {
+	return filepath.Join(tmpDir, "schema-"+schemaHash[:2]+".lock")
 }
 
 // SQLiteTimestampFormats is timestamp formats understood by both this module
@@ -350,3 +1214,10 @@ func parseSqliteDate(d any) (*time.Time, error) {
 
 	return &t, nil
 }
+
+// formatSqliteDate formats a time with the first of SQLiteTimestampFormats.
+// The UTC offset is omitted for UTC times, which parseSqliteDate assumes
+// when a date has no offset.
+func formatSqliteDate(t time.Time) string {
+	return strings.TrimSuffix(t.Format(SQLiteTimestampFormats[0]), "+00:00")
+}
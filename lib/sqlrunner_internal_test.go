@@ -0,0 +1,524 @@
+package sqlrunner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitializeCorruptedDatabase(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE corruptedtest (
+			value TEXT
+		);
+
+		INSERT INTO corruptedtest (value) VALUES ('hello');
+	`
+
+	filename, err := initializeThreadSafe(schema, defaultSchemaOptions, 0)
+	require.NoError(t, err)
+
+	stat, err := os.Stat(filename)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(filename, stat.Size()/2))
+	require.ErrorIs(t, checkIntegrity(filename), errSchemaCorrupted)
+
+	// The file is checked the first time another process reuses it
+	setSchemaFileVerified(filename, false)
+	filename, err = initializeThreadSafe(schema, defaultSchemaOptions, 0)
+	require.NoError(t, err)
+	require.NoError(t, checkIntegrity(filename))
+
+	runner, err := NewSQLRunner(schema)
+	require.NoError(t, err)
+
+	result, err := runner.Query(context.TODO(), "SELECT value FROM corruptedtest")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+}
+
+func TestInitializeUncheckedDatabase(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE uncheckedtest (
+			value TEXT
+		);
+	`
+	filename := schemaFilename(schemaHash(schema, defaultSchemaOptions))
+	require.NoError(t, os.RemoveAll(filename))
+	t.Cleanup(func() { _ = os.RemoveAll(filename) })
+
+	// A file failing to be opened, rather than corrupted, is kept
+	require.NoError(t, os.Mkdir(filename, 0o700))
+	err := checkIntegrity(filename)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errSchemaCorrupted)
+
+	_, err = initialize(context.TODO(), schema, defaultSchemaOptions)
+	require.Error(t, err)
+	assert.NotErrorAs(t, err, &SchemaError{})
+	assert.DirExists(t, filename)
+}
+
+func TestInitializeConcurrently(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE concurrenttest (
+			value TEXT
+		);
+
+		INSERT INTO concurrenttest (value) VALUES ('hello');
+	`
+
+	// Call initialize() directly to bypass the in-process singleflight,
+	// simulating the processes sharing the same directory.
+	var wg sync.WaitGroup
+	filenames := make([]string, 8)
+	errs := make([]error, 8)
+	for i := range filenames {
+		wg.Go(func() {
+			filenames[i], errs[i] = initialize(context.TODO(), schema, defaultSchemaOptions)
+		})
+	}
+	wg.Wait()
+
+	for i := range filenames {
+		require.NoError(t, errs[i])
+		assert.Equal(t, filenames[0], filenames[i])
+	}
+	require.NoError(t, checkIntegrity(filenames[0]))
+
+	// The lock is released, while the shared lock file is kept and no
+	// lock file is left for the schema itself
+	lockFilename := schemaLockFilename(schemaHash(schema, defaultSchemaOptions))
+	unlock, err := acquireFileLock(lockFilename)
+	require.NoError(t, err)
+	unlock()
+	assert.FileExists(t, lockFilename)
+	assert.NoFileExists(t, filenames[0]+".lock")
+}
+
+func TestInitializeWaitsForLock(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE locktest (
+			value TEXT
+		);
+	`
+	filename := schemaFilename(schemaHash(schema, defaultSchemaOptions))
+	require.NoError(t, os.RemoveAll(filename))
+
+	// Pretend another process is initializing the schema.
+	unlock, err := acquireFileLock(schemaLockFilename(schemaHash(schema, defaultSchemaOptions)))
+	require.NoError(t, err)
+	unlock = sync.OnceFunc(unlock)
+	t.Cleanup(unlock)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := initialize(context.TODO(), schema, defaultSchemaOptions)
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("initialize should wait for the lock to be released")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	unlock()
+	require.NoError(t, <-done)
+	require.NoError(t, checkIntegrity(filename))
+}
+
+func TestInitializeLeftoverLockFile(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE leftoverlocktest (
+			value TEXT
+		);
+	`
+	filename := schemaFilename(schemaHash(schema, defaultSchemaOptions))
+	require.NoError(t, os.RemoveAll(filename))
+
+	// The lock file of a crashed process is not locked anymore
+	require.NoError(t, os.WriteFile(schemaLockFilename(schemaHash(schema, defaultSchemaOptions)), nil, 0o600))
+
+	_, err := initialize(context.TODO(), schema, defaultSchemaOptions)
+	require.NoError(t, err)
+	require.NoError(t, checkIntegrity(filename))
+}
+
+func TestInitializeFailedSchemaCache(t *testing.T) {
+	t.Parallel()
+
+	badSchema := `
+		CREATE TABLE failedtest (
+			value TEXT
+		);
+
+		INSERT INTO f:)
+	`
+
+	_, err := initializeThreadSafe(badSchema, defaultSchemaOptions, 0)
+	require.ErrorAs(t, err, &SchemaError{})
+	assert.True(t, failedSchemas.Contains(schemaHash(badSchema, defaultSchemaOptions)))
+
+	// The cached error is returned without executing the schema again.
+	_, cachedErr := initializeThreadSafe(badSchema, defaultSchemaOptions, 0)
+	assert.True(t, err == cachedErr) //nolint:errorlint // compare the error instance
+
+	// A corrected schema has a different hash and is not affected.
+	goodSchema := `
+		CREATE TABLE failedtest (
+			value TEXT
+		);
+	`
+	_, err = initializeThreadSafe(goodSchema, defaultSchemaOptions, 0)
+	require.NoError(t, err)
+
+	// Invalidating the schema forgets the failure.
+	require.NoError(t, InvalidateSchema(badSchema))
+	assert.False(t, failedSchemas.Contains(schemaHash(badSchema, defaultSchemaOptions)))
+}
+
+func TestServiceClosesEvictedRunner(t *testing.T) {
+	t.Parallel()
+
+	service, err := NewService(WithRunnerCapacity(2))
+	require.NoError(t, err)
+
+	schemas := []string{
+		"CREATE TABLE closeevicttest1 (value TEXT);",
+		"CREATE TABLE closeevicttest2 (value TEXT);",
+		"CREATE TABLE closeevicttest3 (value TEXT);",
+	}
+
+	runner1, err := service.Runner(schemas[0])
+	require.NoError(t, err)
+	_, err = runner1.Query(context.TODO(), "SELECT 1")
+	require.NoError(t, err)
+
+	runner2, err := service.Runner(schemas[1])
+	require.NoError(t, err)
+
+	// Use runner1 so that runner2 becomes the least recently used one.
+	_, err = service.Runner(schemas[0])
+	require.NoError(t, err)
+
+	_, err = service.Runner(schemas[2])
+	require.NoError(t, err)
+
+	assert.True(t, runner2.closed.Load())
+	assert.False(t, runner1.closed.Load())
+	assert.Equal(t, 1, runner1.cache.Len())
+}
+
+func TestServiceConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+
+	// slowQuery runs until its context is canceled.
+	const slowQuery = "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c"
+
+	for name, tc := range map[string]struct {
+		queueSize int
+		expected  LimitEvent
+	}{
+		"Queued":   {queueSize: 1, expected: LimitQueued},
+		"Rejected": {queueSize: 0, expected: LimitRejected},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			var events []LimitEvent
+			service, err := NewService(
+				WithConcurrencyLimit(1, tc.queueSize),
+				WithLimitObserver(func(event LimitEvent) {
+					mu.Lock()
+					defer mu.Unlock()
+					events = append(events, event)
+				}),
+			)
+			require.NoError(t, err)
+
+			runner, err := service.Runner("CREATE TABLE concurrencylimittest (value TEXT);")
+			require.NoError(t, err)
+			limiter := runner.options.limiter
+
+			slowCtx, cancelSlow := context.WithCancel(context.TODO())
+			slowDone := make(chan error, 1)
+			go func() {
+				_, err := runner.Query(slowCtx, slowQuery)
+				slowDone <- err
+			}()
+			require.Eventually(t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(events) == 1
+			}, 5*time.Second, time.Millisecond)
+			assert.Equal(t, []LimitEvent{LimitAcquired}, events)
+			assert.Len(t, limiter.slots, 1)
+
+			queryDone := make(chan error, 1)
+			go func() {
+				_, err := runner.Query(context.TODO(), "SELECT 1")
+				queryDone <- err
+			}()
+			require.Eventually(t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(events) == 2
+			}, 5*time.Second, time.Millisecond)
+			assert.Equal(t, []LimitEvent{LimitAcquired, tc.expected}, events)
+
+			if tc.expected == LimitRejected {
+				assert.ErrorIs(t, <-queryDone, ErrTooManyQueries)
+			} else {
+				select {
+				case err := <-queryDone:
+					t.Fatalf("the queued query finished before the slow query: %v", err)
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+
+			cancelSlow()
+			assert.Error(t, <-slowDone)
+
+			if tc.expected == LimitQueued {
+				assert.NoError(t, <-queryDone)
+
+				mu.Lock()
+				assert.Equal(t, []LimitEvent{LimitAcquired, LimitQueued, LimitAcquired}, events)
+				mu.Unlock()
+			}
+			assert.Empty(t, limiter.slots)
+		})
+	}
+}
+
+func TestCloseRunner(t *testing.T) {
+	t.Parallel()
+
+	runner, err := NewSQLRunner("CREATE TABLE closetest (value TEXT); INSERT INTO closetest VALUES ('hello');")
+	require.NoError(t, err)
+
+	_, err = runner.Query(context.TODO(), "SELECT value FROM closetest")
+	require.NoError(t, err)
+	assert.Equal(t, 1, runner.cache.Len())
+
+	require.NoError(t, runner.Close())
+	require.NoError(t, runner.Close())
+	assert.Equal(t, 0, runner.cache.Len())
+
+	// A closed runner can still execute queries, without caching them.
+	result, err := runner.Query(context.TODO(), "SELECT value FROM closetest")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+	assert.Equal(t, 0, runner.cache.Len())
+}
+
+func TestCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	runner, err := NewSQLRunner("CREATE TABLE cachettltest (value TEXT); INSERT INTO cachettltest VALUES ('hello');",
+		WithCacheTTL(time.Minute),
+		func(o *options) {
+			o.now = func() time.Time { return now }
+		})
+	require.NoError(t, err)
+
+	query := "SELECT value FROM cachettltest"
+	result, err := runner.Query(context.TODO(), query)
+	require.NoError(t, err)
+	assert.Equal(t, CacheCold, result.CacheStatus)
+
+	now = now.Add(time.Minute)
+	result, err = runner.Query(context.TODO(), query)
+	require.NoError(t, err)
+	assert.Equal(t, CacheHit, result.CacheStatus)
+
+	// The result has expired, so the query is executed again
+	now = now.Add(time.Second)
+	result, err = runner.Query(context.TODO(), query)
+	require.NoError(t, err)
+	assert.Equal(t, CacheWarm, result.CacheStatus)
+	assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+
+	result, err = runner.Query(context.TODO(), query)
+	require.NoError(t, err)
+	assert.Equal(t, CacheHit, result.CacheStatus)
+}
+
+func TestCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	var evictions atomic.Int64
+	runner, err := NewSQLRunner("CREATE TABLE cacheevictiontest (value TEXT); INSERT INTO cacheevictiontest VALUES ('hello');",
+		WithCacheSize(1),
+		WithEvictionObserver(func() {
+			evictions.Add(1)
+		}))
+	require.NoError(t, err)
+
+	_, err = runner.Query(context.TODO(), "SELECT value FROM cacheevictiontest")
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, evictions.Load())
+
+	_, err = runner.Query(context.TODO(), "SELECT upper(value) FROM cacheevictiontest")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, evictions.Load())
+
+	// Neither a cache hit nor closing the runner evicts a result
+	result, err := runner.Query(context.TODO(), "SELECT upper(value) FROM cacheevictiontest")
+	require.NoError(t, err)
+	assert.Equal(t, CacheHit, result.CacheStatus)
+	require.NoError(t, runner.Close())
+	assert.EqualValues(t, 1, evictions.Load())
+}
+
+func TestBuildSchemaFileRetriesBusy(t *testing.T) {
+	t.Parallel()
+
+	// The schema reads a database another connection holds an exclusive lock of.
+	otherFilename := filepath.Join(t.TempDir(), "other.db")
+	other, err := sql.Open("sqlite", otherFilename)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = other.Close() })
+
+	_, err = other.Exec("CREATE TABLE busytest (value TEXT); INSERT INTO busytest VALUES ('hello');")
+	require.NoError(t, err)
+
+	lock := func(t *testing.T) (unlock func()) {
+		conn, err := other.Conn(context.TODO())
+		require.NoError(t, err)
+		_, err = conn.ExecContext(context.TODO(), "BEGIN EXCLUSIVE;")
+		require.NoError(t, err)
+
+		unlock = sync.OnceFunc(func() {
+			_, _ = conn.ExecContext(context.TODO(), "ROLLBACK;")
+			_ = conn.Close()
+		})
+		t.Cleanup(unlock)
+		return unlock
+	}
+
+	schema := fmt.Sprintf(`
+		ATTACH DATABASE '%s' AS other;
+		CREATE TABLE busytest AS SELECT value FROM other.busytest;
+	`, otherFilename)
+	retry := busyRetry{
+		timeout:  10 * time.Millisecond,
+		attempts: 3,
+		interval: 10 * time.Millisecond,
+	}
+
+	t.Run("Persistent", func(t *testing.T) {
+		filename := filepath.Join(tmpDir, "busytest-persistent.db")
+		t.Cleanup(func() { _ = os.Remove(filename) })
+
+		lock(t)
+
+		err := buildSchemaFileWithRetry(context.TODO(), schema, defaultSchemaOptions, filename, retry)
+		require.ErrorContains(t, err, "busy after 3 attempts")
+		assert.NotErrorAs(t, err, &SchemaError{})
+		assert.NoFileExists(t, filename)
+	})
+
+	t.Run("Released", func(t *testing.T) {
+		filename := filepath.Join(tmpDir, "busytest-released.db")
+		t.Cleanup(func() { _ = os.Remove(filename) })
+
+		unlock := lock(t)
+		time.AfterFunc(50*time.Millisecond, unlock)
+
+		retry := retry
+		retry.attempts = 100
+		require.NoError(t, buildSchemaFileWithRetry(context.TODO(), schema, defaultSchemaOptions, filename, retry))
+		assert.FileExists(t, filename)
+	})
+}
+
+// TestInitializeBusyNotRemembered is not parallel since it shortens the
+// process-wide initBusyRetry.
+func TestInitializeBusyNotRemembered(t *testing.T) {
+	retry := initBusyRetry
+	initBusyRetry = busyRetry{timeout: 10 * time.Millisecond, attempts: 2, interval: 10 * time.Millisecond}
+	defer func() { initBusyRetry = retry }()
+
+	otherFilename := filepath.Join(t.TempDir(), "other.db")
+	other, err := sql.Open("sqlite", otherFilename)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = other.Close() })
+
+	_, err = other.Exec("CREATE TABLE busytest (value TEXT); INSERT INTO busytest VALUES ('hello');")
+	require.NoError(t, err)
+
+	conn, err := other.Conn(context.TODO())
+	require.NoError(t, err)
+	_, err = conn.ExecContext(context.TODO(), "BEGIN EXCLUSIVE;")
+	require.NoError(t, err)
+
+	schema := fmt.Sprintf(`
+		ATTACH DATABASE '%s' AS other;
+		CREATE TABLE busytest AS SELECT value FROM other.busytest;
+	`, otherFilename)
+	_, err = initializeThreadSafe(schema, defaultSchemaOptions, 0)
+	require.ErrorContains(t, err, "busy after 2 attempts")
+
+	// The schema is initialized at once when the database is released
+	_, err = conn.ExecContext(context.TODO(), "ROLLBACK;")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	filename, err := initializeThreadSafe(schema, defaultSchemaOptions, 0)
+	require.NoError(t, err)
+	require.NoError(t, checkIntegrity(filename))
+}
+
+// TestFilePermissions is not parallel since the permissions are process-wide.
+func TestFilePermissions(t *testing.T) {
+	schema := "CREATE TABLE permissionstest (value TEXT);"
+
+	for name, permissions := range map[string]FilePermissions{
+		"Custom":  {Dir: 0o750, File: 0o640},
+		"Default": DefaultFilePermissions,
+	} {
+		t.Run(name, func(t *testing.T) {
+			SetFilePermissions(permissions)
+			defer SetFilePermissions(DefaultFilePermissions)
+
+			filename := schemaFilename(schemaHash(schema, defaultSchemaOptions))
+			require.NoError(t, InvalidateSchema(schema))
+
+			runner, err := NewSQLRunner(schema)
+			require.NoError(t, err)
+			_, err = runner.Query(context.TODO(), "SELECT value FROM permissionstest")
+			require.NoError(t, err)
+
+			stat, err := os.Stat(tmpDir)
+			require.NoError(t, err)
+			assert.Equal(t, permissions.Dir, stat.Mode().Perm())
+
+			stat, err = os.Stat(filename)
+			require.NoError(t, err)
+			assert.Equal(t, permissions.File, stat.Mode().Perm())
+		})
+	}
+}
@@ -2,13 +2,22 @@ package sqlrunner_test
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"math/rand"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	sqlrunner "github.com/database-playground/sqlrunner/lib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestDateFunction(t *testing.T) {
@@ -263,6 +272,43 @@ func TestDbRunnerNoScientificNotation(t *testing.T) {
 	assert.Equal(t, "1145141919.81", result.Rows[1][0])
 }
 
+func TestDbRunnerRealDecimals(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE decimaltest (
+			price REAL,
+			quantity INT
+		);
+
+		INSERT INTO decimaltest (price, quantity) VALUES (1.0, 1);
+		INSERT INTO decimaltest (price, quantity) VALUES (2.5, 2);
+		INSERT INTO decimaltest (price, quantity) VALUES (NULL, 3);
+	`
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema)
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT price, quantity FROM decimaltest")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"1", "1"}, {"2.5", "2"}, {"NULL", "3"}}, result.Rows)
+	})
+
+	t.Run("Fixed decimals", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithRealDecimals(2))
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT price, quantity, price * 2 FROM decimaltest")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"1.00", "1", "2"}, {"2.50", "2", "5"}, {"NULL", "3", "NULL"}}, result.Rows)
+	})
+}
+
 func TestDbRunnerEmptyQuery(t *testing.T) {
 	t.Parallel()
 
@@ -276,11 +322,413 @@ func TestDbRunnerEmptyQuery(t *testing.T) {
 	`)
 	require.NoError(t, err)
 
-	result, err := runner.Query(context.TODO(), "")
+	// A query without statements returns an empty result, like an empty query.
+	for _, query := range []string{"", " \n\t", "-- comment", "/* comment */", ";"} {
+		result, err := runner.Query(context.TODO(), query)
+		require.NoError(t, err, "query %q", query)
+
+		assert.Len(t, result.Rows, 0, "query %q", query)
+		assert.Len(t, result.Columns, 0, "query %q", query)
+	}
+}
+
+// setupTestTracerProvider installs a global tracer provider
+// recording the spans in memory. It is only installed once since
+// the global tracers delegate to the first installed provider.
+var setupTestTracerProvider = sync.OnceValues(func() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+
+	return tp, exporter
+})
+
+func TestStableOrdering(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE stabletest (
+			id INT,
+			name TEXT
+		);
+
+		INSERT INTO stabletest (id, name) VALUES (3, 'c'), (10, 'j'), (1, 'a'), (2, 'b'), (2, 'a');
+	`
+
+	runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithStableOrdering(true))
+	require.NoError(t, err)
+
+	// The cache miss and the cache hit agree on the sorted order
+	for _, fromCache := range []bool{false, true} {
+		result, err := runner.Query(context.TODO(), "SELECT id, name FROM stabletest")
+		require.NoError(t, err)
+		assert.Equal(t, fromCache, result.FromCache)
+		assert.Equal(t, [][]string{{"1", "a"}, {"2", "a"}, {"2", "b"}, {"3", "c"}, {"10", "j"}}, result.Rows)
+	}
+
+	t.Run("ORDER BY", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT name FROM stabletest ORDER BY id DESC, name")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"j"}, {"c"}, {"a"}, {"b"}, {"a"}}, result.Rows)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema)
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT id FROM stabletest")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"3"}, {"10"}, {"1"}, {"2"}, {"2"}}, result.Rows)
+	})
+}
+
+func TestCartesianWarning(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE cartesianstudents (
+			id INTEGER PRIMARY KEY,
+			name TEXT
+		);
+		CREATE TABLE cartesianscores (
+			student_id INT,
+			score INT
+		);
+
+		INSERT INTO cartesianstudents (id, name) VALUES (1, 'alice'), (2, 'bob');
+		INSERT INTO cartesianscores (student_id, score) VALUES (1, 90), (2, 80);
+	`, sqlrunner.WithCartesianWarning(true))
+	require.NoError(t, err)
+
+	for query, warned := range map[string]bool{
+		"SELECT name, score FROM cartesianstudents, cartesianscores":                                                 true,
+		"SELECT s.name, c.score FROM cartesianstudents s, cartesianscores c WHERE c.score > 80":                      true,
+		"SELECT s.name, c.score FROM cartesianstudents s, cartesianscores c WHERE s.id = c.student_id":               false,
+		"SELECT name, score FROM cartesianstudents JOIN cartesianscores ON id = student_id":                          false,
+		"SELECT s1.score, s2.score FROM cartesianscores s1 JOIN cartesianscores s2 ON s1.student_id = s2.student_id": false,
+		"SELECT name, score FROM cartesianstudents CROSS JOIN cartesianscores":                                       false,
+		"WITH one AS (SELECT 1 AS v) SELECT name, v FROM cartesianstudents, one":                                     false,
+		"SELECT name FROM cartesianstudents WHERE id IN (SELECT student_id FROM cartesianscores)":                    false,
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			if warned {
+				require.Len(t, result.Warnings, 1)
+				assert.Contains(t, result.Warnings[0], "join condition")
+			} else {
+				assert.Empty(t, result.Warnings)
+			}
+		})
+	}
+}
+
+func TestIndexWarning(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE indexstudents (
+			id INTEGER PRIMARY KEY,
+			name TEXT,
+			age INT
+		);
+		CREATE INDEX indexstudents_age ON indexstudents (age);
+		CREATE TABLE indexscores (
+			student_id INT,
+			score INT
+		);
+
+		-- Enough rows for the planner to prefer the indexes
+		WITH RECURSIVE n (i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000)
+		INSERT INTO indexstudents (id, name, age) SELECT i, 'student' || i, i FROM n;
+		WITH RECURSIVE n (i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000)
+		INSERT INTO indexscores (student_id, score) SELECT i, i % 100 FROM n;
+	`, sqlrunner.WithIndexWarning(true))
+	require.NoError(t, err)
+
+	for query, warning := range map[string]string{
+		"SELECT name FROM indexstudents WHERE name = 'student1'": "The query scans every row of indexstudents; " +
+			"consider an index on the columns it is filtered or joined by.",
+		"SELECT a.score FROM indexscores a JOIN indexscores b ON a.student_id = b.student_id": "The query builds a temporary " +
+			"index on b (student_id=?) each time it runs; consider creating an index on these columns.",
+		"SELECT name FROM indexstudents WHERE age = 20":                                        "",
+		"SELECT name FROM indexstudents WHERE id = 1":                                          "",
+		"SELECT name FROM indexstudents":                                                       "",
+		"WITH s AS MATERIALIZED (SELECT * FROM indexstudents) SELECT name FROM s WHERE id = 1": "",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			if warning != "" {
+				assert.Contains(t, result.Warnings, warning)
+			} else {
+				assert.Empty(t, result.Warnings)
+			}
+		})
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(`
+			CREATE TABLE indexdisabled (
+				name TEXT
+			);
+		`)
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT name FROM indexdisabled WHERE name = 'alice'")
+		require.NoError(t, err)
+		assert.Empty(t, result.Warnings)
+	})
+}
+
+func TestColumnMetadata(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE metadatastudents (
+			id INTEGER PRIMARY KEY,
+			name TEXT,
+			class_id INT
+		);
+		CREATE TABLE metadataclasses (
+			id INTEGER PRIMARY KEY,
+			title TEXT
+		);
+
+		INSERT INTO metadataclasses (id, title) VALUES (1, 'SQL');
+		INSERT INTO metadatastudents (id, name, class_id) VALUES (1, 'Alice', 1);
+	`, sqlrunner.WithColumnMetadata(true))
+	require.NoError(t, err)
+
+	id := sqlrunner.ColumnMetadata{Table: "metadatastudents", Ordinal: 1, PrimaryKey: true}
+	name := sqlrunner.ColumnMetadata{Table: "metadatastudents", Ordinal: 2}
+	title := sqlrunner.ColumnMetadata{Table: "metadataclasses", Ordinal: 2}
+	unknown := sqlrunner.ColumnMetadata{}
+
+	for query, expected := range map[string][]sqlrunner.ColumnMetadata{
+		"SELECT id, name FROM metadatastudents":                   {id, name},
+		"SELECT * FROM metadatastudents":                          {id, name, {Table: "metadatastudents", Ordinal: 3}},
+		"SELECT s.id AS key, upper(name) FROM metadatastudents s": {id, unknown},
+		"SELECT s.id, c.id, title FROM metadatastudents AS s JOIN metadataclasses c ON s.class_id = c.id": {
+			id, {Table: "metadataclasses", Ordinal: 1, PrimaryKey: true}, title,
+		},
+		// The id is in both tables
+		"SELECT * FROM metadatastudents, metadataclasses": {
+			unknown, name, {Table: "metadatastudents", Ordinal: 3}, unknown, title,
+		},
+		// and the title may be in the subquery.
+		"SELECT name, title FROM (SELECT name FROM metadatastudents), metadataclasses": {unknown, unknown},
+		"WITH metadatastudents AS (SELECT 1 AS id) SELECT id FROM metadatastudents":    {unknown},
+		"SELECT id FROM metadatastudents UNION SELECT id FROM metadataclasses":         {unknown},
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, expected, result.ColumnMetadata)
+		})
+	}
+
+	// Only the SELECT statements have the metadata
+	result, err := runner.Query(context.TODO(), "PRAGMA table_info(metadatastudents)")
+	require.NoError(t, err)
+	assert.Nil(t, result.ColumnMetadata)
+}
+
+func TestEmptyResultHints(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE hintstudents (
+			id INTEGER PRIMARY KEY,
+			name TEXT
+		);
+		CREATE TABLE hintscores (
+			student_id INT,
+			score INT
+		);
+		CREATE TABLE hintempty (
+			value INT
+		);
+
+		INSERT INTO hintstudents (id, name) VALUES (1, 'Alice'), (2, 'Bob');
+		INSERT INTO hintscores (student_id, score) VALUES (1, 90);
+	`, sqlrunner.WithEmptyResultHints(true))
+	require.NoError(t, err)
+
+	for query, expected := range map[string][]string{
+		"SELECT * FROM hintempty": {"The query returns no rows since the table hintempty is empty."},
+		"SELECT name FROM hintstudents s JOIN hintempty e ON s.id = e.value": {
+			"The query returns no rows since the table hintempty is empty.",
+		},
+		"SELECT name FROM hintstudents WHERE name = 'Carol'": {
+			"The query returns no rows: the tables have rows, but the WHERE clause matched none of them.",
+		},
+		"SELECT name FROM hintstudents s JOIN hintscores c ON s.id = c.student_id WHERE c.score < 60": {
+			"The query returns no rows: the tables have rows, but the WHERE clause and the join conditions matched none of them.",
+		},
+		"SELECT name, count(*) FROM hintstudents GROUP BY name HAVING count(*) > 1": {
+			"The query returns no rows: the tables have rows, but the HAVING clause matched none of them.",
+		},
+		// No hints for the results with rows, or without a table to check
+		"SELECT name FROM hintstudents WHERE name = 'Alice'": nil,
+		"SELECT 1 WHERE 0":                      nil,
+		"SELECT name FROM hintstudents LIMIT 0": nil,
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, expected, result.Warnings)
+		})
+	}
+}
+
+func TestDbRunnerOrderWarning(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE ordertest (
+			value INT
+		);
+
+		INSERT INTO ordertest (value) VALUES (1);
+		INSERT INTO ordertest (value) VALUES (2);
+	`
+
+	runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithOrderWarning(true))
+	require.NoError(t, err)
+
+	t.Run("Unordered", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT value FROM ordertest")
+		require.NoError(t, err)
+		assert.Len(t, result.Warnings, 1)
+	})
+
+	t.Run("Ordered", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT value FROM ordertest ORDER BY value DESC")
+		require.NoError(t, err)
+		assert.Empty(t, result.Warnings)
+	})
+
+	t.Run("Ordered subquery only", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT value FROM (SELECT value FROM ordertest ORDER BY value)")
+		require.NoError(t, err)
+		assert.Len(t, result.Warnings, 1)
+	})
+
+	t.Run("Single row", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT COUNT(*) FROM ordertest")
+		require.NoError(t, err)
+		assert.Empty(t, result.Warnings)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema)
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT value FROM ordertest")
+		require.NoError(t, err)
+		assert.Empty(t, result.Warnings)
+	})
+}
+
+func TestOrderCheck(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE ordercheck (
+			value INT
+		);
+
+		INSERT INTO ordercheck (value) VALUES (2), (1);
+	`
+
+	for _, mode := range []sqlrunner.OrderCheck{sqlrunner.OrderCheckOff, sqlrunner.OrderCheckWarn, sqlrunner.OrderCheckError} {
+		t.Run(string(mode), func(t *testing.T) {
+			t.Parallel()
+
+			runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithOrderCheck(mode))
+			require.NoError(t, err)
+
+			result, err := runner.Query(context.TODO(), "SELECT value FROM ordercheck")
+			switch mode {
+			case sqlrunner.OrderCheckOff:
+				require.NoError(t, err)
+				assert.Empty(t, result.Warnings)
+			case sqlrunner.OrderCheckWarn:
+				require.NoError(t, err)
+				assert.Len(t, result.Warnings, 1)
+			case sqlrunner.OrderCheckError:
+				require.ErrorAs(t, err, &sqlrunner.QueryError{})
+				assert.ErrorIs(t, err, sqlrunner.ErrUnorderedRows)
+			}
+
+			// The ordered and the single-row queries pass in every mode
+			result, err = runner.Query(context.TODO(), "SELECT value FROM ordercheck ORDER BY value")
+			require.NoError(t, err)
+			assert.Empty(t, result.Warnings)
+
+			result, err = runner.Query(context.TODO(), "SELECT value FROM ordercheck WHERE value = 1")
+			require.NoError(t, err)
+			assert.Empty(t, result.Warnings)
+		})
+	}
+}
+
+func TestDbRunnerQuerySpanParent(t *testing.T) {
+	tp, exporter := setupTestTracerProvider()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE spantest (
+			value TEXT
+		);
+
+		INSERT INTO spantest (value) VALUES ('hello');
+	`)
+	require.NoError(t, err)
+
+	ctx, requestSpan := tp.Tracer("test").Start(context.Background(), "request")
+	_, err = runner.Query(ctx, "SELECT value FROM spantest")
 	require.NoError(t, err)
+	requestSpan.End()
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() == requestSpan.SpanContext().TraceID() {
+			spans[span.Name] = span
+		}
+	}
 
-	assert.Len(t, result.Rows, 0)
-	assert.Len(t, result.Columns, 0)
+	querySpan, ok := spans["SQLRunner.Query"]
+	require.True(t, ok)
+	assert.Equal(t, requestSpan.SpanContext().SpanID(), querySpan.Parent.SpanID())
+
+	instanceSpan, ok := spans["SQLRunner.getSqliteInstance"]
+	require.True(t, ok)
+	assert.Equal(t, querySpan.SpanContext.SpanID(), instanceSpan.Parent.SpanID())
 }
 
 func BenchmarkDbrunner(b *testing.B) {
@@ -354,3 +802,1268 @@ func BenchmarkDbrunner(b *testing.B) {
 		}
 	})
 }
+
+func TestQueryCacheStatus(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE cachestatustest (
+			value TEXT
+		);
+	`)
+	require.NoError(t, err)
+
+	result, err := runner.Query(context.TODO(), "SELECT value FROM cachestatustest")
+	require.NoError(t, err)
+	assert.Equal(t, sqlrunner.CacheCold, result.CacheStatus)
+
+	result, err = runner.Query(context.TODO(), "SELECT value FROM cachestatustest")
+	require.NoError(t, err)
+	assert.Equal(t, sqlrunner.CacheHit, result.CacheStatus)
+
+	result, err = runner.Query(context.TODO(), "SELECT count(*) FROM cachestatustest")
+	require.NoError(t, err)
+	assert.Equal(t, sqlrunner.CacheWarm, result.CacheStatus)
+}
+
+func TestQueryFromCache(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE fromcachetest (
+			value TEXT
+		);
+	`)
+	require.NoError(t, err)
+
+	first, err := runner.Query(context.TODO(), "SELECT value FROM fromcachetest")
+	require.NoError(t, err)
+	assert.False(t, first.FromCache)
+
+	second, err := runner.Query(context.TODO(), "SELECT value FROM fromcachetest")
+	require.NoError(t, err)
+	assert.True(t, second.FromCache)
+
+	// The flag of the result returned earlier is not changed by the cache hit.
+	assert.False(t, first.FromCache)
+}
+
+func TestForeignKeysOption(t *testing.T) {
+	t.Parallel()
+
+	// The child row is inserted before its parent row.
+	schema := `
+		CREATE TABLE fkparent (
+			id INTEGER PRIMARY KEY
+		);
+
+		CREATE TABLE fkchild (
+			id INTEGER PRIMARY KEY,
+			parent_id INTEGER REFERENCES fkparent (id)
+		);
+
+		INSERT INTO fkchild (id, parent_id) VALUES (1, 1);
+		INSERT INTO fkparent (id) VALUES (1);
+	`
+
+	t.Run("Enabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := sqlrunner.NewSQLRunner(schema)
+		require.ErrorAs(t, err, &sqlrunner.SchemaError{})
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithForeignKeys(false))
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT parent_id FROM fkchild")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"1"}}, result.Rows)
+	})
+
+	t.Run("Writable", func(t *testing.T) {
+		t.Parallel()
+
+		schema := `
+			CREATE TABLE fkwritableparent (
+				id INTEGER PRIMARY KEY
+			);
+
+			CREATE TABLE fkwritablechild (
+				id INTEGER PRIMARY KEY,
+				parent_id INTEGER REFERENCES fkwritableparent (id)
+			);
+
+			INSERT INTO fkwritableparent (id) VALUES (1);
+		`
+		query := "INSERT INTO fkwritablechild (id, parent_id) VALUES (1, 2)"
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithWritable(true))
+		require.NoError(t, err)
+		_, err = runner.Query(context.TODO(), query)
+		require.ErrorAs(t, err, &sqlrunner.QueryError{})
+		assert.ErrorContains(t, err, "FOREIGN KEY constraint failed")
+
+		runner, err = sqlrunner.NewSQLRunner(schema, sqlrunner.WithWritable(true), sqlrunner.WithForeignKeys(false))
+		require.NoError(t, err)
+		result, err := runner.Query(context.TODO(), query)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), result.RowsAffected)
+	})
+}
+
+func BenchmarkAnalyze(b *testing.B) {
+	b.ReportAllocs()
+
+	db, err := sql.Open("sqlite", filepath.Join(b.TempDir(), "analyze.db"))
+	require.NoError(b, err)
+	b.Cleanup(func() { _ = db.Close() })
+
+	// Without the statistics, the planner scans the orders by the barely
+	// selective status index, rather than starting from the few users in TW.
+	_, err = db.Exec(`
+		CREATE TABLE orders (
+			id INTEGER PRIMARY KEY,
+			status TEXT,
+			user_id INT
+		);
+		CREATE INDEX orders_status ON orders (status);
+		CREATE INDEX orders_user_id ON orders (user_id);
+
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			country TEXT
+		);
+		CREATE INDEX users_country ON users (country);
+
+		WITH RECURSIVE seq(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM seq WHERE i < 20000)
+		INSERT INTO orders (id, status, user_id)
+		SELECT i, CASE WHEN i % 10 = 0 THEN 'cancelled' ELSE 'done' END, i % 1000 FROM seq;
+
+		WITH RECURSIVE seq(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM seq WHERE i < 1000)
+		INSERT INTO users (id, country)
+		SELECT i, CASE WHEN i % 100 = 0 THEN 'TW' ELSE 'US' END FROM seq;
+	`)
+	require.NoError(b, err)
+
+	query := `
+		SELECT count(*) FROM orders
+		JOIN users ON users.id = orders.user_id
+		WHERE orders.status = 'done' AND users.country = 'TW'
+	`
+
+	b.Run("Before ANALYZE", func(b *testing.B) {
+		for b.Loop() {
+			var count int
+			require.NoError(b, db.QueryRow(query).Scan(&count))
+		}
+	})
+
+	_, err = db.Exec("ANALYZE;")
+	require.NoError(b, err)
+
+	b.Run("After ANALYZE", func(b *testing.B) {
+		for b.Loop() {
+			var count int
+			require.NoError(b, db.QueryRow(query).Scan(&count))
+		}
+	})
+}
+
+func TestQueryMulti(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE multitest (
+			id INT,
+			name TEXT
+		);
+
+		INSERT INTO multitest (id, name) VALUES (1, 'a;b');
+	`
+
+	t.Run("Multiple SELECTs", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema)
+		require.NoError(t, err)
+
+		results, err := runner.QueryMulti(context.TODO(), "SELECT name FROM multitest; SELECT count(*) AS n FROM multitest;")
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+
+		assert.Equal(t, []string{"name"}, results[0].Columns)
+		assert.Equal(t, [][]string{{"a;b"}}, results[0].Rows)
+		assert.Equal(t, []string{"n"}, results[1].Columns)
+		assert.Equal(t, [][]string{{"1"}}, results[1].Rows)
+	})
+
+	t.Run("DML in writable mode", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithWritable(true))
+		require.NoError(t, err)
+
+		results, err := runner.QueryMulti(context.TODO(), `
+			INSERT INTO multitest (id, name) VALUES (2, 'c'), (3, 'd');
+			UPDATE multitest SET name = 'e' WHERE id = 3;
+			SELECT id, name FROM multitest ORDER BY id;
+		`)
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+
+		assert.Equal(t, int64(2), results[0].RowsAffected)
+		assert.Empty(t, results[0].Rows)
+		assert.Equal(t, int64(1), results[1].RowsAffected)
+		assert.Equal(t, [][]string{{"1", "a;b"}, {"2", "c"}, {"3", "e"}}, results[2].Rows)
+
+		// The modifications are discarded after the query.
+		result, err := runner.Query(context.TODO(), "SELECT count(*) FROM multitest")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"1"}}, result.Rows)
+	})
+
+	t.Run("DML in read-only mode", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema)
+		require.NoError(t, err)
+
+		_, err = runner.QueryMulti(context.TODO(), "SELECT 1; DELETE FROM multitest;")
+		var queryError sqlrunner.QueryError
+		require.ErrorAs(t, err, &queryError)
+		assert.Contains(t, queryError.Error(), "statement 2")
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE truncatetest (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT
+		);
+
+		INSERT INTO truncatetest (name) VALUES ('a'), ('b'), ('c');
+	`
+
+	t.Run("Writable mode", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithWritable(true))
+		require.NoError(t, err)
+
+		for _, command := range []string{"TRUNCATE TABLE truncatetest", "truncate `truncatetest`;"} {
+			results, err := runner.QueryMulti(context.TODO(), command+`;
+				INSERT INTO truncatetest (name) VALUES ('d');
+				SELECT id, name FROM truncatetest;
+			`)
+			require.NoError(t, err)
+			require.Len(t, results, 3)
+
+			assert.Equal(t, int64(3), results[0].RowsAffected)
+			assert.Equal(t, sqlrunner.StatementDelete, results[0].StatementType)
+			// The AUTOINCREMENT counter is reset too
+			assert.Equal(t, [][]string{{"1", "d"}}, results[2].Rows)
+		}
+
+		result, err := runner.Query(context.TODO(), "TRUNCATE TABLE truncatetest")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), result.RowsAffected)
+	})
+
+	t.Run("Read-only mode", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema)
+		require.NoError(t, err)
+
+		_, err = runner.Query(context.TODO(), "TRUNCATE TABLE truncatetest")
+		var queryError sqlrunner.QueryError
+		require.ErrorAs(t, err, &queryError)
+		assert.ErrorIs(t, err, sqlrunner.ErrReadOnly)
+
+		result, err := runner.Query(context.TODO(), "SELECT count(*) FROM truncatetest")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"3"}}, result.Rows)
+	})
+}
+
+func TestStatementType(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE typetest (
+			id INT,
+			name TEXT
+		);
+
+		INSERT INTO typetest (id, name) VALUES (1, 'a');
+	`
+
+	runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithWritable(true))
+	require.NoError(t, err)
+
+	testcases := map[string]sqlrunner.StatementType{
+		"SELECT * FROM typetest":                                       sqlrunner.StatementSelect,
+		"-- comment\n  select id FROM typetest":                        sqlrunner.StatementSelect,
+		"WITH t AS (SELECT 1) SELECT * FROM t":                         sqlrunner.StatementSelect,
+		"VALUES (1), (2)":                                              sqlrunner.StatementSelect,
+		"INSERT INTO typetest (id, name) VALUES (2, 'b')":              sqlrunner.StatementInsert,
+		"REPLACE INTO typetest (id, name) VALUES (2, 'b')":             sqlrunner.StatementInsert,
+		"UPDATE typetest SET name = 'b' WHERE id = 1":                  sqlrunner.StatementUpdate,
+		"DELETE FROM typetest WHERE id = 1":                            sqlrunner.StatementDelete,
+		"CREATE TABLE another (id INT)":                                sqlrunner.StatementCreate,
+		"PRAGMA table_info(typetest)":                                  sqlrunner.StatementPragma,
+		"DROP TABLE typetest":                                          sqlrunner.StatementOther,
+		"SHOW TABLES":                                                  sqlrunner.StatementOther,
+		"INSERT INTO typetest VALUES (2, 'b'); SELECT * FROM typetest": sqlrunner.StatementSelect,
+	}
+
+	for query, expected := range testcases {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, expected, result.StatementType)
+		})
+	}
+
+	t.Run("QueryMulti", func(t *testing.T) {
+		t.Parallel()
+
+		results, err := runner.QueryMulti(context.TODO(), "DELETE FROM typetest; SELECT * FROM typetest;")
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+
+		assert.Equal(t, sqlrunner.StatementDelete, results[0].StatementType)
+		assert.Equal(t, sqlrunner.StatementSelect, results[1].StatementType)
+	})
+
+	t.Run("Empty query", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "-- nothing")
+		require.NoError(t, err)
+		assert.Empty(t, result.StatementType)
+	})
+}
+
+func TestMaxColumns(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE widetest (a INT, b INT, c INT, d INT);
+		INSERT INTO widetest VALUES (1, 2, 3, 4);
+	`, sqlrunner.WithMaxColumns(3))
+	require.NoError(t, err)
+
+	t.Run("Within the limit", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT a, b, c FROM widetest")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"1", "2", "3"}}, result.Rows)
+	})
+
+	for _, query := range []string{
+		"SELECT * FROM widetest",
+		"SELECT 1, 2, 3, 4",
+		"SELECT a, b FROM widetest; SELECT * FROM widetest",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := runner.Query(context.TODO(), query)
+			require.ErrorAs(t, err, &sqlrunner.QueryError{})
+			assert.ErrorIs(t, err, sqlrunner.ErrTooManyColumns)
+		})
+	}
+
+	t.Run("QueryRows", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.QueryRows(context.TODO(), "SELECT * FROM widetest")
+		require.ErrorAs(t, err, &sqlrunner.QueryError{})
+		assert.ErrorIs(t, err, sqlrunner.ErrTooManyColumns)
+	})
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE responsebytestest (value TEXT);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 100)
+		INSERT INTO responsebytestest SELECT printf('%020d', i) FROM n;
+	`, sqlrunner.WithMaxResponseBytes(1000))
+	require.NoError(t, err)
+
+	t.Run("Within the limit", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT value FROM responsebytestest LIMIT 10")
+		require.NoError(t, err)
+		assert.Len(t, result.Rows, 10)
+	})
+
+	t.Run("Exceeding the limit", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.Query(context.TODO(), "SELECT value FROM responsebytestest")
+		require.ErrorAs(t, err, &sqlrunner.QueryError{})
+		assert.ErrorIs(t, err, sqlrunner.ErrResultTooLarge)
+		assert.ErrorContains(t, err, "add a LIMIT")
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(`
+			CREATE TABLE responsebytestest (value TEXT);
+			INSERT INTO responsebytestest VALUES (printf('%.2000c', 'x'));
+		`, sqlrunner.WithMaxResponseBytes(0))
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "SELECT value FROM responsebytestest")
+		require.NoError(t, err)
+		assert.Len(t, result.Rows[0][0], 2000)
+	})
+}
+
+func TestQueryReturning(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE returningtest (
+			id INTEGER PRIMARY KEY,
+			name TEXT
+		);
+
+		INSERT INTO returningtest (id, name) VALUES (1, 'a');
+	`, sqlrunner.WithWritable(true))
+	require.NoError(t, err)
+
+	t.Run("Query", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "INSERT INTO returningtest (name) VALUES ('b'), ('c') RETURNING id")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"id"}, result.Columns)
+		assert.Equal(t, [][]string{{"2"}, {"3"}}, result.Rows)
+		assert.Equal(t, int64(2), result.RowsAffected)
+	})
+
+	t.Run("QueryMulti", func(t *testing.T) {
+		t.Parallel()
+
+		results, err := runner.QueryMulti(context.TODO(), `
+			INSERT INTO returningtest (name) VALUES ('b') RETURNING id, name;
+			DELETE FROM returningtest WHERE id = 1;
+		`)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+
+		assert.Equal(t, [][]string{{"2", "b"}}, results[0].Rows)
+		assert.Equal(t, int64(1), results[0].RowsAffected)
+		assert.Empty(t, results[1].Rows)
+		assert.Equal(t, int64(1), results[1].RowsAffected)
+	})
+
+	t.Run("Without RETURNING", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "UPDATE returningtest SET name = 'z'")
+		require.NoError(t, err)
+		assert.Empty(t, result.Rows)
+		assert.Equal(t, int64(1), result.RowsAffected)
+	})
+}
+
+func TestAllowedStatements(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE policytest (
+			value TEXT
+		);
+
+		INSERT INTO policytest (value) VALUES ('hello');
+	`, sqlrunner.WithWritable(true), sqlrunner.WithAllowedStatements("select"))
+	require.NoError(t, err)
+
+	t.Run("Plain SELECT", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT value FROM policytest")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+	})
+
+	t.Run("SELECT with CTE", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "WITH v AS (SELECT value FROM policytest) SELECT value FROM v")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+	})
+
+	for query, statementType := range map[string]string{
+		"WITH v AS (SELECT 1) DELETE FROM policytest":     "DELETE",
+		"SELECT 1; UPDATE policytest SET value = 'x'":     "UPDATE",
+		"INSERT INTO policytest VALUES ('x') RETURNING *": "INSERT",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := runner.Query(context.TODO(), query)
+			var policyError sqlrunner.PolicyError
+			require.ErrorAs(t, err, &policyError)
+			assert.Equal(t, statementType, policyError.StatementType)
+
+			_, err = runner.QueryMulti(context.TODO(), query)
+			require.ErrorAs(t, err, &policyError)
+		})
+	}
+}
+
+func TestAllowedPragmas(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE pragmatest (
+			id INT,
+			value TEXT
+		);
+	`
+
+	runner, err := sqlrunner.NewSQLRunner(schema)
+	require.NoError(t, err)
+
+	for _, query := range []string{
+		"PRAGMA table_info(pragmatest)",
+		"pragma main.TABLE_INFO('pragmatest');",
+		"SELECT name FROM pragma_table_info('pragmatest')",
+		"DESCRIBE pragmatest",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Len(t, result.Rows, 2)
+		})
+	}
+
+	for query, pragma := range map[string]string{
+		"PRAGMA writable_schema = ON":                     "writable_schema",
+		"PRAGMA case_sensitive_like = true":               "case_sensitive_like",
+		"PRAGMA main.database_list":                       "database_list",
+		`PRAGMA "database_list"`:                          "database_list",
+		"SELECT 1; PRAGMA database_list":                  "database_list",
+		"SELECT file FROM pragma_database_list":           "database_list",
+		"SELECT * FROM main.PRAGMA_DATABASE_LIST() AS db": "database_list",
+		// EXPLAIN compiles the PRAGMA, which applies it
+		"EXPLAIN PRAGMA query_only = 0":                                  "query_only",
+		"EXPLAIN QUERY PLAN PRAGMA query_only = 0":                       "query_only",
+		"explain /* plan */ query plan pragma main.writable_schema = ON": "writable_schema",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := runner.Query(context.TODO(), query)
+			var policyError sqlrunner.PolicyError
+			require.ErrorAs(t, err, &policyError)
+			assert.Equal(t, pragma, policyError.Pragma)
+
+			_, err = runner.QueryMulti(context.TODO(), query)
+			require.ErrorAs(t, err, &policyError)
+
+			err = runner.Prepare(context.TODO(), query)
+			require.ErrorAs(t, err, &policyError)
+		})
+	}
+
+	t.Run("Custom list", func(t *testing.T) {
+		t.Parallel()
+
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithAllowedPragmas("Foreign_Keys"))
+		require.NoError(t, err)
+
+		result, err := runner.Query(context.TODO(), "PRAGMA foreign_keys")
+		require.NoError(t, err)
+		assert.Len(t, result.Rows, 1)
+
+		_, err = runner.Query(context.TODO(), "PRAGMA table_info(pragmatest)")
+		require.ErrorAs(t, err, &sqlrunner.PolicyError{})
+	})
+}
+
+func TestDisabledFunctions(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE disabledfunctionstest (
+			name TEXT
+		);
+
+		INSERT INTO disabledfunctionstest (name) VALUES ('alice');
+	`
+
+	// The runners share the schema and the registered functions, but not
+	// their function sets.
+	full, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithMySQLDateArithmetic(true))
+	require.NoError(t, err)
+	restricted, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithMySQLDateArithmetic(true),
+		sqlrunner.WithDisabledFunctions("left", "IF", "REGEXP", "DATE_ADD", "json_group_array", "LIKE", "GLOB"))
+	require.NoError(t, err)
+
+	for query, function := range map[string]string{
+		"SELECT LEFT(name, 2) FROM disabledfunctionstest":            "LEFT",
+		"SELECT left (name, 2) FROM disabledfunctionstest":           "LEFT",
+		"SELECT IF(name = 'alice', 1, 0) FROM disabledfunctionstest": "IF",
+		// The operators and the MySQL syntax calling the functions
+		"SELECT name REGEXP '^a' FROM disabledfunctionstest":              "REGEXP",
+		"SELECT name rlike '^a' FROM disabledfunctionstest":               "REGEXP",
+		"SELECT DATE('2024-01-01') + INTERVAL 1 DAY":                      "DATE_ADD",
+		"SELECT JSON_ARRAYAGG(name) FROM disabledfunctionstest":           "JSON_GROUP_ARRAY",
+		"SELECT name FROM disabledfunctionstest WHERE name LIKE 'a%'":     "LIKE",
+		"SELECT name FROM disabledfunctionstest WHERE name NOT GLOB 'b*'": "GLOB",
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := full.Query(context.TODO(), query)
+			require.NoError(t, err)
+
+			_, err = restricted.Query(context.TODO(), query)
+			var policyError sqlrunner.PolicyError
+			require.ErrorAs(t, err, &policyError)
+			assert.Equal(t, function, policyError.Function)
+			assert.EqualError(t, err, "function not allowed: "+function)
+		})
+	}
+
+	// The other functions, and the names which are not called, are allowed
+	result, err := restricted.Query(context.TODO(), "SELECT SUBSTR(name, 4) AS \"left\", 'IF(' FROM disabledfunctionstest")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"ce", "IF("}}, result.Rows)
+}
+
+func TestSingleStatement(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE singlestatementtest (
+			value TEXT
+		);
+
+		INSERT INTO singlestatementtest (value) VALUES ('hello');
+	`, sqlrunner.WithSingleStatement(true))
+	require.NoError(t, err)
+
+	for name, query := range map[string]string{
+		"Line comment":       "-- fetch the values\nSELECT value FROM singlestatementtest",
+		"Block comment":      "/* fetch; the values */ SELECT value FROM singlestatementtest",
+		"Trailing semicolon": "SELECT value FROM singlestatementtest; \n\t",
+		"Trailing comment":   "SELECT value FROM singlestatementtest; -- done;\n;",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), query)
+			require.NoError(t, err)
+			assert.Equal(t, [][]string{{"hello"}}, result.Rows)
+		})
+	}
+
+	t.Run("Multiple statements", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.Query(context.TODO(), "SELECT 1; -- first\nSELECT value FROM singlestatementtest;")
+		require.ErrorIs(t, err, sqlrunner.ErrMultipleStatements)
+
+		// QueryMulti is not affected
+		results, err := runner.QueryMulti(context.TODO(), "SELECT 1; SELECT value FROM singlestatementtest;")
+		require.NoError(t, err)
+		assert.Len(t, results, 2)
+	})
+}
+
+func TestWindowFunctions(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE windowtest (
+			name TEXT,
+			dept TEXT,
+			score INT
+		);
+
+		INSERT INTO windowtest (name, dept, score) VALUES ('a', 'x', 90);
+		INSERT INTO windowtest (name, dept, score) VALUES ('b', 'x', 80);
+		INSERT INTO windowtest (name, dept, score) VALUES ('c', 'x', 80);
+		INSERT INTO windowtest (name, dept, score) VALUES ('d', 'y', 70);
+		INSERT INTO windowtest (name, dept, score) VALUES ('e', 'y', 60);
+	`)
+	require.NoError(t, err)
+
+	t.Run("Ranking", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), `
+			SELECT
+				name,
+				ROW_NUMBER() OVER (ORDER BY score DESC, name) AS row_number,
+				RANK() OVER (ORDER BY score DESC) AS rank,
+				DENSE_RANK() OVER (ORDER BY score DESC) AS dense_rank
+			FROM windowtest
+			ORDER BY score DESC, name
+		`)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"name", "row_number", "rank", "dense_rank"}, result.Columns)
+		assert.Equal(t, [][]string{
+			{"a", "1", "1", "1"},
+			{"b", "2", "2", "2"},
+			{"c", "3", "2", "2"},
+			{"d", "4", "4", "3"},
+			{"e", "5", "5", "4"},
+		}, result.Rows)
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), `
+			SELECT name, RANK() OVER w, SUM(score) OVER (PARTITION BY dept)
+			FROM windowtest
+			WINDOW w AS (PARTITION BY dept ORDER BY score DESC)
+			ORDER BY dept, score DESC, name
+		`)
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"a", "1", "250"},
+			{"b", "2", "250"},
+			{"c", "2", "250"},
+			{"d", "1", "130"},
+			{"e", "2", "130"},
+		}, result.Rows)
+		assert.Empty(t, result.Warnings)
+	})
+
+	t.Run("Misuse", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.Query(context.TODO(), "SELECT name FROM windowtest WHERE RANK() OVER (ORDER BY score) = 1")
+		var queryError sqlrunner.QueryError
+		require.ErrorAs(t, err, &queryError)
+		assert.Contains(t, queryError.Error(), "misuse of window function")
+		assert.Contains(t, queryError.Error(), "outer query")
+	})
+}
+
+func TestDefaultCollation(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE collationtest (
+			name TEXT
+		);
+
+		INSERT INTO collationtest (name) VALUES ('B');
+		INSERT INTO collationtest (name) VALUES ('a');
+		INSERT INTO collationtest (name) VALUES ('c');
+	`
+
+	for name, tc := range map[string]struct {
+		opts     []sqlrunner.Option
+		ordered  [][]string
+		equality [][]string
+	}{
+		"BINARY": {
+			ordered:  [][]string{{"B"}, {"a"}, {"c"}},
+			equality: [][]string{},
+		},
+		"NOCASE": {
+			opts:     []sqlrunner.Option{sqlrunner.WithDefaultCollation("nocase")},
+			ordered:  [][]string{{"a"}, {"B"}, {"c"}},
+			equality: [][]string{{"B"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			runner, err := sqlrunner.NewSQLRunner(schema, tc.opts...)
+			require.NoError(t, err)
+
+			result, err := runner.Query(context.TODO(), "SELECT name FROM collationtest ORDER BY name")
+			require.NoError(t, err)
+			assert.Equal(t, tc.ordered, result.Rows)
+
+			result, err = runner.Query(context.TODO(), "SELECT name FROM collationtest WHERE name = 'b'")
+			require.NoError(t, err)
+			assert.Equal(t, tc.equality, result.Rows)
+		})
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE preparetest (
+			id INT,
+			value TEXT
+		);
+
+		INSERT INTO preparetest (id, value) VALUES (1, 'hello');
+	`)
+	require.NoError(t, err)
+
+	t.Run("Valid query", func(t *testing.T) {
+		t.Parallel()
+
+		require.NoError(t, runner.Prepare(context.TODO(), "SELECT value FROM preparetest; DELETE FROM preparetest"))
+
+		// The query is not executed
+		result, err := runner.Query(context.TODO(), "SELECT count(*) FROM preparetest")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"1"}}, result.Rows)
+	})
+
+	for query, tc := range map[string]struct {
+		category     string
+		line, column int
+	}{
+		"SELECT value FORM preparetest":              {sqlrunner.PrepareErrorSyntax, 1, 19},
+		"SELECT 1;\nSELECT value\n  FROM preparetst": {sqlrunner.PrepareErrorReference, 3, 8},
+		"SELECT id,\n  valeu FROM preparetest":       {sqlrunner.PrepareErrorReference, 2, 3},
+		"SELECT value FROM preparetest WHERE (id":    {sqlrunner.PrepareErrorSyntax, 1, 40},
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			err := runner.Prepare(context.TODO(), query)
+			var prepareError sqlrunner.PrepareError
+			require.ErrorAs(t, err, &prepareError)
+			assert.Equal(t, tc.category, prepareError.Category)
+			assert.Equal(t, tc.line, prepareError.Line)
+			assert.Equal(t, tc.column, prepareError.Column)
+		})
+	}
+}
+
+func TestQueryRows(t *testing.T) {
+	t.Parallel()
+
+	service, err := sqlrunner.NewService(sqlrunner.WithConcurrencyLimit(1, 0))
+	require.NoError(t, err)
+
+	runner, err := service.Runner(`
+		CREATE TABLE queryrowstest (
+			id INT,
+			value TEXT
+		);
+
+		INSERT INTO queryrowstest (id, value) VALUES (1, 'a'), (2, 'b'), (3, 'c');
+	`)
+	require.NoError(t, err)
+
+	// The cursor holds the only concurrency slot until it is closed
+	assertSlotReleased := func(t *testing.T, released bool) {
+		t.Helper()
+
+		_, err := runner.Query(context.TODO(), fmt.Sprintf("SELECT %d", time.Now().UnixNano()))
+		if released {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorIs(t, err, sqlrunner.ErrTooManyQueries)
+		}
+	}
+
+	t.Run("Exhausted", func(t *testing.T) {
+		rows, err := runner.QueryRows(context.TODO(), "SELECT id, value FROM queryrowstest ORDER BY id")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"id", "value"}, rows.Columns())
+		assert.Equal(t, []string{"INT", "TEXT"}, rows.ColumnTypes())
+		assertSlotReleased(t, false)
+
+		var got [][]string
+		row := make([]string, 2)
+		for rows.Next() {
+			require.NoError(t, rows.Scan(row))
+			got = append(got, slices.Clone(row))
+		}
+		require.NoError(t, rows.Err())
+		assert.Equal(t, [][]string{{"1", "a"}, {"2", "b"}, {"3", "c"}}, got)
+
+		assertSlotReleased(t, true)
+		assert.Error(t, rows.Scan(row))
+		assert.NoError(t, rows.Close())
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		// The invalid pattern fails from the second row
+		rows, err := runner.QueryRows(context.TODO(), "SELECT CASE WHEN id = 1 THEN value ELSE value REGEXP '(' END FROM queryrowstest")
+		require.NoError(t, err)
+
+		row := make([]string, 1)
+		require.True(t, rows.Next())
+		require.NoError(t, rows.Scan(row))
+		assert.Equal(t, []string{"a"}, row)
+
+		assert.False(t, rows.Next())
+		assert.ErrorAs(t, rows.Err(), &sqlrunner.QueryError{})
+		assert.False(t, rows.Next())
+
+		assertSlotReleased(t, true)
+	})
+
+	t.Run("Closed early", func(t *testing.T) {
+		rows, err := runner.QueryRows(context.TODO(), "SELECT id FROM queryrowstest")
+		require.NoError(t, err)
+		require.True(t, rows.Next())
+		assertSlotReleased(t, false)
+
+		require.NoError(t, rows.Close())
+		assertSlotReleased(t, true)
+	})
+
+	t.Run("Invalid query", func(t *testing.T) {
+		_, err := runner.QueryRows(context.TODO(), "SELECT nonexistent FROM queryrowstest")
+		assert.ErrorAs(t, err, &sqlrunner.QueryError{})
+
+		assertSlotReleased(t, true)
+	})
+}
+
+func TestBooleanFormat(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE booleantest (
+			value INT,
+			active BOOLEAN
+		);
+
+		INSERT INTO booleantest (value, active) VALUES (1, TRUE);
+	`
+
+	for format, expected := range map[sqlrunner.BooleanFormat]map[string][]string{
+		sqlrunner.BooleanNumeric: {
+			"SELECT value = 1, value <> 1 FROM booleantest":                       {"1", "0"},
+			"SELECT active, value FROM booleantest":                               {"1", "1"},
+			"SELECT value IS NULL AS missing, value + 1 AS next FROM booleantest": {"0", "2"},
+		},
+		sqlrunner.BooleanKeyword: {
+			"SELECT value = 1, value <> 1 FROM booleantest":                                        {"TRUE", "FALSE"},
+			"SELECT active, value FROM booleantest":                                                {"TRUE", "1"},
+			"SELECT value IS NULL AS missing, value + 1 AS next FROM booleantest":                  {"FALSE", "2"},
+			"SELECT value >= 1 AND value < 2, value << 1, value >> 1 FROM booleantest":             {"TRUE", "2", "0"},
+			"SELECT CASE WHEN value = 1 THEN 1 ELSE 0 END, (value = 1) FROM booleantest":           {"1", "TRUE"},
+			"SELECT EXISTS (SELECT 1 FROM booleantest), NULL = 1, value LIKE '1' FROM booleantest": {"TRUE", "NULL", "TRUE"},
+			"WITH v AS (SELECT value = 1 AS b FROM booleantest) SELECT b, b + 0 FROM v":            {"1", "1"},
+			"SELECT DISTINCT value != 2, count(*) FROM booleantest GROUP BY value":                 {"TRUE", "1"},
+			"SELECT value = 1 FROM booleantest UNION ALL SELECT 1 = 1 FROM booleantest LIMIT 1":    {"TRUE"},
+			"SELECT value BETWEEN 0 AND 2, NOT value, json_array(value) ->> 0 FROM booleantest":    {"TRUE", "FALSE", "1"},
+		},
+	} {
+		runner, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithBooleanFormat(format))
+		require.NoError(t, err)
+
+		for query, expected := range expected {
+			t.Run(string(format)+"/"+query, func(t *testing.T) {
+				t.Parallel()
+
+				result, err := runner.Query(context.TODO(), query)
+				require.NoError(t, err)
+				assert.Equal(t, [][]string{expected}, result.Rows)
+			})
+		}
+	}
+}
+
+func TestQueryParams(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE paramstest (
+			id INT,
+			name TEXT
+		);
+
+		INSERT INTO paramstest (id, name) VALUES (1, 'alice'), (2, 'bob');
+	`)
+	require.NoError(t, err)
+
+	t.Run("Bound", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT :id + 1, name FROM paramstest WHERE id = :id OR name = @name -- :ignored",
+			sqlrunner.WithParams(map[string]any{"id": int64(1), "name": "bob"}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{":id + 1", "name"}, result.Columns)
+		assert.Equal(t, [][]string{{"2", "alice"}, {"2", "bob"}}, result.Rows)
+
+		// A string is a different value, which is cached separately
+		result, err = runner.Query(context.TODO(), "SELECT :id + 1, name FROM paramstest WHERE id = :id OR name = @name -- :ignored",
+			sqlrunner.WithParams(map[string]any{"id": "2", "name": "alice"}))
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"3", "alice"}, {"3", "bob"}}, result.Rows)
+	})
+
+	t.Run("Without params", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT :id, @name, :id")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"NULL", "NULL", "NULL"}}, result.Rows)
+	})
+
+	t.Run("Placeholders in strings", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT ':id', @id", sqlrunner.WithParams(map[string]any{"id": nil}))
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{":id", "NULL"}}, result.Rows)
+	})
+
+	for query, params := range map[string]map[string]any{
+		"SELECT :id":    {},
+		"SELECT 1":      {"id": int64(1)},
+		"SELECT :id, ?": {"id": int64(1)},
+	} {
+		t.Run(query, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := runner.Query(context.TODO(), query, sqlrunner.WithParams(params))
+			require.ErrorAs(t, err, &sqlrunner.ParameterError{})
+		})
+	}
+
+	t.Run("Multiple statements", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.QueryMulti(context.TODO(), "SELECT :id; SELECT 1", sqlrunner.WithParams(map[string]any{"id": int64(1)}))
+		require.ErrorAs(t, err, &sqlrunner.ParameterError{})
+	})
+}
+
+func TestQueryVariables(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE variablestest (
+			id INT,
+			name TEXT
+		);
+
+		INSERT INTO variablestest (id, name) VALUES (1, 'alice'), (2, 'bob'), (3, 'carol');
+	`)
+	require.NoError(t, err)
+
+	t.Run("Defined", func(t *testing.T) {
+		t.Parallel()
+
+		variables := map[string]any{"min": int64(2), "unused": "ignored"}
+		result, err := runner.Query(context.TODO(), "SELECT @min, name FROM variablestest WHERE id >= @MIN ORDER BY id",
+			sqlrunner.WithVariables(variables))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"@min", "name"}, result.Columns)
+		assert.Equal(t, [][]string{{"2", "bob"}, {"2", "carol"}}, result.Rows)
+
+		// The variables are part of the cache key
+		variables = map[string]any{"min": int64(3)}
+		result, err = runner.Query(context.TODO(), "SELECT @min, name FROM variablestest WHERE id >= @MIN ORDER BY id",
+			sqlrunner.WithVariables(variables))
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"3", "carol"}}, result.Rows)
+	})
+
+	t.Run("With parameters", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT name FROM variablestest WHERE id = :id OR name = @name ORDER BY id",
+			sqlrunner.WithParams(map[string]any{"id": int64(1)}), sqlrunner.WithVariables(map[string]any{"name": "carol"}))
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"alice"}, {"carol"}}, result.Rows)
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := runner.Query(context.TODO(), "SELECT name FROM variablestest WHERE id = @mni",
+			sqlrunner.WithVariables(map[string]any{"min": int64(2)}))
+		require.ErrorAs(t, err, &sqlrunner.ParameterError{})
+		assert.ErrorIs(t, err, sqlrunner.ErrUndefinedVariable)
+		assert.ErrorContains(t, err, "@mni")
+	})
+
+	t.Run("Not referenced", func(t *testing.T) {
+		t.Parallel()
+
+		// The placeholders are bound as without the variables
+		result, err := runner.Query(context.TODO(), "SELECT :name FROM variablestest WHERE id = 1",
+			sqlrunner.WithVariables(map[string]any{"min": int64(2)}))
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"NULL"}}, result.Rows)
+
+		// A positional placeholder without a value is a query error rather
+		// than mixed with the parameters
+		_, err = runner.Query(context.TODO(), "SELECT ? FROM variablestest WHERE id = 1",
+			sqlrunner.WithVariables(map[string]any{"min": int64(2)}))
+		require.ErrorAs(t, err, &sqlrunner.QueryError{})
+		assert.NotErrorAs(t, err, &sqlrunner.ParameterError{})
+	})
+}
+
+func TestBenchmark(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE benchmarktest (id INT);
+		INSERT INTO benchmarktest VALUES (1), (2), (3);
+	`, sqlrunner.WithWritable(true))
+	require.NoError(t, err)
+
+	benchmark, err := runner.Benchmark(context.TODO(), "SELECT COUNT(*) FROM benchmarktest", 5)
+	require.NoError(t, err)
+	assert.Equal(t, 5, benchmark.Runs)
+	assert.Equal(t, [][]string{{"3"}}, benchmark.Result.Rows)
+	assert.False(t, benchmark.Result.FromCache)
+	assert.LessOrEqual(t, benchmark.Min, benchmark.Median)
+	assert.LessOrEqual(t, benchmark.Median, benchmark.P95)
+	assert.LessOrEqual(t, benchmark.P95, benchmark.Max)
+
+	// Each run modifies a fresh copy of the database
+	benchmark, err = runner.Benchmark(context.TODO(), "DELETE FROM benchmarktest WHERE id > 1", 3)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, benchmark.Result.RowsAffected)
+
+	for _, runs := range []int{0, sqlrunner.MaxBenchmarkRuns + 1} {
+		_, err := runner.Benchmark(context.TODO(), "SELECT 1", runs)
+		assert.Error(t, err)
+	}
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE analyzetest (id INTEGER PRIMARY KEY, indexed INT, plain INT);
+		CREATE INDEX analyzetest_indexed ON analyzetest (indexed);
+
+		WITH RECURSIVE c (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM c LIMIT 1000)
+		INSERT INTO analyzetest (indexed, plain) SELECT n, n FROM c;
+	`)
+	require.NoError(t, err)
+
+	indexed, err := runner.ExplainAnalyze(context.TODO(), "SELECT id FROM analyzetest WHERE indexed = :n", sqlrunner.WithParams(map[string]any{"n": 500}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, indexed.ResultRows)
+	assert.Zero(t, indexed.FullScanSteps)
+	require.NotEmpty(t, indexed.Plan)
+	assert.Contains(t, indexed.Plan[0].Detail, "USING COVERING INDEX analyzetest_indexed")
+
+	// The same filter on a column without an index examines every row
+	plain, err := runner.ExplainAnalyze(context.TODO(), "SELECT id FROM analyzetest WHERE plain = :n", sqlrunner.WithParams(map[string]any{"n": 500}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, plain.ResultRows)
+	assert.GreaterOrEqual(t, plain.FullScanSteps, int64(999))
+	assert.Greater(t, plain.VMSteps, indexed.VMSteps)
+	require.NotEmpty(t, plain.Plan)
+	assert.Equal(t, "SCAN analyzetest", plain.Plan[0].Detail)
+
+	sorted, err := runner.ExplainAnalyze(context.TODO(), "SELECT id FROM analyzetest ORDER BY plain DESC LIMIT 3")
+	require.NoError(t, err)
+	assert.Equal(t, 3, sorted.ResultRows)
+	assert.Positive(t, sorted.Sorts)
+
+	_, err = runner.ExplainAnalyze(context.TODO(), "SELECT 1; SELECT 2")
+	assert.ErrorIs(t, err, sqlrunner.ErrMultipleStatements)
+
+	_, err = runner.ExplainAnalyze(context.TODO(), "SELECT unknown FROM analyzetest")
+	assert.ErrorAs(t, err, &sqlrunner.QueryError{})
+}
+
+func TestInitTimeout(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		CREATE TABLE inittimeouttest (
+			n INT
+		);
+
+		WITH RECURSIVE c (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM c)
+		INSERT INTO inittimeouttest SELECT n FROM c LIMIT 1000000000;
+	`
+	t.Cleanup(func() {
+		_ = sqlrunner.InvalidateSchema(schema)
+	})
+
+	start := time.Now()
+	_, err := sqlrunner.NewSQLRunner(schema, sqlrunner.WithInitTimeout(100*time.Millisecond))
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	var schemaError sqlrunner.SchemaError
+	require.ErrorAs(t, err, &schemaError)
+	assert.ErrorIs(t, schemaError.Parent, sqlrunner.ErrSchemaTimeout)
+}
+
+func TestPartialResults(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE partialtest (
+			n INT
+		);
+	`)
+	require.NoError(t, err)
+
+	// Initialize the schema, so that the deadline is spent on the query
+	_, err = runner.Query(context.TODO(), "SELECT n FROM partialtest")
+	require.NoError(t, err)
+
+	// The first row is returned at once, and then a row every 100,000
+	// iterations, without end
+	query := `
+		WITH RECURSIVE c (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM c)
+		SELECT n FROM c WHERE n = 1 OR n % 100000 = 0
+	`
+	queryWithTimeout := func(opts ...sqlrunner.QueryOption) (*sqlrunner.QueryResult, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		return runner.Query(ctx, query, opts...)
+	}
+
+	t.Run("Partial", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := queryWithTimeout(sqlrunner.WithPartialResults(true))
+		require.NoError(t, err)
+		assert.True(t, result.Partial)
+		require.NotEmpty(t, result.Rows)
+		assert.Equal(t, []string{"1"}, result.Rows[0])
+
+		// The partial result is not cached
+		result, err = queryWithTimeout(sqlrunner.WithPartialResults(true))
+		require.NoError(t, err)
+		assert.True(t, result.Partial)
+		require.NotEmpty(t, result.Rows)
+		assert.Equal(t, []string{"1"}, result.Rows[0])
+		assert.False(t, result.FromCache)
+	})
+
+	t.Run("Error by default", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := queryWithTimeout()
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,352 @@
+package sqlrunner
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SplitStatements splits a script into its statements, without the
+// terminating semicolons, like QueryMulti. Empty statements, which have
+// only whitespace and comments, are skipped.
+//
+// The semicolons in strings, quoted identifiers, comments, and the
+// BEGIN ... END body of a CREATE TRIGGER statement do not end a statement.
+func SplitStatements(script string) []string {
+	tokens := tokenize(script)
+
+	var statements []string
+	start := 0 // index of the first token of the statement
+	empty := true
+	trigger := false
+	depth := 0 // nesting level of BEGIN and CASE in a trigger body
+
+	for i, t := range tokens {
+		switch {
+		case t.kind == tokenWhitespace || t.kind == tokenComment:
+			continue
+		case t.kind == tokenSemicolon && depth == 0:
+			if !empty {
+				statements = append(statements, statementText(script, tokens[start:i]))
+			}
+			start = i + 1
+			empty = true
+			trigger = false
+			continue
+		case t.is("TRIGGER") && !empty && isCreateStatement(tokens, i):
+			trigger = true
+		case trigger && (t.is("BEGIN") || t.is("CASE")):
+			depth++
+		case trigger && t.is("END") && depth > 0:
+			depth--
+		}
+
+		empty = false
+	}
+
+	if !empty {
+		statements = append(statements, statementText(script, tokens[start:]))
+	}
+
+	return statements
+}
+
+// statementText returns the text of the tokens of a statement without the
+// leading and trailing whitespace, including the newline ending a trailing
+// comment. The whitespace in an unterminated string is kept.
+func statementText(script string, tokens []token) string {
+	for len(tokens) > 0 && tokens[0].kind == tokenWhitespace {
+		tokens = tokens[1:]
+	}
+	for len(tokens) > 0 && tokens[len(tokens)-1].kind == tokenWhitespace {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	first, last := tokens[0], tokens[len(tokens)-1]
+	text := script[first.pos : last.pos+len(last.text)]
+	if last.kind == tokenComment {
+		text = strings.TrimRightFunc(text, unicode.IsSpace)
+	}
+
+	return text
+}
+
+// isCreateStatement reports whether the statement containing the i-th token
+// starts with CREATE.
+func isCreateStatement(tokens []token, i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		if tokens[j].kind == tokenSemicolon {
+			break
+		}
+		if tokens[j].kind == tokenIdentifier && tokens[j].is("CREATE") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rowStatementKeywords are the leading keywords of the statements producing rows.
+var rowStatementKeywords = map[string]bool{
+	"SELECT":  true,
+	"VALUES":  true,
+	"PRAGMA":  true,
+	"EXPLAIN": true,
+	// The MySQL commands translated by translateCommand
+	"DESCRIBE": true,
+	"DESC":     true,
+	"SHOW":     true,
+}
+
+// modificationKeywords are the leading keywords of the statements modifying rows.
+var modificationKeywords = map[string]bool{
+	"INSERT":  true,
+	"REPLACE": true,
+	"UPDATE":  true,
+	"DELETE":  true,
+	// TRUNCATE TABLE is emulated with DELETE, see truncatedTable
+	"TRUNCATE": true,
+}
+
+// returnsRows reports whether the statement produces rows, such as
+// SELECT and INSERT ... RETURNING, or only modifies the database,
+// such as INSERT.
+func returnsRows(statement string) bool {
+	tokens := tokenize(statement)
+	return rowStatementKeywords[mainKeyword(tokens)] || hasReturning(tokens)
+}
+
+// isModification reports whether the statement modifies rows,
+// such as INSERT, UPDATE, and DELETE.
+func isModification(statement string) bool {
+	return modificationKeywords[mainKeyword(tokenize(statement))]
+}
+
+// hasReturning reports whether the statement modifies rows
+// and returns them with a RETURNING clause.
+func hasReturning(tokens []token) bool {
+	if !modificationKeywords[mainKeyword(tokens)] {
+		return false
+	}
+
+	depth := 0
+	for _, t := range tokens {
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth == 0 && t.is("RETURNING"):
+			return true
+		}
+	}
+
+	return false
+}
+
+// mainKeyword returns the upper-cased keyword the statement starts with.
+// For a statement with a WITH clause, it is the keyword following the
+// common table expressions, such as SELECT or INSERT.
+func mainKeyword(tokens []token) string {
+	i := nextSignificant(tokens, -1)
+	if i < 0 || tokens[i].kind != tokenIdentifier {
+		return ""
+	}
+	if !tokens[i].is("WITH") {
+		return strings.ToUpper(tokens[i].text)
+	}
+
+	depth := 0
+	for _, t := range tokens[i+1:] {
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth == 0 && t.kind == tokenIdentifier:
+			keyword := strings.ToUpper(t.text)
+			switch keyword {
+			case "SELECT", "VALUES", "INSERT", "REPLACE", "UPDATE", "DELETE":
+				return keyword
+			}
+		}
+	}
+
+	return ""
+}
+
+// classifyStatement returns the type of the last statement of the query,
+// whose result Query returns, or empty if it has no statements.
+func classifyStatement(query string) StatementType {
+	statements := SplitStatements(query)
+	if len(statements) == 0 {
+		return ""
+	}
+
+	switch mainKeyword(tokenize(statements[len(statements)-1])) {
+	case "SELECT", "VALUES":
+		return StatementSelect
+	case "INSERT", "REPLACE":
+		return StatementInsert
+	case "UPDATE":
+		return StatementUpdate
+	case "DELETE", "TRUNCATE":
+		return StatementDelete
+	case "CREATE":
+		return StatementCreate
+	case "PRAGMA":
+		return StatementPragma
+	default:
+		return StatementOther
+	}
+}
+
+// checkPolicy returns a PolicyError if the script has a statement
+// whose type is not allowed, a PRAGMA which is not allowed, or a call to a
+// disabled function.
+func (o options) checkPolicy(script string) error {
+	for _, statement := range SplitStatements(script) {
+		tokens := tokenize(statement)
+		statementType := mainKeyword(tokens)
+		if o.allowedStatements != nil && !o.allowedStatements[statementType] {
+			return PolicyError{StatementType: statementType}
+		}
+
+		// SQLite applies a PRAGMA when compiling it, so EXPLAIN PRAGMA runs it
+		if explained := explainedStatement(tokens); mainKeyword(explained) == "PRAGMA" {
+			if pragma := pragmaName(explained); !o.allowedPragmas[pragma] {
+				return PolicyError{StatementType: statementType, Pragma: pragma}
+			}
+		}
+		if pragma, ok := o.disallowedPragmaFunction(tokens); ok {
+			return PolicyError{StatementType: statementType, Pragma: pragma}
+		}
+		if function, ok := o.disabledFunctionCall(statement); ok {
+			return PolicyError{StatementType: statementType, Function: function}
+		}
+	}
+
+	return nil
+}
+
+// explainedStatement returns the tokens of the statement explained by an
+// EXPLAIN or EXPLAIN QUERY PLAN statement, or the tokens of any other
+// statement as is.
+func explainedStatement(tokens []token) []token {
+	i := nextSignificant(tokens, -1)
+	if i < 0 || !tokens[i].is("EXPLAIN") {
+		return tokens
+	}
+
+	i = nextSignificant(tokens, i)
+	if i >= 0 && tokens[i].is("QUERY") {
+		if next := nextSignificant(tokens, i); next >= 0 && tokens[next].is("PLAN") {
+			i = nextSignificant(tokens, next)
+		}
+	}
+	if i < 0 {
+		return nil
+	}
+
+	return tokens[i:]
+}
+
+// pragmaName returns the lower-cased name of the PRAGMA statement,
+// without the schema name, such as table_info for PRAGMA main.table_info(t).
+func pragmaName(tokens []token) string {
+	var name string
+	for i := nextSignificant(tokens, nextSignificant(tokens, -1)); i >= 0; i = nextSignificant(tokens, i) {
+		if !isIdentifierToken(tokens[i]) {
+			break
+		}
+		name = unquoteIdentifier(tokens[i])
+
+		// schema.name
+		next := nextSignificant(tokens, i)
+		if next < 0 || !tokens[next].is(".") {
+			break
+		}
+		i = next
+	}
+
+	return strings.ToLower(name)
+}
+
+// disallowedPragmaFunction returns the name of the PRAGMA whose table-valued
+// function, such as pragma_database_list, the statement uses but which is
+// not allowed.
+func (o options) disallowedPragmaFunction(tokens []token) (string, bool) {
+	for _, t := range tokens {
+		if !isIdentifierToken(t) {
+			continue
+		}
+
+		name := strings.ToLower(unquoteIdentifier(t))
+		if pragma, ok := strings.CutPrefix(name, "pragma_"); ok && !o.allowedPragmas[pragma] {
+			return pragma, true
+		}
+	}
+
+	return "", false
+}
+
+// operatorFunctions are the operators SQLite evaluates by calling the
+// function of the same name, such as x REGEXP y by regexp(y, x).
+var operatorFunctions = map[string]bool{
+	"LIKE":   true,
+	"GLOB":   true,
+	"REGEXP": true,
+	"MATCH":  true,
+}
+
+// disabledFunctionCall returns the upper-cased name of the disabled
+// function the statement calls, if any. The statement is checked both as
+// written and as rewritten, since the rewrite calls the functions for the
+// MySQL syntax, such as DATE_ADD for d + INTERVAL 1 DAY and
+// json_group_array for JSON_ARRAYAGG, and the operators call the functions
+// too, such as x RLIKE y.
+func (o options) disabledFunctionCall(statement string) (string, bool) {
+	if len(o.disabledFunctions) == 0 {
+		return "", false
+	}
+
+	rewritten, _ := o.rewrite(statement)
+	for _, query := range []string{statement, rewritten} {
+		tokens := tokenize(query)
+		for i, t := range tokens {
+			if !isIdentifierToken(t) {
+				continue
+			}
+
+			name := strings.ToUpper(unquoteIdentifier(t))
+			if !o.disabledFunctions[name] {
+				continue
+			}
+			if next := nextSignificant(tokens, i); next >= 0 && tokens[next].is("(") {
+				return name, true
+			}
+			if t.kind == tokenIdentifier && operatorFunctions[name] {
+				return name, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// checkSingleStatement returns ErrMultipleStatements if the single statement
+// guard is enabled and the query has more than one statement. The comments
+// and the empty statements are skipped by SplitStatements.
+func (o options) checkSingleStatement(query string) error {
+	if !o.singleStatement {
+		return nil
+	}
+
+	if len(SplitStatements(query)) > 1 {
+		return ErrMultipleStatements
+	}
+
+	return nil
+}
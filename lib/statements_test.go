@@ -0,0 +1,125 @@
+package sqlrunner
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		script   string
+		expected []string
+	}{
+		"Single statement": {
+			script:   "SELECT 1",
+			expected: []string{"SELECT 1"},
+		},
+		"Multiple statements": {
+			script:   "SELECT 1; SELECT 2;",
+			expected: []string{"SELECT 1", "SELECT 2"},
+		},
+		"Semicolons in strings and identifiers": {
+			script:   `SELECT 'a;b' AS "c;d"; SELECT [e;f]`,
+			expected: []string{`SELECT 'a;b' AS "c;d"`, "SELECT [e;f]"},
+		},
+		"Semicolons in comments": {
+			script:   "SELECT 1 -- one; two\n; /* ; */ SELECT 2",
+			expected: []string{"SELECT 1 -- one; two", "/* ; */ SELECT 2"},
+		},
+		"Semicolon literal": {
+			script:   "SELECT ';'; SELECT 'it''s;'",
+			expected: []string{"SELECT ';'", "SELECT 'it''s;'"},
+		},
+		"Unterminated string": {
+			script:   "SELECT 1; SELECT 'a; b",
+			expected: []string{"SELECT 1", "SELECT 'a; b"},
+		},
+		"Empty statements": {
+			script:   " ; SELECT 1;; -- trailing\n",
+			expected: []string{"SELECT 1"},
+		},
+		"Trigger body": {
+			script: "CREATE TRIGGER t AFTER INSERT ON a BEGIN INSERT INTO b VALUES (CASE WHEN 1 THEN 2 END); DELETE FROM c; END; SELECT 1",
+			expected: []string{
+				"CREATE TRIGGER t AFTER INSERT ON a BEGIN INSERT INTO b VALUES (CASE WHEN 1 THEN 2 END); DELETE FROM c; END",
+				"SELECT 1",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, SplitStatements(tc.script))
+		})
+	}
+}
+
+func FuzzSplitStatements(f *testing.F) {
+	for _, seed := range lexerSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, script string) {
+		tokens := tokenize(script)
+
+		// The offsets a statement may start or end at: the token
+		// boundaries, and the trailing whitespace of a comment, which
+		// is trimmed
+		boundaries := map[int]bool{len(script): true}
+		for _, token := range tokens {
+			boundaries[token.pos] = true
+			if token.kind == tokenComment {
+				trimmed := strings.TrimRightFunc(token.text, unicode.IsSpace)
+				for end := token.pos + len(trimmed); end <= token.pos+len(token.text); end++ {
+					boundaries[end] = true
+				}
+			}
+		}
+
+		offset := 0
+		for _, statement := range SplitStatements(script) {
+			if statement == "" {
+				t.Fatal("empty statement")
+			}
+
+			// The statements are the substrings of the script in order,
+			// which do not split a string, an identifier, or a comment.
+			start := strings.Index(script[offset:], statement)
+			if start < 0 {
+				t.Fatalf("statement %q not found after %d", statement, offset)
+			}
+			start += offset
+			offset = start + len(statement)
+			if !boundaries[start] || !boundaries[offset] {
+				t.Fatalf("statement %q splits a token", statement)
+			}
+		}
+	})
+}
+
+func TestReturnsRows(t *testing.T) {
+	t.Parallel()
+
+	for statement, expected := range map[string]bool{
+		"SELECT 1":                             true,
+		"  -- comment\n select 1":              true,
+		"VALUES (1)":                           true,
+		"WITH a AS (SELECT 1) SELECT * FROM a": true,
+		"WITH a AS (SELECT 1) INSERT INTO b SELECT 1": false,
+		"INSERT INTO a VALUES (1)":                    false,
+		"UPDATE a SET b = (SELECT 1)":                 false,
+		"CREATE TABLE a (b INT)":                      false,
+		"":                                            false,
+	} {
+		t.Run(statement, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, expected, returnsRows(statement))
+		})
+	}
+}
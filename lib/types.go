@@ -1,9 +1,191 @@
 package sqlrunner
 
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
 // QueryResult is a struct that holds the result of a query
 type QueryResult struct {
 	// Columns is a slice of column names
 	Columns []string `json:"columns"`
+	// ColumnTypes is a slice of the declared types of the columns,
+	// which is empty for expressions
+	ColumnTypes []string `json:"-"`
+	// ColumnMetadata is a slice of the origins of the columns of a SELECT,
+	// see WithColumnMetadata
+	ColumnMetadata []ColumnMetadata `json:"column_metadata,omitempty"`
 	// Rows is a slice of rows, each row is a slice of strings
 	Rows [][]string `json:"rows"`
+	// Warnings is a slice of non-fatal warnings about the query
+	Warnings []string `json:"warnings,omitempty"`
+	// RowsAffected is the number of rows modified by a statement
+	// which does not return rows, such as INSERT
+	RowsAffected int64 `json:"rows_affected,omitempty"`
+	// StatementType is the type of the statement of the result,
+	// which is empty for a query without statements
+	StatementType StatementType `json:"statement_type,omitempty"`
+	// Partial reports whether the query timed out while its rows were
+	// read, and Rows has the rows read so far, see WithPartialResults
+	Partial bool `json:"partial,omitempty"`
+	// CacheStatus reports how the result was produced
+	CacheStatus CacheStatus `json:"-"`
+	// FromCache reports whether the result was served from the cache
+	FromCache bool `json:"-"`
+}
+
+// CacheStatus reports whether a result was served from the cache
+type CacheStatus string
+
+const (
+	// CacheHit means the result was served from the cache of the runner
+	CacheHit CacheStatus = "hit"
+	// CacheDisk means the result was served from the disk cache,
+	// see WithDiskCache
+	CacheDisk CacheStatus = "disk"
+	// CacheWarm means the query was executed by a runner
+	// which had executed queries before
+	CacheWarm CacheStatus = "warm"
+	// CacheCold means the query was the first one executed by the runner
+	CacheCold CacheStatus = "cold"
+)
+
+// StatementType is the coarse type of a statement, for the frontends to
+// badge a query
+type StatementType string
+
+const (
+	// StatementSelect is a statement returning rows, including
+	// a VALUES statement and a SELECT statement with a WITH clause
+	StatementSelect StatementType = "SELECT"
+	// StatementInsert is an INSERT or a REPLACE statement
+	StatementInsert StatementType = "INSERT"
+	StatementUpdate StatementType = "UPDATE"
+	// StatementDelete is a DELETE or a TRUNCATE TABLE statement
+	StatementDelete StatementType = "DELETE"
+	StatementCreate StatementType = "CREATE"
+	StatementPragma StatementType = "PRAGMA"
+	// StatementOther is any other statement, such as DROP and SHOW TABLES
+	StatementOther StatementType = "OTHER"
+)
+
+// ColumnarQueryResult is a column-oriented form of QueryResult
+type ColumnarQueryResult struct {
+	// Columns is a slice of columns, each holds the values of all rows
+	Columns []ColumnData `json:"columns"`
+	// Warnings is a slice of non-fatal warnings about the query
+	Warnings []string `json:"warnings,omitempty"`
+	// StatementType is the type of the statement of the result
+	StatementType StatementType `json:"statement_type,omitempty"`
+	// Partial reports whether the result has the rows read so far only
+	Partial bool `json:"partial,omitempty"`
+}
+
+// ColumnData is a column of a ColumnarQueryResult
+type ColumnData struct {
+	// Name is the column name
+	Name string `json:"name"`
+	// Type is the declared type of the column, which is empty for expressions
+	Type string `json:"type"`
+	// Values is a slice of the cells of the column in the row order
+	Values []string `json:"values"`
+}
+
+// Columnar converts the result to the column-oriented form.
+func (r *QueryResult) Columnar() *ColumnarQueryResult {
+	columns := make([]ColumnData, len(r.Columns))
+	for i, name := range r.Columns {
+		columns[i] = ColumnData{
+			Name:   name,
+			Values: make([]string, 0, len(r.Rows)),
+		}
+		if i < len(r.ColumnTypes) {
+			columns[i].Type = r.ColumnTypes[i]
+		}
+	}
+
+	for _, row := range r.Rows {
+		for i, cell := range row {
+			columns[i].Values = append(columns[i].Values, cell)
+		}
+	}
+
+	return &ColumnarQueryResult{
+		Columns:       columns,
+		Warnings:      r.Warnings,
+		StatementType: r.StatementType,
+		Partial:       r.Partial,
+	}
+}
+
+// RowOriented converts the result back to the row-oriented form.
+func (r *ColumnarQueryResult) RowOriented() *QueryResult {
+	result := &QueryResult{
+		Columns:       make([]string, 0, len(r.Columns)),
+		ColumnTypes:   make([]string, 0, len(r.Columns)),
+		Rows:          [][]string{},
+		Warnings:      r.Warnings,
+		StatementType: r.StatementType,
+		Partial:       r.Partial,
+	}
+
+	for _, column := range r.Columns {
+		result.Columns = append(result.Columns, column.Name)
+		result.ColumnTypes = append(result.ColumnTypes, column.Type)
+	}
+
+	if len(r.Columns) == 0 {
+		return result
+	}
+
+	for i := range r.Columns[0].Values {
+		row := make([]string, 0, len(r.Columns))
+		for _, column := range r.Columns {
+			row = append(row, column.Values[i])
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result
+}
+
+// Distinct returns a copy of the result without the duplicate rows, keeping
+// the first of each in the row order, like SELECT DISTINCT. Two rows are
+// duplicates if all their cells are equal, and the NULL cells are equal to
+// each other, like DISTINCT. Since the cells are rendered as strings,
+// a NULL is equal to the string 'NULL' too.
+//
+// It is for the graders to compare a result with the one of a DISTINCT
+// query regardless of the duplicates.
+func (r *QueryResult) Distinct() *QueryResult {
+	result := *r
+	result.Rows = make([][]string, 0, len(r.Rows))
+
+	seen := make(map[string]bool, len(r.Rows))
+	for _, row := range r.Rows {
+		key := rowKey(row)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		result.Rows = append(result.Rows, slices.Clone(row))
+	}
+
+	return &result
+}
+
+// rowKey encodes the cells of a row with their lengths, so that the rows
+// with the same cells have the same key, and no other rows do, such as
+// ("a,b", "c") and ("a", "b,c").
+func rowKey(row []string) string {
+	var b strings.Builder
+	for _, cell := range row {
+		b.WriteString(strconv.Itoa(len(cell)))
+		b.WriteByte(':')
+		b.WriteString(cell)
+	}
+
+	return b.String()
 }
@@ -0,0 +1,118 @@
+package sqlrunner_test
+
+import (
+	"context"
+	"testing"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestColumnarQueryResult(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE columnartest (
+			id INT,
+			name TEXT
+		);
+
+		INSERT INTO columnartest (id, name) VALUES (1, 'hello');
+		INSERT INTO columnartest (id, name) VALUES (2, NULL);
+	`)
+	require.NoError(t, err)
+
+	t.Run("Round trip", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT id, name, id * 2 FROM columnartest ORDER BY id")
+		require.NoError(t, err)
+
+		columnar := result.Columnar()
+		require.Len(t, columnar.Columns, 3)
+		assert.Equal(t, sqlrunner.ColumnData{Name: "id", Type: "INT", Values: []string{"1", "2"}}, columnar.Columns[0])
+		assert.Equal(t, sqlrunner.ColumnData{Name: "name", Type: "TEXT", Values: []string{"hello", "NULL"}}, columnar.Columns[1])
+		assert.Equal(t, sqlrunner.ColumnData{Name: "id * 2", Type: "", Values: []string{"2", "4"}}, columnar.Columns[2])
+
+		assertSameResult(t, result, columnar.RowOriented())
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT id FROM columnartest WHERE id > 2")
+		require.NoError(t, err)
+
+		columnar := result.Columnar()
+		require.Len(t, columnar.Columns, 1)
+		assert.Empty(t, columnar.Columns[0].Values)
+
+		assertSameResult(t, result, columnar.RowOriented())
+	})
+}
+
+func TestQueryResultDistinct(t *testing.T) {
+	t.Parallel()
+
+	runner, err := sqlrunner.NewSQLRunner(`
+		CREATE TABLE distincttest (
+			dept TEXT,
+			grade INT
+		);
+
+		INSERT INTO distincttest (dept, grade) VALUES
+			('cs', 90), ('cs', 90), ('ee', NULL), ('cs', 80),
+			(NULL, NULL), ('ee', NULL), (NULL, NULL), ('cs', 90),
+			('a,b', NULL), ('a', NULL);
+	`)
+	require.NoError(t, err)
+
+	for _, columns := range []string{"dept, grade", "dept", "grade", "dept || ',', grade"} {
+		t.Run(columns, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := runner.Query(context.TODO(), "SELECT "+columns+" FROM distincttest")
+			require.NoError(t, err)
+			expected, err := runner.Query(context.TODO(), "SELECT DISTINCT "+columns+" FROM distincttest")
+			require.NoError(t, err)
+
+			distinct := result.Distinct()
+			assert.Equal(t, result.Columns, distinct.Columns)
+			assert.ElementsMatch(t, expected.Rows, distinct.Rows)
+		})
+	}
+
+	t.Run("NULL", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT dept, grade FROM distincttest WHERE grade IS NULL ORDER BY rowid")
+		require.NoError(t, err)
+		require.Len(t, result.Rows, 6)
+
+		// The duplicates with NULLs are collapsed in the order of their first rows
+		assert.Equal(t, [][]string{{"ee", "NULL"}, {"NULL", "NULL"}, {"a,b", "NULL"}, {"a", "NULL"}}, result.Distinct().Rows)
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := runner.Query(context.TODO(), "SELECT dept FROM distincttest WHERE grade = 80")
+		require.NoError(t, err)
+
+		distinct := result.Distinct()
+		distinct.Rows[0][0] = "changed"
+		assert.Equal(t, [][]string{{"cs"}}, result.Rows)
+	})
+}
+
+// assertSameResult asserts the results have the same content,
+// regardless of how they were produced.
+func assertSameResult(t *testing.T, expected, actual *sqlrunner.QueryResult) {
+	t.Helper()
+
+	assert.Equal(t, expected.Columns, actual.Columns)
+	assert.Equal(t, expected.ColumnTypes, actual.ColumnTypes)
+	assert.Equal(t, expected.Rows, actual.Rows)
+	assert.Equal(t, expected.Warnings, actual.Warnings)
+}
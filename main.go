@@ -1,26 +1,30 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Depado/ginprom"
 	sqlrunner "github.com/database-playground/sqlrunner/lib"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	sloggin "github.com/samber/slog-gin"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
-	"golang.org/x/sync/singleflight"
 )
 
 var tracer = otel.Tracer("sqlrunner")
@@ -34,11 +38,7 @@ func main() {
 		addr = ":" + os.Getenv("PORT")
 	}
 
-	shutdown, err := setupOTelSDK(ctx)
-	if err != nil {
-		slog.Error("Failed to setup OpenTelemetry", slog.Any("error", err))
-		os.Exit(1)
-	}
+	shutdown := setupOTelSDK(ctx)
 	defer func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -47,20 +47,266 @@ func main() {
 		}
 	}()
 
+	permissions := sqlrunner.DefaultFilePermissions
+	if mode, ok := fileModeEnv("TMP_DIR_MODE"); ok {
+		permissions.Dir = mode
+	}
+	if mode, ok := fileModeEnv("TMP_FILE_MODE"); ok {
+		permissions.File = mode
+	}
+	sqlrunner.SetFilePermissions(permissions)
+
+	serverInfo := sqlrunner.DefaultServerInfo
+	if version := os.Getenv("SERVER_VERSION"); version != "" {
+		serverInfo.Version = version
+	}
+	if database := os.Getenv("DATABASE_NAME"); database != "" {
+		serverInfo.Database = database
+	}
+	sqlrunner.SetServerInfo(serverInfo)
+
+	var serviceOpts []sqlrunner.ServiceOption
+	if capacity, ok := intEnv("RUNNER_CAPACITY", 1); ok {
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerCapacity(capacity))
+	}
+	if maxBytes, ok := intEnv("DISK_CACHE_MAX_BYTES", 1); ok {
+		var ttl time.Duration
+		if value := os.Getenv("DISK_CACHE_TTL"); value != "" {
+			var err error
+			if ttl, err = time.ParseDuration(value); err != nil || ttl < 0 {
+				slog.Error("Invalid DISK_CACHE_TTL", slog.String("value", value))
+				os.Exit(1)
+			}
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithDiskCache(int64(maxBytes), ttl)))
+	}
+	if value := os.Getenv("QUERY_CACHE_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			slog.Error("Invalid QUERY_CACHE_TTL", slog.String("value", value))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithCacheTTL(ttl)))
+	}
+	if value := os.Getenv("SCHEMA_INIT_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			slog.Error("Invalid SCHEMA_INIT_TIMEOUT", slog.String("value", value))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithInitTimeout(timeout)))
+	}
+	if format := sqlrunner.BooleanFormat(os.Getenv("BOOLEAN_FORMAT")); format != "" {
+		if format != sqlrunner.BooleanNumeric && format != sqlrunner.BooleanKeyword {
+			slog.Error("Invalid BOOLEAN_FORMAT", slog.String("value", string(format)))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithBooleanFormat(format)))
+	}
+	if maxLen, ok := intEnv("GROUP_CONCAT_MAX_LEN", 1); ok {
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithGroupConcatMaxLen(maxLen)))
+	}
+	if value, ok := os.LookupEnv("ALLOWED_PRAGMAS"); ok {
+		var pragmas []string
+		for pragma := range strings.SplitSeq(value, ",") {
+			if pragma = strings.TrimSpace(pragma); pragma != "" {
+				pragmas = append(pragmas, pragma)
+			}
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithAllowedPragmas(pragmas...)))
+	}
+	if value := os.Getenv("DISABLED_FUNCTIONS"); value != "" {
+		var functions []string
+		for function := range strings.SplitSeq(value, ",") {
+			if function = strings.TrimSpace(function); function != "" {
+				functions = append(functions, function)
+			}
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithDisabledFunctions(functions...)))
+	}
+	if mode := sqlrunner.IntegerOverflow(os.Getenv("INTEGER_OVERFLOW")); mode != "" {
+		if mode != sqlrunner.OverflowFloat && mode != sqlrunner.OverflowError && mode != sqlrunner.OverflowWrap {
+			slog.Error("Invalid INTEGER_OVERFLOW", slog.String("value", string(mode)))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithIntegerOverflow(mode)))
+	}
+	if mode := sqlrunner.OrderCheck(os.Getenv("ORDER_CHECK")); mode != "" {
+		if mode != sqlrunner.OrderCheckOff && mode != sqlrunner.OrderCheckWarn && mode != sqlrunner.OrderCheckError {
+			slog.Error("Invalid ORDER_CHECK", slog.String("value", string(mode)))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithOrderCheck(mode)))
+	}
+	if value := os.Getenv("STRICT_DIVISION"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Error("Invalid STRICT_DIVISION", slog.String("value", value))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithStrictDivision(enabled)))
+	}
+	if value := os.Getenv("CARTESIAN_WARNINGS"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Error("Invalid CARTESIAN_WARNINGS", slog.String("value", value))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithCartesianWarning(enabled)))
+	}
+	if value := os.Getenv("INDEX_WARNINGS"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Error("Invalid INDEX_WARNINGS", slog.String("value", value))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithIndexWarning(enabled)))
+	}
+	if value := os.Getenv("COLUMN_METADATA"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Error("Invalid COLUMN_METADATA", slog.String("value", value))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithColumnMetadata(enabled)))
+	}
+	if value := os.Getenv("EMPTY_RESULT_HINTS"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Error("Invalid EMPTY_RESULT_HINTS", slog.String("value", value))
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithEmptyResultHints(enabled)))
+	}
+	if size, ok := intEnv("QUERY_CACHE_SIZE", 1); ok {
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithCacheSize(size)))
+	}
+	if maxColumns, ok := intEnv("MAX_RESULT_COLUMNS", 1); ok {
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithMaxColumns(maxColumns)))
+	}
+	if maxBytes, ok := intEnv("MAX_RESPONSE_BYTES", 0); ok {
+		serviceOpts = append(serviceOpts, sqlrunner.WithRunnerOptions(sqlrunner.WithMaxResponseBytes(maxBytes)))
+	}
+	if limit, ok := intEnv("MAX_CONCURRENT_QUERIES", 1); ok {
+		queueSize, _ := intEnv("QUERY_QUEUE_SIZE", 0)
+		serviceOpts = append(serviceOpts, sqlrunner.WithConcurrencyLimit(limit, queueSize))
+	}
+
+	r := newRouter(nil, serviceOpts...)
+	if enabled, _ := strconv.ParseBool(os.Getenv("ENABLE_DEBUG_ENDPOINTS")); enabled {
+		registerDebugRoutes(r)
+	}
+
+	drainTimeout := queryTimeout
+	if value := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"); value != "" {
+		var err error
+		if drainTimeout, err = time.ParseDuration(value); err != nil || drainTimeout < 0 {
+			slog.Error("Invalid SHUTDOWN_DRAIN_TIMEOUT", slog.String("value", value))
+			os.Exit(1)
+		}
+	}
+
+	drain := &drainer{}
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: drain.handler(r),
+	}
+
+	go func() {
+		slog.Info("Starting server", slog.String("address", addr))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("ListenAndServe failed", slog.Any("error", err))
+			panic(err)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("Received signal to shutdown")
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+	if err := drain.drain(drainCtx); err != nil {
+		slog.Warn("Requests still in flight after the drain timeout", slog.Duration("timeout", drainTimeout))
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Shutdown failed", slog.Any("error", err))
+	}
+}
+
+// intEnv parses the integer environment variable of the name, if set.
+// It exits if the value is not an integer of at least the minimum.
+func intEnv(name string, minimum int) (int, bool) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < minimum {
+		slog.Error("Invalid "+name, slog.String("value", value))
+		os.Exit(1)
+	}
+
+	return n, true
+}
+
+// fileModeEnv parses the octal permission bits in the environment variable
+// of the name, if set. It exits if the value is not valid.
+func fileModeEnv(name string) (fs.FileMode, bool) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, false
+	}
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > uint64(fs.ModePerm) {
+		slog.Error("Invalid "+name, slog.String("value", value))
+		os.Exit(1)
+	}
+
+	return fs.FileMode(mode), true
+}
+
+// rateEnv parses the fraction from 0 to 1 in the environment variable of
+// the name, if set. It exits if the value is not valid.
+func rateEnv(name string) (float64, bool) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, false
+	}
+
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		slog.Error("Invalid "+name, slog.String("value", value))
+		os.Exit(1)
+	}
+
+	return rate, true
+}
+
+// newRouter creates the HTTP router of the service.
+// The metrics are registered to the registry, or the default one if nil.
+func newRouter(registry *prometheus.Registry, serviceOpts ...sqlrunner.ServiceOption) *gin.Engine {
 	r := gin.Default()
 	p := ginprom.New(
 		ginprom.Engine(r),
 		ginprom.Path("/metrics"),
+		ginprom.Registry(registry),
 	)
 	r.Use(gin.Recovery())
 	r.Use(gin.ErrorLogger())
 	r.Use(p.Instrument())
 	r.Use(otelgin.Middleware("sqlrunner"))
+	r.Use(requestIDMiddleware)
 
 	config := sloggin.Config{
 		WithSpanID:    true,
 		WithTraceID:   true,
 		WithUserAgent: true,
+		WithRequestID: true,
 	}
 	r.Use(sloggin.NewWithConfig(slog.Default(), config))
 
@@ -71,45 +317,342 @@ func main() {
 		c.Next()
 	})
 
-	p.AddCustomCounter("query_requests_total", "The total number of SQL query requests.", []string{"code"})
+	p.AddCustomCounter("query_requests_total", "The total number of SQL query requests.", []string{"code", "cache"})
 	p.AddCustomHistogram("query_requests_duration_seconds", "The duration of each SQL query request.", []string{"code"})
-
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: r,
-	}
+	p.AddCustomCounter("schema_init_failures_total", "The total number of schemas failed to initialize.", []string{"category"})
+	p.AddCustomCounter("query_limit_events_total", "The total number of queries queued, rejected, or acquiring a slot by the concurrency limit.", []string{"event"})
+	p.AddCustomCounter("query_cache_evictions_total", "The total number of cached results evicted to make room for others.", nil)
 
 	r.GET("/healthz", func(c *gin.Context) {
 		c.String(http.StatusOK, "OK")
 	})
 
+	r.GET("/functions", func(c *gin.Context) {
+		c.JSON(http.StatusOK, sqlrunner.RegisteredFunctions())
+	})
+
+	serviceOpts = append([]sqlrunner.ServiceOption{
+		sqlrunner.WithRunnerOptions(sqlrunner.WithEvictionObserver(func() {
+			p.IncrementCounterValue("query_cache_evictions_total", nil)
+		})),
+		sqlrunner.WithLimitObserver(func(event sqlrunner.LimitEvent) {
+			p.IncrementCounterValue("query_limit_events_total", []string{string(event)})
+		}),
+	}, serviceOpts...)
+	runners, err := sqlrunner.NewService(serviceOpts...)
+	if err != nil {
+		panic(err)
+	}
+
 	service := &SqlQueryService{
 		p:       p,
-		sfgroup: singleflight.Group{},
+		runners: runners,
 	}
-	r.POST("/query", service.Serve)
+	accessLog := accessLogger{logger: slog.Default()}
+	accessLog.rate, _ = rateEnv("ACCESS_LOG_SAMPLE_RATE")
+	accessLog.queryText, _ = strconv.ParseBool(os.Getenv("ACCESS_LOG_QUERY_TEXT"))
 
-	go func() {
-		slog.Info("Starting server", slog.String("address", addr))
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("ListenAndServe failed", slog.Any("error", err))
-			panic(err)
-		}
+	r.POST("/query", accessLog.middleware, newIdempotency().middleware, service.Serve)
+	r.POST("/query/events", accessLog.middleware, service.ServeEvents)
+	r.GET("/schema/:hash", service.ServeSchema)
+	r.POST("/schema/diff", service.ServeSchemaDiff)
+	r.GET("/schemas/:name", service.ServeNamedSchema)
+	r.PUT("/schemas/:name", service.ServeRegisterSchema)
+	r.GET("/export", service.ServeExport)
+	r.POST("/check", service.ServeCheck)
+	r.POST("/benchmark", accessLog.middleware, service.ServeBenchmark)
+	r.POST("/analyze", accessLog.middleware, service.ServeAnalyze)
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		registerAdminRoutes(r, service, token)
+	}
+
+	return r
+}
+
+// queryTimeout is the maximum duration of a query.
+const queryTimeout = time.Minute
+
+// maxExportBytes is the maximum size of a database to export.
+const maxExportBytes = 64 << 20
+
+// contentTypeSQLite is the content type of a SQLite database file.
+const contentTypeSQLite = "application/x-sqlite3"
+
+// ServeExport returns the SQLite database file the schema in the `schema`
+// query parameter is initialized into, to download it for offline study.
+func (s *SqlQueryService) ServeExport(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "SqlQueryService.ServeExport")
+	defer span.End()
+
+	schema := c.Query("schema")
+	if schema == "" {
+		span.SetStatus(codes.Error, "bad payload")
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("schema is required")))
+		return
+	}
+
+	runner, err := s.runners.Runner(schema)
+	if err != nil {
+		span.SetStatus(codes.Error, "runner find error")
+		span.RecordError(err)
+
+		c.JSON(http.StatusInternalServerError, failedResponse(c, err))
+		return
+	}
+
+	database, size, err := runner.ExportDatabase(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, "export error")
+		span.RecordError(err)
+
+		c.JSON(http.StatusInternalServerError, failedResponse(c, err))
+		return
+	}
+	defer func() {
+		_ = database.Close()
 	}()
 
-	<-ctx.Done()
-	slog.Info("Received signal to shutdown")
+	if size > maxExportBytes {
+		span.SetStatus(codes.Error, "too large")
+		c.JSON(http.StatusRequestEntityTooLarge, failedResponse(c, TooLargeError{Resource: "database", Limit: maxExportBytes}))
+		return
+	}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	span.SetStatus(codes.Ok, "success")
+	c.DataFromReader(http.StatusOK, size, contentTypeSQLite, database, map[string]string{
+		"Content-Disposition": `attachment; filename="` + runner.SchemaHash() + `.db"`,
+		"X-Schema-Hash":       runner.SchemaHash(),
+	})
+}
+
+// ServeCheck validates the query against the schema without executing it,
+// such as to give instant feedback in a SQL editor.
+func (s *SqlQueryService) ServeCheck(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "SqlQueryService.ServeCheck")
+	defer span.End()
+
+	var req QueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(err)
+
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, BadPayloadError{Parent: err}))
+		return
+	}
+	if err := req.validate(); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, err))
+		return
+	}
+
+	runner, err := s.runner(req)
+	if err != nil {
+		span.SetStatus(codes.Error, "runner find error")
+		span.RecordError(err)
+
+		c.JSON(runnerErrorStatus(err), failedResponse(c, err))
+		return
+	}
+
+	c.Header("X-Schema-Hash", runner.SchemaHash())
+
+	err = runner.Prepare(ctx, req.Query)
+
+	var prepareError sqlrunner.PrepareError
+	switch {
+	case err == nil:
+		span.SetStatus(codes.Ok, "valid")
+		c.JSON(http.StatusOK, NewSuccessResponse(CheckResponse{Valid: true}))
+	case errors.As(err, &prepareError):
+		span.SetStatus(codes.Ok, "invalid")
+		c.JSON(http.StatusOK, NewSuccessResponse(CheckResponse{
+			Valid: false,
+			Error: &CheckError{
+				Category: prepareError.Category,
+				Message:  prepareError.Parent.Error(),
+				Line:     prepareError.Line,
+				Column:   prepareError.Column,
+			},
+		}))
+	case errors.As(err, &sqlrunner.SchemaError{}):
+		span.SetStatus(codes.Error, "schema error")
+		span.RecordError(err)
+
+		c.JSON(http.StatusInternalServerError, failedResponse(c, err))
+	default:
+		span.SetStatus(codes.Error, "check error")
+		span.RecordError(err)
+
+		c.JSON(http.StatusBadRequest, failedResponse(c, err))
+	}
+}
+
+// defaultBenchmarkRuns is the number of runs of a benchmark request
+// without the runs field.
+const defaultBenchmarkRuns = 10
+
+// ServeBenchmark executes the query of the payload repeatedly, bypassing
+// the cache, and returns the statistics of the execution durations with the
+// result of the first run, such as for the lessons on performance tuning.
+func (s *SqlQueryService) ServeBenchmark(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "SqlQueryService.ServeBenchmark")
+	defer span.End()
+
+	req := BenchmarkRequest{Runs: defaultBenchmarkRuns}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(err)
+
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, BadPayloadError{Parent: err}))
+		return
+	}
+	if err := req.validate(); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, err))
+		return
+	}
+	if req.Runs < 1 || req.Runs > sqlrunner.MaxBenchmarkRuns {
+		span.SetStatus(codes.Error, "bad payload")
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError(
+			fmt.Sprintf("runs must be between 1 and %d", sqlrunner.MaxBenchmarkRuns))))
+		return
+	}
+
+	runner, err := s.runner(req.QueryRequest)
+	if err != nil {
+		span.SetStatus(codes.Error, "runner find error")
+		span.RecordError(err)
+
+		c.JSON(runnerErrorStatus(err), failedResponse(c, err))
+		return
+	}
+
+	c.Header("X-Schema-Hash", runner.SchemaHash())
+	accessLogEntry := &accessLogEntry{schemaHash: runner.SchemaHash(), query: req.Query}
+	setAccessLogEntry(c, accessLogEntry)
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
 	defer cancel()
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		slog.Error("Shutdown failed", slog.Any("error", err))
+
+	benchmark, err := runner.Benchmark(queryCtx, req.Query, req.Runs, req.queryOptions(sqlrunner.BlobHex, false)...)
+	if err != nil {
+		span.SetStatus(codes.Error, "benchmark error")
+		span.RecordError(err)
+
+		switch {
+		case errors.Is(err, sqlrunner.ErrTooManyQueries):
+			c.JSON(http.StatusTooManyRequests, failedResponse(c, err))
+		case errors.As(err, &sqlrunner.ParameterError{}):
+			c.JSON(http.StatusUnprocessableEntity, failedResponse(c, err))
+		default:
+			c.JSON(http.StatusBadRequest, failedResponse(c, err))
+		}
+		return
+	}
+
+	accessLogEntry.result = benchmark.Result
+	span.SetStatus(codes.Ok, "success")
+	c.JSON(http.StatusOK, NewSuccessResponse(BenchmarkResponse{
+		Runs:     benchmark.Runs,
+		MinMs:    milliseconds(benchmark.Min),
+		MedianMs: milliseconds(benchmark.Median),
+		P95Ms:    milliseconds(benchmark.P95),
+		MaxMs:    milliseconds(benchmark.Max),
+		Result:   benchmark.Result,
+	}))
+}
+
+// ServeAnalyze executes the query of the payload, bypassing the cache, and
+// returns its plan with the counters of its execution, like EXPLAIN ANALYZE,
+// such as for the lessons on performance tuning.
+func (s *SqlQueryService) ServeAnalyze(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "SqlQueryService.ServeAnalyze")
+	defer span.End()
+
+	var req QueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(err)
+
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, BadPayloadError{Parent: err}))
+		return
 	}
+	if err := req.validate(); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, err))
+		return
+	}
+
+	runner, err := s.runner(req)
+	if err != nil {
+		span.SetStatus(codes.Error, "runner find error")
+		span.RecordError(err)
+
+		c.JSON(runnerErrorStatus(err), failedResponse(c, err))
+		return
+	}
+
+	c.Header("X-Schema-Hash", runner.SchemaHash())
+	setAccessLogEntry(c, &accessLogEntry{schemaHash: runner.SchemaHash(), query: req.Query})
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	analyze, err := runner.ExplainAnalyze(queryCtx, req.Query, req.queryOptions(sqlrunner.BlobHex, false)...)
+	if err != nil {
+		span.SetStatus(codes.Error, "analyze error")
+		span.RecordError(err)
+
+		switch {
+		case errors.Is(err, sqlrunner.ErrTooManyQueries):
+			c.JSON(http.StatusTooManyRequests, failedResponse(c, err))
+		case errors.As(err, &sqlrunner.ParameterError{}):
+			c.JSON(http.StatusUnprocessableEntity, failedResponse(c, err))
+		default:
+			c.JSON(http.StatusBadRequest, failedResponse(c, err))
+		}
+		return
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	c.JSON(http.StatusOK, NewSuccessResponse(analyze))
+}
+
+// milliseconds returns the duration in fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// registerDebugRoutes registers the internal endpoints for the operators,
+// which are not exposed unless ENABLE_DEBUG_ENDPOINTS is set.
+func registerDebugRoutes(r *gin.Engine) {
+	r.GET("/debug/selftest", serveSelfTest)
+}
+
+// serveSelfTest runs the example query of each registered function and
+// reports the result per function. It responds 500 if any of them failed.
+func serveSelfTest(c *gin.Context) {
+	results, err := sqlrunner.SelfTest(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, failedResponse(c, err))
+		return
+	}
+
+	response := SelfTestResponse{Passed: true, Functions: results}
+	for _, result := range results {
+		response.Passed = response.Passed && result.Passed
+	}
+
+	status := http.StatusOK
+	if !response.Passed {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, NewSuccessResponse(response))
 }
 
 type SqlQueryService struct {
 	p       *ginprom.Prometheus
-	sfgroup singleflight.Group
+	runners *sqlrunner.Service
 }
 
 func (s *SqlQueryService) Serve(c *gin.Context) {
@@ -123,95 +666,500 @@ func (s *SqlQueryService) Serve(c *gin.Context) {
 		span.SetStatus(codes.Error, "bad payload")
 		span.RecordError(err)
 
-		recordMetrics(http.StatusUnprocessableEntity)
-		c.JSON(http.StatusUnprocessableEntity, NewFailedResponse(BadPayloadError{Parent: err}))
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, BadPayloadError{Parent: err}))
+		return
+	}
+
+	format := responseFormat(c)
+	if !isSupportedFormat(format) {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(errors.New("unsupported format"))
+
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("unsupported format: "+format)))
+		return
+	}
+
+	shape := responseShape(c)
+	if !isSupportedShape(shape) {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(errors.New("unsupported shape"))
+
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("unsupported shape: "+shape)))
+		return
+	}
+
+	blob := blobEncoding(c)
+	if !isSupportedBlobEncoding(blob) {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(errors.New("unsupported blob encoding"))
+
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("unsupported blob encoding: "+string(blob))))
+		return
+	}
+
+	cells, integers := responseCells(c), responseIntegers(c)
+	if !isSupportedCells(cells) || !isSupportedIntegers(integers) {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(errors.New("unsupported cells"))
+
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("unsupported cells: "+cells+", integers: "+integers)))
+		return
+	}
+	partial, err := partialResults(c)
+	if err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(err)
+
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("unsupported partial: "+c.Query("partial"))))
 		return
 	}
+	if cells == cellsTyped && (format != formatJSON || shape != shapeArrays) {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(errors.New("unsupported typed cells"))
 
-	if req.Schema == "" || req.Query == "" {
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, NewBadPayloadError("typed cells require the json format and the arrays shape")))
+		return
+	}
+
+	// An empty query is passed to the runner, which returns an empty result.
+	if err := req.validate(); err != nil {
 		span.SetStatus(codes.Error, "bad payload")
-		span.RecordError(errors.New("schema and query are required"))
+		span.RecordError(err)
 
-		recordMetrics(http.StatusUnprocessableEntity)
-		c.JSON(http.StatusUnprocessableEntity, NewFailedResponse(NewBadPayloadError("schema and query are required")))
+		recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, err))
 		return
 	}
 
 	span.AddEvent("runner.find")
-	runner, err := s.findRunner(req.Schema)
+	runner, err := s.runner(req)
 	if err != nil {
 		span.SetStatus(codes.Error, "runner find error")
 		span.RecordError(err)
 
-		recordMetrics(http.StatusInternalServerError)
-		c.JSON(http.StatusInternalServerError, NewFailedResponse(err))
+		var schemaError sqlrunner.SchemaError
+		if errors.As(err, &schemaError) {
+			s.p.IncrementCounterValue("schema_init_failures_total", []string{schemaError.Category()})
+		}
+
+		status := runnerErrorStatus(err)
+		recordMetrics(status, cacheStatusNone)
+		c.JSON(status, failedResponse(c, err))
 		return
 	}
 
-	queryCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	c.Header("X-Schema-Hash", runner.SchemaHash())
+	accessLogEntry := &accessLogEntry{schemaHash: runner.SchemaHash(), query: req.Query}
+	setAccessLogEntry(c, accessLogEntry)
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
 	defer cancel()
 
 	span.AddEvent("runner.query")
-	result, err := runner.Query(queryCtx, req.Query)
+	result, err := runner.Query(queryCtx, req.Query, req.queryOptions(blob, partial)...)
+	accessLogEntry.result = result
 	if err != nil {
 		span.SetStatus(codes.Error, "query error")
 		span.RecordError(err)
 
-		recordMetrics(http.StatusBadRequest)
-		c.JSON(http.StatusBadRequest, NewFailedResponse(err))
+		if errors.Is(err, sqlrunner.ErrTooManyQueries) {
+			recordMetrics(http.StatusTooManyRequests, cacheStatusNone)
+			c.JSON(http.StatusTooManyRequests, failedResponse(c, err))
+			return
+		}
+		if errors.As(err, &sqlrunner.ParameterError{}) {
+			recordMetrics(http.StatusUnprocessableEntity, cacheStatusNone)
+			c.JSON(http.StatusUnprocessableEntity, failedResponse(c, err))
+			return
+		}
+
+		recordMetrics(http.StatusBadRequest, cacheStatusNone)
+		c.JSON(http.StatusBadRequest, failedResponse(c, err))
 		return
 	}
 
-	recordMetrics(http.StatusOK)
+	// The format of the integers only matters to the typed cells
+	etagCells := cells
+	if cells == cellsTyped {
+		etagCells += "/" + integers
+	}
+	etag, err := resultETag(result, format, shape, etagCells)
+	if err != nil {
+		span.SetStatus(codes.Error, "etag error")
+		span.RecordError(err)
+
+		recordMetrics(http.StatusInternalServerError, cacheStatusNone)
+		c.JSON(http.StatusInternalServerError, failedResponse(c, err))
+		return
+	}
+	c.Header("ETag", etag)
+
+	// The client has the same result already
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		recordMetrics(http.StatusNotModified, result.CacheStatus)
+		span.SetStatus(codes.Ok, "not modified")
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	recordMetrics(http.StatusOK, result.CacheStatus)
 	span.SetStatus(codes.Ok, "success")
 
-	c.JSON(http.StatusOK, NewSuccessResponse(result))
+	switch format {
+	case formatNDJSON:
+		c.Header("Content-Type", contentTypeNDJSON)
+		c.Status(http.StatusOK)
+		if err := writeNDJSON(c.Writer, result); err != nil {
+			_ = c.Error(err)
+		}
+	case formatColumnar:
+		c.JSON(http.StatusOK, NewSuccessResponse(result.Columnar()))
+	case formatJSON:
+		if shape == shapeObjects {
+			objectResult, err := newObjectQueryResult(result)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, failedResponse(c, err))
+				return
+			}
+
+			c.JSON(http.StatusOK, NewSuccessResponse(objectResult))
+			return
+		}
+		if cells == cellsTyped {
+			c.JSON(http.StatusOK, NewSuccessResponse(newTypedQueryResult(result, integers)))
+			return
+		}
+
+		c.JSON(http.StatusOK, NewSuccessResponse(result))
+	}
 }
 
-func (s *SqlQueryService) createRecordMetricsFunc() func(code int) {
-	now := time.Now()
+// cacheStatusNone is the cache status label of the requests without a result.
+const cacheStatusNone sqlrunner.CacheStatus = "none"
 
-	return func(code int) {
-		s.p.IncrementCounterValue("query_requests_total", []string{strconv.Itoa(code)})
-		s.p.AddCustomHistogramValue("query_requests_duration_seconds", []string{strconv.Itoa(code)}, time.Since(now).Seconds())
+// ServeSchema returns the schema of the hash in the X-Schema-Hash header
+// of a query response, as long as the service keeps its runner.
+func (s *SqlQueryService) ServeSchema(c *gin.Context) {
+	hash := c.Param("hash")
+
+	schema, ok := s.runners.Schema(hash)
+	if !ok {
+		c.JSON(http.StatusNotFound, failedResponse(c, NotFoundError{Resource: "schema " + hash}))
+		return
 	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(SchemaResponse{
+		Hash:   hash,
+		Schema: schema,
+	}))
 }
 
-func (s *SqlQueryService) findRunner(schema string) (*sqlrunner.SQLRunner, error) {
-	result, err, _ := s.sfgroup.Do(schema, func() (any, error) {
-		newRunner, err := sqlrunner.NewSQLRunner(schema)
-		if err != nil {
-			return nil, fmt.Errorf("create SQLRunner: %w", err)
+// ServeSchemaDiff compares the two schemas in the payload, such as two
+// versions of the schema of an exercise, and returns the tables and the
+// columns added, removed, or changed.
+func (s *SqlQueryService) ServeSchemaDiff(c *gin.Context) {
+	ctx, span := tracer.Start(c.Request.Context(), "SqlQueryService.ServeSchemaDiff")
+	defer span.End()
+
+	var req SchemaDiffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(err)
+
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, BadPayloadError{Parent: err}))
+		return
+	}
+
+	diff, err := s.runners.SchemaDiff(ctx, req.SchemaA, req.SchemaB)
+	if err != nil {
+		span.SetStatus(codes.Error, "diff error")
+		span.RecordError(err)
+
+		var schemaError sqlrunner.SchemaError
+		if errors.As(err, &schemaError) {
+			s.p.IncrementCounterValue("schema_init_failures_total", []string{schemaError.Category()})
+			c.JSON(http.StatusBadRequest, failedResponse(c, err))
+			return
 		}
 
-		return newRunner, nil
-	})
+		c.JSON(http.StatusInternalServerError, failedResponse(c, err))
+		return
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	c.JSON(http.StatusOK, NewSuccessResponse(diff))
+}
+
+// ServeNamedSchema returns the current version of the named schema.
+func (s *SqlQueryService) ServeNamedSchema(c *gin.Context) {
+	name := c.Param("name")
+
+	version, ok := s.runners.NamedSchema(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, failedResponse(c, NotFoundError{Resource: "schema " + name}))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewSuccessResponse(version))
+}
+
+// ServeRegisterSchema registers the schema in the payload under the name,
+// superseding its previous version, so that the queries can target the
+// name with the schema_name field.
+func (s *SqlQueryService) ServeRegisterSchema(c *gin.Context) {
+	_, span := tracer.Start(c.Request.Context(), "SqlQueryService.ServeRegisterSchema")
+	defer span.End()
+
+	var req RegisterSchemaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.SetStatus(codes.Error, "bad payload")
+		span.RecordError(err)
+
+		c.JSON(http.StatusUnprocessableEntity, failedResponse(c, BadPayloadError{Parent: err}))
+		return
+	}
+
+	version, err := s.runners.RegisterSchema(c.Param("name"), req.Schema)
 	if err != nil {
-		return nil, err
+		span.SetStatus(codes.Error, "register error")
+		span.RecordError(err)
+
+		var schemaError sqlrunner.SchemaError
+		if errors.As(err, &schemaError) {
+			s.p.IncrementCounterValue("schema_init_failures_total", []string{schemaError.Category()})
+			c.JSON(http.StatusBadRequest, failedResponse(c, err))
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, failedResponse(c, err))
+		return
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	c.JSON(http.StatusOK, NewSuccessResponse(version))
+}
+
+// runner returns the runner of the schema of the request, which is either
+// the schema text or the name of a registered schema.
+func (s *SqlQueryService) runner(req QueryRequest) (*sqlrunner.SQLRunner, error) {
+	if req.SchemaName == "" {
+		return s.runners.Runner(req.Schema)
 	}
 
-	typedResult := result.(*sqlrunner.SQLRunner)
-	return typedResult, err
+	runner, err := s.runners.NamedRunner(req.SchemaName)
+	if errors.Is(err, sqlrunner.ErrUnknownSchemaName) {
+		return nil, NotFoundError{Resource: "schema " + req.SchemaName}
+	}
+	return runner, err
+}
+
+// runnerErrorStatus returns the status of the error finding the runner
+// of a request.
+func runnerErrorStatus(err error) int {
+	if errors.As(err, &NotFoundError{}) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *SqlQueryService) createRecordMetricsFunc() func(code int, cacheStatus sqlrunner.CacheStatus) {
+	now := time.Now()
+
+	return func(code int, cacheStatus sqlrunner.CacheStatus) {
+		s.p.IncrementCounterValue("query_requests_total", []string{strconv.Itoa(code), string(cacheStatus)})
+		s.p.AddCustomHistogramValue("query_requests_duration_seconds", []string{strconv.Itoa(code)}, time.Since(now).Seconds())
+	}
 }
 
 type QueryRequest struct {
 	Schema string `json:"schema"`
-	Query  string `json:"query"`
+	// SchemaName is the name of a registered schema to query instead of
+	// the schema text.
+	SchemaName string `json:"schema_name"`
+	Query      string `json:"query"`
+	// Params are the values of the named placeholders of the query, such
+	// as :name and @name, by their names without the prefix.
+	Params map[string]json.RawMessage `json:"params,omitempty"`
+	// Variables are the values of the MySQL user variables the query
+	// references, such as @x, by their names without the @.
+	Variables map[string]json.RawMessage `json:"variables,omitempty"`
+}
+
+// validate checks that the request has either the schema or the schema name.
+func (req QueryRequest) validate() error {
+	switch {
+	case req.Schema == "" && req.SchemaName == "":
+		return NewBadPayloadError("schema is required")
+	case req.Schema != "" && req.SchemaName != "":
+		return NewBadPayloadError("schema and schema_name are mutually exclusive")
+	default:
+		if _, err := req.params(); err != nil {
+			return err
+		}
+		_, err := req.variables()
+		return err
+	}
+}
+
+// params decodes the values of the named placeholders, see decodeValues.
+func (req QueryRequest) params() (map[string]any, error) {
+	return decodeValues("parameter", req.Params)
+}
+
+// variables decodes the values of the user variables, see decodeValues.
+func (req QueryRequest) variables() (map[string]any, error) {
+	return decodeValues("variable", req.Variables)
+}
+
+// decodeValues decodes the values of the parameters or the variables, which
+// are JSON strings, numbers, booleans, or nulls. The integers are bound as
+// integers and the other numbers as doubles. The kind names them in the
+// errors.
+func decodeValues(kind string, raws map[string]json.RawMessage) (map[string]any, error) {
+	if raws == nil {
+		return nil, nil
+	}
+
+	values := make(map[string]any, len(raws))
+	for name, raw := range raws {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return nil, BadPayloadError{Parent: fmt.Errorf("%s %s: %w", kind, name, err)}
+		}
+
+		switch value := value.(type) {
+		case nil, string, bool:
+			values[name] = value
+		case json.Number:
+			if n, err := value.Int64(); err == nil {
+				values[name] = n
+			} else if f, err := value.Float64(); err == nil {
+				values[name] = f
+			} else {
+				return nil, NewBadPayloadError("unsupported value of the " + kind + " " + name + ": " + value.String())
+			}
+		default:
+			return nil, NewBadPayloadError("unsupported value of the " + kind + " " + name + ": " + string(raw))
+		}
+	}
+
+	return values, nil
+}
+
+// queryOptions returns the options of the query of the request, with the
+// encoding of the BLOB values and whether to return the partial results.
+// The parameters and the variables are checked by validate.
+func (req QueryRequest) queryOptions(blob sqlrunner.BlobEncoding, partial bool) []sqlrunner.QueryOption {
+	opts := []sqlrunner.QueryOption{sqlrunner.WithBlobEncoding(blob), sqlrunner.WithPartialResults(partial)}
+	if params, _ := req.params(); params != nil {
+		opts = append(opts, sqlrunner.WithParams(params))
+	}
+	if variables, _ := req.variables(); variables != nil {
+		opts = append(opts, sqlrunner.WithVariables(variables))
+	}
+
+	return opts
+}
+
+// BenchmarkRequest is the payload of POST /benchmark.
+type BenchmarkRequest struct {
+	QueryRequest
+	// Runs is the number of times to execute the query, up to
+	// sqlrunner.MaxBenchmarkRuns.
+	Runs int `json:"runs"`
+}
+
+// RegisterSchemaRequest is the payload of PUT /schemas/:name.
+type RegisterSchemaRequest struct {
+	Schema string `json:"schema" binding:"required"`
+}
+
+// SchemaDiffRequest is the payload of POST /schema/diff.
+type SchemaDiffRequest struct {
+	// SchemaA is the schema to compare from, such as the previous version.
+	SchemaA string `json:"schema_a" binding:"required"`
+	// SchemaB is the schema to compare to.
+	SchemaB string `json:"schema_b" binding:"required"`
+}
+
+// SelfTestResponse is the response of GET /debug/selftest.
+type SelfTestResponse struct {
+	// Passed reports whether all the functions passed.
+	Passed    bool                       `json:"passed"`
+	Functions []sqlrunner.SelfTestResult `json:"functions"`
+}
+
+// BenchmarkResponse is the response of POST /benchmark. The durations are
+// in milliseconds.
+type BenchmarkResponse struct {
+	Runs     int                    `json:"runs"`
+	MinMs    float64                `json:"min_ms"`
+	MedianMs float64                `json:"median_ms"`
+	P95Ms    float64                `json:"p95_ms"`
+	MaxMs    float64                `json:"max_ms"`
+	Result   *sqlrunner.QueryResult `json:"result"`
+}
+
+// CheckResponse is the response of POST /check.
+type CheckResponse struct {
+	Valid bool        `json:"valid"`
+	Error *CheckError `json:"error,omitempty"` // valid = false
+}
+
+// CheckError describes why a query is invalid.
+type CheckError struct {
+	// Category is "syntax", "reference", or "other".
+	Category string `json:"category"`
+	Message  string `json:"message"`
+	// Line and Column are the 1-based position of the error in the query,
+	// which are omitted if unknown.
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+}
+
+type SchemaResponse struct {
+	Hash   string `json:"hash"`
+	Schema string `json:"schema"`
 }
 
 type QueryResponse struct {
 	Success bool `json:"success"`
 
-	Data    *sqlrunner.QueryResult `json:"data,omitempty"`    // success = true
-	Message *string                `json:"message,omitempty"` // success = false
-	Code    *string                `json:"code,omitempty"`    // success = false
+	Data    any     `json:"data,omitempty"`    // success = true; *sqlrunner.QueryResult, *ObjectQueryResult, or *sqlrunner.ColumnarQueryResult
+	Message *string `json:"message,omitempty"` // success = false
+	Code    *string `json:"code,omitempty"`    // success = false
+	// RequestID is the ID of the failed request, for the students
+	// to report it. success = false
+	RequestID string `json:"request_id,omitempty"`
 }
 
 type BadPayloadError struct {
 	Parent error
 }
 
-func NewSuccessResponse(data *sqlrunner.QueryResult) QueryResponse {
+// NotFoundError is returned when the requested resource does not exist.
+type NotFoundError struct {
+	Resource string
+}
+
+// TooLargeError is returned when the requested resource exceeds the size limit.
+type TooLargeError struct {
+	Resource string
+	// Limit is the size limit in bytes.
+	Limit int64
+}
+
+func NewSuccessResponse(data any) QueryResponse {
 	return QueryResponse{
 		Success: true,
 		Data:    data,
@@ -220,8 +1168,14 @@ func NewSuccessResponse(data *sqlrunner.QueryResult) QueryResponse {
 
 func NewFailedResponse(err error) QueryResponse {
 	var badPayloadError BadPayloadError
+	var parameterError sqlrunner.ParameterError
+	var notFoundError NotFoundError
+	var tooLargeError TooLargeError
+	var unauthorizedError UnauthorizedError
+	var shuttingDownError ShuttingDownError
 	var schemaError sqlrunner.SchemaError
 	var queryError sqlrunner.QueryError
+	var policyError sqlrunner.PolicyError
 
 	var code string
 	var message string
@@ -229,9 +1183,30 @@ func NewFailedResponse(err error) QueryResponse {
 	if errors.As(err, &badPayloadError) {
 		code = "BAD_PAYLOAD"
 		message = badPayloadError.Parent.Error()
+	} else if errors.As(err, &parameterError) {
+		code = "BAD_PAYLOAD"
+		message = parameterError.Parent.Error()
+	} else if errors.As(err, &notFoundError) {
+		code = "NOT_FOUND"
+		message = notFoundError.Error()
+	} else if errors.As(err, &tooLargeError) {
+		code = "TOO_LARGE"
+		message = tooLargeError.Error()
+	} else if errors.As(err, &unauthorizedError) {
+		code = "UNAUTHORIZED"
+		message = unauthorizedError.Error()
+	} else if errors.As(err, &shuttingDownError) {
+		code = "SHUTTING_DOWN"
+		message = shuttingDownError.Error()
 	} else if errors.As(err, &schemaError) {
 		code = "SCHEMA_ERROR"
 		message = schemaError.Parent.Error()
+	} else if errors.Is(err, sqlrunner.ErrTooManyQueries) {
+		code = "TOO_MANY_QUERIES"
+		message = err.Error()
+	} else if errors.As(err, &policyError) {
+		code = "POLICY_ERROR"
+		message = policyError.Error()
 	} else if errors.As(err, &queryError) {
 		code = "QUERY_ERROR"
 		message = queryError.Parent.Error()
@@ -247,6 +1222,15 @@ func NewFailedResponse(err error) QueryResponse {
 	}
 }
 
+// failedResponse returns the failed response of the error with
+// the ID of the request.
+func failedResponse(c *gin.Context, err error) QueryResponse {
+	response := NewFailedResponse(err)
+	response.RequestID = requestID(c.Request.Context())
+
+	return response
+}
+
 func NewBadPayloadError(message string) BadPayloadError {
 	return BadPayloadError{Parent: errors.New(message)}
 }
@@ -254,3 +1238,11 @@ func NewBadPayloadError(message string) BadPayloadError {
 func (e BadPayloadError) Error() string {
 	return "bad payload: " + e.Parent.Error()
 }
+
+func (e NotFoundError) Error() string {
+	return e.Resource + " not found"
+}
+
+func (e TooLargeError) Error() string {
+	return e.Resource + " exceeds the size limit of " + strconv.FormatInt(e.Limit, 10) + " bytes"
+}
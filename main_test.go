@@ -0,0 +1,1339 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/log/global"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	return newRouter(prometheus.NewRegistry())
+}
+
+func postQuery(t *testing.T, r http.Handler, url string, req QueryRequest, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	httpReq := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	for key, values := range header {
+		for _, value := range values {
+			httpReq.Header.Add(key, value)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httpReq)
+	return w
+}
+
+func TestServeNDJSON(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	req := QueryRequest{
+		Schema: "CREATE TABLE ndjsontest (id INT, name TEXT); INSERT INTO ndjsontest VALUES (1, 'a'), (2, 'b');",
+		Query:  "SELECT id, name FROM ndjsontest ORDER BY id",
+	}
+
+	for name, tc := range map[string]struct {
+		url    string
+		header http.Header
+	}{
+		"Query parameter": {url: "/query?format=ndjson"},
+		"Accept header":   {url: "/query", header: http.Header{"Accept": {contentTypeNDJSON}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := postQuery(t, r, tc.url, req, tc.header)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, contentTypeNDJSON, w.Header().Get("Content-Type"))
+
+			scanner := bufio.NewScanner(w.Body)
+			require.True(t, scanner.Scan())
+
+			var header NDJSONHeader
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+			assert.Equal(t, []string{"id", "name"}, header.Columns)
+
+			var rows [][]string
+			for scanner.Scan() {
+				var object map[string]string
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &object))
+
+				row := make([]string, 0, len(header.Columns))
+				for _, column := range header.Columns {
+					row = append(row, object[column])
+				}
+				rows = append(rows, row)
+			}
+			require.NoError(t, scanner.Err())
+
+			assert.Equal(t, [][]string{{"1", "a"}, {"2", "b"}}, rows)
+		})
+	}
+
+	t.Run("Unsupported format", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query?format=xml", req, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
+
+func TestServeObjectShape(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+
+	t.Run("Objects", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query?shape=objects", QueryRequest{
+			Schema: "CREATE TABLE shapetest (id INT, name TEXT); INSERT INTO shapetest VALUES (1, 'a'), (2, 'b');",
+			Query:  "SELECT id, name FROM shapetest ORDER BY id",
+		}, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data struct {
+				Columns []string            `json:"columns"`
+				Rows    []map[string]string `json:"rows"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, []string{"id", "name"}, resp.Data.Columns)
+		assert.Equal(t, []map[string]string{
+			{"id": "1", "name": "a"},
+			{"id": "2", "name": "b"},
+		}, resp.Data.Rows)
+	})
+
+	t.Run("Duplicate column names", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query?shape=objects", QueryRequest{
+			Schema: "CREATE TABLE shapetest (id INT, id_2 INT); INSERT INTO shapetest VALUES (1, 2);",
+			Query:  "SELECT id, id, id_2, id FROM shapetest",
+		}, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data struct {
+				Rows []json.RawMessage `json:"rows"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Data.Rows, 1)
+		assert.JSONEq(t, `{"id":"1","id_3":"1","id_2":"2","id_4":"1"}`, string(resp.Data.Rows[0]))
+	})
+
+	t.Run("Arrays by default", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", QueryRequest{
+			Schema: "CREATE TABLE shapetest (id INT); INSERT INTO shapetest VALUES (1);",
+			Query:  "SELECT id FROM shapetest",
+		}, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"success":true,"data":{"columns":["id"],"rows":[["1"]],"statement_type":"SELECT"}}`, w.Body.String())
+	})
+
+	t.Run("Unsupported shape", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query?shape=columns", QueryRequest{
+			Schema: "CREATE TABLE shapetest (id INT);",
+			Query:  "SELECT id FROM shapetest",
+		}, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
+
+func TestServeColumnar(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+
+	w := postQuery(t, r, "/query?format=columnar", QueryRequest{
+		Schema: "CREATE TABLE columnartest (id INT, name TEXT); INSERT INTO columnartest VALUES (1, 'a'), (2, 'b');",
+		Query:  "SELECT id, name FROM columnartest ORDER BY id",
+	}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"success": true,
+		"data": {
+			"columns": [
+				{"name": "id", "type": "INT", "values": ["1", "2"]},
+				{"name": "name", "type": "TEXT", "values": ["a", "b"]}
+			],
+			"statement_type": "SELECT"
+		}
+	}`, w.Body.String())
+}
+
+func TestServeBlobBase64(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	req := QueryRequest{
+		Schema: "CREATE TABLE blobtest (data BLOB); INSERT INTO blobtest VALUES (X'00FF10'), (NULL);",
+		Query:  "SELECT data FROM blobtest ORDER BY data",
+	}
+
+	for name, url := range map[string]string{
+		"JSON":     "/query?blob=base64",
+		"NDJSON":   "/query?blob=base64&format=ndjson",
+		"Columnar": "/query?blob=base64&format=columnar",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := postQuery(t, r, url, req, nil)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `"AP8Q"`)
+		})
+	}
+
+	t.Run("Decodes", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query?blob=base64", req, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data struct {
+				Rows [][]string `json:"rows"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Data.Rows, 2)
+		assert.Equal(t, "NULL", resp.Data.Rows[0][0])
+
+		data, err := base64.StdEncoding.DecodeString(resp.Data.Rows[1][0])
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x00, 0xff, 0x10}, data)
+	})
+
+	t.Run("Hex by default", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", req, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"00ff10"`)
+	})
+
+	t.Run("Unsupported encoding", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query?blob=base32", req, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
+
+func TestServeTypedCells(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	req := QueryRequest{
+		Schema: `
+			CREATE TABLE typedtest (id INTEGER, price REAL, name TEXT);
+			INSERT INTO typedtest VALUES (1, 2.5, '3'), (9007199254740993, NULL, NULL);
+		`,
+		Query: "SELECT id, price, name, COUNT(*) OVER () FROM typedtest ORDER BY id",
+	}
+
+	for name, testCase := range map[string]struct {
+		url  string
+		rows string
+	}{
+		"Safe": {
+			url:  "/query?cells=typed",
+			rows: `[[1, 2.5, "3", "2"], ["9007199254740993", null, "NULL", "2"]]`,
+		},
+		"Number": {
+			url:  "/query?cells=typed&integers=number",
+			rows: `[[1, 2.5, "3", "2"], [9007199254740993, null, "NULL", "2"]]`,
+		},
+		"String": {
+			url:  "/query?cells=typed&integers=string",
+			rows: `[["1", 2.5, "3", "2"], ["9007199254740993", null, "NULL", "2"]]`,
+		},
+		"Text": {
+			url:  "/query",
+			rows: `[["1", "2.5", "3", "2"], ["9007199254740993", "NULL", "NULL", "2"]]`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := postQuery(t, r, testCase.url, req, nil)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var resp struct {
+				Data struct {
+					Rows json.RawMessage `json:"rows"`
+				} `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.JSONEq(t, testCase.rows, string(resp.Data.Rows))
+			// The big integer is kept as it is, rather than rounded
+			assert.Contains(t, w.Body.String(), "9007199254740993")
+		})
+	}
+
+	t.Run("ETag", func(t *testing.T) {
+		t.Parallel()
+
+		typed := postQuery(t, r, "/query?cells=typed", req, nil)
+		text := postQuery(t, r, "/query", req, nil)
+		assert.NotEqual(t, typed.Header().Get("ETag"), text.Header().Get("ETag"))
+	})
+
+	for name, url := range map[string]string{
+		"Unsupported cells":    "/query?cells=native",
+		"Unsupported integers": "/query?cells=typed&integers=bigint",
+		"Unsupported format":   "/query?cells=typed&format=ndjson",
+		"Unsupported shape":    "/query?cells=typed&shape=objects",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := postQuery(t, r, url, req, nil)
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		})
+	}
+}
+
+func TestServeEmptyQuery(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+
+	// The empty query returns the empty result, like SQLRunner.Query.
+	w := postQuery(t, r, "/query", QueryRequest{Schema: "CREATE TABLE emptyquerytest (id INT);", Query: ""}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"success": true,
+		"data": {"columns": [], "rows": []}
+	}`, w.Body.String())
+
+	// The schema is still required.
+	w = postQuery(t, r, "/query", QueryRequest{Schema: "", Query: "SELECT 1"}, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"BAD_PAYLOAD"`)
+}
+
+func TestServeETag(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	req := QueryRequest{
+		Schema: "CREATE TABLE etagtest (id INT); INSERT INTO etagtest VALUES (1), (2);",
+		Query:  "SELECT id FROM etagtest ORDER BY id",
+	}
+
+	w := postQuery(t, r, "/query", req, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	t.Run("Not modified", func(t *testing.T) {
+		t.Parallel()
+
+		for _, ifNoneMatch := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+			w := postQuery(t, r, "/query", req, http.Header{"If-None-Match": {ifNoneMatch}})
+			assert.Equal(t, http.StatusNotModified, w.Code, "If-None-Match: %s", ifNoneMatch)
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+			assert.Empty(t, w.Body.String())
+		}
+	})
+
+	t.Run("Modified", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", req, http.Header{"If-None-Match": {`"other"`}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+	})
+
+	t.Run("Other result", func(t *testing.T) {
+		t.Parallel()
+
+		other := req
+		other.Query = "SELECT id FROM etagtest ORDER BY id DESC"
+		w := postQuery(t, r, "/query", other, http.Header{"If-None-Match": {etag}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	})
+
+	t.Run("Other format", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query?format=columnar", req, http.Header{"If-None-Match": {etag}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	})
+}
+
+func TestQueryMetricsCacheLabel(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	registry := prometheus.NewRegistry()
+	r := newRouter(registry)
+
+	req := QueryRequest{
+		Schema: "CREATE TABLE metricscachetest (id INT);",
+		Query:  "SELECT id FROM metricscachetest",
+	}
+	for range 2 {
+		w := postQuery(t, r, "/query", req, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	counts := counterValues(t, registry, "query_requests_total", "cache")
+	assert.Equal(t, map[string]float64{"cold": 1, "hit": 1}, counts)
+}
+
+func TestServeIdempotencyKey(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	registry := prometheus.NewRegistry()
+	r := newRouter(registry)
+
+	req := QueryRequest{
+		Schema: "CREATE TABLE idempotencytest (id INT); INSERT INTO idempotencytest VALUES (1);",
+		Query:  "SELECT id FROM idempotencytest",
+	}
+	header := http.Header{idempotencyKeyHeader: {"idempotencytest-1"}}
+
+	first := postQuery(t, r, "/query", req, header)
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get(idempotentReplayedHeader))
+
+	// The retry is replayed rather than served from the cache of the runner,
+	// whose response would differ by its cache status.
+	retry := postQuery(t, r, "/query", req, header)
+	require.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(idempotentReplayedHeader))
+	assert.Equal(t, first.Body.Bytes(), retry.Body.Bytes())
+	assert.Equal(t, first.Header().Get("ETag"), retry.Header().Get("ETag"))
+	assert.NotEqual(t, first.Header().Get(requestIDHeader), retry.Header().Get(requestIDHeader))
+
+	counts := counterValues(t, registry, "query_requests_total", "cache")
+	assert.Equal(t, map[string]float64{"cold": 1}, counts)
+
+	t.Run("Another request", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", QueryRequest{Schema: req.Schema, Query: "SELECT 1"}, header)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"BAD_PAYLOAD"`)
+	})
+
+	t.Run("Another key", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", req, http.Header{idempotencyKeyHeader: {"idempotencytest-2"}})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(idempotentReplayedHeader))
+	})
+}
+
+// TestServeFlush is not parallel since it sets ADMIN_TOKEN, and flushing
+// removes the schema databases of the other tests too.
+func TestServeFlush(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "flushtest")
+
+	gin.SetMode(gin.TestMode)
+	registry := prometheus.NewRegistry()
+	r := newRouter(registry)
+
+	flush := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/flush", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	req := QueryRequest{
+		Schema: "CREATE TABLE flushtest (id INT); INSERT INTO flushtest VALUES (1);",
+		Query:  "SELECT id FROM flushtest",
+	}
+	for range 2 {
+		w := postQuery(t, r, "/query", req, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	w := postQuery(t, r, "/query", req, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	// The schema databases are in the tmpDir of the lib package
+	filename := filepath.Join("/tmp/sqlrunner", w.Header().Get("X-Schema-Hash")+".db")
+	require.FileExists(t, filename)
+
+	for _, token := range []string{"", "wrong"} {
+		w := flush(token)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"UNAUTHORIZED"`)
+	}
+	assert.FileExists(t, filename)
+
+	w = flush("flushtest")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"success": true}`, w.Body.String())
+	assert.NoFileExists(t, filename)
+
+	// The cached query is executed again on the initialized schema
+	w = postQuery(t, r, "/query", req, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"rows":[["1"]]`)
+	assert.FileExists(t, filename)
+
+	counts := counterValues(t, registry, "query_requests_total", "cache")
+	assert.Equal(t, map[string]float64{"cold": 2, "hit": 2}, counts)
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Setenv("ADMIN_TOKEN", "")
+
+		w := httptest.NewRecorder()
+		newTestRouter(t).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/flush", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestSchemaInitFailuresMetric(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	registry := prometheus.NewRegistry()
+	r := newRouter(registry)
+
+	for _, schema := range []string{
+		"CREATE TABLE schemafailuretest (id INT",
+		"CREATE TABLE schemafailuretest (id INT PRIMARY KEY); INSERT INTO schemafailuretest VALUES (1), (1);",
+	} {
+		w := postQuery(t, r, "/query", QueryRequest{Schema: schema, Query: "SELECT 1"}, nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	}
+
+	counts := counterValues(t, registry, "schema_init_failures_total", "category")
+	assert.Equal(t, map[string]float64{"parse": 1, "constraint": 1}, counts)
+}
+
+func TestServeTooManyQueries(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	registry := prometheus.NewRegistry()
+	r := newRouter(registry, sqlrunner.WithConcurrencyLimit(1, 0))
+
+	schema := "CREATE TABLE toomanyqueriestest (id INT);"
+
+	// The slow query holds the only slot until it is canceled.
+	slowBody, err := json.Marshal(QueryRequest{
+		Schema: schema,
+		Query:  "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c",
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	slowReq := httptest.NewRequestWithContext(ctx, http.MethodPost, "/query", bytes.NewReader(slowBody))
+	slowReq.Header.Set("Content-Type", "application/json")
+
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(slowDone)
+		r.ServeHTTP(httptest.NewRecorder(), slowReq)
+	}()
+
+	// Probe only once the slow query holds the slot, since a probe taking
+	// it first would have the slow query rejected instead.
+	require.Eventually(t, func() bool {
+		return counterValues(t, registry, "query_limit_events_total", "event")["acquired"] == 1
+	}, 5*time.Second, time.Millisecond)
+
+	w := postQuery(t, r, "/query", QueryRequest{Schema: schema, Query: "SELECT id FROM toomanyqueriestest"}, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"TOO_MANY_QUERIES"`)
+
+	cancel()
+	<-slowDone
+
+	counts := counterValues(t, registry, "query_limit_events_total", "event")
+	assert.Equal(t, 1.0, counts["rejected"])
+}
+
+func TestServeExport(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	schema := "CREATE TABLE exporttest (id INT); INSERT INTO exporttest VALUES (1), (2);"
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export?schema="+url.QueryEscape(schema), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, contentTypeSQLite, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), w.Header().Get("X-Schema-Hash")+".db")
+
+	// The returned bytes are a SQLite database with the schema
+	filename := filepath.Join(t.TempDir(), "export.db")
+	require.NoError(t, os.WriteFile(filename, w.Body.Bytes(), 0o644))
+
+	db, err := sql.Open("sqlite", "file:"+filename+"?mode=ro")
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM exporttest").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	t.Run("Missing schema", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export", nil))
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("Invalid schema", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export?schema="+url.QueryEscape("CREATE TABLE"), nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"SCHEMA_ERROR"`)
+	})
+}
+
+func TestServeCheck(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	schema := "CREATE TABLE checktest (id INT); INSERT INTO checktest VALUES (1);"
+
+	t.Run("Valid query", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/check", QueryRequest{Schema: schema, Query: "SELECT id FROM checktest"}, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"success":true,"data":{"valid":true}}`, w.Body.String())
+	})
+
+	t.Run("Invalid query", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/check", QueryRequest{Schema: schema, Query: "SELECT id\nFORM checktest"}, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			Data CheckResponse `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.False(t, response.Data.Valid)
+		require.NotNil(t, response.Data.Error)
+		assert.Equal(t, "syntax", response.Data.Error.Category)
+		assert.Contains(t, response.Data.Error.Message, `near "checktest": syntax error`)
+		assert.Equal(t, 2, response.Data.Error.Line)
+		assert.Equal(t, 6, response.Data.Error.Column)
+	})
+
+	t.Run("Missing schema", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/check", QueryRequest{Query: "SELECT 1"}, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
+
+func TestServeSelfTest(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		r := newTestRouter(t)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/selftest", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		t.Parallel()
+
+		r := newTestRouter(t)
+		registerDebugRoutes(r)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/selftest", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data SelfTestResponse `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.True(t, resp.Data.Passed)
+		require.Len(t, resp.Data.Functions, len(sqlrunner.RegisteredFunctions()))
+		for _, result := range resp.Data.Functions {
+			assert.True(t, result.Passed, "function %s", result.Function)
+		}
+	})
+}
+
+// counterValues returns the values of the counter with the name suffix,
+// summed by the value of the label.
+func counterValues(t *testing.T, registry *prometheus.Registry, nameSuffix, labelName string) map[string]float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, family := range families {
+		if !strings.HasSuffix(family.GetName(), nameSuffix) {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == labelName {
+					values[label.GetValue()] += metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+func TestServeSchema(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	schema := "CREATE TABLE schemahashtest (id INT);"
+
+	w := postQuery(t, r, "/query", QueryRequest{Schema: schema, Query: "SELECT id FROM schemahashtest"}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	hash := w.Header().Get("X-Schema-Hash")
+	require.NotEmpty(t, hash)
+
+	t.Run("Known", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/schema/"+hash, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data SchemaResponse `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, SchemaResponse{Hash: hash, Schema: schema}, resp.Data)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		t.Parallel()
+
+		req := httptest.NewRequest(http.MethodGet, "/schema/unknown", nil)
+		req.Header.Set("X-Request-ID", "schematest")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"success": false, "code": "NOT_FOUND", "message": "schema unknown not found", "request_id": "schematest"}`, w.Body.String())
+	})
+}
+
+func TestServeSchemaDiff(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+
+	post := func(t *testing.T, req SchemaDiffRequest) *httptest.ResponseRecorder {
+		t.Helper()
+
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+
+		httpReq := httptest.NewRequest(http.MethodPost, "/schema/diff", bytes.NewReader(body))
+		httpReq.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httpReq)
+		return w
+	}
+
+	t.Run("Diff", func(t *testing.T) {
+		t.Parallel()
+
+		w := post(t, SchemaDiffRequest{
+			SchemaA: "CREATE TABLE servediffa (id INT); CREATE TABLE servediffb (id INT);",
+			SchemaB: "CREATE TABLE servediffa (id INT, name TEXT);",
+		})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"success": true, "data": {
+			"added_tables": [],
+			"removed_tables": ["servediffb"],
+			"changed_tables": [
+				{"table": "servediffa", "added_columns": ["name"], "removed_columns": [], "changed_columns": []}
+			]
+		}}`, w.Body.String())
+	})
+
+	t.Run("InvalidSchema", func(t *testing.T) {
+		t.Parallel()
+
+		w := post(t, SchemaDiffRequest{SchemaA: "CREATE TABLE servediffc (id INT);", SchemaB: "CREATE TABLE"})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"SCHEMA_ERROR"`)
+	})
+
+	t.Run("MissingSchema", func(t *testing.T) {
+		t.Parallel()
+
+		w := post(t, SchemaDiffRequest{SchemaA: "CREATE TABLE servediffd (id INT);"})
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
+
+func TestServeNamedSchema(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+
+	register := func(t *testing.T, name, schema string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		body, err := json.Marshal(RegisterSchemaRequest{Schema: schema})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPut, "/schemas/"+name, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := register(t, "namedschematest", "CREATE TABLE namedschematest (id INT); INSERT INTO namedschematest VALUES (1);")
+	require.Equal(t, http.StatusOK, w.Code)
+	var v1 struct {
+		Data sqlrunner.SchemaVersion `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &v1))
+	assert.Equal(t, 1, v1.Data.Version)
+
+	w = postQuery(t, r, "/query", QueryRequest{SchemaName: "namedschematest", Query: "SELECT id FROM namedschematest"}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, v1.Data.Hash, w.Header().Get("X-Schema-Hash"))
+	assert.Contains(t, w.Body.String(), `"rows":[["1"]]`)
+
+	w = register(t, "namedschematest", "CREATE TABLE namedschematest (id INT); INSERT INTO namedschematest VALUES (2);")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"version":2`)
+
+	w = postQuery(t, r, "/query", QueryRequest{SchemaName: "namedschematest", Query: "SELECT id FROM namedschematest"}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"rows":[["2"]]`)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/schemas/namedschematest", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"version":2`)
+
+	t.Run("Unknown", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", QueryRequest{SchemaName: "unknown", Query: "SELECT 1"}, nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"NOT_FOUND"`)
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/schemas/unknown", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Both", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", QueryRequest{Schema: "CREATE TABLE t (id INT);", SchemaName: "namedschematest", Query: "SELECT 1"}, nil)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"BAD_PAYLOAD"`)
+	})
+
+	t.Run("Invalid schema", func(t *testing.T) {
+		t.Parallel()
+
+		w := register(t, "namedschemainvalidtest", "CREATE TABLE")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"SCHEMA_ERROR"`)
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	request := QueryRequest{
+		Schema: "CREATE TABLE requestidtest (id INT);",
+		Query:  "SELECT id FROM requestidtest",
+	}
+
+	t.Run("Provided", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", request, http.Header{"X-Request-Id": {"report-42"}})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "report-42", w.Header().Get("X-Request-ID"))
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", request, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+
+		other := postQuery(t, r, "/query", request, nil)
+		assert.NotEqual(t, w.Header().Get("X-Request-ID"), other.Header().Get("X-Request-ID"))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, id := range []string{"has space", strings.Repeat("x", 129)} {
+			w := postQuery(t, r, "/query", request, http.Header{"X-Request-Id": {id}})
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+			assert.NotEqual(t, id, w.Header().Get("X-Request-ID"))
+		}
+	})
+
+	t.Run("Error body", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", QueryRequest{
+			Schema: request.Schema,
+			Query:  "SELECT missing FROM requestidtest",
+		}, http.Header{"X-Request-Id": {"report-43"}})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "report-43", w.Header().Get("X-Request-ID"))
+
+		var resp QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "report-43", resp.RequestID)
+	})
+
+	t.Run("Logs", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		logger := slog.New(requestIDHandler{Handler: slog.NewJSONHandler(&buf, nil)})
+
+		ctx := context.WithValue(context.TODO(), requestIDKey{}, "report-44")
+		logger.With(slog.String("component", "runner")).InfoContext(ctx, "query failed")
+
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+		assert.Equal(t, "report-44", record["request.id"])
+		assert.Equal(t, "runner", record["component"])
+	})
+}
+
+// readEvents parses the Server-Sent Events of a response body
+// into pairs of the event and its data.
+func readEvents(t *testing.T, body string) [][2]string {
+	t.Helper()
+
+	var events [][2]string
+	for block := range strings.SplitSeq(strings.TrimSpace(body), "\n\n") {
+		var event [2]string
+		for line := range strings.SplitSeq(block, "\n") {
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event[0] = name
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				event[1] = data
+			}
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// TestServeEvents is not parallel since it shortens the process-wide
+// heartbeat interval.
+func TestServeEvents(t *testing.T) {
+	interval := heartbeatInterval
+	heartbeatInterval = 5 * time.Millisecond
+	t.Cleanup(func() { heartbeatInterval = interval })
+
+	r := newTestRouter(t)
+	schema := "CREATE TABLE eventstest (id INT);"
+
+	t.Run("Slow query", func(t *testing.T) {
+		w := postQuery(t, r, "/query/events", QueryRequest{
+			Schema: schema,
+			Query:  "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000) SELECT count(*) AS n FROM c",
+		}, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, contentTypeEventStream, w.Header().Get("Content-Type"))
+
+		events := readEvents(t, w.Body.String())
+		require.GreaterOrEqual(t, len(events), 2)
+		assert.Equal(t, eventHeartbeat, events[0][0])
+		assert.Contains(t, events[0][1], `"elapsed_ms":`)
+
+		last := events[len(events)-1]
+		assert.Equal(t, eventResult, last[0])
+		assert.JSONEq(t, `{"success":true,"data":{"columns":["n"],"rows":[["1000000"]],"statement_type":"SELECT"}}`, last[1])
+	})
+
+	t.Run("Query error", func(t *testing.T) {
+		w := postQuery(t, r, "/query/events", QueryRequest{
+			Schema: schema,
+			Query:  "SELECT missing FROM eventstest",
+		}, http.Header{"X-Request-Id": {"eventstest"}})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		events := readEvents(t, w.Body.String())
+		last := events[len(events)-1]
+		assert.Equal(t, eventError, last[0])
+
+		var resp QueryResponse
+		require.NoError(t, json.Unmarshal([]byte(last[1]), &resp))
+		assert.False(t, resp.Success)
+		assert.Equal(t, "QUERY_ERROR", *resp.Code)
+		assert.Equal(t, "eventstest", resp.RequestID)
+	})
+
+	t.Run("Bad payload", func(t *testing.T) {
+		w := postQuery(t, r, "/query/events", QueryRequest{Query: "SELECT 1"}, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
+
+func TestServeNamedParams(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	schema := `
+		CREATE TABLE namedparamstest (id INT, name TEXT);
+		INSERT INTO namedparamstest VALUES (1, 'alice'), (2, 'bob'), (3, 'carol');
+	`
+	query := "SELECT id, name FROM namedparamstest WHERE id >= :min AND name <> @name ORDER BY id"
+
+	w := postQuery(t, r, "/query", QueryRequest{
+		Schema: schema,
+		Query:  query,
+		Params: map[string]json.RawMessage{"min": json.RawMessage(`2`), "name": json.RawMessage(`"carol"`)},
+	}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"rows":[["2","bob"]]`)
+
+	// The parameters are part of the cache key
+	w = postQuery(t, r, "/query", QueryRequest{
+		Schema: schema,
+		Query:  query,
+		Params: map[string]json.RawMessage{"min": json.RawMessage(`1`), "name": json.RawMessage(`"bob"`)},
+	}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"rows":[["1","alice"],["3","carol"]]`)
+
+	t.Run("Missing", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", QueryRequest{
+			Schema: schema,
+			Query:  query,
+			Params: map[string]json.RawMessage{"min": json.RawMessage(`2`)},
+		}, nil)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"BAD_PAYLOAD"`)
+		assert.Contains(t, w.Body.String(), "missing value of the parameter @name")
+	})
+
+	t.Run("Unused", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", QueryRequest{
+			Schema: schema,
+			Query:  "SELECT :min",
+			Params: map[string]json.RawMessage{"min": json.RawMessage(`2`), "max": json.RawMessage(`3`)},
+		}, nil)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), "unused parameter max")
+	})
+
+	t.Run("Unsupported value", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", QueryRequest{
+			Schema: schema,
+			Query:  "SELECT :min",
+			Params: map[string]json.RawMessage{"min": json.RawMessage(`[1, 2]`)},
+		}, nil)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"BAD_PAYLOAD"`)
+	})
+}
+
+func TestServeVariables(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	schema := `
+		CREATE TABLE variablestest (id INT, name TEXT);
+		INSERT INTO variablestest VALUES (1, 'alice'), (2, 'bob'), (3, 'carol');
+	`
+
+	w := postQuery(t, r, "/query", QueryRequest{
+		Schema:    schema,
+		Query:     "SELECT id, name FROM variablestest WHERE id >= @x ORDER BY id",
+		Variables: map[string]json.RawMessage{"x": json.RawMessage(`3`)},
+	}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"rows":[["3","carol"]]`)
+
+	t.Run("Undefined", func(t *testing.T) {
+		t.Parallel()
+
+		w := postQuery(t, r, "/query", QueryRequest{
+			Schema:    schema,
+			Query:     "SELECT id FROM variablestest WHERE id >= @y",
+			Variables: map[string]json.RawMessage{"x": json.RawMessage(`3`)},
+		}, nil)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"BAD_PAYLOAD"`)
+		assert.Contains(t, w.Body.String(), "undefined variable @y")
+	})
+}
+
+func TestServeBenchmark(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	post := func(t *testing.T, req BenchmarkRequest) *httptest.ResponseRecorder {
+		t.Helper()
+
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+
+		httpReq := httptest.NewRequest(http.MethodPost, "/benchmark", bytes.NewReader(body))
+		httpReq.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httpReq)
+		return w
+	}
+	query := QueryRequest{
+		Schema: "CREATE TABLE benchmarktest (id INT); INSERT INTO benchmarktest VALUES (1), (2), (3);",
+		Query:  "SELECT SUM(id) FROM benchmarktest",
+	}
+
+	w := post(t, BenchmarkRequest{QueryRequest: query, Runs: 20})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Data struct {
+			BenchmarkResponse
+			Result struct {
+				Rows [][]string `json:"rows"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 20, resp.Data.Runs)
+	assert.Equal(t, [][]string{{"6"}}, resp.Data.Result.Rows)
+	assert.Positive(t, resp.Data.MinMs)
+	assert.LessOrEqual(t, resp.Data.MinMs, resp.Data.MedianMs)
+	assert.LessOrEqual(t, resp.Data.MedianMs, resp.Data.P95Ms)
+	assert.LessOrEqual(t, resp.Data.P95Ms, resp.Data.MaxMs)
+
+	t.Run("Too many runs", func(t *testing.T) {
+		t.Parallel()
+
+		w := post(t, BenchmarkRequest{QueryRequest: query, Runs: 1000})
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"BAD_PAYLOAD"`)
+	})
+
+	t.Run("Query error", func(t *testing.T) {
+		t.Parallel()
+
+		w := post(t, BenchmarkRequest{QueryRequest: QueryRequest{Schema: query.Schema, Query: "SELECT unknown FROM benchmarktest"}, Runs: 2})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"QUERY_ERROR"`)
+	})
+}
+
+func TestServeAnalyze(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	body, err := json.Marshal(QueryRequest{
+		Schema: "CREATE TABLE analyzetest (id INT); INSERT INTO analyzetest VALUES (1), (2), (3);",
+		Query:  "SELECT id FROM analyzetest WHERE id > 1",
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Data sqlrunner.AnalyzeResult `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Data.ResultRows)
+	assert.EqualValues(t, 2, resp.Data.FullScanSteps)
+	assert.Equal(t, []sqlrunner.PlanStep{{ID: 2, Detail: "SCAN analyzetest"}}, resp.Data.Plan)
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	t.Parallel()
+
+	for rate, expected := range map[float64]int{1.0: 3, 0.0: 0} {
+		t.Run(strconv.FormatFloat(rate, 'f', 1, 64), func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			accessLog := accessLogger{logger: slog.New(slog.NewJSONHandler(&buf, nil)), rate: rate}
+
+			r := gin.New()
+			r.POST("/query", accessLog.middleware, func(c *gin.Context) {
+				setAccessLogEntry(c, &accessLogEntry{
+					schemaHash: "0123456789abcdef",
+					query:      "SELECT 'secret'",
+					result:     &sqlrunner.QueryResult{Rows: [][]string{{"secret"}}, StatementType: sqlrunner.StatementSelect},
+				})
+				c.Status(http.StatusOK)
+			})
+			for range 3 {
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/query", nil))
+			}
+
+			if expected == 0 {
+				assert.Empty(t, buf.String())
+				return
+			}
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			require.Len(t, lines, expected)
+
+			var record map[string]any
+			require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+			assert.Equal(t, "0123456789abcdef", record["schema.hash"])
+			assert.EqualValues(t, len("SELECT 'secret'"), record["query.length"])
+			assert.Equal(t, "SELECT", record["query.statement_type"])
+			assert.EqualValues(t, 1, record["result.rows"])
+			assert.EqualValues(t, http.StatusOK, record["status"])
+			assert.Contains(t, record, "client.ip")
+			assert.Contains(t, record, "duration")
+			// The query text is not logged by default
+			assert.NotContains(t, buf.String(), "secret")
+		})
+	}
+}
+
+// TestSetupOTelSDKInvalidExporter is not parallel since it sets the
+// exporters by the environment, and the process-wide providers.
+func TestSetupOTelSDKInvalidExporter(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "invalid")
+	t.Setenv("OTEL_LOGS_EXPORTER", "invalid")
+
+	tracerProvider, loggerProvider, logger := otel.GetTracerProvider(), global.GetLoggerProvider(), slog.Default()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tracerProvider)
+		global.SetLoggerProvider(loggerProvider)
+		slog.SetDefault(logger)
+	})
+
+	shutdown := setupOTelSDK(context.TODO())
+	t.Cleanup(func() {
+		assert.NoError(t, shutdown(context.TODO()))
+	})
+
+	assert.IsType(t, tracenoop.TracerProvider{}, otel.GetTracerProvider())
+	_, span := otel.Tracer("sqlrunner").Start(context.TODO(), "test")
+	assert.False(t, span.SpanContext().IsValid())
+	span.End()
+
+	// The service still serves the queries
+	r := newTestRouter(t)
+	w := postQuery(t, r, "/query", QueryRequest{
+		Schema: "CREATE TABLE oteltest (value INT); INSERT INTO oteltest VALUES (1);",
+		Query:  "SELECT value FROM oteltest",
+	}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestDrainWaitsForQueries(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRouter(t)
+	drain := &drainer{}
+	started := make(chan struct{})
+	var completed atomic.Bool
+	handler := drain.handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		r.ServeHTTP(w, req)
+		completed.Store(true)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- postQuery(t, handler, "/query", QueryRequest{
+			Schema: "CREATE TABLE draintest (value INT);",
+			Query:  "WITH RECURSIVE n (i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 100000) SELECT count(*) FROM n",
+		}, nil)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, drain.drain(ctx))
+
+	// The query in flight completed before the drain did
+	assert.True(t, completed.Load())
+	w := <-done
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"rows":[["100000"]]`)
+
+	// and the next requests are rejected.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"SHUTTING_DOWN"`)
+}
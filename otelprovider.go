@@ -16,16 +16,22 @@ import (
 	"go.opentelemetry.io/otel/exporters/stdout/stdoutlog"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/log/global"
+	lognoop "go.opentelemetry.io/otel/log/noop"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
 )
 
 // setupOTelSDK bootstraps the OpenTelemetry pipeline.
-// If it does not return an error, make sure to call shutdown for proper cleanup.
-func setupOTelSDK(ctx context.Context) (func(context.Context) error, error) {
+// Make sure to call shutdown for proper cleanup.
+//
+// The observability must not keep the service from starting, so an exporter
+// which cannot be constructed, such as with an unsupported protocol, is
+// logged as a warning instead: the traces fall back to a no-op provider, and
+// the logs to the standard error.
+func setupOTelSDK(ctx context.Context) func(context.Context) error {
 	var shutdownFuncs []func(context.Context) error
-	var err error
 
 	// shutdown calls cleanup functions registered via shutdownFuncs.
 	// The errors from the calls are joined.
@@ -39,36 +45,33 @@ func setupOTelSDK(ctx context.Context) (func(context.Context) error, error) {
 		return err
 	}
 
-	// handleErr calls shutdown for cleanup and makes sure that all errors are returned.
-	handleErr := func(inErr error) {
-		err = errors.Join(inErr, shutdown(ctx))
-	}
-
 	// Set up propagator.
 	prop := newPropagator()
 	otel.SetTextMapPropagator(prop)
 
 	// Set up trace provider.
-	tracerProvider, err := newTracerProvider(ctx)
-	if err != nil {
-		handleErr(err)
-		return shutdown, err
+	if tracerProvider, err := newTracerProvider(ctx); err != nil {
+		slog.Warn("Failed to set up the trace exporter; tracing is disabled", slog.Any("error", err))
+		otel.SetTracerProvider(tracenoop.NewTracerProvider())
+	} else {
+		shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
+		otel.SetTracerProvider(tracerProvider)
 	}
-	shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
-	otel.SetTracerProvider(tracerProvider)
 
 	// Set up logger provider.
 	loggerProvider, err := newLoggerProvider(ctx)
 	if err != nil {
-		handleErr(err)
-		return shutdown, err
+		slog.Warn("Failed to set up the log exporter; logging to the standard error", slog.Any("error", err))
+		global.SetLoggerProvider(lognoop.NewLoggerProvider())
+		slog.SetDefault(slog.New(requestIDHandler{Handler: slog.NewTextHandler(os.Stderr, nil)}))
+		return shutdown
 	}
 	shutdownFuncs = append(shutdownFuncs, loggerProvider.Shutdown)
 	global.SetLoggerProvider(loggerProvider)
 
-	slog.SetDefault(slog.New(otelslog.NewHandler("sqlrunner")))
+	slog.SetDefault(slog.New(requestIDHandler{Handler: otelslog.NewHandler("sqlrunner")}))
 
-	return shutdown, err
+	return shutdown
 }
 
 func newPropagator() propagation.TextMapPropagator {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader is the header carrying the ID of a request, which is
+// accepted from the client or generated, and echoed in the response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of a request ID accepted from
+// the client, so that an arbitrary header is not copied into the logs.
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// requestIDMiddleware assigns an ID to each request to correlate its logs
+// and traces, such as when a student reports a failed query. The ID is
+// taken from the X-Request-ID header if it is valid, or generated otherwise.
+// It is attached to the span, the request context for the logs, and the
+// response header.
+func requestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !isValidRequestID(id) {
+		id = uuid.NewString()
+	}
+
+	// The access log reads the ID from the request header
+	c.Request.Header.Set(requestIDHeader, id)
+	c.Header(requestIDHeader, id)
+
+	ctx := c.Request.Context()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", id))
+	c.Request = c.Request.WithContext(context.WithValue(ctx, requestIDKey{}, id))
+
+	c.Next()
+}
+
+// isValidRequestID reports whether a request ID from the client is
+// non-empty, not too long, and of the printable ASCII characters only.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := range len(id) {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestID returns the ID of the request of the context,
+// or empty if it has none.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDHandler is a slog.Handler adding the request ID of the context
+// to the records, so that the logs of SQLRunner are correlated too.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request.id", id))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	sqlrunner "github.com/database-playground/sqlrunner/lib"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// cellsText serializes each cell as a string, as rendered by SQLRunner.
+	cellsText = "text"
+	// cellsTyped serializes the cells of the numeric columns as JSON numbers
+	// and their NULLs as null.
+	cellsTyped = "typed"
+)
+
+// The formats of the integers in the typed cells.
+const (
+	// integersSafe serializes the integers as JSON numbers, except the ones
+	// beyond maxSafeInteger, which are serialized as strings.
+	integersSafe = "safe"
+	// integersNumber serializes all the integers as JSON numbers.
+	integersNumber = "number"
+	// integersString serializes all the integers as strings.
+	integersString = "string"
+)
+
+// maxSafeInteger is 2^53 - 1, the largest integer a JavaScript number
+// represents exactly. A larger integer parsed by JSON.parse is rounded,
+// such as 9007199254740993 to 9007199254740992.
+const maxSafeInteger = 1<<53 - 1
+
+// TypedQueryResult is a query result whose cells are typed.
+type TypedQueryResult struct {
+	Columns []string `json:"columns"`
+	// Rows is a slice of rows, each row is a slice of strings, numbers, and nulls.
+	Rows          [][]any                 `json:"rows"`
+	Warnings      []string                `json:"warnings,omitempty"`
+	StatementType sqlrunner.StatementType `json:"statement_type,omitempty"`
+	Partial       bool                    `json:"partial,omitempty"`
+}
+
+// responseCells determines the serialization of the cells from the `cells`
+// query parameter.
+func responseCells(c *gin.Context) string {
+	return c.DefaultQuery("cells", cellsText)
+}
+
+// responseIntegers determines the format of the integers in the typed cells
+// from the `integers` query parameter.
+func responseIntegers(c *gin.Context) string {
+	return c.DefaultQuery("integers", integersSafe)
+}
+
+// isSupportedCells reports whether the serialization of the cells is supported.
+func isSupportedCells(cells string) bool {
+	switch cells {
+	case cellsText, cellsTyped:
+		return true
+	default:
+		return false
+	}
+}
+
+// isSupportedIntegers reports whether the format of the integers is supported.
+func isSupportedIntegers(integers string) bool {
+	switch integers {
+	case integersSafe, integersNumber, integersString:
+		return true
+	default:
+		return false
+	}
+}
+
+// columnKind is the kind of the cells of a column in the typed result.
+type columnKind int
+
+const (
+	columnText columnKind = iota
+	columnInteger
+	columnReal
+)
+
+// newColumnKind returns the kind of the column of the declared type,
+// according to the column affinity of SQLite. The expressions have no
+// declared type, so their cells are kept as strings.
+func newColumnKind(declType string) columnKind {
+	declType = strings.ToUpper(declType)
+	switch {
+	case strings.Contains(declType, "INT"):
+		return columnInteger
+	case strings.Contains(declType, "CHAR"), strings.Contains(declType, "CLOB"), strings.Contains(declType, "TEXT"),
+		strings.Contains(declType, "BLOB"):
+		return columnText
+	case strings.Contains(declType, "REAL"), strings.Contains(declType, "FLOA"), strings.Contains(declType, "DOUB"):
+		return columnReal
+	default:
+		return columnText
+	}
+}
+
+// newTypedQueryResult converts the result to the typed cells, with the
+// integers in the format.
+func newTypedQueryResult(result *sqlrunner.QueryResult, integers string) *TypedQueryResult {
+	kinds := make([]columnKind, len(result.Columns))
+	for i := range kinds {
+		if i < len(result.ColumnTypes) {
+			kinds[i] = newColumnKind(result.ColumnTypes[i])
+		}
+	}
+
+	rows := make([][]any, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		typedRow := make([]any, len(row))
+		for i, cell := range row {
+			typedRow[i] = typedCell(kinds[i], cell, integers)
+		}
+		rows = append(rows, typedRow)
+	}
+
+	return &TypedQueryResult{
+		Columns:       result.Columns,
+		Rows:          rows,
+		Warnings:      result.Warnings,
+		StatementType: result.StatementType,
+		Partial:       result.Partial,
+	}
+}
+
+// typedCell converts a cell of the column kind. A cell of a numeric column
+// which is not a number, such as a string stored in an INT column, is kept
+// as a string.
+func typedCell(kind columnKind, cell string, integers string) any {
+	if kind == columnText {
+		return cell
+	}
+	if cell == "NULL" {
+		return nil
+	}
+	if !isJSONNumber(cell) {
+		return cell
+	}
+
+	if n, err := strconv.ParseInt(cell, 10, 64); err == nil {
+		switch {
+		case integers == integersString:
+			return cell
+		case integers == integersSafe && (n > maxSafeInteger || n < -maxSafeInteger):
+			return cell
+		default:
+			return json.Number(cell)
+		}
+	}
+	if kind == columnReal {
+		return json.Number(cell)
+	}
+
+	return cell
+}
+
+// isJSONNumber reports whether the cell is a number in the JSON syntax,
+// which, unlike strconv, rejects such as "+1", "007", and "Inf".
+func isJSONNumber(cell string) bool {
+	if cell == "" || (cell[0] != '-' && !isDigit(cell[0])) || !isDigit(cell[len(cell)-1]) {
+		return false
+	}
+
+	return json.Valid([]byte(cell))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}